@@ -1,17 +1,22 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"Custom_DB/pkg/handlers"
@@ -23,6 +28,122 @@ import (
 
 var db *schema.Database
 
+// Every client of the server has a session of db, with temporary tables
+// and settings of its own. A session is named by a random id the server
+// gives out in the customdb_session cookie and the X-Session-ID header of
+// its answers; a client sends it back in either to stay in the session,
+// whatever connection it uses. A session idle for sessionIdleTimeout ends,
+// dropping its temporary tables, and a request naming it starts a new one.
+const (
+	sessionCookie      = "customdb_session"
+	sessionHeader      = "X-Session-ID"
+	sessionIdleTimeout = 30 * time.Minute
+)
+
+var sessions = struct {
+	sync.Mutex
+	m map[string]*session
+}{m: map[string]*session{}}
+
+// session is a session of db and the requests using it.
+type session struct {
+	db       *schema.Database
+	active   int // requests running in the session
+	lastUsed time.Time
+}
+
+type sessionKey struct{}
+
+// withSession runs h in the session the request names, starting a new one
+// when it names none or one that has ended.
+func withSession(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, s, err := startRequest(requestSessionID(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer endRequest(s)
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+		w.Header().Set(sessionHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s.db)))
+	})
+}
+
+// requestSessionID returns the session id r names, from its header or else
+// its cookie.
+func requestSessionID(r *http.Request) string {
+	if id := r.Header.Get(sessionHeader); id != "" {
+		return id
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// startRequest marks the session named id as in use, starting a new session
+// under a new id if there is no such session.
+func startRequest(id string) (string, *session, error) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	s, ok := sessions.m[id]
+	if !ok {
+		var err error
+		if id, err = newSessionID(); err != nil {
+			return "", nil, err
+		}
+		s = &session{db: db.Session(id)}
+		sessions.m[id] = s
+	}
+	s.active++
+	s.lastUsed = time.Now()
+	return id, s, nil
+}
+
+// endRequest marks a request of s as done.
+func endRequest(s *session) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	s.active--
+	s.lastUsed = time.Now()
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create session id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// expireSessions ends the sessions with no request running that have been
+// idle since before cutoff.
+func expireSessions(cutoff time.Time) {
+	var ended []*schema.Database
+	sessions.Lock()
+	for id, s := range sessions.m {
+		if s.active == 0 && s.lastUsed.Before(cutoff) {
+			delete(sessions.m, id)
+			ended = append(ended, s.db)
+		}
+	}
+	sessions.Unlock()
+	for _, sdb := range ended {
+		if err := handlers.DropTempTables(sdb); err != nil {
+			log.Printf("Warning: %s", err)
+		}
+	}
+}
+
+// sessionDB returns the session r runs in.
+func sessionDB(r *http.Request) *schema.Database {
+	if s, ok := r.Context().Value(sessionKey{}).(*schema.Database); ok {
+		return s
+	}
+	return db
+}
+
 // nlColEntry pairs a column's actual name with its space-normalized uppercase form.
 type nlColEntry struct {
 	name       string
@@ -69,6 +190,32 @@ type TablesResponse struct {
 	Tables  []TableInfo `json:"tables"`
 }
 
+// BufferPoolMetrics are the counters of the buffer pool shared by all
+// sessions; see storage.PoolStats.
+type BufferPoolMetrics struct {
+	Capacity  int     `json:"capacity"`
+	Pages     int     `json:"pages"`
+	Pinned    int     `json:"pinned"`
+	Dirty     int     `json:"dirty"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRatio  float64 `json:"hitRatio"`
+	Evictions int64   `json:"evictions"`
+	Writes    int64   `json:"writes"`
+}
+
+type MetricsResponse struct {
+	Success    bool              `json:"success"`
+	BufferPool BufferPoolMetrics `json:"bufferPool"`
+}
+
+// RowResponse answers an image upload with the rowid of the row written.
+type RowResponse struct {
+	Success bool   `json:"success"`
+	RowID   int    `json:"rowid"`
+	Error   string `json:"error,omitempty"`
+}
+
 // -- Conversation persistence --------------------------------------------------
 
 const convsDir = "data/conversations"
@@ -169,12 +316,20 @@ func main() {
 	http.HandleFunc("/", serveHome)
 	http.HandleFunc("/api/query", handleQuery)
 	http.HandleFunc("/api/tables", handleTables)
+	http.HandleFunc("/api/tables/", handleTableRows)
 	http.HandleFunc("/api/upload", handleUpload)
 	http.HandleFunc("/api/conversations", handleConversations)
 	http.HandleFunc("/api/conversations/", handleConversationByID)
+	http.HandleFunc("/api/metrics", handleMetrics)
 
 	fmt.Println("CustomDB Web UI running at http://localhost:8082")
-	log.Fatal(http.ListenAndServe(":8082", nil))
+	go func() {
+		for range time.Tick(time.Minute) {
+			expireSessions(time.Now().Add(-sessionIdleTimeout))
+		}
+	}()
+	srv := &http.Server{Addr: ":8082", Handler: withSession(http.DefaultServeMux)}
+	log.Fatal(srv.ListenAndServe())
 }
 
 func serveHome(w http.ResponseWriter, r *http.Request) {
@@ -183,12 +338,175 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 
 func handleTables(w http.ResponseWriter, r *http.Request) {
 	var tables []TableInfo
-	for name, table := range db.Tables {
-		tables = append(tables, TableInfo{Name: name, Columns: table.Columns})
+	sdb := sessionDB(r)
+	for _, name := range sdb.GetAllTableNames() {
+		if table, ok := sdb.GetTable(name); ok {
+			tables = append(tables, TableInfo{Name: name, Columns: table.Columns})
+		}
 	}
 	writeJSON(w, TablesResponse{Success: true, Tables: tables})
 }
 
+// GET /api/metrics ? counters of the server, as SHOW STATS lists them
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	st := storage.SharedBuffers.Stats()
+	writeJSON(w, MetricsResponse{Success: true, BufferPool: BufferPoolMetrics{
+		Capacity:  st.Capacity,
+		Pages:     st.Pages,
+		Pinned:    st.Pinned,
+		Dirty:     st.Dirty,
+		Hits:      st.Hits,
+		Misses:    st.Misses,
+		HitRatio:  st.HitRatio(),
+		Evictions: st.Evictions,
+		Writes:    st.Writes,
+	}})
+}
+
+// GET  /api/tables/{t}/rows/{rowid}/{col}          ? stream a BLOB or IMAGE value
+// GET  /api/tables/{t}/rows/{rowid}/{col}?thumb=N  ? image scaled to N pixels
+// POST /api/tables/{t}/rows/{rowid}/{col}          ? replace it by the uploaded "file"
+// POST /api/tables/{t}/rows                        ? insert a row from a multipart form
+//
+// A rowid is the row's line number in the table's data file, from 0.
+func handleTableRows(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/tables/"), "/")
+	switch {
+	case len(parts) == 2 && parts[1] == "rows":
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		insertUploadedRow(w, r, parts[0])
+	case len(parts) == 4 && parts[1] == "rows":
+		rowid, err := strconv.Atoi(parts[2])
+		if err != nil || rowid < 0 {
+			http.Error(w, "invalid rowid '"+parts[2]+"'", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			serveRowBlob(w, r, parts[0], rowid, parts[3])
+		case http.MethodPost:
+			updateRowBlob(w, r, parts[0], rowid, parts[3])
+		default:
+			http.Error(w, "GET or POST only", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveRowBlob streams a BLOB or IMAGE value. Values are tagged with their
+// content digest, so a client revalidating its cached copy gets 304 Not
+// Modified until the row is given new content.
+func serveRowBlob(w http.ResponseWriter, r *http.Request, table string, rowid int, col string) {
+	ref, err := handlers.RowBlob(sessionDB(r), table, rowid, col)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	etag := ref.SHA256
+	mime := ref.MIME
+	var data []byte
+	if thumb := r.URL.Query().Get("thumb"); thumb != "" {
+		size, err := strconv.Atoi(thumb)
+		if err != nil {
+			http.Error(w, "invalid thumbnail size '"+thumb+"'", http.StatusBadRequest)
+			return
+		}
+		if data, err = handlers.ImageThumbnail(sessionDB(r), ref, size); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		etag = fmt.Sprintf("%s-%d", ref.SHA256, size)
+		mime = storage.DetectMIME(data)
+	} else {
+		store, err := storage.NewBlobStore(db.GetDBPath())
+		if err == nil {
+			data, err = store.Read(ref)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// readUpload returns the content of an uploaded file.
+func readUpload(header *multipart.FileHeader) (handlers.BlobUpload, error) {
+	f, err := header.Open()
+	if err != nil {
+		return handlers.BlobUpload{}, fmt.Errorf("failed to read upload '%s': %w", header.Filename, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return handlers.BlobUpload{}, fmt.Errorf("failed to read upload '%s': %w", header.Filename, err)
+	}
+	return handlers.BlobUpload{Filename: header.Filename, Data: data}, nil
+}
+
+// updateRowBlob replaces a BLOB or IMAGE value by the form's "file".
+func updateRowBlob(w http.ResponseWriter, r *http.Request, table string, rowid int, col string) {
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		writeJSON(w, RowResponse{Success: false, Error: "failed to parse upload: " + err.Error()})
+		return
+	}
+	_, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, RowResponse{Success: false, Error: "no file received"})
+		return
+	}
+	up, err := readUpload(header)
+	if err == nil {
+		err = handlers.UpdateRowBlob(sessionDB(r), table, rowid, col, up)
+	}
+	if err != nil {
+		writeJSON(w, RowResponse{Success: false, Error: err.Error()})
+		return
+	}
+	writeJSON(w, RowResponse{Success: true, RowID: rowid})
+}
+
+// insertUploadedRow inserts a row whose BLOB and IMAGE values are the files
+// of the form and whose other values are its fields, each named after its
+// column. Empty fields are left out of the row, so their columns are NULL.
+func insertUploadedRow(w http.ResponseWriter, r *http.Request, table string) {
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		writeJSON(w, RowResponse{Success: false, Error: "failed to parse upload: " + err.Error()})
+		return
+	}
+	values := map[string]string{}
+	for name, vals := range r.MultipartForm.Value {
+		if len(vals) > 0 && vals[0] != "" {
+			values[name] = vals[0]
+		}
+	}
+	files := map[string]handlers.BlobUpload{}
+	for name, headers := range r.MultipartForm.File {
+		if len(headers) == 0 {
+			continue
+		}
+		up, err := readUpload(headers[0])
+		if err != nil {
+			writeJSON(w, RowResponse{Success: false, Error: err.Error()})
+			return
+		}
+		files[name] = up
+	}
+	rowid, err := handlers.InsertUploadedRow(sessionDB(r), table, values, files)
+	if err != nil {
+		writeJSON(w, RowResponse{Success: false, Error: err.Error()})
+		return
+	}
+	writeJSON(w, RowResponse{Success: true, RowID: rowid})
+}
+
 // GET  /api/conversations       ? list all conversations (no messages)
 // POST /api/conversations        ? create new conversation
 func handleConversations(w http.ResponseWriter, r *http.Request) {
@@ -374,9 +692,9 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	var importErr error
 	switch ext {
 	case ".csv":
-		importErr = importer.ImportCSV(tmpf.Name(), db, tableName)
+		importErr = importer.ImportCSV(tmpf.Name(), sessionDB(r), tableName)
 	case ".parquet":
-		importErr = importer.ImportParquet(tmpf.Name(), db, tableName)
+		importErr = importer.ImportParquet(tmpf.Name(), sessionDB(r), tableName)
 	}
 
 	if importErr != nil {
@@ -410,6 +728,8 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sdb := sessionDB(r)
+
 	// Natural language mode or auto-detect
 	var prevTable string
 	if req.ConversationID != "" {
@@ -417,7 +737,7 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 			for i := len(c.Messages) - 1; i >= 0; i-- {
 				if c.Messages[i].Role == "bot" && c.Messages[i].SQL != "" {
 					upperSQL := strings.ToUpper(c.Messages[i].SQL)
-					tables := db.GetAllTableNames()
+					tables := sdb.GetAllTableNames()
 					for _, t := range tables {
 						if strings.Contains(upperSQL, strings.ToUpper(t)) {
 							prevTable = t
@@ -443,7 +763,7 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, QueryResponse{Success: false, Error: perr.Error(), GeneratedSQL: sql})
 			return
 		}
-		result, execErr := runCommand(cmd)
+		result, execErr := runCommand(sdb, cmd)
 		if execErr != nil {
 			writeJSON(w, QueryResponse{Success: false, Error: execErr.Error(), GeneratedSQL: sql})
 			return
@@ -458,7 +778,7 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, execErr := runCommand(cmd)
+	result, execErr := runCommand(sdb, cmd)
 	if execErr != nil {
 		writeJSON(w, QueryResponse{Success: false, Error: execErr.Error()})
 		return
@@ -466,7 +786,8 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, QueryResponse{Success: true, Result: result})
 }
 
-func runCommand(cmd parser.Command) (string, error) {
+// runCommand runs cmd in the session db.
+func runCommand(db *schema.Database, cmd parser.Command) (string, error) {
 	switch cmd.Type {
 	case "SELECT":
 		return handlers.HandleSelect(cmd, db)
@@ -480,6 +801,18 @@ func runCommand(cmd parser.Command) (string, error) {
 	case "DELETE":
 		return handlers.HandleDelete(cmd, db)
 
+	case "MIGRATE":
+		return handlers.HandleMigrate(cmd, db)
+
+	case "SET":
+		return handlers.HandleSet(cmd, db)
+
+	case "EXPLAIN":
+		return handlers.HandleExplain(cmd, db)
+
+	case "ANALYZE":
+		return handlers.HandleAnalyze(cmd, db)
+
 	case "SHOW":
 		if len(cmd.Tokens) > 1 && strings.ToUpper(cmd.Tokens[1]) == "TABLES" {
 			names := db.GetAllTableNames()
@@ -493,66 +826,81 @@ func runCommand(cmd parser.Command) (string, error) {
 			}
 			return sb.String(), nil
 		}
+		if len(cmd.Tokens) > 1 && strings.ToUpper(cmd.Tokens[1]) == "FUNCTIONS" {
+			return handlers.HandleShowFunctions()
+		}
+		if len(cmd.Tokens) > 1 && strings.ToUpper(cmd.Tokens[1]) == "STATS" {
+			return handlers.HandleShowStats()
+		}
 		return "", fmt.Errorf("unknown SHOW command")
 
 	case "CREATE":
 		parts := cmd.Tokens
+		if len(parts) > 1 && strings.ToUpper(parts[1]) == "INDEX" {
+			return handlers.HandleCreateIndex(cmd, db)
+		}
+		temp := len(parts) > 1 && (strings.EqualFold(parts[1], "TEMP") || strings.EqualFold(parts[1], "TEMPORARY"))
+		if temp {
+			parts = append([]string{parts[0]}, parts[2:]...)
+		}
 		if len(parts) < 3 || strings.ToUpper(parts[1]) != "TABLE" {
 			return "", fmt.Errorf("invalid CREATE TABLE syntax. Example: CREATE TABLE users (id INT, name TEXT)")
 		}
 		tableName := strings.TrimSpace(parts[2])
-		full := strings.Join(parts, " ")
+		full, format, err := schema.ParseTableOptions(strings.Join(parts, " "))
+		if err != nil {
+			return "", err
+		}
 		openParen := strings.Index(full, "(")
 		closeParen := strings.LastIndex(full, ")")
 		if openParen == -1 || closeParen == -1 || closeParen <= openParen {
 			return "", fmt.Errorf("missing column definitions in CREATE TABLE")
 		}
 		colsStr := full[openParen+1 : closeParen]
-		colDefs := strings.Split(colsStr, ",")
-		var columns []schema.Column
-		for _, colDef := range colDefs {
-			colParts := strings.Fields(strings.TrimSpace(colDef))
-			if len(colParts) != 2 {
-				return "", fmt.Errorf("invalid column definition: %s", colDef)
-			}
-			colType := strings.ToUpper(colParts[1])
-			if !schema.ValidateColumnType(colType) {
-				return "", fmt.Errorf("invalid column type: %s (supported: INT, TEXT, DECIMAL, BOOL, IMAGE)", colType)
-			}
-			columns = append(columns, schema.Column{Name: colParts[0], Type: schema.DataType(colType)})
+		columns, err := schema.ParseColumnDefs(colsStr)
+		if err != nil {
+			return "", fmt.Errorf("%w (supported: INT, SMALLINT, BIGINT, TEXT, DECIMAL, NUMERIC(p,s), REAL, DOUBLE, BOOL, IMAGE, BLOB, DATE, TIMESTAMP, TIMESTAMPTZ, INTERVAL, JSON, ARRAY<T>, STRUCT<name T, ...>)", err)
 		}
 		if len(columns) == 0 {
 			return "", fmt.Errorf("no columns defined")
 		}
-		if err := db.AddTable(schema.Table{Name: tableName, Columns: columns}); err != nil {
+		if err := db.AddTable(schema.Table{Name: tableName, Columns: columns, Format: format, Temp: temp}); err != nil {
 			return "", err
 		}
 		return fmt.Sprintf("Table '%s' created successfully.", tableName), nil
 
 	case "DROP":
 		parts := cmd.Tokens
+		if len(parts) > 1 && strings.ToUpper(parts[1]) == "INDEX" {
+			return handlers.HandleDropIndex(cmd, db)
+		}
 		if len(parts) < 3 || strings.ToUpper(parts[1]) != "TABLE" {
 			return "", fmt.Errorf("invalid DROP TABLE syntax. Example: DROP TABLE users")
 		}
 		tableName := strings.TrimSpace(parts[2])
+		table, _ := db.GetTable(tableName)
 		if err := db.RemoveTable(tableName); err != nil {
 			return "", err
 		}
-		tf, err := storage.NewTableFile(db.GetDBPath(), tableName)
+		blobErr := handlers.DropTableBlobs(db, table)
+		tf, err := storage.OpenTable(db.GetDBPath(), table)
 		if err == nil {
 			tf.DeleteFile()
 		}
+		if blobErr != nil {
+			return "", fmt.Errorf("table '%s' dropped, but its blobs were not released: %w", tableName, blobErr)
+		}
 		return fmt.Sprintf("Table '%s' dropped successfully.", tableName), nil
 
 	default:
-		return "", fmt.Errorf("unknown command: %s. Supported: SELECT, INSERT, UPDATE, DELETE, CREATE TABLE, DROP TABLE, SHOW TABLES", cmd.Type)
+		return "", fmt.Errorf("unknown command: %s. Supported: SELECT, INSERT, UPDATE, DELETE, CREATE [TEMP] TABLE, DROP TABLE, CREATE INDEX, DROP INDEX, SHOW TABLES, SHOW FUNCTIONS, SHOW STATS, MIGRATE NULLS, MIGRATE IMAGES, SET TIME ZONE, SET WORK_MEM, SET PARALLEL_WORKERS, EXPLAIN [ANALYZE] SELECT, ANALYZE", cmd.Type)
 	}
 }
 
 // isNaturalLanguage returns true when the input is not a SQL keyword
 func isNaturalLanguage(input string) bool {
 	upper := strings.ToUpper(strings.TrimSpace(input))
-	for _, kw := range []string{"SELECT ", "INSERT ", "UPDATE ", "DELETE ", "CREATE ", "DROP ", "SHOW TABLES"} {
+	for _, kw := range []string{"SELECT ", "INSERT ", "UPDATE ", "DELETE ", "CREATE ", "DROP ", "SHOW TABLES", "SHOW FUNCTIONS", "SHOW STATS", "MIGRATE ", "EXPLAIN ", "ANALYZE ", "SET TIME", "SET TIMEZONE", "SET WORK_MEM", "SET PARALLEL_WORKERS"} {
 		if strings.HasPrefix(upper, kw) || upper == strings.TrimSpace(kw) {
 			return false
 		}
@@ -803,27 +1151,29 @@ func matchColumnInContext(context string, cols []nlColEntry) string {
 	return ""
 }
 
-// sampleTableValues reads up to limit rows from a table's .dat file and returns
+// sampleTableValues reads up to limit rows from a table and returns
 // a map of lowercase(value) ? {columnName, originalValue} for enum-like fields.
 func sampleTableValues(tableName string, limit int) map[string]nlValueInfo {
-	path := filepath.Join(db.GetDBPath(), tableName+".dat")
-	f, err := os.Open(path)
+	table, ok := db.GetTable(tableName)
+	if !ok {
+		return nil
+	}
+	tf, err := storage.OpenTable(db.GetDBPath(), table)
 	if err != nil {
 		return nil
 	}
-	defer f.Close()
+	rows, err := tf.Scan(nil, storage.ScanOptions{})
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
 
 	result := make(map[string]nlValueInfo)
-	scanner := bufio.NewScanner(f)
 	count := 0
-	for scanner.Scan() && count < limit {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-		var row map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &row); err != nil {
-			continue
+	for count < limit {
+		row, _, err := rows.Next()
+		if row == nil || err != nil {
+			break
 		}
 		for col, val := range row {
 			if val == nil {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Custom_DB/pkg/schema"
+)
+
+// postQuery sends sql to /api/query as the web UI does and returns the
+// answer.
+func postQuery(t *testing.T, sql string) QueryResponse {
+	t.Helper()
+	body, _ := json.Marshal(QueryRequest{Query: sql})
+	rec := httptest.NewRecorder()
+	handleQuery(rec, httptest.NewRequest(http.MethodPost, "/api/query", bytes.NewReader(body)))
+	var resp QueryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return resp
+}
+
+func setTestDB(t *testing.T) {
+	t.Helper()
+	var err error
+	old := db
+	if db, err = schema.NewDatabase(t.TempDir()); err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	t.Cleanup(func() { db = old })
+}
+
+func TestQuery_SetCommands(t *testing.T) {
+	setTestDB(t)
+	for sql, want := range map[string]string{
+		"SET WORK_MEM '16MB';":    "Work memory set to 16MB",
+		"SET PARALLEL_WORKERS 2;": "Parallel workers set to 2",
+		"SET TIME ZONE 'UTC';":    "Time zone set to UTC",
+		"SET TIMEZONE TO 'UTC';":  "Time zone set to UTC",
+	} {
+		resp := postQuery(t, sql)
+		if !resp.Success || !strings.Contains(resp.Result, want) || resp.GeneratedSQL != "" {
+			t.Errorf("%s: got %+v, want %q", sql, resp, want)
+		}
+	}
+}
+
+// postSessionQuery sends sql to /api/query in the session id names and
+// returns the answer and the id of the session it ran in.
+func postSessionQuery(t *testing.T, id, sql string) (QueryResponse, string) {
+	t.Helper()
+	body, _ := json.Marshal(QueryRequest{Query: sql})
+	req := httptest.NewRequest(http.MethodPost, "/api/query", bytes.NewReader(body))
+	if id != "" {
+		req.Header.Set(sessionHeader, id)
+	}
+	rec := httptest.NewRecorder()
+	withSession(http.HandlerFunc(handleQuery)).ServeHTTP(rec, req)
+	var resp QueryResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return resp, rec.Header().Get(sessionHeader)
+}
+
+func TestQuery_Sessions(t *testing.T) {
+	setTestDB(t)
+	_, id := postSessionQuery(t, "", "CREATE TEMP TABLE scratch (id INT);")
+	if id == "" {
+		t.Fatal("no session id given out")
+	}
+	if resp, got := postSessionQuery(t, id, "SELECT * FROM scratch;"); !resp.Success || got != id {
+		t.Errorf("same session: got %+v in session %q", resp, got)
+	}
+	if resp, got := postSessionQuery(t, "", "SELECT * FROM scratch;"); resp.Success || got == id {
+		t.Errorf("new session sees the temporary table: %+v", resp)
+	}
+
+	expireSessions(time.Now().Add(time.Second))
+	resp, got := postSessionQuery(t, id, "SELECT * FROM scratch;")
+	if resp.Success || got == id || got == "" {
+		t.Errorf("expired session: got %+v in session %q", resp, got)
+	}
+}
@@ -55,7 +55,12 @@ func main() {
 	fmt.Println("")
 	fmt.Println("")
 
+	// the database may be named on the command line; ":memory:" opens a
+	// scratch one that is gone on exit
 	dbPath := "data/my_first_db"
+	if len(os.Args) > 1 {
+		dbPath = os.Args[1]
+	}
 	db, err := schema.NewDatabase(dbPath)
 	if err != nil {
 		fmt.Printf("Failed to initialize database: %s\n", err)
@@ -118,6 +123,14 @@ func main() {
 		// Execute SQL command
 		executeCommand(cmd, db)
 	}
+
+	// temporary tables last as long as the shell
+	if err := handlers.DropTempTables(db); err != nil {
+		fmt.Printf("Warning: %s\n", err)
+	}
+	if err := db.Close(); err != nil {
+		fmt.Printf("Warning: %s\n", err)
+	}
 }
 
 // Enhanced natural language detection with better pattern recognition
@@ -125,12 +138,15 @@ func isNaturalLanguageImproved(input string) bool {
 	upperInput := strings.ToUpper(strings.TrimSpace(input))
 	
 	// Definite SQL keywords that indicate structured SQL
-	sqlKeywords := []string{"SELECT ", "INSERT ", "UPDATE ", "DELETE ", "CREATE ", "DROP "}
+	sqlKeywords := []string{"SELECT ", "INSERT ", "UPDATE ", "DELETE ", "CREATE ", "DROP ", "MIGRATE ", "EXPLAIN ", "ANALYZE ", "SET TIME", "SET TIMEZONE", "SET WORK_MEM", "SET PARALLEL_WORKERS"}
 	for _, keyword := range sqlKeywords {
 		if strings.HasPrefix(upperInput, keyword) {
 			return false
 		}
 	}
+	if upperInput == "ANALYZE" || upperInput == "ANALYZE;" {
+		return false
+	}
 	
 	// Special case for SHOW command - check if it's proper SQL syntax
 	if strings.HasPrefix(upperInput, "SHOW ") {
@@ -138,6 +154,12 @@ func isNaturalLanguageImproved(input string) bool {
 		if strings.TrimSpace(upperInput) == "SHOW TABLES" || strings.TrimSpace(upperInput) == "SHOW TABLES;" {
 			return false
 		}
+		if strings.TrimSpace(upperInput) == "SHOW FUNCTIONS" || strings.TrimSpace(upperInput) == "SHOW FUNCTIONS;" {
+			return false
+		}
+		if strings.TrimSpace(upperInput) == "SHOW STATS" || strings.TrimSpace(upperInput) == "SHOW STATS;" {
+			return false
+		}
 		// Other SHOW variations are likely natural language
 		return true
 	}
@@ -788,11 +810,9 @@ func executeCommand(cmd parser.Command, db *schema.Database) {
 
 	switch command {
 	case "SELECT":
-		out, err := handlers.HandleSelect(cmd, db)
-		if err != nil {
+		// rows are printed as they are produced rather than held in memory
+		if err := handlers.StreamSelect(cmd, db, os.Stdout); err != nil {
 			fmt.Println("SELECT error:", err)
-		} else {
-			fmt.Print(out)
 		}
 
 	case "INSERT":
@@ -804,6 +824,19 @@ func executeCommand(cmd parser.Command, db *schema.Database) {
 		}
 
 	case "CREATE":
+		if len(parts) > 1 && strings.ToUpper(parts[1]) == "INDEX" {
+			out, err := handlers.HandleCreateIndex(cmd, db)
+			if err != nil {
+				fmt.Println("CREATE INDEX error:", err)
+			} else {
+				fmt.Println(out)
+			}
+			return
+		}
+		temp := len(parts) > 1 && (strings.ToUpper(parts[1]) == "TEMP" || strings.ToUpper(parts[1]) == "TEMPORARY")
+		if temp {
+			parts = append([]string{parts[0]}, parts[2:]...)
+		}
 		if len(parts) < 3 || strings.ToUpper(parts[1]) != "TABLE" {
 			fmt.Println("Invalid CREATE TABLE syntax. Example: CREATE TABLE users (id INT, name TEXT);")
 			return
@@ -812,7 +845,11 @@ func executeCommand(cmd parser.Command, db *schema.Database) {
 		tableName := strings.TrimSpace(parts[2])
 		
 		// Parse column definitions
-		fullCommand := strings.Join(parts, " ")
+		fullCommand, format, err := schema.ParseTableOptions(strings.Join(parts, " "))
+		if err != nil {
+			fmt.Println("Invalid CREATE TABLE syntax.", err)
+			return
+		}
 		openParen := strings.Index(fullCommand, "(")
 		closeParen := strings.LastIndex(fullCommand, ")")
 		
@@ -822,28 +859,10 @@ func executeCommand(cmd parser.Command, db *schema.Database) {
 		}
 		
 		colsStr := fullCommand[openParen+1 : closeParen]
-		colDefs := strings.Split(colsStr, ",")
-		
-		var columns []schema.Column
-		for _, colDef := range colDefs {
-			colParts := strings.Fields(strings.TrimSpace(colDef))
-			if len(colParts) != 2 {
-				fmt.Printf("Invalid column definition: %s\n", colDef)
-				return
-			}
-			
-			colName := strings.TrimSpace(colParts[0])
-			colType := strings.ToUpper(strings.TrimSpace(colParts[1]))
-			
-			if !schema.ValidateColumnType(colType) {
-				fmt.Printf("Invalid column type: %s. Supported types: INT, TEXT, DECIMAL, BOOL, IMAGE\n", colType)
-				return
-			}
-			
-			columns = append(columns, schema.Column{
-				Name: colName,
-				Type: schema.DataType(colType),
-			})
+		columns, err := schema.ParseColumnDefs(colsStr)
+		if err != nil {
+			fmt.Printf("%s. Supported types: INT, SMALLINT, BIGINT, TEXT, DECIMAL, NUMERIC(p,s), REAL, DOUBLE, BOOL, IMAGE, BLOB, DATE, TIMESTAMP, TIMESTAMPTZ, INTERVAL, JSON, ARRAY<T>, STRUCT<name T, ...>\n", err)
+			return
 		}
 		
 		if len(columns) == 0 {
@@ -854,6 +873,8 @@ func executeCommand(cmd parser.Command, db *schema.Database) {
 		table := schema.Table{
 			Name:    tableName,
 			Columns: columns,
+			Format:  format,
+			Temp:    temp,
 		}
 		
 		if err := db.AddTable(table); err != nil {
@@ -873,23 +894,50 @@ func executeCommand(cmd parser.Command, db *schema.Database) {
 					fmt.Printf("- %s\n", name)
 				}
 			}
+		} else if len(parts) > 1 && strings.ToUpper(parts[1]) == "FUNCTIONS" {
+			out, err := handlers.HandleShowFunctions()
+			if err != nil {
+				fmt.Println("SHOW FUNCTIONS error:", err)
+			} else {
+				fmt.Println(out)
+			}
+		} else if len(parts) > 1 && strings.ToUpper(parts[1]) == "STATS" {
+			out, err := handlers.HandleShowStats()
+			if err != nil {
+				fmt.Println("SHOW STATS error:", err)
+			} else {
+				fmt.Println(out)
+			}
 		} else {
-			fmt.Println("Invalid SHOW syntax. Example: SHOW TABLES;")
+			fmt.Println("Invalid SHOW syntax. Example: SHOW TABLES; SHOW FUNCTIONS; or SHOW STATS;")
 		}
 
 	case "DROP":
+		if len(parts) > 1 && strings.ToUpper(parts[1]) == "INDEX" {
+			out, err := handlers.HandleDropIndex(cmd, db)
+			if err != nil {
+				fmt.Println("DROP INDEX error:", err)
+			} else {
+				fmt.Println(out)
+			}
+			return
+		}
 		if len(parts) < 3 || strings.ToUpper(parts[1]) != "TABLE" {
 			fmt.Println("Invalid DROP TABLE syntax. Example: DROP TABLE users;")
 			return
 		}
 		tableName := strings.TrimSpace(parts[2])
+		table, _ := db.GetTable(tableName)
 
 		if err := db.RemoveTable(tableName); err != nil {
 			fmt.Printf("Error dropping table from schema: %s\n", err)
 			return
 		}
+		if err := handlers.DropTableBlobs(db, table); err != nil {
+			fmt.Printf("Warning: Could not release blobs of '%s': %s\n", tableName, err)
+		}
 
-		tableFile, err := storage.NewTableFile(db.GetDBPath(), tableName)
+		tableFile, err := storage.OpenTable(db.GetDBPath(), table)
 		if err != nil {
 			fmt.Printf("Warning: Could not get table file for '%s' to delete: %s\n", tableName, err)
 		} else {
@@ -900,7 +948,7 @@ func executeCommand(cmd parser.Command, db *schema.Database) {
 		fmt.Printf("✅ Table '%s' dropped successfully.\n", tableName)
 
 	case "UPDATE":
-		out, err := handlers.HandleUpdate(cmd, db)
+		out, err := handlers.HandleUpdateWithImages(cmd, db, imageDirectory)
 		if err != nil {
 			fmt.Println("UPDATE error:", err)
 		} else {
@@ -915,9 +963,41 @@ func executeCommand(cmd parser.Command, db *schema.Database) {
 			fmt.Println(out)
 		}
 
+	case "MIGRATE":
+		out, err := handlers.HandleMigrate(cmd, db)
+		if err != nil {
+			fmt.Println("MIGRATE error:", err)
+		} else {
+			fmt.Println(out)
+		}
+
+	case "SET":
+		out, err := handlers.HandleSet(cmd, db)
+		if err != nil {
+			fmt.Println("SET error:", err)
+		} else {
+			fmt.Println(out)
+		}
+
+	case "EXPLAIN":
+		out, err := handlers.HandleExplain(cmd, db)
+		if err != nil {
+			fmt.Println("EXPLAIN error:", err)
+		} else {
+			fmt.Print(out)
+		}
+
+	case "ANALYZE":
+		out, err := handlers.HandleAnalyze(cmd, db)
+		if err != nil {
+			fmt.Println("ANALYZE error:", err)
+		} else {
+			fmt.Println(out)
+		}
+
 	default:
 		fmt.Printf("❌ Unknown SQL command: %s\n", command)
-		fmt.Println("💡 Supported commands: SELECT, INSERT, CREATE TABLE, DROP TABLE, SHOW TABLES")
+		fmt.Println("💡 Supported commands: SELECT, INSERT, CREATE [TEMP] TABLE, DROP TABLE, CREATE INDEX, DROP INDEX, SHOW TABLES, SHOW FUNCTIONS, SHOW STATS, MIGRATE NULLS, MIGRATE IMAGES, SET TIME ZONE, SET WORK_MEM, SET PARALLEL_WORKERS, EXPLAIN [ANALYZE] SELECT, ANALYZE")
 	}
 }
 
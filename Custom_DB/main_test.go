@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+)
+
+// runShellLine runs a line typed at the shell prompt the way the shell
+// dispatches it, and returns what it prints; natural language is reported
+// rather than sent to the model.
+func runShellLine(t *testing.T, db *schema.Database, input string) string {
+	t.Helper()
+	input = strings.TrimSuffix(strings.TrimSpace(input), ";")
+	if isNaturalLanguageImproved(input) {
+		t.Fatalf("%q taken for natural language", input)
+	}
+	cmd, err := parser.Parse(input)
+	if err != nil {
+		t.Fatalf("parse %q: %v", input, err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	executeCommand(cmd, db)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestShell_SetCommands(t *testing.T) {
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	for input, want := range map[string]string{
+		"SET WORK_MEM '16MB';":    "Work memory set to 16MB",
+		"SET PARALLEL_WORKERS 2;": "Parallel workers set to 2",
+		"SET TIME ZONE 'UTC';":    "Time zone set to UTC",
+		"SET TIMEZONE TO 'UTC';":  "Time zone set to UTC",
+	} {
+		if out := runShellLine(t, db, input); !strings.Contains(out, want) {
+			t.Errorf("%s: got %q, want %q", input, out, want)
+		}
+	}
+}
@@ -0,0 +1,886 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"Custom_DB/pkg/storage"
+)
+
+// Accumulator collects the argument values of one group for an aggregate.
+type Accumulator interface {
+	// Step adds one row's arguments, already converted to the declared kinds.
+	Step(args []interface{}) error
+	// Result returns the aggregate value for the rows seen so far.
+	Result() (interface{}, error)
+}
+
+// Merger is an Accumulator that can take in the state of another of the
+// same aggregate, so a group can be aggregated in parts, as parallel
+// workers do, and the parts combined. Merging parts in the order of their
+// rows gives the result of stepping through all the rows in that order.
+type Merger interface {
+	Accumulator
+	Merge(other Accumulator) error
+}
+
+// Mergeable reports whether the call's accumulators can be merged. Calls
+// with DISTINCT or ORDER BY cannot.
+func (a *AggregateCall) Mergeable() bool {
+	_, ok := a.NewAccumulator().(Merger)
+	return ok
+}
+
+// AggregateFunction is an aggregate callable from SQL, such as SUM(x).
+type AggregateFunction struct {
+	Name      string
+	Params    []Param
+	Returns   Kind
+	AllowStar bool // accepts FUNC(*), which calls Step with no arguments once per row
+	// WithinGroup ordered-set aggregates also accept FUNC(args) WITHIN GROUP
+	// (ORDER BY value), which passes value as the first argument.
+	WithinGroup bool
+	// NullCall aggregates see rows whose arguments are NULL; for all others
+	// such rows are skipped.
+	NullCall bool
+	// New returns a fresh accumulator for one group.
+	New func() Accumulator
+}
+
+// Signature renders the aggregate's declared parameters.
+func (a *AggregateFunction) Signature() string {
+	f := Function{Name: a.Name, Params: a.Params, Returns: a.Returns}
+	if a.AllowStar && len(a.Params) == 0 {
+		return a.Name + "(*) -> " + a.Returns.String()
+	}
+	return f.Signature()
+}
+
+var builtinAggregates = map[string]*AggregateFunction{}
+
+func registerBuiltinAggregate(a *AggregateFunction) {
+	builtinAggregates[a.Name] = a
+}
+
+// AggregateCall is an aggregate invocation inside an expression, such as
+// SUM(price * qty). The query executor computes it once per group and stores
+// the result in the group's row under Slot, where EvalValue finds it.
+type AggregateCall struct {
+	Func     *AggregateFunction
+	Args     []ValueExpr
+	Star     bool       // called as FUNC(*)
+	Distinct bool       // FUNC(DISTINCT ...) feeds each distinct argument tuple once
+	OrderBy  []OrderKey // FUNC(... ORDER BY ...) feeds rows in this order
+	Filter   Expr       // FUNC(...) FILTER (WHERE ...) skips rows failing it
+	Text     string     // normalized source text, e.g. SUM(price * qty)
+	ArgText  string     // source text of the first argument, which names the call's column
+	Slot     string
+}
+
+// OrderKey is one sort key of an ordered aggregate.
+type OrderKey struct {
+	Expr ValueExpr
+	Desc bool
+}
+
+func (a *AggregateCall) EvalValue(row storage.Row) (interface{}, error) {
+	if a.Slot != "" {
+		if v, ok := row[a.Slot]; ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("aggregate %s is not allowed here", a.Text)
+}
+
+func (a *AggregateCall) children() []ValueExpr {
+	out := append([]ValueExpr{}, a.Args...)
+	for _, k := range a.OrderBy {
+		out = append(out, k.Expr)
+	}
+	if a.Filter != nil {
+		out = append(out, a.Filter.(ValueExpr))
+	}
+	return out
+}
+
+// NewAccumulator returns a fresh accumulator for one group, wrapped to apply
+// the call's DISTINCT and ORDER BY modifiers.
+func (a *AggregateCall) NewAccumulator() Accumulator {
+	acc := a.Func.New()
+	if a.Distinct {
+		acc = &distinctAcc{inner: acc, seen: map[string]bool{}}
+	}
+	if len(a.OrderBy) > 0 {
+		acc = &orderedAcc{inner: acc, keys: a.OrderBy}
+	}
+	return acc
+}
+
+// Step evaluates the call's arguments against row and feeds them to acc.
+func (a *AggregateCall) Step(acc Accumulator, row storage.Row) error {
+	if a.Filter != nil {
+		ok, err := a.Filter.Eval(row)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	if a.Star {
+		return acc.Step(nil)
+	}
+	args := make([]interface{}, len(a.Args))
+	for i, e := range a.Args {
+		v, err := e.EvalValue(row)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			if !a.Func.NullCall {
+				return nil
+			}
+			continue
+		}
+		p := paramAt(a.Func.Params, i)
+		cv, err := coerceArg(v, p.Kind)
+		if err != nil {
+			return fmt.Errorf("%s: argument %d (%s) %w", a.Func.Name, i+1, p.Name, err)
+		}
+		args[i] = cv
+	}
+	if o, ok := acc.(*orderedAcc); ok {
+		keys := make([]interface{}, len(a.OrderBy))
+		for i, k := range a.OrderBy {
+			v, err := k.Expr.EvalValue(row)
+			if err != nil {
+				return err
+			}
+			keys[i] = v
+		}
+		o.rows = append(o.rows, orderedRow{args: args, keys: keys})
+		return nil
+	}
+	if err := acc.Step(args); err != nil {
+		return fmt.Errorf("%s: %w", a.Func.Name, err)
+	}
+	return nil
+}
+
+func paramAt(params []Param, i int) Param {
+	if i >= len(params) {
+		return params[len(params)-1]
+	}
+	return params[i]
+}
+
+// CollectAggregates returns the aggregate calls in an expression in the order
+// they appear.
+func CollectAggregates(e interface{}) []*AggregateCall {
+	out := []*AggregateCall{}
+	var walk func(interface{})
+	walk = func(x interface{}) {
+		if a, ok := x.(*AggregateCall); ok {
+			out = append(out, a)
+			return
+		}
+		if n, ok := x.(node); ok {
+			for _, ch := range n.children() {
+				if ch != nil {
+					walk(ch)
+				}
+			}
+		}
+	}
+	if e != nil {
+		walk(e)
+	}
+	return out
+}
+
+// FreeColumns returns the columns an expression references outside of any
+// aggregate or GROUPING call, i.e. those that must be grouped on in a grouped
+// query.
+func FreeColumns(e interface{}) []string {
+	cols := []string{}
+	seen := map[string]struct{}{}
+	var walk func(interface{})
+	walk = func(x interface{}) {
+		switch v := x.(type) {
+		case *AggregateCall, *GroupingCall:
+			return
+		case *colRef:
+			if _, ok := seen[v.name]; !ok {
+				seen[v.name] = struct{}{}
+				cols = append(cols, v.name)
+			}
+			return
+		}
+		if n, ok := x.(node); ok {
+			for _, ch := range n.children() {
+				if ch != nil {
+					walk(ch)
+				}
+			}
+		}
+	}
+	if e != nil {
+		walk(e)
+	}
+	return cols
+}
+
+func (p *parser) parseAggregateCall(fn *AggregateFunction, start int) (ValueExpr, error) {
+	call := &AggregateCall{Func: fn}
+	if strings.EqualFold(p.cur(), "DISTINCT") {
+		p.eat()
+		call.Distinct = true
+	} else if strings.EqualFold(p.cur(), "ALL") {
+		p.eat()
+	}
+	argStart, argEnd := p.pos, p.pos
+	if p.cur() == "*" && p.peek(1) == ")" {
+		if !fn.AllowStar || call.Distinct {
+			return nil, fmt.Errorf("%s does not accept *", fn.Name)
+		}
+		p.eat()
+		call.Star = true
+	} else if p.cur() != ")" {
+		for {
+			a, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if len(CollectAggregates(a)) > 0 {
+				return nil, fmt.Errorf("%s: aggregate function calls cannot be nested", fn.Name)
+			}
+			call.Args = append(call.Args, a)
+			if len(call.Args) == 1 {
+				argEnd = p.pos
+			}
+			if p.cur() == "," {
+				p.eat()
+				continue
+			}
+			break
+		}
+	}
+	if strings.EqualFold(p.cur(), "ORDER") && strings.EqualFold(p.peek(1), "BY") {
+		keys, err := p.parseOrderKeys(fn.Name)
+		if err != nil {
+			return nil, err
+		}
+		call.OrderBy = keys
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name, err)
+	}
+	// ordered-set form: PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY x)
+	// passes the ordering expression as the first argument
+	if strings.EqualFold(p.cur(), "WITHIN") && strings.EqualFold(p.peek(1), "GROUP") {
+		if !fn.WithinGroup {
+			return nil, fmt.Errorf("%s does not support WITHIN GROUP", fn.Name)
+		}
+		p.eat()
+		p.eat()
+		if err := p.expect("("); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name, err)
+		}
+		if !strings.EqualFold(p.cur(), "ORDER") || !strings.EqualFold(p.peek(1), "BY") {
+			return nil, fmt.Errorf("%s: WITHIN GROUP expects ORDER BY", fn.Name)
+		}
+		keys, err := p.parseOrderKeys(fn.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) != 1 {
+			return nil, fmt.Errorf("%s: WITHIN GROUP expects exactly one sort key", fn.Name)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name, err)
+		}
+		args := []ValueExpr{keys[0].Expr}
+		for _, a := range call.Args {
+			if keys[0].Desc {
+				// a fraction of a descending order counts from the top
+				a = &arithOp{op: "-", left: &literal{val: int64(1)}, right: a}
+			}
+			args = append(args, a)
+		}
+		call.Args = args
+	}
+	if strings.EqualFold(p.cur(), "FILTER") && p.peek(1) == "(" {
+		p.eat()
+		p.eat()
+		if !strings.EqualFold(p.eat(), "WHERE") {
+			return nil, fmt.Errorf("%s: FILTER expects WHERE", fn.Name)
+		}
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if len(CollectAggregates(f)) > 0 {
+			return nil, fmt.Errorf("%s: aggregate function calls cannot be nested", fn.Name)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name, err)
+		}
+		call.Filter = asExpr(f)
+	}
+	if !call.Star {
+		decl := &Function{Params: fn.Params}
+		if len(call.Args) < decl.minArgs() || len(call.Args) > len(fn.Params) {
+			return nil, fmt.Errorf("%s expects %s, got %d argument(s)", fn.Name, arityText(decl), len(call.Args))
+		}
+		for i, a := range call.Args {
+			prm := fn.Params[i]
+			if err := checkKind(a, prm.Kind, nil); err != nil {
+				return nil, fmt.Errorf("%s: argument %d (%s) %w", fn.Name, i+1, prm.Name, err)
+			}
+		}
+	}
+	call.Text = joinTokens(p.toks[start:p.pos])
+	call.ArgText = joinTokens(p.toks[argStart:argEnd])
+	return call, nil
+}
+
+// parseOrderKeys parses ORDER BY expr [ASC|DESC] [, ...] inside an aggregate.
+func (p *parser) parseOrderKeys(name string) ([]OrderKey, error) {
+	p.eat() // ORDER
+	p.eat() // BY
+	keys := []OrderKey{}
+	for {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		k := OrderKey{Expr: e}
+		switch strings.ToUpper(p.cur()) {
+		case "DESC":
+			p.eat()
+			k.Desc = true
+		case "ASC":
+			p.eat()
+		}
+		keys = append(keys, k)
+		if p.cur() != "," {
+			break
+		}
+		p.eat()
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: ORDER BY expects a sort key", name)
+	}
+	return keys, nil
+}
+
+// joinTokens rebuilds expression text from tokens without the spaces the
+// tokenizer introduced around parentheses and commas.
+func joinTokens(toks []string) string {
+	s := strings.Join(toks, " ")
+	s = strings.ReplaceAll(s, " (", "(")
+	s = strings.ReplaceAll(s, "( ", "(")
+	s = strings.ReplaceAll(s, " )", ")")
+	s = strings.ReplaceAll(s, " ,", ",")
+	return s
+}
+
+// ----- built-in aggregates -----
+
+type countAcc struct{ n int }
+
+func (c *countAcc) Step(args []interface{}) error { c.n++; return nil }
+func (c *countAcc) Result() (interface{}, error)  { return c.n, nil }
+
+func (c *countAcc) Merge(other Accumulator) error {
+	c.n += other.(*countAcc).n
+	return nil
+}
+
+// sumAcc computes SUM and AVG. Integers and numerics are summed exactly:
+// SUM of integers is an integer, or NUMERIC once it no longer fits in 64
+// bits, and SUM of numerics is NUMERIC. Any float makes the result a float,
+// REAL when every input was REAL. AVG of exact values is NUMERIC.
+type sumAcc struct {
+	ints     int64
+	exact    Numeric // integers that overflowed ints, and numerics
+	floats   float64
+	count    int
+	numeric  bool // a NUMERIC input was seen
+	float    bool // a float input was seen
+	notReal  bool // an input other than REAL was seen
+	overflow bool
+	avg      bool
+}
+
+func (s *sumAcc) Step(args []interface{}) error {
+	if _, ok := args[0].(float32); !ok {
+		s.notReal = true
+	}
+	switch v := args[0].(type) {
+	case int:
+		s.addInt(int64(v))
+	case int32:
+		s.addInt(int64(v))
+	case int64:
+		s.addInt(v)
+	case Numeric:
+		s.exact = s.exact.Add(v)
+		s.numeric = true
+	case float32:
+		s.floats += float64(v)
+		s.float = true
+	case float64:
+		s.floats += v
+		s.float = true
+	default:
+		// non-numeric values are ignored, as imported TEXT columns often mix them in
+		i, f, isInt, ok := toNumber(v)
+		if !ok {
+			return nil
+		}
+		if isInt {
+			s.addInt(i)
+		} else {
+			s.floats += f
+			s.float = true
+		}
+	}
+	s.count++
+	return nil
+}
+
+func (s *sumAcc) addInt(i int64) {
+	r := s.ints + i
+	if (s.ints >= 0) == (i >= 0) && (r >= 0) != (i >= 0) {
+		s.exact = s.exact.Add(NumericFromInt(s.ints)).Add(NumericFromInt(i))
+		s.ints, s.overflow = 0, true
+		return
+	}
+	s.ints = r
+}
+
+func (s *sumAcc) Merge(other Accumulator) error {
+	o := other.(*sumAcc)
+	s.addInt(o.ints)
+	s.exact = s.exact.Add(o.exact)
+	s.floats += o.floats
+	s.count += o.count
+	s.numeric = s.numeric || o.numeric
+	s.float = s.float || o.float
+	s.notReal = s.notReal || o.notReal
+	s.overflow = s.overflow || o.overflow
+	return nil
+}
+
+// Result is NULL when no non-NULL value was seen.
+func (s *sumAcc) Result() (interface{}, error) {
+	if s.count == 0 {
+		return nil, nil
+	}
+	exact := s.exact.Add(NumericFromInt(s.ints))
+	if s.float {
+		f := s.floats + exact.Float64()
+		if s.avg {
+			return f / float64(s.count), nil
+		}
+		if !s.notReal {
+			return float32(f), nil
+		}
+		return f, nil
+	}
+	if s.avg {
+		return exact.Quo(NumericFromInt(int64(s.count)))
+	}
+	if !s.numeric && !s.overflow {
+		return s.ints, nil
+	}
+	return exact, nil
+}
+
+// extremeAcc keeps the smallest or largest value under compareOrder, so
+// MIN and MAX work on text and timestamps as well as numbers.
+type extremeAcc struct {
+	val  interface{}
+	seen bool
+	max  bool
+}
+
+func (e *extremeAcc) Step(args []interface{}) error {
+	v := args[0]
+	if !e.seen {
+		e.val, e.seen = v, true
+		return nil
+	}
+	c := compareOrder(v, e.val)
+	if (e.max && c > 0) || (!e.max && c < 0) {
+		e.val = v
+	}
+	return nil
+}
+
+func (e *extremeAcc) Result() (interface{}, error) { return e.val, nil }
+
+func (e *extremeAcc) Merge(other Accumulator) error {
+	if o := other.(*extremeAcc); o.seen {
+		return e.Step([]interface{}{o.val})
+	}
+	return nil
+}
+
+// distinctAcc passes each distinct argument tuple to inner once.
+type distinctAcc struct {
+	inner Accumulator
+	seen  map[string]bool
+}
+
+func (d *distinctAcc) Step(args []interface{}) error {
+	k := GroupKey(args...)
+	if d.seen[k] {
+		return nil
+	}
+	d.seen[k] = true
+	return d.inner.Step(args)
+}
+
+func (d *distinctAcc) Result() (interface{}, error) { return d.inner.Result() }
+
+type orderedRow struct {
+	args []interface{}
+	keys []interface{}
+}
+
+// orderedAcc buffers rows and replays them to inner sorted by the call's
+// ORDER BY keys. AggregateCall.Step fills rows directly, since the keys are
+// evaluated from the input row rather than the arguments.
+type orderedAcc struct {
+	inner Accumulator
+	keys  []OrderKey
+	rows  []orderedRow
+}
+
+func (o *orderedAcc) Step(args []interface{}) error {
+	o.rows = append(o.rows, orderedRow{args: args})
+	return nil
+}
+
+func (o *orderedAcc) Result() (interface{}, error) {
+	sort.SliceStable(o.rows, func(i, j int) bool {
+		for k, key := range o.keys {
+			c := compareOrder(o.rows[i].keys[k], o.rows[j].keys[k])
+			if c == 0 {
+				continue
+			}
+			if key.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	for _, r := range o.rows {
+		if err := o.inner.Step(r.args); err != nil {
+			return nil, err
+		}
+	}
+	o.rows = nil
+	return o.inner.Result()
+}
+
+// compareOrder orders two values for sorting: NULLs last, numbers by value,
+// timestamps chronologically, false before true, anything else as text.
+func compareOrder(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	a, b = unwrapJSON(a), unwrapJSON(b)
+	if at, bt, ok := asTimes(a, b); ok {
+		return at.Compare(bt)
+	}
+	if ai, bi, ok := asIntervals(a, b); ok {
+		return cmpInt64(ai.micros(), bi.micros())
+	}
+	if c, ok := compareExact(a, b); ok {
+		return c
+	}
+	if c, ok := compareComposite(a, b); ok {
+		return c
+	}
+	ab, aok := a.(bool)
+	bb, bok := b.(bool)
+	if aok && bok {
+		switch {
+		case ab == bb:
+			return 0
+		case bb:
+			return -1
+		}
+		return 1
+	}
+	af, anum := toFloat(a)
+	bf, bnum := toFloat(b)
+	if anum && bnum {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(formatText(a), formatText(b))
+}
+
+// statsAcc computes variance and standard deviation with Welford's method.
+type statsAcc struct {
+	n      int
+	mean   float64
+	m2     float64
+	pop    bool // population rather than sample statistics
+	stddev bool
+}
+
+func (s *statsAcc) Step(args []interface{}) error {
+	x, _ := toFloat(args[0])
+	s.n++
+	d := x - s.mean
+	s.mean += d / float64(s.n)
+	s.m2 += d * (x - s.mean)
+	return nil
+}
+
+// Merge combines the running statistics of two parts (Chan et al.).
+func (s *statsAcc) Merge(other Accumulator) error {
+	o := other.(*statsAcc)
+	if o.n == 0 {
+		return nil
+	}
+	n := s.n + o.n
+	d := o.mean - s.mean
+	s.m2 += o.m2 + d*d*float64(s.n)*float64(o.n)/float64(n)
+	s.mean += d * float64(o.n) / float64(n)
+	s.n = n
+	return nil
+}
+
+func (s *statsAcc) Result() (interface{}, error) {
+	div := float64(s.n)
+	if !s.pop {
+		div--
+	}
+	if div <= 0 {
+		return nil, nil
+	}
+	v := s.m2 / div
+	if s.stddev {
+		return math.Sqrt(v), nil
+	}
+	return v, nil
+}
+
+// percentileAcc collects values for MEDIAN and PERCENTILE_CONT/DISC. The
+// fraction is taken from the first row.
+type percentileAcc struct {
+	vals     []interface{}
+	fraction float64
+	fixed    bool // fraction is preset, as for MEDIAN
+	cont     bool // interpolate between neighbours instead of picking one
+}
+
+func (p *percentileAcc) Step(args []interface{}) error {
+	if !p.fixed {
+		f, _ := toFloat(args[1])
+		if f < 0 || f > 1 {
+			return fmt.Errorf("fraction must be between 0 and 1, got %v", args[1])
+		}
+		p.fraction, p.fixed = f, true
+	}
+	p.vals = append(p.vals, args[0])
+	return nil
+}
+
+func (p *percentileAcc) Merge(other Accumulator) error {
+	o := other.(*percentileAcc)
+	if !p.fixed {
+		p.fraction, p.fixed = o.fraction, o.fixed
+	}
+	p.vals = append(p.vals, o.vals...)
+	return nil
+}
+
+func (p *percentileAcc) Result() (interface{}, error) {
+	n := len(p.vals)
+	if n == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(p.vals, func(i, j int) bool { return compareOrder(p.vals[i], p.vals[j]) < 0 })
+	if !p.cont {
+		idx := int(math.Ceil(p.fraction*float64(n))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return p.vals[idx], nil
+	}
+	pos := p.fraction * float64(n-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	lf, _ := toFloat(p.vals[lo])
+	hf, _ := toFloat(p.vals[hi])
+	return lf + (hf-lf)*(pos-float64(lo)), nil
+}
+
+type stringAggAcc struct {
+	sb   strings.Builder
+	seen bool
+	lead string // the delimiter of the first row, which it does not write
+}
+
+func (s *stringAggAcc) Step(args []interface{}) error {
+	if s.seen && len(args) > 1 {
+		s.sb.WriteString(args[1].(string))
+	}
+	if !s.seen && len(args) > 1 {
+		s.lead = args[1].(string)
+	}
+	s.sb.WriteString(args[0].(string))
+	s.seen = true
+	return nil
+}
+
+func (s *stringAggAcc) Merge(other Accumulator) error {
+	o := other.(*stringAggAcc)
+	if !o.seen {
+		return nil
+	}
+	if s.seen {
+		s.sb.WriteString(o.lead)
+	} else {
+		s.lead = o.lead
+	}
+	s.sb.WriteString(o.sb.String())
+	s.seen = true
+	return nil
+}
+
+func (s *stringAggAcc) Result() (interface{}, error) {
+	if !s.seen {
+		return nil, nil
+	}
+	return s.sb.String(), nil
+}
+
+type arrayAggAcc struct{ vals []interface{} }
+
+func (a *arrayAggAcc) Step(args []interface{}) error {
+	a.vals = append(a.vals, args[0])
+	return nil
+}
+
+func (a *arrayAggAcc) Merge(other Accumulator) error {
+	a.vals = append(a.vals, other.(*arrayAggAcc).vals...)
+	return nil
+}
+
+func (a *arrayAggAcc) Result() (interface{}, error) {
+	if a.vals == nil {
+		return nil, nil
+	}
+	return a.vals, nil
+}
+
+type boolAcc struct {
+	val  bool
+	seen bool
+	or   bool
+}
+
+func (b *boolAcc) Step(args []interface{}) error {
+	v := args[0].(bool)
+	if !b.seen {
+		b.val, b.seen = v, true
+	} else if b.or {
+		b.val = b.val || v
+	} else {
+		b.val = b.val && v
+	}
+	return nil
+}
+
+func (b *boolAcc) Merge(other Accumulator) error {
+	if o := other.(*boolAcc); o.seen {
+		return b.Step([]interface{}{o.val})
+	}
+	return nil
+}
+
+func (b *boolAcc) Result() (interface{}, error) {
+	if !b.seen {
+		return nil, nil
+	}
+	return b.val, nil
+}
+
+func init() {
+	value := []Param{{Name: "value", Kind: KindAny}}
+	num := []Param{{Name: "value", Kind: KindNumeric}}
+	registerBuiltinAggregate(&AggregateFunction{Name: "COUNT", Params: value, Returns: KindInteger, AllowStar: true,
+		New: func() Accumulator { return &countAcc{} }})
+	registerBuiltinAggregate(&AggregateFunction{Name: "SUM", Params: value, Returns: KindNumeric,
+		New: func() Accumulator { return &sumAcc{} }})
+	registerBuiltinAggregate(&AggregateFunction{Name: "AVG", Params: value, Returns: KindNumeric,
+		New: func() Accumulator { return &sumAcc{avg: true} }})
+	registerBuiltinAggregate(&AggregateFunction{Name: "MIN", Params: value, Returns: KindAny,
+		New: func() Accumulator { return &extremeAcc{} }})
+	registerBuiltinAggregate(&AggregateFunction{Name: "MAX", Params: value, Returns: KindAny,
+		New: func() Accumulator { return &extremeAcc{max: true} }})
+
+	// ----- statistics -----
+	stats := func(name string, pop, stddev bool) {
+		registerBuiltinAggregate(&AggregateFunction{Name: name, Params: num, Returns: KindNumeric,
+			New: func() Accumulator { return &statsAcc{pop: pop, stddev: stddev} }})
+	}
+	stats("VARIANCE", false, false)
+	stats("VAR_SAMP", false, false)
+	stats("VAR_POP", true, false)
+	stats("STDDEV", false, true)
+	stats("STDDEV_SAMP", false, true)
+	stats("STDDEV_POP", true, true)
+	registerBuiltinAggregate(&AggregateFunction{Name: "MEDIAN", Params: num, Returns: KindNumeric,
+		New: func() Accumulator { return &percentileAcc{fraction: 0.5, fixed: true, cont: true} }})
+	registerBuiltinAggregate(&AggregateFunction{Name: "PERCENTILE_CONT", Returns: KindNumeric, WithinGroup: true,
+		Params: []Param{{Name: "value", Kind: KindNumeric}, {Name: "fraction", Kind: KindNumeric}},
+		New:    func() Accumulator { return &percentileAcc{cont: true} }})
+	registerBuiltinAggregate(&AggregateFunction{Name: "PERCENTILE_DISC", Returns: KindAny, WithinGroup: true,
+		Params: []Param{{Name: "value", Kind: KindAny}, {Name: "fraction", Kind: KindNumeric}},
+		New:    func() Accumulator { return &percentileAcc{} }})
+
+	// ----- collections -----
+	registerBuiltinAggregate(&AggregateFunction{Name: "STRING_AGG", Returns: KindText,
+		Params: []Param{{Name: "value", Kind: KindText}, {Name: "delimiter", Kind: KindText, Optional: true}},
+		New:    func() Accumulator { return &stringAggAcc{} }})
+	registerBuiltinAggregate(&AggregateFunction{Name: "ARRAY_AGG", Params: value, Returns: KindAny, NullCall: true,
+		New: func() Accumulator { return &arrayAggAcc{} }})
+
+	// ----- boolean -----
+	boolean := []Param{{Name: "value", Kind: KindBoolean}}
+	registerBuiltinAggregate(&AggregateFunction{Name: "BOOL_AND", Params: boolean, Returns: KindBoolean,
+		New: func() Accumulator { return &boolAcc{} }})
+	registerBuiltinAggregate(&AggregateFunction{Name: "EVERY", Params: boolean, Returns: KindBoolean,
+		New: func() Accumulator { return &boolAcc{} }})
+	registerBuiltinAggregate(&AggregateFunction{Name: "BOOL_OR", Params: boolean, Returns: KindBoolean,
+		New: func() Accumulator { return &boolAcc{or: true} }})
+}
+
+// sortedAggregates returns the aggregates in m sorted by name.
+func sortedAggregates(m map[string]*AggregateFunction) []*AggregateFunction {
+	out := make([]*AggregateFunction, 0, len(m))
+	for _, a := range m {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,191 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"Custom_DB/pkg/storage"
+)
+
+// aggregate evaluates an expression containing aggregate calls over rows as
+// a single group.
+func aggregate(t *testing.T, raw string, rows []storage.Row) interface{} {
+	t.Helper()
+	v, err := ParseValue(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	calls := CollectAggregates(v)
+	group := storage.Row{}
+	for i, c := range calls {
+		c.Slot = string(rune('a' + i))
+		acc := c.NewAccumulator()
+		for _, r := range rows {
+			if err := c.Step(acc, r); err != nil {
+				t.Fatalf("step %q: %v", raw, err)
+			}
+		}
+		res, err := acc.Result()
+		if err != nil {
+			t.Fatalf("result %q: %v", raw, err)
+		}
+		group[c.Slot] = res
+	}
+	out, err := v.EvalValue(group)
+	if err != nil {
+		t.Fatalf("eval %q: %v", raw, err)
+	}
+	return out
+}
+
+func TestAggregateFunctions(t *testing.T) {
+	rows := []storage.Row{
+		{"name": "cy", "x": 4.0, "ok": true},
+		{"name": "al", "x": 2.0, "ok": true},
+		{"name": "bo", "x": 4.0, "ok": false},
+		{"name": nil, "x": 10.0, "ok": nil},
+	}
+	cases := []struct {
+		raw  string
+		want interface{}
+	}{
+		{"COUNT(DISTINCT x)", 3},
+		{"COUNT(name)", 3},
+		{"MIN(name)", "al"},
+		{"MAX(name)", "cy"},
+		{"MAX(x)", 10.0},
+		{"MEDIAN(x)", 4.0},
+		{"PERCENTILE_CONT(0.25) WITHIN GROUP (ORDER BY x)", 3.5},
+		{"PERCENTILE_DISC(0.5) WITHIN GROUP (ORDER BY x)", 4.0},
+		{"PERCENTILE_DISC(0.25) WITHIN GROUP (ORDER BY x DESC)", 4.0},
+		{"PERCENTILE_CONT(x, 1)", 10.0},
+		{"VAR_POP(x)", 9.0},
+		{"VARIANCE(x)", 12.0},
+		{"STDDEV_POP(x)", 3.0},
+		{"STRING_AGG(name, ',' ORDER BY name)", "al,bo,cy"},
+		{"STRING_AGG(DISTINCT CAST(x AS TEXT), '-' ORDER BY x DESC)", "10-4-2"},
+		{"ARRAY_AGG(name ORDER BY x, name)", `["al","bo","cy",null]`},
+		{"BOOL_AND(ok)", false},
+		{"BOOL_OR(ok)", true},
+		{"COUNT(*) FILTER (WHERE x > 3)", 3},
+		{"SUM(x) FILTER (WHERE name LIKE '%o')", 4.0},
+		{"COUNT(*) - COUNT(name)", int64(1)},
+	}
+	for _, c := range cases {
+		got := aggregate(t, c.raw, rows)
+		if arr, ok := got.([]interface{}); ok {
+			got = FormatValue(arr)
+		}
+		if f, ok := got.(float64); ok && math.Abs(f-toFloatMust(c.want)) < 1e-9 {
+			continue
+		}
+		if got != c.want {
+			t.Fatalf("%q: got %#v, want %#v", c.raw, got, c.want)
+		}
+	}
+}
+
+func toFloatMust(v interface{}) float64 {
+	f, _ := toFloat(v)
+	return f
+}
+
+func TestAggregateEmptyGroups(t *testing.T) {
+	for _, raw := range []string{"SUM(x)", "AVG(x)", "MIN(x)", "MEDIAN(x)", "STDDEV(x)", "STRING_AGG(name, ',')", "BOOL_AND(ok)", "ARRAY_AGG(x)"} {
+		if got := aggregate(t, raw, nil); got != nil {
+			t.Fatalf("%q over no rows: got %#v, want NULL", raw, got)
+		}
+	}
+	if got := aggregate(t, "STDDEV(x)", []storage.Row{{"x": 1}}); got != nil {
+		t.Fatalf("sample stddev of one value: got %#v, want NULL", got)
+	}
+	if got := aggregate(t, "SUM(x)", []storage.Row{{"x": nil}, {}}); got != nil {
+		t.Fatalf("SUM over only NULLs: got %#v, want NULL", got)
+	}
+}
+
+func TestAggregateSyntaxErrors(t *testing.T) {
+	for _, raw := range []string{
+		"SUM(*)",
+		"COUNT(DISTINCT *)",
+		"SUM(x) WITHIN GROUP (ORDER BY x)",
+		"PERCENTILE_CONT(0.5) WITHIN GROUP (x)",
+		"COUNT(*) FILTER (x > 1)",
+		"MEDIAN(x) FILTER (WHERE SUM(x) > 1)",
+		"MEDIAN('abc')",
+	} {
+		if _, err := ParseValue(raw); err == nil {
+			t.Fatalf("expected parse error for %q", raw)
+		}
+	}
+	v, err := ParseValue("PERCENTILE_CONT(x, 2)")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	c := CollectAggregates(v)[0]
+	if err := c.Step(c.NewAccumulator(), storage.Row{"x": 1}); err == nil {
+		t.Fatalf("expected out-of-range fraction error")
+	}
+}
+
+func TestAccumulatorMerge(t *testing.T) {
+	rows := []storage.Row{}
+	for i := 0; i < 20; i++ {
+		r := storage.Row{"name": string(rune('a' + i%7)), "x": float64(i*i%11) + 0.5, "n": int64(i), "ok": i%3 != 0}
+		if i%5 == 0 {
+			r["name"], r["x"] = nil, nil
+		}
+		rows = append(rows, r)
+	}
+	for _, raw := range []string{
+		"COUNT(*)", "COUNT(name)", "SUM(n)", "SUM(x)", "AVG(n)", "MIN(name)", "MAX(x)",
+		"VAR_SAMP(x)", "STDDEV_POP(x)", "MEDIAN(x)", "STRING_AGG(name, '-')", "STRING_AGG(name)",
+		"ARRAY_AGG(name)", "BOOL_AND(ok)", "BOOL_OR(ok)", "COUNT(*) FILTER (WHERE x > 3)",
+	} {
+		v, err := ParseValue(raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", raw, err)
+		}
+		c := CollectAggregates(v)[0]
+		if !c.Mergeable() {
+			t.Errorf("%s: expected a mergeable aggregate", raw)
+			continue
+		}
+		whole := c.NewAccumulator()
+		for _, r := range rows {
+			if err := c.Step(whole, r); err != nil {
+				t.Fatalf("step %q: %v", raw, err)
+			}
+		}
+		// the same rows in parts, one of them empty, merged in order
+		merged := c.NewAccumulator().(Merger)
+		for _, part := range [][]storage.Row{rows[:1], rows[1:1], rows[1:8], rows[8:]} {
+			acc := c.NewAccumulator()
+			for _, r := range part {
+				if err := c.Step(acc, r); err != nil {
+					t.Fatalf("step %q: %v", raw, err)
+				}
+			}
+			if err := merged.Merge(acc); err != nil {
+				t.Fatalf("merge %q: %v", raw, err)
+			}
+		}
+		want, _ := whole.Result()
+		got, _ := merged.Result()
+		if f, ok := want.(float64); ok && math.Abs(f-toFloatMust(got)) < 1e-9 {
+			continue
+		}
+		if FormatValue(got) != FormatValue(want) {
+			t.Errorf("%s: merged %v, want %v", raw, FormatValue(got), FormatValue(want))
+		}
+	}
+	for _, raw := range []string{"COUNT(DISTINCT name)", "STRING_AGG(name, ',' ORDER BY x)"} {
+		v, err := ParseValue(raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", raw, err)
+		}
+		if CollectAggregates(v)[0].Mergeable() {
+			t.Errorf("%s: expected no merging", raw)
+		}
+	}
+}
@@ -0,0 +1,609 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// Array is the value of an ARRAY<T> column. Like JSON it holds its elements
+// as canonical JSON text, so equal arrays compare, group and index alike, and
+// it is written to storage as the nested JSON array itself. elem is the type
+// the elements read back as; it is empty for an array taken from a JSON
+// document, whose elements stay JSON values.
+type Array struct {
+	elem schema.DataType
+	text string
+}
+
+// Struct is the value of a STRUCT<...> column: a JSON object holding the
+// fields in declared order, and the struct type that names and types them.
+type Struct struct {
+	typ  schema.DataType
+	text string
+}
+
+// String returns the elements as JSON text.
+func (a Array) String() string {
+	if a.text == "" {
+		return "[]"
+	}
+	return a.text
+}
+
+// MarshalJSON writes the array itself, so stored rows keep it nested.
+func (a Array) MarshalJSON() ([]byte, error) { return []byte(a.String()), nil }
+
+// elements decodes the elements to their SQL values.
+func (a Array) elements() []interface{} {
+	doc, _ := decodeJSON(a.String())
+	items, _ := doc.([]interface{})
+	out := make([]interface{}, len(items))
+	for i, it := range items {
+		v, err := fromDoc(it, a.elem)
+		if err != nil {
+			v = jsonValue(it)
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// String returns the fields as a JSON object.
+func (s Struct) String() string {
+	if s.text == "" {
+		return "{}"
+	}
+	return s.text
+}
+
+// MarshalJSON writes the struct as a nested JSON object.
+func (s Struct) MarshalJSON() ([]byte, error) { return []byte(s.String()), nil }
+
+// field returns the value of the field called name, matched without regard
+// to case. ok is false when the struct has no such field.
+func (s Struct) field(name string) (interface{}, bool) {
+	fields, _ := s.typ.StructFields()
+	doc, _ := decodeJSON(s.String())
+	members, _ := doc.(map[string]interface{})
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			v, err := fromDoc(members[f.Name], f.Type)
+			if err != nil {
+				v = jsonValue(members[f.Name])
+			}
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// values returns the field values in declared order.
+func (s Struct) values() []interface{} {
+	fields, _ := s.typ.StructFields()
+	out := make([]interface{}, len(fields))
+	for i, f := range fields {
+		out[i], _ = s.field(f.Name)
+	}
+	return out
+}
+
+// newArray converts each item to elem with conv and builds the array.
+func newArray(items []interface{}, elem schema.DataType, conv func(interface{}, schema.DataType) (interface{}, error)) (Array, error) {
+	vals := make([]interface{}, len(items))
+	for i, it := range items {
+		v, err := conv(it, elem)
+		if err != nil {
+			return Array{}, fmt.Errorf("array element %d: %w", i+1, err)
+		}
+		vals[i] = v
+	}
+	j, err := jsonOf(vals)
+	if err != nil {
+		return Array{}, err
+	}
+	return Array{elem: elem, text: j.text}, nil
+}
+
+// newStruct converts the members named like the fields of struct type t
+// with conv and builds the struct. Missing fields are NULL; a member that
+// names no field is an error.
+func newStruct(members map[string]interface{}, t schema.DataType, conv func(interface{}, schema.DataType) (interface{}, error)) (Struct, error) {
+	fields, ok := t.StructFields()
+	if !ok {
+		return Struct{}, fmt.Errorf("invalid struct type %s", t)
+	}
+	find := func(name string) (interface{}, bool) {
+		if v, ok := members[name]; ok {
+			return v, true
+		}
+		for k, v := range members {
+			if strings.EqualFold(k, name) {
+				return v, true
+			}
+		}
+		return nil, false
+	}
+	for k := range members {
+		known := false
+		for _, f := range fields {
+			known = known || strings.EqualFold(f.Name, k)
+		}
+		if !known {
+			return Struct{}, fmt.Errorf("%s has no field '%s'", t, k)
+		}
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, f := range fields {
+		raw, _ := find(f.Name)
+		v, err := conv(raw, f.Type)
+		if err != nil {
+			return Struct{}, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		key, _ := jsonOf(f.Name)
+		val, err := jsonOf(v)
+		if err != nil {
+			return Struct{}, err
+		}
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(key.text + ":" + val.text)
+	}
+	sb.WriteByte('}')
+	return Struct{typ: t, text: sb.String()}, nil
+}
+
+// fromDoc converts an element of a decoded JSON document, or a nested value
+// read from storage, to type t. An empty t keeps it as a JSON value.
+func fromDoc(raw interface{}, t schema.DataType) (interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	switch t.Base() {
+	case "":
+		return jsonValue(raw), nil
+	case schema.JSON:
+		return jsonOf(raw)
+	case schema.Array:
+		items, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %s to %s", docText(raw), t)
+		}
+		elem, _ := t.ElementType()
+		return newArray(items, elem, fromDoc)
+	case schema.Struct:
+		members, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %s to %s", docText(raw), t)
+		}
+		return newStruct(members, t, fromDoc)
+	}
+	switch n := raw.(type) {
+	case json.Number:
+		raw = jsonNumber(n)
+	case []interface{}, map[string]interface{}:
+		return nil, fmt.Errorf("cannot convert %s to %s", docText(raw), t)
+	}
+	return CastValue(raw, t)
+}
+
+// docText renders a document element for error messages.
+func docText(raw interface{}) string {
+	j, err := jsonOf(raw)
+	if err != nil {
+		return fmt.Sprint(raw)
+	}
+	return j.text
+}
+
+// castNested converts v to an ARRAY<T> or STRUCT<...> type t. Text is read
+// as JSON, JSON arrays and objects convert element by element, and arrays
+// and structs of other types cast each element or field.
+func castNested(v interface{}, t schema.DataType) (interface{}, error) {
+	switch x := v.(type) {
+	case Array:
+		elem, ok := t.ElementType()
+		switch {
+		case !ok:
+		case x.elem == elem:
+			return x, nil
+		default:
+			return newArray(x.elements(), elem, CastValue)
+		}
+	case Struct:
+		if x.typ == t {
+			return x, nil
+		}
+		if t.Base() == schema.Struct {
+			fields, _ := x.typ.StructFields()
+			members := map[string]interface{}{}
+			for i, fv := range x.values() {
+				members[fields[i].Name] = fv
+			}
+			return newStruct(members, t, CastValue)
+		}
+	case JSON:
+		return fromDoc(x.doc(), t)
+	case string:
+		doc, err := decodeJSON(x)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input syntax for type %s: %w", t, err)
+		}
+		return fromDoc(doc, t)
+	case []interface{}, map[string]interface{}:
+		return fromDoc(x, t)
+	}
+	return nil, fmt.Errorf("cannot cast '%v' to %s", v, t)
+}
+
+// toArray converts a function argument to an array: arrays as they are and
+// JSON arrays, or text holding one, as arrays of JSON values.
+func toArray(v interface{}) (Array, error) {
+	switch x := v.(type) {
+	case Array:
+		return x, nil
+	case JSON, string:
+		if a, err := castNested(x, schema.ArrayType("")); err == nil {
+			return a.(Array), nil
+		}
+	}
+	return Array{}, fmt.Errorf("'%v' is not an array", v)
+}
+
+// valueType returns the column type an ARRAY[...] constructor stores v as.
+func valueType(v interface{}) schema.DataType {
+	switch t := v.(type) {
+	case string:
+		return schema.Text
+	case bool:
+		return schema.Boolean
+	case int, int32:
+		return schema.Integer
+	case int64:
+		if t < math.MinInt32 || t > math.MaxInt32 {
+			return schema.BigInt
+		}
+		return schema.Integer
+	case float32, float64:
+		return schema.Double
+	case Numeric:
+		return schema.Numeric
+	case Date:
+		return schema.Date
+	case time.Time:
+		return schema.Timestamp
+	case TimestampTZ:
+		return schema.TimestampTZ
+	case Interval:
+		return schema.Interval
+	case JSON:
+		return schema.JSON
+	case Array:
+		if t.elem == "" {
+			return schema.ArrayType(schema.JSON)
+		}
+		return schema.ArrayType(t.elem)
+	case Struct:
+		return t.typ
+	}
+	return ""
+}
+
+// commonType returns the type both a and b convert to: the wider of two
+// numeric types, TIMESTAMP for a date and a timestamp, and otherwise a only
+// when the types are the same.
+func commonType(a, b schema.DataType) (schema.DataType, bool) {
+	if a == b {
+		return a, true
+	}
+	rank := map[schema.DataType]int{schema.SmallInt: 1, schema.Integer: 2, schema.BigInt: 3, schema.Numeric: 4, schema.Double: 5}
+	if ra, rb := rank[a.Base()], rank[b.Base()]; ra > 0 && rb > 0 {
+		if ra == rb {
+			return a.Base(), true
+		}
+		if ra > rb {
+			return a, true
+		}
+		return b, true
+	}
+	if (a == schema.Date && b == schema.Timestamp) || (a == schema.Timestamp && b == schema.Date) {
+		return schema.Timestamp, true
+	}
+	return "", false
+}
+
+// compareComposite orders two arrays element by element, a prefix before
+// the longer array, and two structs field by field. ok is false unless both
+// values are arrays or both are structs.
+func compareComposite(a, b interface{}) (int, bool) {
+	var av, bv []interface{}
+	switch x := a.(type) {
+	case Array:
+		y, ok := b.(Array)
+		if !ok {
+			return 0, false
+		}
+		av, bv = x.elements(), y.elements()
+	case Struct:
+		y, ok := b.(Struct)
+		if !ok {
+			return 0, false
+		}
+		av, bv = x.values(), y.values()
+	default:
+		return 0, false
+	}
+	for i := 0; i < len(av) && i < len(bv); i++ {
+		if c := compareOrder(av[i], bv[i]); c != 0 {
+			return c, true
+		}
+	}
+	return cmpInt64(int64(len(av)), int64(len(bv))), true
+}
+
+// ARRAY[a, b, ...] constructor node
+type arrayCtor struct{ elems []ValueExpr }
+
+// EvalValue builds an array whose element type is the common type of the
+// non-NULL elements; an array of NULLs only is an array of TEXT.
+func (c *arrayCtor) EvalValue(row storage.Row) (interface{}, error) {
+	vals := make([]interface{}, len(c.elems))
+	elem := schema.DataType("")
+	for i, e := range c.elems {
+		v, err := e.EvalValue(row)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+		if v == nil {
+			continue
+		}
+		t := valueType(v)
+		if t == "" {
+			return nil, fmt.Errorf("ARRAY: cannot store '%v' in an array", v)
+		}
+		if elem == "" {
+			elem = t
+			continue
+		}
+		common, ok := commonType(elem, t)
+		if !ok {
+			return nil, fmt.Errorf("ARRAY: elements of types %s and %s cannot be mixed", elem, t)
+		}
+		elem = common
+	}
+	if elem == "" {
+		elem = schema.Text
+	}
+	return newArray(vals, elem, CastValue)
+}
+
+func (c *arrayCtor) children() []ValueExpr { return c.elems }
+
+// array[index] node; indexes start at 1
+type subscriptOp struct {
+	left  ValueExpr
+	index ValueExpr
+}
+
+// EvalValue returns the element at the index, or NULL when the index is
+// out of range.
+func (s *subscriptOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := s.left.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := s.index.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil || iv == nil {
+		return nil, nil
+	}
+	arr, err := toArray(lv)
+	if err != nil {
+		return nil, fmt.Errorf("cannot subscript: %w", err)
+	}
+	i, err := coerceArg(iv, KindInteger)
+	if err != nil {
+		return nil, fmt.Errorf("array subscript %w", err)
+	}
+	elems := arr.elements()
+	n := i.(int64)
+	if n < 1 || n > int64(len(elems)) {
+		return nil, nil
+	}
+	return elems[n-1], nil
+}
+
+func (s *subscriptOp) children() []ValueExpr { return []ValueExpr{s.left, s.index} }
+
+// struct.field node; also reads a member of a JSON object
+type fieldOp struct {
+	left ValueExpr
+	name string
+}
+
+func (f *fieldOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := f.left.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	switch v := lv.(type) {
+	case nil:
+		return nil, nil
+	case Struct:
+		fv, ok := v.field(f.name)
+		if !ok {
+			return nil, fmt.Errorf("%s has no field '%s'", v.typ, f.name)
+		}
+		return fv, nil
+	case JSON:
+		member, _ := jsonStep(v.doc(), f.name)
+		return jsonValue(member), nil
+	case storage.BlobRef:
+		return blobField(v, f.name)
+	}
+	return nil, fmt.Errorf("cannot read field '%s' of '%v': not a struct", f.name, lv)
+}
+
+func (f *fieldOp) children() []ValueExpr { return []ValueExpr{f.left} }
+
+// x op ANY (array) and x op ALL (array) node. ANY is true when the
+// comparison holds for some element and ALL when it holds for every one;
+// otherwise a NULL operand or element makes the result NULL. ANY over an
+// empty array is false and ALL is true.
+type quantifiedOp struct {
+	op    string
+	left  ValueExpr
+	right ValueExpr
+	all   bool
+}
+
+func (q *quantifiedOp) Eval(row storage.Row) (bool, error) { return evalTrue(q, row) }
+
+func (q *quantifiedOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := q.left.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := q.right.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	if rv == nil {
+		return nil, nil
+	}
+	arr, err := toArray(rv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", q.name(), err)
+	}
+	elems := arr.elements()
+	if len(elems) == 0 {
+		return q.all, nil
+	}
+	if lv == nil {
+		return nil, nil
+	}
+	sawNull := false
+	for _, e := range elems {
+		if e == nil {
+			sawNull = true
+			continue
+		}
+		ok, err := compareValues(q.op, lv, e)
+		if err != nil {
+			return nil, err
+		}
+		if ok != q.all {
+			// a match decides ANY and a mismatch decides ALL
+			return ok, nil
+		}
+	}
+	if sawNull {
+		return nil, nil
+	}
+	return q.all, nil
+}
+
+func (q *quantifiedOp) name() string {
+	if q.all {
+		return "ALL"
+	}
+	return "ANY"
+}
+
+func (q *quantifiedOp) children() []ValueExpr { return []ValueExpr{q.left, q.right} }
+
+// parseArrayCtor parses ARRAY[a, b, ...].
+func (p *parser) parseArrayCtor() (ValueExpr, error) {
+	p.eat() // ARRAY
+	p.eat() // [
+	c := &arrayCtor{}
+	if p.cur() != "]" {
+		for {
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			c.elems = append(c.elems, e)
+			if p.cur() != "," {
+				break
+			}
+			p.eat()
+		}
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, fmt.Errorf("ARRAY: %w", err)
+	}
+	return c, nil
+}
+
+// parseSubscript parses [index] following left.
+func (p *parser) parseSubscript(left ValueExpr) (ValueExpr, error) {
+	p.eat() // [
+	index, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("array subscript: %w", err)
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, fmt.Errorf("array subscript: %w", err)
+	}
+	return &subscriptOp{left: left, index: index}, nil
+}
+
+// fieldPath applies the field names of a dotted path such as .city or
+// .address.city to left.
+func fieldPath(left ValueExpr, path string) (ValueExpr, error) {
+	for _, name := range strings.Split(path, ".") {
+		if name == "" {
+			return nil, fmt.Errorf("missing field name in '%s'", path)
+		}
+		left = &fieldOp{left: left, name: strings.Trim(name, "`\"")}
+	}
+	return left, nil
+}
+
+// parseQuantified parses ANY (array), SOME (array) or ALL (array) following
+// the comparison operator op.
+func (p *parser) parseQuantified(left ValueExpr, op string) (ValueExpr, error) {
+	q := strings.ToUpper(p.eat())
+	p.eat() // (
+	right, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", q, err)
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, fmt.Errorf("%s: %w", q, err)
+	}
+	return &quantifiedOp{op: op, left: left, right: right, all: q == "ALL"}, nil
+}
+
+func init() {
+	array := Param{Name: "array", Kind: KindArray}
+	length := func(args []interface{}) (interface{}, error) {
+		return int64(len(args[0].(Array).elements())), nil
+	}
+	registerBuiltin(&Function{Name: "CARDINALITY", Params: []Param{array}, Returns: KindInteger, Impl: length})
+	registerBuiltin(&Function{Name: "ARRAY_LENGTH", Params: []Param{array}, Returns: KindInteger, Impl: length})
+
+	registerTableFunction(&TableFunction{
+		Name:   "UNNEST",
+		Params: []Param{array},
+		// the element type depends on the argument
+		Columns: []schema.Column{{Name: "unnest"}},
+		Impl: func(args []interface{}) ([]storage.Row, error) {
+			rows := []storage.Row{}
+			for _, e := range args[0].(Array).elements() {
+				rows = append(rows, storage.Row{"unnest": e})
+			}
+			return rows, nil
+		}})
+}
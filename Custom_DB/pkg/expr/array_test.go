@@ -0,0 +1,151 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func TestArrayAndStructValues(t *testing.T) {
+	addrType, _ := schema.ResolveTypeName("STRUCT<city TEXT, zip INT, since DATE>")
+	addr, err := CastValue(`{"since": "2020-02-03", "city": "Oslo", "zip": "150"}`, addrType)
+	if err != nil {
+		t.Fatalf("cast struct: %v", err)
+	}
+	// fields keep their declared order and types
+	if got := FormatValue(addr); got != `{"city":"Oslo","zip":150,"since":"2020-02-03"}` {
+		t.Fatalf("struct = %s", got)
+	}
+	tags, err := CastValue(`["red", null, "blue"]`, schema.ArrayType(schema.Text))
+	if err != nil {
+		t.Fatalf("cast array: %v", err)
+	}
+	visitsType, _ := schema.ResolveTypeName("ARRAY<STRUCT<day DATE, n INT>>")
+	visits, err := CastValue(`[{"day": "2024-01-02", "n": 1}, {"day": "2024-03-04", "n": 3}]`, visitsType)
+	if err != nil {
+		t.Fatalf("cast array of structs: %v", err)
+	}
+	row := storage.Row{"addr": addr, "tags": tags, "visits": visits, "scores": DecodeValue([]interface{}{int64(4), int64(9)}, "ARRAY<INT>", time.UTC)}
+
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{"tags[1]", "red"},
+		{"tags[2]", "NULL"},
+		{"tags[4]", "NULL"},
+		{"tags[0]", "NULL"},
+		{"addr.city", "Oslo"},
+		{"addr.zip + 1", "151"},
+		{"addr.since + INTERVAL '1 day'", "2020-02-04"},
+		{"visits[2].day", "2024-03-04"},
+		{"visits[1].n * 10", "10"},
+		{"CARDINALITY(visits)", "2"},
+		{"ARRAY_LENGTH(tags)", "3"},
+		{"scores[1] + scores[2]", "13"},
+		{"ARRAY[1, 2.5, NULL]", "[1,2.5,null]"},
+		{"ARRAY[DATE '2024-01-01']", `["2024-01-01"]`},
+		{"ARRAY['a', 'b'][2]", "b"},
+		{"CAST('[1, 2]' AS INT[])", "[1,2]"},
+		{"CARDINALITY('[1, 2, 3]')", "3"},
+	}
+	for _, c := range cases {
+		v, err := ParseValue(c.raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.raw, err)
+		}
+		got, err := v.EvalValue(row)
+		if err != nil {
+			t.Fatalf("eval %q: %v", c.raw, err)
+		}
+		if FormatValue(got) != c.want {
+			t.Fatalf("%q = %s, want %s", c.raw, FormatValue(got), c.want)
+		}
+	}
+
+	conds := map[string]interface{}{
+		"'blue' = ANY(tags)":                      true,
+		"'green' = ANY(tags)":                     nil, // the NULL element might be green
+		"'green' = ANY(ARRAY['red', 'blue'])":     false,
+		"3 < ALL(scores)":                         true,
+		"5 < ALL(scores)":                         false,
+		"5 < SOME(scores)":                        true,
+		"1 = ANY(ARRAY[])":                        false,
+		"NULL = ANY(scores)":                      nil,
+		"1 = ALL(CAST('[]' AS INT[]))":            true,
+		"scores = ARRAY[4, 9]":                    true,
+		"scores < ARRAY[4, 9, 0]":                 true,
+		"addr.zip IN (150, 151)":                  true,
+		"visits[1] < visits[2]":                   true,
+		"addr.city = 'Oslo' AND tags[3] = 'blue'": true,
+	}
+	for raw, want := range conds {
+		v, err := ParseValue(raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", raw, err)
+		}
+		got, err := v.EvalValue(row)
+		if err != nil || got != want {
+			t.Fatalf("%q = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+
+	for _, raw := range []string{"addr.country", "tags.city", "addr[1]", "tags['x']", "ARRAY[1, 'a']", "CAST('[\"x\"]' AS INT[])", "CAST('{\"town\": 1}' AS STRUCT<city TEXT>)"} {
+		v, err := ParseValue(raw)
+		if err == nil {
+			_, err = v.EvalValue(row)
+		}
+		if err == nil {
+			t.Fatalf("expected an error for %q", raw)
+		}
+	}
+}
+
+func TestArrayStorageRoundTrip(t *testing.T) {
+	typ, _ := schema.ResolveTypeName("ARRAY<STRUCT<at TIMESTAMP, price NUMERIC(6,2)>>")
+	v, err := CastValue(`[{"at": "2024-05-06 07:08:09", "price": 1.5}]`, typ)
+	if err != nil {
+		t.Fatalf("cast: %v", err)
+	}
+	b, _ := v.(Array).MarshalJSON()
+	// storage hands nested values back decoded, with numbers as int64 or
+	// float64; they read back as the same array
+	stored := []interface{}{map[string]interface{}{"at": "2024-05-06T07:08:09Z", "price": "1.50"}}
+	back := DecodeValue(stored, typ, time.UTC)
+	if back != v {
+		t.Fatalf("DecodeValue = %#v, want %#v (stored as %s)", back, v, b)
+	}
+	if GroupKey(back) != GroupKey(v) {
+		t.Fatalf("equal arrays should group together")
+	}
+	if DecodeValue("not an array", typ, time.UTC) != "not an array" {
+		t.Fatalf("values that do not convert are returned unchanged")
+	}
+}
+
+func TestUnnest(t *testing.T) {
+	call, err := ParseTableCall("UNNEST(tags) AS tag")
+	if err != nil {
+		t.Fatalf("ParseTableCall: %v", err)
+	}
+	if cols := call.OutputColumns(); len(cols) != 1 || cols[0].Name != "tag" {
+		t.Fatalf("OutputColumns = %v", cols)
+	}
+	tags, _ := CastValue(`["a", "b"]`, schema.ArrayType(schema.Text))
+	rows, err := call.Rows(storage.Row{"tags": tags})
+	if err != nil || len(rows) != 2 || rows[1]["tag"] != "b" {
+		t.Fatalf("UNNEST rows = %v, %v", rows, err)
+	}
+	call, _ = ParseTableCall("UNNEST(ARRAY[3, 1])")
+	rows, _ = call.Rows(storage.Row{})
+	if len(rows) != 2 || rows[0]["unnest"] != int64(3) {
+		t.Fatalf("UNNEST of a constructor = %v", rows)
+	}
+	for _, bad := range []string{"UNNEST(5)", "UNNEST(tags) AS", "UNNEST(tags) AS a b", "JSON_EACH('[1]') AS x"} {
+		if _, err := ParseTableCall(bad); err == nil {
+			t.Fatalf("expected an error for %q", bad)
+		}
+	}
+}
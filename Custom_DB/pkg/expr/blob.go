@@ -0,0 +1,163 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+
+	"Custom_DB/pkg/imaging"
+	"Custom_DB/pkg/storage"
+)
+
+// BLOB and IMAGE values are storage.BlobRef references to content in the
+// database's blob store. They compare and group by digest, so rows holding
+// the same content are equal.
+
+// blobField reads the metadata of a blob as a virtual field, as in img.width.
+// Metadata that was not recorded, such as the width of content that is not
+// an image, is NULL.
+func blobField(b storage.BlobRef, name string) (interface{}, error) {
+	switch strings.ToLower(name) {
+	case "mime":
+		return b.MIME, nil
+	case "size":
+		return b.Size, nil
+	case "format":
+		if b.Format == "" {
+			return nil, nil
+		}
+		return b.Format, nil
+	case "width", "height":
+		n := b.Width
+		if strings.EqualFold(name, "height") {
+			n = b.Height
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		return int64(n), nil
+	case "exif":
+		if b.EXIF == "" {
+			return nil, nil
+		}
+		return ParseJSON(b.EXIF)
+	}
+	return nil, fmt.Errorf("blob has no field '%s' (fields: mime, size, format, width, height, exif)", name)
+}
+
+// imageHash returns the perceptual hash of an IMAGE value, or of a hash
+// written as 16 hex digits. ok is false for an image that has no hash, such
+// as one in a format that is not decoded.
+func imageHash(v interface{}) (hash uint64, ok bool, err error) {
+	switch t := v.(type) {
+	case storage.BlobRef:
+		if t.PHash == "" {
+			return 0, false, nil
+		}
+		hash, ok = imaging.ParseHash(t.PHash)
+		return hash, ok, nil
+	case string:
+		if hash, ok = imaging.ParseHash(t); ok {
+			return hash, true, nil
+		}
+		return 0, false, fmt.Errorf("unknown image '%s'", t)
+	}
+	return 0, false, fmt.Errorf("'%v' is not an image", v)
+}
+
+// imageDistance returns the number of bits in which the perceptual hashes of
+// two images differ, or NULL when either has none.
+func imageDistance(a, b interface{}) (interface{}, error) {
+	ha, okA, err := imageHash(a)
+	if err != nil {
+		return nil, err
+	}
+	hb, okB, err := imageHash(b)
+	if err != nil || !okA || !okB {
+		return nil, err
+	}
+	return int64(imaging.Distance(ha, hb)), nil
+}
+
+// similarityFunctions take an image reference as their second argument.
+var similarityFunctions = map[string]bool{"IMAGE_DISTANCE": true, "IMAGE_SIMILAR": true}
+
+// BindImageRefs resolves the image names given as references to
+// IMAGE_DISTANCE and IMAGE_SIMILAR in e, as in
+// IMAGE_SIMILAR(img, 'photo001', 8), to perceptual hashes with lookup.
+// Names lookup does not find are left as they are and fail when evaluated.
+func BindImageRefs(e interface{}, lookup func(name string) (hash string, ok bool, err error)) error {
+	var walk func(x interface{}) error
+	walk = func(x interface{}) error {
+		if f, ok := x.(*funcCall); ok && similarityFunctions[f.fn.Name] && len(f.args) > 1 {
+			if l, ok := f.args[1].(*literal); ok {
+				if name, ok := l.val.(string); ok {
+					hash, found, err := lookup(name)
+					if err != nil {
+						return err
+					}
+					if found {
+						l.val = hash
+					}
+				}
+			}
+		}
+		if n, ok := x.(node); ok {
+			for _, ch := range n.children() {
+				if ch == nil {
+					continue
+				}
+				if err := walk(ch); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if e == nil {
+		return nil
+	}
+	return walk(e)
+}
+
+func init() {
+	blob := Param{Name: "blob", Kind: KindBlob}
+	image := Param{Name: "image", Kind: KindBlob}
+	field := func(name string) func(args []interface{}) (interface{}, error) {
+		return func(args []interface{}) (interface{}, error) { return blobField(args[0].(storage.BlobRef), name) }
+	}
+	registerBuiltin(&Function{Name: "BLOB_SIZE", Params: []Param{blob}, Returns: KindInteger, Impl: field("size")})
+	registerBuiltin(&Function{Name: "BLOB_MIME", Params: []Param{blob}, Returns: KindText, Impl: field("mime")})
+	registerBuiltin(&Function{Name: "IMAGE_WIDTH", Params: []Param{image}, Returns: KindInteger, Impl: field("width")})
+	registerBuiltin(&Function{Name: "IMAGE_HEIGHT", Params: []Param{image}, Returns: KindInteger, Impl: field("height")})
+	registerBuiltin(&Function{Name: "IMAGE_FORMAT", Params: []Param{image}, Returns: KindText, Impl: field("format")})
+	// IMAGE_EXIF(img) returns every EXIF field as a JSON object, and
+	// IMAGE_EXIF(img, 'Make') the named one, or NULL when it is missing
+	registerBuiltin(&Function{Name: "IMAGE_EXIF", Params: []Param{image, {Name: "tag", Kind: KindText, Optional: true}}, Returns: KindAny,
+		Impl: func(args []interface{}) (interface{}, error) {
+			exif, err := blobField(args[0].(storage.BlobRef), "exif")
+			if exif == nil || err != nil || len(args) == 1 {
+				return exif, err
+			}
+			member, _ := jsonStep(exif.(JSON).doc(), args[1].(string))
+			return jsonValue(member), nil
+		}})
+
+	// the reference is another image, an image name or a hash; names are
+	// resolved through the database's image index by BindImageRefs
+	reference := Param{Name: "reference", Kind: KindAny}
+	registerBuiltin(&Function{Name: "IMAGE_DISTANCE", Params: []Param{image, reference}, Returns: KindInteger,
+		Impl: func(args []interface{}) (interface{}, error) { return imageDistance(args[0], args[1]) }})
+	registerBuiltin(&Function{Name: "IMAGE_SIMILAR", Params: []Param{image, reference, {Name: "max_distance", Kind: KindInteger, Optional: true}}, Returns: KindBoolean,
+		Impl: func(args []interface{}) (interface{}, error) {
+			d, err := imageDistance(args[0], args[1])
+			if d == nil || err != nil {
+				return nil, err
+			}
+			// by default images up to 10 of the 64 bits apart are similar
+			max := int64(10)
+			if len(args) > 2 {
+				max = args[2].(int64)
+			}
+			return d.(int64) <= max, nil
+		}})
+}
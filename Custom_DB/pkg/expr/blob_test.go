@@ -0,0 +1,63 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func TestBlobFunctions(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	photo := storage.BlobRef{SHA256: sum, MIME: "image/jpeg", Size: 2048, Format: "jpeg", Width: 4000, Height: 3000,
+		EXIF: `{"DateTimeOriginal":"2023-06-01 12:34:56","FNumber":2.8,"GPSLatitude":-33.86,"Make":"Canon"}`, PHash: "00000000000000ff"}
+	// a stored row holds the reference as a JSON object
+	stored := map[string]interface{}{"sha256": sum, "mime": "text/plain; charset=utf-8", "size": int64(5)}
+	row := storage.Row{"img": photo, "raw": DecodeValue(stored, schema.Blob, time.UTC)}
+
+	cases := map[string]string{
+		"IMAGE_WIDTH(img)":                          "4000",
+		"img.height / 1000":                         "3",
+		"IMAGE_FORMAT(img)":                         "jpeg",
+		"BLOB_SIZE(img) + BLOB_SIZE(raw)":           "2053",
+		"LENGTH(img) + LENGTH(raw)":                 "2053",
+		"IMAGE_EXIF(img, 'Make')":                   "Canon",
+		"IMAGE_EXIF(img, 'FNumber') * 10":           "28.0",
+		"IMAGE_EXIF(img, 'Model')":                  "NULL",
+		"IMAGE_EXIF(img)->>'Make'":                  "Canon",
+		"IMAGE_EXIF(raw, 'Make')":                   "NULL",
+		"IMAGE_WIDTH(raw)":                          "NULL",
+		"BLOB_MIME(raw)":                            "text/plain; charset=utf-8",
+		"IMAGE_DISTANCE(img, '000000000000000f')":   "4",
+		"IMAGE_DISTANCE(img, img)":                  "0",
+		"IMAGE_SIMILAR(img, '0000000000000000')":    "true",
+		"IMAGE_SIMILAR(img, '0000000000000000', 7)": "false",
+		"IMAGE_DISTANCE(raw, img)":                  "NULL",
+		"CAST(img AS TEXT)":                         "sha256:" + sum,
+		"IMAGE_EXIF(img, 'DateTimeOriginal') < TIMESTAMP '2024-01-01 00:00:00'": "true",
+	}
+	for raw, want := range cases {
+		v, err := ParseValue(raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", raw, err)
+		}
+		got, err := v.EvalValue(row)
+		if err != nil {
+			t.Fatalf("eval %q: %v", raw, err)
+		}
+		if FormatValue(got) != want {
+			t.Fatalf("%q = %s, want %s", raw, FormatValue(got), want)
+		}
+	}
+	for _, raw := range []string{"IMAGE_WIDTH('photo.png')", "img.colour", "CAST('x' AS BLOB)", "IMAGE_DISTANCE(img, 'photo001')", "IMAGE_DISTANCE(img, 5)"} {
+		v, err := ParseValue(raw)
+		if err == nil {
+			_, err = v.EvalValue(row)
+		}
+		if err == nil {
+			t.Fatalf("expected an error for %q", raw)
+		}
+	}
+}
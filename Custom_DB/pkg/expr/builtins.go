@@ -0,0 +1,338 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// TimestampLayout is the layout timestamps are rendered with as text.
+const TimestampLayout = "2006-01-02 15:04:05.999999"
+
+// timeLayouts are the formats accepted when text is read as a timestamp.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+}
+
+// toTime converts a temporal value or ISO-8601 style text to a time.Time.
+// A TIMESTAMPTZ is returned in its time zone, the session's, so date
+// functions see its local clock time.
+func toTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case Date:
+		return t.Time, true
+	case TimestampTZ:
+		return t.Time, true
+	case string:
+		ts, _, ok := parseTimeText(t, time.UTC)
+		return ts, ok
+	}
+	return time.Time{}, false
+}
+
+// KindOfType returns the kind of values stored in a column of type t.
+func KindOfType(t schema.DataType) Kind {
+	switch t.Base() {
+	case schema.Integer, schema.SmallInt, schema.BigInt:
+		return KindInteger
+	case schema.Decimal, schema.Real, schema.Double, schema.Numeric:
+		return KindNumeric
+	case schema.Boolean:
+		return KindBoolean
+	case schema.Text:
+		return KindText
+	case schema.Blob, schema.Image:
+		return KindBlob
+	case schema.Date, schema.Timestamp, schema.TimestampTZ:
+		return KindTimestamp
+	case schema.Interval:
+		return KindInterval
+	case schema.JSON:
+		return KindJSON
+	case schema.Array:
+		return KindArray
+	}
+	return KindAny
+}
+
+func init() {
+	text := func(name string) Param { return Param{Name: name, Kind: KindText} }
+	num := func(name string) Param { return Param{Name: name, Kind: KindNumeric} }
+	integer := func(name string) Param { return Param{Name: name, Kind: KindInteger} }
+	ts := func(name string) Param { return Param{Name: name, Kind: KindTimestamp} }
+	optional := func(p Param) Param { p.Optional = true; return p }
+
+	// ----- conditional -----
+	registerBuiltin(&Function{Name: "COALESCE", Params: []Param{{Name: "value", Kind: KindAny}}, Variadic: true, Returns: KindAny, NullCall: true,
+		Impl: func(args []interface{}) (interface{}, error) {
+			for _, a := range args {
+				if a != nil {
+					return a, nil
+				}
+			}
+			return nil, nil
+		}})
+	registerBuiltin(&Function{Name: "NULLIF", Params: []Param{{Name: "value", Kind: KindAny}, {Name: "other", Kind: KindAny}}, Returns: KindAny, NullCall: true,
+		Impl: func(args []interface{}) (interface{}, error) {
+			if args[0] != nil && args[1] != nil {
+				eq, err := compareValues("=", args[0], args[1])
+				if err != nil {
+					return nil, err
+				}
+				if eq {
+					return nil, nil
+				}
+			}
+			return args[0], nil
+		}})
+
+	// ----- string -----
+	registerBuiltin(&Function{Name: "UPPER", Params: []Param{text("string")}, Returns: KindText,
+		Impl: func(args []interface{}) (interface{}, error) { return strings.ToUpper(args[0].(string)), nil }})
+	registerBuiltin(&Function{Name: "LOWER", Params: []Param{text("string")}, Returns: KindText,
+		Impl: func(args []interface{}) (interface{}, error) { return strings.ToLower(args[0].(string)), nil }})
+	trim := func(name string, fn func(string, string) string) {
+		registerBuiltin(&Function{Name: name, Params: []Param{text("string"), optional(text("characters"))}, Returns: KindText,
+			Impl: func(args []interface{}) (interface{}, error) {
+				cutset := " \t\r\n"
+				if len(args) > 1 {
+					cutset = args[1].(string)
+				}
+				return fn(args[0].(string), cutset), nil
+			}})
+	}
+	trim("TRIM", strings.Trim)
+	trim("LTRIM", strings.TrimLeft)
+	trim("RTRIM", strings.TrimRight)
+	substr := func(args []interface{}) (interface{}, error) {
+		r := []rune(args[0].(string))
+		// SQL positions are 1-based; a start before 1 eats into the length
+		start := args[1].(int64) - 1
+		end := int64(len(r))
+		if len(args) > 2 {
+			n := args[2].(int64)
+			if n < 0 {
+				return nil, fmt.Errorf("argument 3 (length) must not be negative, got %d", n)
+			}
+			end = start + n
+		}
+		if start < 0 {
+			start = 0
+		}
+		if end > int64(len(r)) {
+			end = int64(len(r))
+		}
+		if start >= end {
+			return "", nil
+		}
+		return string(r[start:end]), nil
+	}
+	registerBuiltin(&Function{Name: "SUBSTR", Params: []Param{text("string"), integer("start"), optional(integer("length"))}, Returns: KindText, Impl: substr})
+	registerBuiltin(&Function{Name: "SUBSTRING", Params: []Param{text("string"), integer("start"), optional(integer("length"))}, Returns: KindText, Impl: substr})
+	// LENGTH of a BLOB or IMAGE is its size in bytes, not the length of
+	// the reference it is stored as
+	registerBuiltin(&Function{Name: "LENGTH", Params: []Param{{Name: "string", Kind: KindAny}}, Returns: KindInteger,
+		Impl: func(args []interface{}) (interface{}, error) {
+			if ref, ok := storage.BlobRefOf(args[0]); ok {
+				return ref.Size, nil
+			}
+			s, err := coerceArg(args[0], KindText)
+			if err != nil {
+				return nil, err
+			}
+			return int64(utf8.RuneCountInString(s.(string))), nil
+		}})
+	registerBuiltin(&Function{Name: "REPLACE", Params: []Param{text("string"), text("from"), text("to")}, Returns: KindText,
+		Impl: func(args []interface{}) (interface{}, error) {
+			if args[1].(string) == "" {
+				return args[0], nil
+			}
+			return strings.ReplaceAll(args[0].(string), args[1].(string), args[2].(string)), nil
+		}})
+	registerBuiltin(&Function{Name: "SPLIT_PART", Params: []Param{text("string"), text("delimiter"), integer("field")}, Returns: KindText,
+		Impl: func(args []interface{}) (interface{}, error) {
+			n := args[2].(int64)
+			if n <= 0 {
+				return nil, fmt.Errorf("argument 3 (field) must be greater than zero, got %d", n)
+			}
+			parts := []string{args[0].(string)}
+			if d := args[1].(string); d != "" {
+				parts = strings.Split(args[0].(string), d)
+			}
+			if n > int64(len(parts)) {
+				return "", nil
+			}
+			return parts[n-1], nil
+		}})
+
+	// ----- math -----
+	registerBuiltin(&Function{Name: "ROUND", Params: []Param{num("value"), optional(integer("digits"))}, Returns: KindNumeric,
+		Impl: func(args []interface{}) (interface{}, error) {
+			var digits int64
+			if len(args) > 1 {
+				digits = args[1].(int64)
+			}
+			if i, ok := args[0].(int64); ok && digits >= 0 {
+				return i, nil
+			}
+			if n, ok := args[0].(Numeric); ok {
+				if digits < -maxNumericExponent || digits > maxNumericExponent {
+					return nil, fmt.Errorf("digits out of range: %d", digits)
+				}
+				return n.Round(int(digits)), nil
+			}
+			f, _ := toFloat(args[0])
+			scale := math.Pow(10, float64(digits))
+			return math.Round(f*scale) / scale, nil
+		}})
+	unaryMath := func(name string, fn func(float64) float64, ceil bool) {
+		registerBuiltin(&Function{Name: name, Params: []Param{num("value")}, Returns: KindNumeric,
+			Impl: func(args []interface{}) (interface{}, error) {
+				switch v := args[0].(type) {
+				case int64:
+					return v, nil
+				case Numeric:
+					return v.floorCeil(ceil), nil
+				}
+				return fn(args[0].(float64)), nil
+			}})
+	}
+	unaryMath("FLOOR", math.Floor, false)
+	unaryMath("CEIL", math.Ceil, true)
+	unaryMath("CEILING", math.Ceil, true)
+	registerBuiltin(&Function{Name: "ABS", Params: []Param{num("value")}, Returns: KindNumeric,
+		Impl: func(args []interface{}) (interface{}, error) {
+			switch v := args[0].(type) {
+			case int64:
+				if v == math.MinInt64 {
+					return nil, fmt.Errorf("bigint out of range")
+				}
+				if v < 0 {
+					return -v, nil
+				}
+				return v, nil
+			case Numeric:
+				if v.Sign() < 0 {
+					return v.Neg(), nil
+				}
+				return v, nil
+			}
+			return math.Abs(args[0].(float64)), nil
+		}})
+	power := func(args []interface{}) (interface{}, error) {
+		b, _ := toFloat(args[0])
+		e, _ := toFloat(args[1])
+		r := math.Pow(b, e)
+		if math.IsNaN(r) {
+			return nil, fmt.Errorf("result is undefined for base %v and exponent %v", args[0], args[1])
+		}
+		return r, nil
+	}
+	registerBuiltin(&Function{Name: "POWER", Params: []Param{num("base"), num("exponent")}, Returns: KindNumeric, Impl: power})
+	registerBuiltin(&Function{Name: "POW", Params: []Param{num("base"), num("exponent")}, Returns: KindNumeric, Impl: power})
+	registerBuiltin(&Function{Name: "MOD", Params: []Param{num("dividend"), num("divisor")}, Returns: KindNumeric,
+		Impl: func(args []interface{}) (interface{}, error) {
+			return applyArith("%", args[0], args[1])
+		}})
+
+	// ----- date/time -----
+	registerBuiltin(&Function{Name: "NOW", Returns: KindTimestamp,
+		Impl: func(args []interface{}) (interface{}, error) { return time.Now(), nil }})
+	registerBuiltin(&Function{Name: "DATE_TRUNC", Params: []Param{text("unit"), ts("timestamp")}, Returns: KindTimestamp,
+		Impl: func(args []interface{}) (interface{}, error) {
+			t := args[1].(time.Time)
+			y, m, d := t.Date()
+			loc := t.Location()
+			switch strings.ToLower(args[0].(string)) {
+			case "year":
+				return time.Date(y, 1, 1, 0, 0, 0, 0, loc), nil
+			case "quarter":
+				return time.Date(y, ((m-1)/3)*3+1, 1, 0, 0, 0, 0, loc), nil
+			case "month":
+				return time.Date(y, m, 1, 0, 0, 0, 0, loc), nil
+			case "week":
+				// ISO weeks start on Monday
+				offset := (int(t.Weekday()) + 6) % 7
+				return time.Date(y, m, d-offset, 0, 0, 0, 0, loc), nil
+			case "day":
+				return time.Date(y, m, d, 0, 0, 0, 0, loc), nil
+			case "hour":
+				return time.Date(y, m, d, t.Hour(), 0, 0, 0, loc), nil
+			case "minute":
+				return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, loc), nil
+			case "second":
+				return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, loc), nil
+			}
+			return nil, fmt.Errorf("argument 1 (unit) has unsupported value '%s'", args[0])
+		}})
+	registerBuiltin(&Function{Name: "EXTRACT", Params: []Param{text("field"), ts("source")}, Returns: KindNumeric,
+		Impl: func(args []interface{}) (interface{}, error) {
+			t := args[1].(time.Time)
+			switch strings.ToLower(args[0].(string)) {
+			case "year":
+				return int64(t.Year()), nil
+			case "quarter":
+				return int64((t.Month()-1)/3 + 1), nil
+			case "month":
+				return int64(t.Month()), nil
+			case "week":
+				_, w := t.ISOWeek()
+				return int64(w), nil
+			case "day":
+				return int64(t.Day()), nil
+			case "dow":
+				return int64(t.Weekday()), nil
+			case "doy":
+				return int64(t.YearDay()), nil
+			case "hour":
+				return int64(t.Hour()), nil
+			case "minute":
+				return int64(t.Minute()), nil
+			case "second":
+				return float64(t.Second()) + float64(t.Nanosecond())/1e9, nil
+			case "epoch":
+				return float64(t.UnixNano()) / 1e9, nil
+			}
+			return nil, fmt.Errorf("argument 1 (field) has unsupported value '%s'", args[0])
+		}})
+	registerBuiltin(&Function{Name: "DATE_ADD", Params: []Param{ts("timestamp"), integer("amount"), optional(text("unit"))}, Returns: KindTimestamp,
+		Impl: func(args []interface{}) (interface{}, error) {
+			t := args[0].(time.Time)
+			n := int(args[1].(int64))
+			unit := "day"
+			if len(args) > 2 {
+				unit = strings.ToLower(args[2].(string))
+			}
+			switch strings.TrimSuffix(unit, "s") {
+			case "year":
+				return t.AddDate(n, 0, 0), nil
+			case "month":
+				return t.AddDate(0, n, 0), nil
+			case "week":
+				return t.AddDate(0, 0, 7*n), nil
+			case "day":
+				return t.AddDate(0, 0, n), nil
+			case "hour":
+				return t.Add(time.Duration(n) * time.Hour), nil
+			case "minute":
+				return t.Add(time.Duration(n) * time.Minute), nil
+			case "second":
+				return t.Add(time.Duration(n) * time.Second), nil
+			}
+			return nil, fmt.Errorf("argument 3 (unit) has unsupported value '%s'", unit)
+		}})
+}
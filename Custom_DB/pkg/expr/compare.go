@@ -0,0 +1,109 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Collation selects how TEXT values are ordered.
+type Collation int
+
+const (
+	CollateBinary Collation = iota // byte-wise, the default
+	CollateNoCase                  // case-insensitive, ties broken byte-wise
+)
+
+// ParseCollation resolves a COLLATE name: BINARY or C, and NOCASE or CI.
+func ParseCollation(name string) (Collation, error) {
+	switch strings.ToUpper(strings.Trim(name, "`\"'")) {
+	case "BINARY", "C":
+		return CollateBinary, nil
+	case "NOCASE", "CI":
+		return CollateNoCase, nil
+	}
+	return CollateBinary, fmt.Errorf("unknown collation '%s'", name)
+}
+
+// CompareAs orders two non-NULL values as kind k: numbers by value,
+// timestamps chronologically, false before true and text by collation c.
+// Values that do not convert to k, such as a non-numeric string stored in a
+// numeric column, sort after those that do and are compared as text. KindAny
+// compares by the values' own types.
+func CompareAs(a, b interface{}, k Kind, c Collation) int {
+	switch k {
+	case KindText:
+		return compareText(formatText(a), formatText(b), c)
+	case KindAny:
+		if sa, ok := a.(string); ok {
+			if sb, ok := b.(string); ok {
+				if _, _, _, an := toNumber(sa); !an {
+					return compareText(sa, sb, c)
+				}
+			}
+		}
+		return compareOrder(a, b)
+	}
+	av, aerr := coerceArg(a, k)
+	bv, berr := coerceArg(b, k)
+	switch {
+	case aerr != nil && berr != nil:
+		return compareText(formatText(a), formatText(b), c)
+	case aerr != nil:
+		return 1
+	case berr != nil:
+		return -1
+	}
+	return compareOrder(av, bv)
+}
+
+func compareText(a, b string, c Collation) int {
+	if c == CollateNoCase {
+		if r := strings.Compare(strings.ToLower(a), strings.ToLower(b)); r != 0 {
+			return r
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// InferKind returns the kind of value an expression produces, using colKind
+// for column references, or KindAny when it cannot be known before
+// evaluation.
+func InferKind(v ValueExpr, colKind func(name string) Kind) Kind {
+	return staticKind(v, colKind)
+}
+
+// CheckArgKinds rejects the function and aggregate calls in e with an
+// argument known to be of the wrong kind. Parsing checks the arguments
+// whose kind needs no table; this checks column references too, of the
+// kinds colKind gives.
+func CheckArgKinds(e interface{}, colKind func(name string) Kind) error {
+	switch n := e.(type) {
+	case *funcCall:
+		for i, a := range n.args {
+			p := n.fn.param(i)
+			if err := checkKind(a, p.Kind, colKind); err != nil {
+				return fmt.Errorf("%s: argument %d (%s) %w", n.fn.Name, i+1, p.Name, err)
+			}
+		}
+	case *AggregateCall:
+		if !n.Star {
+			for i, a := range n.Args {
+				p := n.Func.Params[i]
+				if err := checkKind(a, p.Kind, colKind); err != nil {
+					return fmt.Errorf("%s: argument %d (%s) %w", n.Func.Name, i+1, p.Name, err)
+				}
+			}
+		}
+	}
+	if n, ok := e.(node); ok {
+		for _, ch := range n.children() {
+			if ch == nil {
+				continue
+			}
+			if err := CheckArgKinds(ch, colKind); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package expr
+
+import "testing"
+
+func TestCompareAs(t *testing.T) {
+	cases := []struct {
+		a, b interface{}
+		k    Kind
+		c    Collation
+		want int
+	}{
+		{"10", "9", KindText, CollateBinary, -1},
+		{"10", "9", KindInteger, CollateBinary, 1},
+		{10.0, int64(10), KindNumeric, CollateBinary, 0},
+		{"abc", 5.0, KindNumeric, CollateBinary, 1},
+		{"B", "a", KindText, CollateBinary, -1},
+		{"B", "a", KindText, CollateNoCase, 1},
+		{"a", "A", KindText, CollateNoCase, 1},
+		{"2024-01-02", "2023-12-31 23:00:00", KindTimestamp, CollateBinary, 1},
+		{true, false, KindBoolean, CollateBinary, 1},
+		{"10", "9", KindAny, CollateBinary, 1},
+	}
+	for _, c := range cases {
+		got := CompareAs(c.a, c.b, c.k, c.c)
+		if (got < 0) != (c.want < 0) || (got > 0) != (c.want > 0) {
+			t.Fatalf("CompareAs(%#v, %#v, %s): got %d, want %d", c.a, c.b, c.k, got, c.want)
+		}
+	}
+	if _, err := ParseCollation("nocase"); err != nil {
+		t.Fatalf("ParseCollation: %v", err)
+	}
+}
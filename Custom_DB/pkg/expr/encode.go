@@ -0,0 +1,181 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// MarshalValue encodes any SQL value so UnmarshalValue returns the same
+// value of the same type, which the JSON stored in data files does not
+// do without the column's type. It is how operators that run out of memory
+// write rows to temporary files. Values are encoded as a JSON pair of a
+// type tag and the value's text.
+func MarshalValue(v interface{}) ([]byte, error) {
+	var tag string
+	var text interface{}
+	switch t := v.(type) {
+	case nil:
+		return []byte("null"), nil
+	case bool:
+		tag, text = "b", t
+	case int:
+		tag, text = "i", strconv.Itoa(t)
+	case int64:
+		tag, text = "i", strconv.FormatInt(t, 10)
+	case float64:
+		tag, text = "f", strconv.FormatFloat(t, 'g', -1, 64)
+	case string:
+		tag, text = "s", t
+	case Numeric:
+		tag, text = "n", t.String()
+	case Date:
+		tag, text = "d", t.String()
+	case TimestampTZ:
+		tag, text = "z", t.Format(time.RFC3339Nano)
+	case time.Time:
+		tag, text = "t", t.Format(time.RFC3339Nano)
+	case Interval:
+		tag, text = "v", []int64{int64(t.Months), int64(t.Days), int64(t.Duration)}
+	case JSON:
+		tag, text = "j", t.text
+	case Array:
+		tag, text = "a", []string{string(t.elem), t.text}
+	case Struct:
+		tag, text = "r", []string{string(t.typ), t.text}
+	case storage.BlobRef:
+		tag, text = "o", t
+	default:
+		// values decoded from JSON without a type, such as a row value
+		// written before its column was typed
+		tag, text = "x", t
+	}
+	return json.Marshal([]interface{}{tag, text})
+}
+
+// UnmarshalValue decodes a value encoded by MarshalValue.
+func UnmarshalValue(data []byte) (interface{}, error) {
+	if bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+	var pair []json.RawMessage
+	if err := json.Unmarshal(data, &pair); err != nil || len(pair) != 2 {
+		return nil, fmt.Errorf("invalid encoded value %s", data)
+	}
+	var tag string
+	if err := json.Unmarshal(pair[0], &tag); err != nil {
+		return nil, fmt.Errorf("invalid encoded value %s", data)
+	}
+	text := func() (string, error) {
+		var s string
+		err := json.Unmarshal(pair[1], &s)
+		return s, err
+	}
+	pairOf := func() (string, string, error) {
+		var p [2]string
+		err := json.Unmarshal(pair[1], &p)
+		return p[0], p[1], err
+	}
+	var v interface{}
+	var err error
+	switch tag {
+	case "b":
+		var b bool
+		err = json.Unmarshal(pair[1], &b)
+		v = b
+	case "i", "f", "s", "n", "d", "z", "t":
+		var s string
+		if s, err = text(); err != nil {
+			break
+		}
+		switch tag {
+		case "i":
+			v, err = strconv.ParseInt(s, 10, 64)
+		case "f":
+			v, err = strconv.ParseFloat(s, 64)
+		case "s":
+			v = s
+		case "n":
+			v, err = ParseNumeric(s)
+		case "d":
+			var t time.Time
+			t, err = time.Parse(DateLayout, s)
+			v = Date{t}
+		case "z":
+			var t time.Time
+			t, err = time.Parse(time.RFC3339Nano, s)
+			v = TimestampTZ{t}
+		case "t":
+			var t time.Time
+			t, err = time.Parse(time.RFC3339Nano, s)
+			v = t
+		}
+	case "v":
+		var f [3]int64
+		err = json.Unmarshal(pair[1], &f)
+		v = Interval{Months: int(f[0]), Days: int(f[1]), Duration: time.Duration(f[2])}
+	case "j":
+		var s string
+		s, err = text()
+		v = JSON{text: s}
+	case "a", "r":
+		var typ, s string
+		typ, s, err = pairOf()
+		if tag == "a" {
+			v = Array{elem: schema.DataType(typ), text: s}
+		} else {
+			v = Struct{typ: schema.DataType(typ), text: s}
+		}
+	case "o":
+		var ref storage.BlobRef
+		err = json.Unmarshal(pair[1], &ref)
+		v = ref
+	case "x":
+		dec := json.NewDecoder(bytes.NewReader(pair[1]))
+		dec.UseNumber()
+		err = dec.Decode(&v)
+	default:
+		err = fmt.Errorf("unknown type tag %q", tag)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoded value %s: %w", data, err)
+	}
+	return v, nil
+}
+
+// ValueSize estimates the bytes of memory v holds, for operators that keep
+// rows within a memory budget.
+func ValueSize(v interface{}) int {
+	switch t := v.(type) {
+	case string:
+		return 16 + len(t)
+	case JSON:
+		return 16 + len(t.text)
+	case Array:
+		return 32 + len(t.text)
+	case Struct:
+		return 32 + len(t.text)
+	case Numeric:
+		return 48
+	case storage.BlobRef:
+		return 128 + len(t.EXIF)
+	case map[string]interface{}:
+		n := 48
+		for k, e := range t {
+			n += 16 + len(k) + ValueSize(e)
+		}
+		return n
+	case []interface{}:
+		n := 24
+		for _, e := range t {
+			n += ValueSize(e)
+		}
+		return n
+	}
+	return 16
+}
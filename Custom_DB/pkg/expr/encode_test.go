@@ -0,0 +1,41 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func TestMarshalValue(t *testing.T) {
+	num, _ := ParseNumeric("-12.50")
+	doc, _ := ParseJSON(`{"a": [1, 2]}`)
+	arr, _ := CastValue([]interface{}{int64(1), int64(2)}, schema.ArrayType(schema.Integer))
+	iv, _ := ParseInterval("1 year 2 days 03:04:05")
+	values := []interface{}{
+		nil, true, int64(-7), 2.5, "it's", num,
+		NewDate(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)),
+		TimestampTZ{time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)},
+		time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC),
+		iv, doc, arr,
+		storage.BlobRef{SHA256: "ab", MIME: "image/png", Size: 3, Width: 2},
+	}
+	for _, v := range values {
+		data, err := MarshalValue(v)
+		if err != nil {
+			t.Fatalf("MarshalValue(%#v): %v", v, err)
+		}
+		got, err := UnmarshalValue(data)
+		if err != nil {
+			t.Fatalf("UnmarshalValue(%s): %v", data, err)
+		}
+		if !reflect.DeepEqual(got, v) && FormatValue(got) != FormatValue(v) || reflect.TypeOf(got) != reflect.TypeOf(v) {
+			t.Errorf("%T %v came back as %T %v", v, v, got, got)
+		}
+	}
+	if _, err := UnmarshalValue([]byte(`["?", 1]`)); err == nil {
+		t.Errorf("expected an error for an unknown tag")
+	}
+}
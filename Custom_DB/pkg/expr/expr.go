@@ -1,10 +1,13 @@
 package expr
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
+	"Custom_DB/pkg/schema"
 	"Custom_DB/pkg/storage"
 )
 
@@ -13,19 +16,16 @@ type Expr interface {
 	Eval(row storage.Row) (bool, error)
 }
 
-// operand abstraction (column ref or literal)
-type operand struct {
-	isColumn bool
-	col      string
-	lit      interface{}
+// ValueExpr is a scalar expression that produces a value for a row. Every node
+// in the AST implements it; boolean nodes additionally implement Expr.
+type ValueExpr interface {
+	EvalValue(row storage.Row) (interface{}, error)
 }
 
-func (o *operand) Value(row storage.Row) (interface{}, bool) {
-	if o.isColumn {
-		v, ok := row[o.col]
-		return v, ok
-	}
-	return o.lit, true
+// node is implemented by every AST node so tree walks don't need to know
+// about each concrete type.
+type node interface {
+	children() []ValueExpr
 }
 
 func toFloat(v interface{}) (float64, bool) {
@@ -38,6 +38,8 @@ func toFloat(v interface{}) (float64, bool) {
 		return float64(t), true
 	case int64:
 		return float64(t), true
+	case Numeric:
+		return t.Float64(), true
 	case string:
 		if f, err := strconv.ParseFloat(t, 64); err == nil {
 			return f, true
@@ -46,81 +48,58 @@ func toFloat(v interface{}) (float64, bool) {
 	return 0, false
 }
 
-// ----- AST nodes -----
-
-type binaryOp struct {
-	op    string
-	left  Expr
-	right Expr
-}
-
-func (b *binaryOp) Eval(row storage.Row) (bool, error) {
-	l, err := b.left.Eval(row)
-	if err != nil {
-		return false, err
-	}
-	if strings.EqualFold(b.op, "AND") {
-		if !l {
-			return false, nil
-		}
-		r, err := b.right.Eval(row)
-		return r, err
-	}
-	if strings.EqualFold(b.op, "OR") {
-		if l {
-			return true, nil
-		}
-		r, err := b.right.Eval(row)
-		return r, err
+// truthy reports whether a value counts as true in a boolean context.
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
 	}
-	return false, fmt.Errorf("unsupported binary op: %s", b.op)
+	return fmt.Sprintf("%v", v) != "false"
 }
 
-type notOp struct{ child Expr }
-
-func (n *notOp) Eval(row storage.Row) (bool, error) {
-	v, err := n.child.Eval(row)
-	if err != nil {
-		return false, err
+// asExpr adapts a value expression for use in a boolean context.
+func asExpr(v ValueExpr) Expr {
+	if e, ok := v.(Expr); ok {
+		return e
 	}
-	return !v, nil
+	return &truthyOp{child: v}
 }
 
-// comparison node
-type compOp struct {
-	op    string
-	left  operand
-	right operand
-}
-
-func (c *compOp) Eval(row storage.Row) (bool, error) {
-	lv, lok := c.left.Value(row)
-	rv, rok := c.right.Value(row)
-	// if either side is a column reference that doesn't exist, error
-	if c.left.isColumn && !lok {
-		return false, fmt.Errorf("column '%s' not found", c.left.col)
-	}
-	if c.right.isColumn && !rok {
-		return false, fmt.Errorf("column '%s' not found", c.right.col)
-	}
-	// if either operand is a sub-expression (Expr), evaluate it
-	if se, ok := lv.(Expr); ok {
-		b, err := se.Eval(row)
-		if err != nil {
-			return false, err
-		}
-		lv = b
+// compareValues applies a comparison operator using numeric comparison when
+// both sides are numeric and string comparison otherwise.
+func compareValues(op string, lv, rv interface{}) (bool, error) {
+	lv, rv = unwrapJSON(lv), unwrapJSON(rv)
+	c, ordered := 0, false
+	if lt, rt, ok := asTimes(lv, rv); ok {
+		c, ordered = lt.Compare(rt), true
+	} else if li, ri, ok := asIntervals(lv, rv); ok {
+		c, ordered = cmpInt64(li.micros(), ri.micros()), true
+	} else if ec, ok := compareExact(lv, rv); ok {
+		c, ordered = ec, true
+	} else if cc, ok := compareComposite(lv, rv); ok {
+		c, ordered = cc, true
 	}
-	if se, ok := rv.(Expr); ok {
-		b, err := se.Eval(row)
-		if err != nil {
-			return false, err
+	if ordered {
+		switch op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		case ">=":
+			return c >= 0, nil
 		}
-		rv = b
 	}
 	lf, lnum := toFloat(lv)
 	rf, rnum := toFloat(rv)
-	switch c.op {
+	switch op {
 	case "=":
 		if lnum && rnum {
 			return lf == rf, nil
@@ -152,130 +131,356 @@ func (c *compOp) Eval(row storage.Row) (bool, error) {
 		}
 		return fmt.Sprintf("%v", lv) >= fmt.Sprintf("%v", rv), nil
 	}
-	return false, fmt.Errorf("unsupported comp op: %s", c.op)
+	return false, fmt.Errorf("unsupported comp op: %s", op)
 }
 
-// IN node
-type inOp struct {
-	left operand
-	list []operand
+// asTimes converts both operands to timestamps when at least one of them
+// already is a date or timestamp and the other can be read as one. Against a
+// TIMESTAMPTZ, the other side is read in its time zone, the session's.
+func asTimes(lv, rv interface{}) (time.Time, time.Time, bool) {
+	if !isTemporal(lv) && !isTemporal(rv) {
+		return time.Time{}, time.Time{}, false
+	}
+	ltz, lTZ := lv.(TimestampTZ)
+	rtz, rTZ := rv.(TimestampTZ)
+	if lTZ != rTZ {
+		var err error
+		if lTZ {
+			rv, err = CastValueIn(rv, schema.TimestampTZ, ltz.Location())
+		} else {
+			lv, err = CastValueIn(lv, schema.TimestampTZ, rtz.Location())
+		}
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	lt, lok := toTime(lv)
+	rt, rok := toTime(rv)
+	return lt, rt, lok && rok
 }
 
-func (i *inOp) Eval(row storage.Row) (bool, error) {
-	lv, lok := i.left.Value(row)
-	if i.left.isColumn && !lok {
-		return false, fmt.Errorf("column '%s' not found", i.left.col)
+// asIntervals converts both operands to intervals when at least one of them
+// already is one and the other can be read as one.
+func asIntervals(lv, rv interface{}) (Interval, Interval, bool) {
+	_, lIs := lv.(Interval)
+	_, rIs := rv.(Interval)
+	if !lIs && !rIs {
+		return Interval{}, Interval{}, false
 	}
-	if se, ok := lv.(Expr); ok {
-		b, err := se.Eval(row)
+	li, lok := toInterval(lv)
+	ri, rok := toInterval(rv)
+	return li, ri, lok && rok
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ----- AST nodes -----
+//
+// Boolean nodes follow SQL three-valued logic: EvalValue yields true, false
+// or NULL (nil) for unknown, and Eval reports whether the result is true, so
+// WHERE and HAVING drop rows whose condition is unknown.
+
+// evalTrue evaluates a boolean node and reports whether it is true.
+func evalTrue(v ValueExpr, row storage.Row) (bool, error) {
+	x, err := v.EvalValue(row)
+	if err != nil {
+		return false, err
+	}
+	b, _ := x.(bool)
+	return b, nil
+}
+
+// boolOf converts a value to true, false or NULL.
+func boolOf(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return truthy(v)
+}
+
+// column reference; a key missing from the row reads as NULL
+type colRef struct{ name string }
+
+func (c *colRef) EvalValue(row storage.Row) (interface{}, error) {
+	return row[c.name], nil
+}
+
+func (c *colRef) children() []ValueExpr { return nil }
+
+// literal value
+type literal struct{ val interface{} }
+
+func (l *literal) EvalValue(row storage.Row) (interface{}, error) { return l.val, nil }
+
+func (l *literal) children() []ValueExpr { return nil }
+
+// truthyOp evaluates a non-boolean expression in a boolean context
+type truthyOp struct{ child ValueExpr }
+
+func (t *truthyOp) Eval(row storage.Row) (bool, error) { return evalTrue(t, row) }
+
+func (t *truthyOp) EvalValue(row storage.Row) (interface{}, error) {
+	v, err := t.child.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	return boolOf(v), nil
+}
+
+func (t *truthyOp) children() []ValueExpr { return []ValueExpr{t.child} }
+
+type binaryOp struct {
+	op    string
+	left  Expr
+	right Expr
+}
+
+func (b *binaryOp) Eval(row storage.Row) (bool, error) { return evalTrue(b, row) }
+
+// EvalValue applies Kleene logic: FALSE AND NULL is FALSE, TRUE OR NULL is
+// TRUE, and otherwise a NULL operand makes the result NULL.
+func (b *binaryOp) EvalValue(row storage.Row) (interface{}, error) {
+	and := strings.EqualFold(b.op, "AND")
+	if !and && !strings.EqualFold(b.op, "OR") {
+		return nil, fmt.Errorf("unsupported binary op: %s", b.op)
+	}
+	lv, err := b.left.(ValueExpr).EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	l := boolOf(lv)
+	// short-circuit on the deciding value
+	if l == !and {
+		return l, nil
+	}
+	rv, err := b.right.(ValueExpr).EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	r := boolOf(rv)
+	if r == !and {
+		return r, nil
+	}
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	return and, nil
+}
+
+func (b *binaryOp) children() []ValueExpr {
+	return []ValueExpr{b.left.(ValueExpr), b.right.(ValueExpr)}
+}
+
+type notOp struct{ child Expr }
+
+func (n *notOp) Eval(row storage.Row) (bool, error) { return evalTrue(n, row) }
+
+func (n *notOp) EvalValue(row storage.Row) (interface{}, error) {
+	v, err := n.child.(ValueExpr).EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	if b := boolOf(v); b != nil {
+		return !b.(bool), nil
+	}
+	return nil, nil
+}
+
+func (n *notOp) children() []ValueExpr { return []ValueExpr{n.child.(ValueExpr)} }
+
+// comparison node
+type compOp struct {
+	op    string
+	left  ValueExpr
+	right ValueExpr
+}
+
+func (c *compOp) Eval(row storage.Row) (bool, error) { return evalTrue(c, row) }
+
+func (c *compOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := c.left.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := c.right.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil || rv == nil {
+		return nil, nil
+	}
+	return compareValues(c.op, lv, rv)
+}
+
+func (c *compOp) children() []ValueExpr { return []ValueExpr{c.left, c.right} }
+
+// IS [NOT] NULL node
+type isNullOp struct {
+	child  ValueExpr
+	negate bool
+}
+
+func (i *isNullOp) Eval(row storage.Row) (bool, error) { return evalTrue(i, row) }
+
+func (i *isNullOp) EvalValue(row storage.Row) (interface{}, error) {
+	v, err := i.child.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	return (v == nil) != i.negate, nil
+}
+
+func (i *isNullOp) children() []ValueExpr { return []ValueExpr{i.child} }
+
+// IS [NOT] DISTINCT FROM node: equality that treats NULLs as equal values
+type distinctOp struct {
+	left   ValueExpr
+	right  ValueExpr
+	negate bool // IS NOT DISTINCT FROM
+}
+
+func (d *distinctOp) Eval(row storage.Row) (bool, error) { return evalTrue(d, row) }
+
+func (d *distinctOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := d.left.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := d.right.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	distinct := lv != nil || rv != nil
+	if lv != nil && rv != nil {
+		eq, err := compareValues("=", lv, rv)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
-		lv = b
+		distinct = !eq
 	}
+	return distinct != d.negate, nil
+}
+
+func (d *distinctOp) children() []ValueExpr { return []ValueExpr{d.left, d.right} }
+
+// IN node; x IN (...) is NULL when x is NULL, or when nothing matches and the
+// list holds a NULL
+type inOp struct {
+	left ValueExpr
+	list []ValueExpr
+}
+
+func (i *inOp) Eval(row storage.Row) (bool, error) { return evalTrue(i, row) }
+
+func (i *inOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := i.left.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil {
+		return nil, nil
+	}
+	lv = unwrapJSON(lv)
+	sawNull := false
 	for _, it := range i.list {
-		v, rok := it.Value(row)
-		if it.isColumn && !rok {
-			return false, fmt.Errorf("column '%s' not found", it.col)
+		v, err := it.EvalValue(row)
+		if err != nil {
+			return nil, err
 		}
-		if se, ok := v.(Expr); ok {
-			b, err := se.Eval(row)
-			if err != nil {
-				return false, err
-			}
-			v = b
+		if v == nil {
+			sawNull = true
+			continue
 		}
-		if fmt.Sprintf("%v", lv) == fmt.Sprintf("%v", v) {
+		if fmt.Sprintf("%v", lv) == fmt.Sprintf("%v", unwrapJSON(v)) {
 			return true, nil
 		}
 	}
+	if sawNull {
+		return nil, nil
+	}
 	return false, nil
 }
 
+func (i *inOp) children() []ValueExpr { return append([]ValueExpr{i.left}, i.list...) }
+
 // BETWEEN node
 type betweenOp struct {
-	left operand
-	lo   operand
-	hi   operand
+	left ValueExpr
+	lo   ValueExpr
+	hi   ValueExpr
 }
 
-func (b *betweenOp) Eval(row storage.Row) (bool, error) {
-	lv, lok := b.left.Value(row)
-	if b.left.isColumn && !lok {
-		return false, fmt.Errorf("column '%s' not found", b.left.col)
-	}
-	// evaluate subexprs
-	if se, ok := lv.(Expr); ok {
-		bb, err := se.Eval(row)
-		if err != nil {
-			return false, err
-		}
-		lv = bb
-	}
-	lofV, lok2 := b.lo.Value(row)
-	if b.lo.isColumn && !lok2 {
-		return false, fmt.Errorf("column '%s' not found", b.lo.col)
+func (b *betweenOp) Eval(row storage.Row) (bool, error) { return evalTrue(b, row) }
+
+func (b *betweenOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := b.left.EvalValue(row)
+	if err != nil {
+		return nil, err
 	}
-	if se, ok := lofV.(Expr); ok {
-		bb, err := se.Eval(row)
-		if err != nil {
-			return false, err
-		}
-		lofV = bb
+	lofV, err := b.lo.EvalValue(row)
+	if err != nil {
+		return nil, err
 	}
-	hifV, hik2 := b.hi.Value(row)
-	if b.hi.isColumn && !hik2 {
-		return false, fmt.Errorf("column '%s' not found", b.hi.col)
+	hifV, err := b.hi.EvalValue(row)
+	if err != nil {
+		return nil, err
 	}
-	if se, ok := hifV.(Expr); ok {
-		bb, err := se.Eval(row)
-		if err != nil {
-			return false, err
-		}
-		hifV = bb
+	if lv == nil || lofV == nil || hifV == nil {
+		return nil, nil
 	}
-	lof, lok := toFloat(lofV)
-	hif, hik := toFloat(hifV)
-	lf, lnum := toFloat(lv)
-	if lnum && lok && hik {
-		return lf >= lof && lf <= hif, nil
+	// each bound compares the way the operator would: numerically, in time
+	// order or as text
+	ge, err := compareValues(">=", lv, lofV)
+	if err != nil || !ge {
+		return false, err
 	}
-	ls := fmt.Sprintf("%v", lv)
-	loS := fmt.Sprintf("%v", lofV)
-	hiS := fmt.Sprintf("%v", hifV)
-	return ls >= loS && ls <= hiS, nil
+	return compareValues("<=", lv, hifV)
 }
 
+func (b *betweenOp) children() []ValueExpr { return []ValueExpr{b.left, b.lo, b.hi} }
+
 // LIKE node
 type likeOp struct {
-	left    operand
+	left    ValueExpr
 	pattern string
 }
 
-func (l *likeOp) Eval(row storage.Row) (bool, error) {
-	lv, lok := l.left.Value(row)
-	if l.left.isColumn && !lok {
-		return false, fmt.Errorf("column '%s' not found", l.left.col)
+func (l *likeOp) Eval(row storage.Row) (bool, error) { return evalTrue(l, row) }
+
+func (l *likeOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := l.left.EvalValue(row)
+	if err != nil {
+		return nil, err
 	}
-	if se, ok := lv.(Expr); ok {
-		b, err := se.Eval(row)
-		if err != nil {
-			return false, err
-		}
-		lv = b
+	if lv == nil {
+		return nil, nil
 	}
-	s := fmt.Sprintf("%v", lv)
-	p := l.pattern
+	return likeMatch(formatText(lv), l.pattern), nil
+}
+
+// likeMatch reports whether s matches a LIKE pattern, which may start or
+// end with %.
+func likeMatch(s, p string) bool {
 	if strings.HasPrefix(p, "%") && strings.HasSuffix(p, "%") {
-		return strings.Contains(s, strings.Trim(p, "%")), nil
+		return strings.Contains(s, strings.Trim(p, "%"))
 	} else if strings.HasPrefix(p, "%") {
-		return strings.HasSuffix(s, strings.TrimLeft(p, "%")), nil
+		return strings.HasSuffix(s, strings.TrimLeft(p, "%"))
 	} else if strings.HasSuffix(p, "%") {
-		return strings.HasPrefix(s, strings.TrimRight(p, "%")), nil
+		return strings.HasPrefix(s, strings.TrimRight(p, "%"))
 	}
-	return s == p, nil
+	return s == p
 }
 
+func (l *likeOp) children() []ValueExpr { return []ValueExpr{l.left} }
+
 // ----- Parser (simple recursive descent) -----
 
 type parser struct {
@@ -283,53 +488,55 @@ type parser struct {
 	pos  int
 }
 
+// ParseExpression parses a boolean expression such as a WHERE or HAVING
+// clause. A non-boolean expression is true unless it evaluates to false.
 func ParseExpression(raw string) (Expr, error) {
+	v, err := ParseValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	return asExpr(v), nil
+}
+
+// ParseValue parses a scalar expression: arithmetic, string concatenation,
+// CASE, CAST, function calls and any boolean expression accepted by
+// ParseExpression.
+func ParseValue(raw string) (ValueExpr, error) {
 	toks := tokenizeExpr(raw)
 	p := &parser{toks: toks, pos: 0}
-	return p.parseOr()
+	v, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected token '%s'", p.cur())
+	}
+	return v, nil
+}
+
+// ColumnName reports whether v is a bare column reference and returns its name.
+func ColumnName(v ValueExpr) (string, bool) {
+	if c, ok := v.(*colRef); ok {
+		return c.name, true
+	}
+	return "", false
 }
 
 // CollectColumns returns a list of column names referenced by the expression.
-func CollectColumns(e Expr) []string {
+func CollectColumns(e interface{}) []string {
 	cols := []string{}
-	var walk func(Expr)
-	walk = func(x Expr) {
-		switch v := x.(type) {
-		case *binaryOp:
-			walk(v.left)
-			walk(v.right)
-		case *notOp:
-			walk(v.child)
-		case *compOp:
-			if v.left.isColumn {
-				cols = append(cols, v.left.col)
-			}
-			if v.right.isColumn {
-				cols = append(cols, v.right.col)
-			}
-		case *inOp:
-			if v.left.isColumn {
-				cols = append(cols, v.left.col)
-			}
-			for _, it := range v.list {
-				if it.isColumn {
-					cols = append(cols, it.col)
+	var walk func(interface{})
+	walk = func(x interface{}) {
+		if c, ok := x.(*colRef); ok {
+			cols = append(cols, c.name)
+			return
+		}
+		if n, ok := x.(node); ok {
+			for _, ch := range n.children() {
+				if ch != nil {
+					walk(ch)
 				}
 			}
-		case *betweenOp:
-			if v.left.isColumn {
-				cols = append(cols, v.left.col)
-			}
-			if v.lo.isColumn {
-				cols = append(cols, v.lo.col)
-			}
-			if v.hi.isColumn {
-				cols = append(cols, v.hi.col)
-			}
-		case *likeOp:
-			if v.left.isColumn {
-				cols = append(cols, v.left.col)
-			}
 		}
 	}
 	if e != nil {
@@ -350,12 +557,27 @@ func CollectColumns(e Expr) []string {
 func tokenizeExpr(s string) []string {
 	var out []string
 	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			out = append(out, cur.String())
+			cur.Reset()
+		}
+	}
 	for i := 0; i < len(s); i++ {
 		c := s[i]
 		if c == '\'' {
 			cur.WriteByte(c)
 			i++
-			for i < len(s) && s[i] != '\'' {
+			for i < len(s) {
+				if s[i] == '\'' {
+					// '' inside a string literal is an escaped quote
+					if i+1 < len(s) && s[i+1] == '\'' {
+						cur.WriteString("''")
+						i += 2
+						continue
+					}
+					break
+				}
 				cur.WriteByte(s[i])
 				i++
 			}
@@ -366,24 +588,39 @@ func tokenizeExpr(s string) []string {
 		}
 		switch c {
 		case ' ', '\t', '\n', '\r':
-			if cur.Len() > 0 {
-				out = append(out, cur.String())
-				cur.Reset()
-			}
-		case '(', ')', ',', '=':
-			if cur.Len() > 0 {
-				out = append(out, cur.String())
-				cur.Reset()
+			flush()
+		case '-':
+			flush()
+			// -> and ->> are the JSON path operators
+			switch {
+			case strings.HasPrefix(s[i:], "->>"):
+				out = append(out, "->>")
+				i += 2
+			case strings.HasPrefix(s[i:], "->"):
+				out = append(out, "->")
+				i++
+			default:
+				out = append(out, "-")
 			}
+		case '(', ')', ',', '=', '+', '*', '/', '%', '[', ']':
+			flush()
 			out = append(out, string(c))
-		case '!', '<', '>':
-			if cur.Len() > 0 {
-				out = append(out, cur.String())
-				cur.Reset()
+		case '|':
+			flush()
+			if i+1 < len(s) && s[i+1] == '|' {
+				out = append(out, "||")
+				i++
+			} else {
+				out = append(out, "|")
 			}
+		case '!', '<', '>':
+			flush()
 			if i+1 < len(s) && s[i+1] == '=' {
 				out = append(out, s[i:i+2])
 				i++
+			} else if c == '<' && i+1 < len(s) && s[i+1] == '>' {
+				out = append(out, "!=")
+				i++
 			} else {
 				out = append(out, string(c))
 			}
@@ -391,9 +628,7 @@ func tokenizeExpr(s string) []string {
 			cur.WriteByte(c)
 		}
 	}
-	if cur.Len() > 0 {
-		out = append(out, cur.String())
-	}
+	flush()
 	for i := range out {
 		out[i] = strings.TrimSpace(out[i])
 	}
@@ -408,7 +643,26 @@ func (p *parser) cur() string {
 }
 func (p *parser) eat() string { t := p.cur(); p.pos++; return t }
 
-func (p *parser) parseOr() (Expr, error) {
+// peek returns the token n positions ahead of the current one.
+func (p *parser) peek(n int) string {
+	if p.pos+n >= len(p.toks) {
+		return ""
+	}
+	return p.toks[p.pos+n]
+}
+
+func (p *parser) expect(tok string) error {
+	if !strings.EqualFold(p.cur(), tok) {
+		if p.cur() == "" {
+			return fmt.Errorf("expected %s but reached end of expression", tok)
+		}
+		return fmt.Errorf("expected %s but found '%s'", tok, p.cur())
+	}
+	p.eat()
+	return nil
+}
+
+func (p *parser) parseOr() (ValueExpr, error) {
 	left, err := p.parseAnd()
 	if err != nil {
 		return nil, err
@@ -419,12 +673,12 @@ func (p *parser) parseOr() (Expr, error) {
 		if err != nil {
 			return nil, err
 		}
-		left = &binaryOp{op: "OR", left: left, right: right}
+		left = &binaryOp{op: "OR", left: asExpr(left), right: asExpr(right)}
 	}
 	return left, nil
 }
 
-func (p *parser) parseAnd() (Expr, error) {
+func (p *parser) parseAnd() (ValueExpr, error) {
 	left, err := p.parseNot()
 	if err != nil {
 		return nil, err
@@ -435,40 +689,95 @@ func (p *parser) parseAnd() (Expr, error) {
 		if err != nil {
 			return nil, err
 		}
-		left = &binaryOp{op: "AND", left: left, right: right}
+		left = &binaryOp{op: "AND", left: asExpr(left), right: asExpr(right)}
 	}
 	return left, nil
 }
 
-func (p *parser) parseNot() (Expr, error) {
+func (p *parser) parseNot() (ValueExpr, error) {
 	if strings.EqualFold(p.cur(), "NOT") {
 		p.eat()
 		child, err := p.parseNot()
 		if err != nil {
 			return nil, err
 		}
-		return &notOp{child: child}, nil
+		return &notOp{child: asExpr(child)}, nil
 	}
 	return p.parseComparison()
 }
 
-func (p *parser) parseComparison() (Expr, error) {
-	prim, err := p.parsePrimaryOperand()
+func (p *parser) parseComparison() (ValueExpr, error) {
+	prim, err := p.parseConcat()
 	if err != nil {
 		return nil, err
 	}
 	cur := strings.ToUpper(p.cur())
+	if cur == "IS" {
+		return p.parseIs(prim)
+	}
+	// x NOT IN / NOT LIKE / NOT BETWEEN negate the positive form
+	if cur == "NOT" {
+		switch next := strings.ToUpper(p.peek(1)); next {
+		case "IN", "LIKE", "BETWEEN":
+			p.eat()
+			e, err := p.parsePredicate(prim, next)
+			if err != nil {
+				return nil, err
+			}
+			return &notOp{child: e.(Expr)}, nil
+		}
+	}
+	return p.parsePredicate(prim, cur)
+}
+
+// parseIs parses the IS [NOT] NULL and IS [NOT] DISTINCT FROM forms
+// following prim.
+func (p *parser) parseIs(prim ValueExpr) (ValueExpr, error) {
+	p.eat()
+	negate := false
+	if strings.EqualFold(p.cur(), "NOT") {
+		p.eat()
+		negate = true
+	}
+	switch strings.ToUpper(p.cur()) {
+	case "NULL":
+		p.eat()
+		return &isNullOp{child: prim, negate: negate}, nil
+	case "DISTINCT":
+		p.eat()
+		if !strings.EqualFold(p.cur(), "FROM") {
+			return nil, fmt.Errorf("IS DISTINCT missing FROM")
+		}
+		p.eat()
+		right, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		return &distinctOp{left: prim, right: right, negate: negate}, nil
+	}
+	return nil, fmt.Errorf("IS expects NULL or DISTINCT FROM, got %q", p.cur())
+}
+
+// parsePredicate parses the comparison, BETWEEN, IN or LIKE operator cur
+// following prim; any other token leaves prim as is.
+func (p *parser) parsePredicate(prim ValueExpr, cur string) (ValueExpr, error) {
 	switch cur {
 	case "=", "!=", "<", ">", "<=", ">=":
 		op := p.eat()
-		right, err := p.parsePrimaryOperand()
+		switch strings.ToUpper(p.cur()) {
+		case "ANY", "SOME", "ALL":
+			if p.peek(1) == "(" {
+				return p.parseQuantified(prim, op)
+			}
+		}
+		right, err := p.parseConcat()
 		if err != nil {
 			return nil, err
 		}
 		return &compOp{op: op, left: prim, right: right}, nil
 	case "BETWEEN":
 		p.eat()
-		lo, err := p.parsePrimaryOperand()
+		lo, err := p.parseConcat()
 		if err != nil {
 			return nil, err
 		}
@@ -476,7 +785,7 @@ func (p *parser) parseComparison() (Expr, error) {
 			return nil, fmt.Errorf("BETWEEN missing AND")
 		}
 		p.eat()
-		hi, err := p.parsePrimaryOperand()
+		hi, err := p.parseConcat()
 		if err != nil {
 			return nil, err
 		}
@@ -487,9 +796,9 @@ func (p *parser) parseComparison() (Expr, error) {
 			return nil, fmt.Errorf("IN expects (list)")
 		}
 		p.eat()
-		list := []operand{}
+		list := []ValueExpr{}
 		for {
-			opd, err := p.parsePrimaryOperand()
+			opd, err := p.parseConcat()
 			if err != nil {
 				return nil, err
 			}
@@ -505,45 +814,382 @@ func (p *parser) parseComparison() (Expr, error) {
 		}
 		p.eat()
 		return &inOp{left: prim, list: list}, nil
-	default:
-		if strings.EqualFold(p.cur(), "LIKE") {
+	case "LIKE":
+		p.eat()
+		pat := p.eat()
+		pat = strings.Trim(pat, "'\"")
+		return &likeOp{left: prim, pattern: pat}, nil
+	}
+	return prim, nil
+}
+
+// parseConcat handles the string concatenation operator ||.
+func (p *parser) parseConcat() (ValueExpr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for p.cur() == "||" {
+		p.eat()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = &concatOp{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (ValueExpr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.cur() == "+" || p.cur() == "-" {
+		op := p.eat()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithOp{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseMultiplicative() (ValueExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.cur() == "*" || p.cur() == "/" || p.cur() == "%" {
+		op := p.eat()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithOp{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (ValueExpr, error) {
+	if p.cur() == "-" || p.cur() == "+" {
+		op := p.eat()
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			return child, nil
+		}
+		// fold negative numeric literals so "-5" stays a plain literal
+		if l, ok := child.(*literal); ok {
+			switch n := l.val.(type) {
+			case int64:
+				return &literal{val: -n}, nil
+			case float64:
+				return &literal{val: -n}, nil
+			case Numeric:
+				return &literal{val: n.Neg()}, nil
+			}
+		}
+		return &arithOp{op: "-", left: &literal{val: int64(0)}, right: child}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses a primary expression followed by any number of ->
+// and ->> operators, [index] subscripts and .field accesses, which bind
+// tighter than arithmetic.
+func (p *parser) parsePostfix() (ValueExpr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		cur := p.cur()
+		switch {
+		case cur == "->" || cur == "->>":
+			left, err = p.parseJSONGet(left)
+		case cur == "[":
+			left, err = p.parseSubscript(left)
+		case strings.HasPrefix(cur, ".") && len(cur) > 1:
+			// tags[1].city and (addr).city tokenize the field as ".city"
 			p.eat()
-			pat := p.eat()
-			pat = strings.Trim(pat, "'\"")
-			return &likeOp{left: prim, pattern: pat}, nil
+			left, err = fieldPath(left, cur[1:])
+		default:
+			return left, nil
+		}
+		if err != nil {
+			return nil, err
 		}
-		// fallback: treat as boolean comparison to true
-		return &compOp{op: "!=", left: prim, right: operand{lit: false}}, nil
 	}
 }
 
-func (p *parser) parsePrimaryOperand() (operand, error) {
+func (p *parser) parsePrimary() (ValueExpr, error) {
 	cur := p.cur()
 	if cur == "" {
-		return operand{}, fmt.Errorf("unexpected end")
+		return nil, fmt.Errorf("unexpected end")
 	}
 	if cur == "(" {
 		p.eat()
 		sub, err := p.parseOr()
 		if err != nil {
-			return operand{}, err
+			return nil, err
 		}
 		if p.cur() != ")" {
-			return operand{}, fmt.Errorf("missing )")
+			return nil, fmt.Errorf("missing )")
 		}
 		p.eat()
-		// represent boolean subexpr as literal by storing it as lit (Expr) and handling in Eval via fmt
-		return operand{isColumn: false, lit: sub}, nil
+		return sub, nil
 	}
 	if strings.HasPrefix(cur, "'") && strings.HasSuffix(cur, "'") && len(cur) >= 2 {
 		p.eat()
-		return operand{isColumn: false, lit: strings.Trim(cur, "'")}, nil
+		return &literal{val: strings.ReplaceAll(cur[1:len(cur)-1], "''", "'")}, nil
+	}
+	if n, ok := parseNumber(cur); ok {
+		p.eat()
+		return &literal{val: n}, nil
+	}
+	switch strings.ToUpper(cur) {
+	case "NULL":
+		p.eat()
+		return &literal{val: nil}, nil
+	case "TRUE":
+		p.eat()
+		return &literal{val: true}, nil
+	case "FALSE":
+		p.eat()
+		return &literal{val: false}, nil
+	case "CASE":
+		return p.parseCase()
+	case "CAST":
+		if p.peek(1) == "(" {
+			return p.parseCast()
+		}
+	case "DATE", "TIMESTAMP", "TIMESTAMPTZ", "INTERVAL":
+		if lit, ok, err := p.parseTypedLiteral(); ok {
+			return lit, err
+		}
+	case "ARRAY":
+		if p.peek(1) == "[" {
+			return p.parseArrayCtor()
+		}
+	}
+	if isOperatorToken(cur) {
+		return nil, fmt.Errorf("unexpected token '%s'", cur)
+	}
+	// function call
+	if p.peek(1) == "(" {
+		return p.parseFuncCall()
+	}
+	// identifier; a dotted name such as addr.city reads a struct field
+	p.eat()
+	if strings.HasPrefix(cur, "`") || strings.HasPrefix(cur, "\"") {
+		return &colRef{name: strings.Trim(cur, "`\"")}, nil
+	}
+	name, path, _ := strings.Cut(cur, ".")
+	if path == "" && !strings.HasSuffix(cur, ".") {
+		return &colRef{name: name}, nil
+	}
+	return fieldPath(&colRef{name: name}, path)
+}
+
+func (p *parser) parseCase() (ValueExpr, error) {
+	p.eat() // CASE
+	c := &caseOp{}
+	if !strings.EqualFold(p.cur(), "WHEN") {
+		operand, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.operand = operand
+	}
+	for strings.EqualFold(p.cur(), "WHEN") {
+		p.eat()
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("THEN"); err != nil {
+			return nil, fmt.Errorf("CASE: %w", err)
+		}
+		res, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, whenClause{cond: cond, result: res})
+	}
+	if len(c.whens) == 0 {
+		return nil, fmt.Errorf("CASE requires at least one WHEN clause")
 	}
-	if _, err := strconv.ParseFloat(cur, 64); err == nil {
+	if strings.EqualFold(p.cur(), "ELSE") {
 		p.eat()
-		return operand{isColumn: false, lit: cur}, nil
+		els, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.elseExpr = els
+	}
+	if err := p.expect("END"); err != nil {
+		return nil, fmt.Errorf("CASE: %w", err)
+	}
+	return c, nil
+}
+
+func (p *parser) parseCast() (ValueExpr, error) {
+	p.eat() // CAST
+	p.eat() // (
+	child, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("AS"); err != nil {
+		return nil, fmt.Errorf("CAST: %w", err)
+	}
+	typeName := p.typeName()
+	// keep a modifier suffix such as NUMERIC(10, 2) for ResolveTypeName
+	if p.cur() == "(" {
+		for p.cur() != ")" && p.cur() != "" {
+			typeName += p.eat()
+		}
+		typeName += p.eat()
+	}
+	// and the element or field types of ARRAY<T> and STRUCT<name T, ...>
+	if p.cur() == "<" {
+		depth := 0
+		for p.cur() != "" {
+			tok := p.eat()
+			typeName += " " + tok
+			if tok == "<" {
+				depth++
+			} else if tok == ">" {
+				if depth--; depth == 0 {
+					break
+				}
+			}
+		}
+	}
+	for p.cur() == "[" && p.peek(1) == "]" {
+		p.pos += 2
+		typeName += "[]"
+	}
+	t, ok := schema.ResolveTypeName(typeName)
+	if !ok {
+		return nil, fmt.Errorf("CAST: unknown type '%s'", typeName)
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, fmt.Errorf("CAST: %w", err)
+	}
+	return &castOp{child: child, typ: t}, nil
+}
+
+// typeName consumes a type name, joining the words of TIMESTAMP WITH TIME
+// ZONE, TIMESTAMP WITHOUT TIME ZONE and DOUBLE PRECISION.
+func (p *parser) typeName() string {
+	name := p.eat()
+	if strings.EqualFold(name, "DOUBLE") && strings.EqualFold(p.cur(), "PRECISION") {
+		return name + " " + p.eat()
+	}
+	if strings.EqualFold(name, "TIMESTAMP") {
+		next := strings.ToUpper(p.cur())
+		if (next == "WITH" || next == "WITHOUT") && strings.EqualFold(p.peek(1), "TIME") && strings.EqualFold(p.peek(2), "ZONE") {
+			name += " " + p.eat() + " " + p.eat() + " " + p.eat()
+		}
+	}
+	return name
+}
+
+// parseTypedLiteral parses DATE '...', TIMESTAMP [WITH TIME ZONE] '...',
+// TIMESTAMPTZ '...' and INTERVAL '...' [unit]. The text is converted once,
+// at parse time. ok is false when the keyword is not followed by a string,
+// so a column named date still parses as a column.
+func (p *parser) parseTypedLiteral() (lit ValueExpr, ok bool, err error) {
+	start := p.pos
+	name := p.typeName()
+	text := p.cur()
+	if len(text) < 2 || !strings.HasPrefix(text, "'") || !strings.HasSuffix(text, "'") {
+		p.pos = start
+		return nil, false, nil
 	}
-	// identifier
 	p.eat()
-	return operand{isColumn: true, col: cur}, nil
+	text = strings.ReplaceAll(text[1:len(text)-1], "''", "'")
+	t, _ := schema.ResolveTypeName(name)
+	if t == schema.Interval {
+		// INTERVAL '3' DAY gives the unit after the string
+		if _, isUnit := intervalUnits[strings.TrimSuffix(strings.ToLower(p.cur()), "s")]; isUnit && !strings.ContainsAny(text, " :") {
+			text += " " + p.eat()
+		}
+	}
+	v, err := CastValue(text, t)
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid %s literal '%s'", t, text)
+	}
+	if t == schema.TimestampTZ {
+		// read in the session time zone once it is bound
+		return &castOp{child: &literal{val: text}, typ: t}, true, nil
+	}
+	return &literal{val: v}, true, nil
+}
+
+func (p *parser) parseFuncCall() (ValueExpr, error) {
+	start := p.pos
+	name := strings.ToUpper(p.eat())
+	p.eat() // (
+	if agg, ok := LookupAggregate(name); ok {
+		return p.parseAggregateCall(agg, start)
+	}
+	if name == "GROUPING" {
+		return p.parseGroupingCall()
+	}
+	args := []ValueExpr{}
+	// EXTRACT(field FROM source) names its field with a bare keyword
+	if name == "EXTRACT" && strings.EqualFold(p.peek(1), "FROM") {
+		args = append(args, &literal{val: strings.ToLower(p.eat())})
+		p.eat() // FROM
+	}
+	if p.cur() != ")" {
+		for {
+			a, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if p.cur() == "," {
+				p.eat()
+				continue
+			}
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return newFuncCall(name, args)
+}
+
+// parseNumber parses an integer or decimal literal. Integers too large for
+// 64 bits are exact NUMERIC values.
+func parseNumber(s string) (interface{}, bool) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return i, true
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		if n, err := ParseNumeric(s); err == nil {
+			return n, true
+		}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, true
+	}
+	return nil, false
+}
+
+func isOperatorToken(t string) bool {
+	switch t {
+	case ")", ",", "=", "!=", "<", ">", "<=", ">=", "+", "-", "*", "/", "%", "||", "|", "!", "->", "->>", "[", "]":
+		return true
+	}
+	return false
 }
@@ -65,3 +65,125 @@ func TestComplexOrNot(t *testing.T) {
 		t.Fatalf("expected true")
 	}
 }
+
+func TestArithmeticAndConcat(t *testing.T) {
+	row := storage.Row{"price": 2.5, "qty": 4, "first": "Ada", "last": "Lovelace"}
+	cases := []struct {
+		raw  string
+		want interface{}
+	}{
+		{"price * qty", 10.0},
+		{"qty + 2 * 3", int64(10)},
+		{"(qty + 2) * 3", int64(18)},
+		{"-qty + 1", int64(-3)},
+		{"qty % 3", int64(1)},
+		{"qty / 8", 0.5},
+		{"first || ' ' || last", "Ada Lovelace"},
+	}
+	for _, c := range cases {
+		e, err := ParseValue(c.raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.raw, err)
+		}
+		got, err := e.EvalValue(row)
+		if err != nil {
+			t.Fatalf("eval %q: %v", c.raw, err)
+		}
+		if got != c.want {
+			t.Fatalf("%q: got %#v, want %#v", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestCaseCastCoalesce(t *testing.T) {
+	row := storage.Row{"score": 85, "nick": nil, "name": "Bob", "amount": "12.6"}
+	cases := []struct {
+		raw  string
+		want interface{}
+	}{
+		{"CASE WHEN score >= 90 THEN 'A' WHEN score >= 80 THEN 'B' ELSE 'C' END", "B"},
+		{"CASE score WHEN 85 THEN 'hit' END", "hit"},
+		{"CASE WHEN score > 100 THEN 'x' END", nil},
+		{"CAST(amount AS INT)", int64(13)},
+		{"CAST(score AS TEXT) || '%'", "85%"},
+		{"CAST('true' AS BOOLEAN)", true},
+		{"COALESCE(nick, name)", "Bob"},
+		{"NULLIF(name, 'Bob')", nil},
+		{"NULLIF(score, 0)", 85},
+	}
+	for _, c := range cases {
+		e, err := ParseValue(c.raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.raw, err)
+		}
+		got, err := e.EvalValue(row)
+		if err != nil {
+			t.Fatalf("eval %q: %v", c.raw, err)
+		}
+		if got != c.want {
+			t.Fatalf("%q: got %#v, want %#v", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestThreeValuedLogic(t *testing.T) {
+	row := storage.Row{"a": 1, "n": nil, "name": "Bob"}
+	cases := []struct {
+		raw  string
+		want interface{}
+	}{
+		{"n = 1", nil},
+		{"n = n", nil},
+		{"NOT n = 1", nil},
+		{"n = 1 AND a = 2", false},
+		{"n = 1 AND a = 1", nil},
+		{"n = 1 OR a = 1", true},
+		{"n = 1 OR a = 2", nil},
+		{"a IN (2, NULL)", nil},
+		{"a IN (1, NULL)", true},
+		{"a NOT IN (2, NULL)", nil},
+		{"a NOT IN (2, 3)", true},
+		{"n BETWEEN 0 AND 5", nil},
+		{"name NOT LIKE 'B%'", false},
+		{"n LIKE '%'", nil},
+		{"missing = 1", nil},
+		{"n IS NULL", true},
+		{"missing IS NULL", true},
+		{"a IS NOT NULL", true},
+		{"a + n IS NULL", true},
+		{"n IS DISTINCT FROM NULL", false},
+		{"a IS DISTINCT FROM n", true},
+		{"a IS NOT DISTINCT FROM 1", true},
+	}
+	for _, c := range cases {
+		e, err := ParseExpression(c.raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.raw, err)
+		}
+		got, err := e.(ValueExpr).EvalValue(row)
+		if err != nil {
+			t.Fatalf("eval %q: %v", c.raw, err)
+		}
+		if got != c.want {
+			t.Fatalf("%q: got %#v, want %#v", c.raw, got, c.want)
+		}
+		// a condition is satisfied only when it is true
+		ok, err := e.Eval(row)
+		if err != nil || ok != (c.want == true) {
+			t.Fatalf("%q: Eval = %v, %v", c.raw, ok, err)
+		}
+	}
+	for _, raw := range []string{"a IS 1", "a IS DISTINCT 1"} {
+		if _, err := ParseExpression(raw); err == nil {
+			t.Fatalf("expected parse error for %q", raw)
+		}
+	}
+}
+
+func TestParseValueErrors(t *testing.T) {
+	for _, raw := range []string{"a +", "CASE WHEN a THEN 1", "CAST(a AS widget)", "UNKNOWNFN(a)", "a b"} {
+		if _, err := ParseValue(raw); err == nil {
+			t.Fatalf("expected parse error for %q", raw)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package expr
+
+import "strings"
+
+// Fold replaces the constant parts of v, those that refer to no columns and
+// call no aggregates or user-defined functions, by their values, so
+// WHERE price > 10 * 1.2 computes 12 once rather than for every row. A
+// constant part that fails to evaluate is kept, to fail when the expression
+// is. v itself may be changed, and the folded expression is returned.
+func Fold(v ValueExpr) ValueExpr {
+	switch v.(type) {
+	case nil, *literal, *colRef:
+		return v
+	}
+	if isConstant(v) {
+		if c, err := v.EvalValue(nil); err == nil {
+			return &literal{val: c}
+		}
+		return v
+	}
+	foldChildren(v)
+	return v
+}
+
+// FoldExpr is Fold for a boolean expression.
+func FoldExpr(e Expr) Expr {
+	if e == nil {
+		return nil
+	}
+	return asExpr(Fold(e.(ValueExpr)))
+}
+
+// isConstant reports whether v has the same value for every row.
+func isConstant(v ValueExpr) bool {
+	if len(CollectColumns(v)) > 0 || len(CollectAggregates(v)) > 0 || len(CollectGroupingCalls(v)) > 0 {
+		return false
+	}
+	constant := true
+	var walk func(x interface{})
+	walk = func(x interface{}) {
+		if f, ok := x.(*funcCall); ok && builtinFunctions[f.fn.Name] != f.fn {
+			// user functions may not return the same value every call
+			constant = false
+		}
+		if n, ok := x.(node); ok {
+			for _, ch := range n.children() {
+				if ch != nil {
+					walk(ch)
+				}
+			}
+		}
+	}
+	walk(v)
+	return constant
+}
+
+// foldChildren folds the operands of v in place.
+func foldChildren(v ValueExpr) {
+	foldAll := func(list []ValueExpr) {
+		for i := range list {
+			list[i] = Fold(list[i])
+		}
+	}
+	switch n := v.(type) {
+	case *truthyOp:
+		n.child = Fold(n.child)
+	case *binaryOp:
+		n.left, n.right = FoldExpr(n.left), FoldExpr(n.right)
+	case *notOp:
+		n.child = FoldExpr(n.child)
+	case *compOp:
+		n.left, n.right = Fold(n.left), Fold(n.right)
+	case *isNullOp:
+		n.child = Fold(n.child)
+	case *distinctOp:
+		n.left, n.right = Fold(n.left), Fold(n.right)
+	case *inOp:
+		n.left = Fold(n.left)
+		foldAll(n.list)
+	case *betweenOp:
+		n.left, n.lo, n.hi = Fold(n.left), Fold(n.lo), Fold(n.hi)
+	case *likeOp:
+		n.left = Fold(n.left)
+	case *funcCall:
+		foldAll(n.args)
+	case *AggregateCall:
+		foldAll(n.Args)
+		for i := range n.OrderBy {
+			n.OrderBy[i].Expr = Fold(n.OrderBy[i].Expr)
+		}
+		n.Filter = FoldExpr(n.Filter)
+	case *arrayCtor:
+		foldAll(n.elems)
+	case *subscriptOp:
+		n.left, n.index = Fold(n.left), Fold(n.index)
+	case *fieldOp:
+		n.left = Fold(n.left)
+	case *quantifiedOp:
+		n.left, n.right = Fold(n.left), Fold(n.right)
+	case *jsonGetOp:
+		n.left, n.key = Fold(n.left), Fold(n.key)
+	case *arithOp:
+		n.left, n.right = Fold(n.left), Fold(n.right)
+	case *concatOp:
+		n.left, n.right = Fold(n.left), Fold(n.right)
+	case *caseOp:
+		n.operand = Fold(n.operand)
+		for i := range n.whens {
+			n.whens[i].cond, n.whens[i].result = Fold(n.whens[i].cond), Fold(n.whens[i].result)
+		}
+		n.elseExpr = Fold(n.elseExpr)
+	case *castOp:
+		n.child = Fold(n.child)
+	}
+}
+
+// Constant returns the value of a folded expression that is a constant; a
+// boolean expression's value is TRUE, FALSE or NULL.
+func Constant(e interface{}) (interface{}, bool) {
+	switch n := e.(type) {
+	case *literal:
+		return n.val, true
+	case *truthyOp:
+		if l, ok := n.child.(*literal); ok {
+			return boolOf(l.val), true
+		}
+	}
+	return nil, false
+}
+
+// Conjuncts splits e into the conditions AND-ed together at its top.
+func Conjuncts(e Expr) []Expr {
+	if b, ok := e.(*binaryOp); ok && strings.EqualFold(b.op, "AND") {
+		return append(Conjuncts(b.left), Conjuncts(b.right)...)
+	}
+	return []Expr{e}
+}
+
+// And joins conditions with AND; it returns nil for none.
+func And(conds []Expr) Expr {
+	if len(conds) == 0 {
+		return nil
+	}
+	e := conds[0]
+	for _, c := range conds[1:] {
+		e = &binaryOp{op: "AND", left: e, right: c}
+	}
+	return e
+}
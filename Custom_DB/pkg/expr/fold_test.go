@@ -0,0 +1,57 @@
+package expr
+
+import (
+	"testing"
+
+	"Custom_DB/pkg/storage"
+)
+
+func TestFold(t *testing.T) {
+	cases := map[string]string{
+		"price > 10 * 1.2":                    "price > 12",
+		"UPPER('a' || 'b') = name":            "'AB' = name",
+		"x + 1 > 2 AND 1 = 1":                 "(x + 1) > 2 AND TRUE",
+		"CASE WHEN 1 > 2 THEN 'a' ELSE y END": "CASE WHEN FALSE THEN 'a' ELSE y END",
+		"qty IN (1 + 1, 3) OR name IS NULL":   "qty IN (2, 3) OR name IS NULL",
+		"1 / 0 = x":                           "(1 / 0) = x",
+		"CAST('5' AS INT) BETWEEN lo AND hi":  "5 BETWEEN lo AND hi",
+	}
+	for src, want := range cases {
+		e, err := ParseExpression(src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		if got := Format(FoldExpr(e)); got != want {
+			t.Errorf("Fold(%q) = %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestFoldKeepsResults(t *testing.T) {
+	row := storage.Row{"price": 15, "name": "AB"}
+	for _, src := range []string{"price > 10 * 1.2", "UPPER('a' || 'b') = name", "NOT (2 < 1) AND price = 15"} {
+		e, _ := ParseExpression(src)
+		before, _ := e.Eval(row)
+		after, err := FoldExpr(e).Eval(row)
+		if err != nil || after != before {
+			t.Errorf("%q: folded = %v, %v; want %v", src, after, err, before)
+		}
+	}
+}
+
+func TestConjunctsAndConstant(t *testing.T) {
+	e, _ := ParseExpression("a = 1 AND (b = 2 AND 2 > 1)")
+	conds := Conjuncts(FoldExpr(e))
+	if len(conds) != 3 {
+		t.Fatalf("got %d conjuncts, want 3", len(conds))
+	}
+	if v, ok := Constant(conds[2]); !ok || v != true {
+		t.Errorf("Constant(%s) = %v, %v; want true", Format(conds[2]), v, ok)
+	}
+	if _, ok := Constant(conds[0]); ok {
+		t.Errorf("a = 1 is not constant")
+	}
+	if got := Format(And(conds[:2])); got != "a = 1 AND b = 2" {
+		t.Errorf("And = %q", got)
+	}
+}
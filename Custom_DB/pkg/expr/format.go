@@ -0,0 +1,146 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Format renders a parsed expression as SQL text, as EXPLAIN shows it.
+// Operands that are operations themselves are parenthesized, so the text
+// reads unambiguously rather than as it was written.
+func Format(e interface{}) string {
+	switch n := e.(type) {
+	case nil:
+		return ""
+	case *colRef:
+		return n.name
+	case *literal:
+		return FormatConstant(n.val)
+	case *truthyOp:
+		return Format(n.child)
+	case *binaryOp:
+		op := strings.ToUpper(n.op)
+		return formatSide(n.left, op) + " " + op + " " + formatSide(n.right, op)
+	case *notOp:
+		return "NOT " + formatOperand(n.child)
+	case *compOp:
+		return formatOperand(n.left) + " " + n.op + " " + formatOperand(n.right)
+	case *isNullOp:
+		if n.negate {
+			return formatOperand(n.child) + " IS NOT NULL"
+		}
+		return formatOperand(n.child) + " IS NULL"
+	case *distinctOp:
+		not := ""
+		if n.negate {
+			not = "NOT "
+		}
+		return formatOperand(n.left) + " IS " + not + "DISTINCT FROM " + formatOperand(n.right)
+	case *inOp:
+		return formatOperand(n.left) + " IN (" + formatList(n.list) + ")"
+	case *betweenOp:
+		return formatOperand(n.left) + " BETWEEN " + formatOperand(n.lo) + " AND " + formatOperand(n.hi)
+	case *likeOp:
+		return formatOperand(n.left) + " LIKE " + FormatConstant(n.pattern)
+	case *funcCall:
+		return n.fn.Name + "(" + formatList(n.args) + ")"
+	case *AggregateCall:
+		return n.Text
+	case *GroupingCall:
+		return "GROUPING(" + strings.Join(n.ArgTexts, ", ") + ")"
+	case *arrayCtor:
+		return "ARRAY[" + formatList(n.elems) + "]"
+	case *subscriptOp:
+		return formatOperand(n.left) + "[" + Format(n.index) + "]"
+	case *fieldOp:
+		return formatOperand(n.left) + "." + n.name
+	case *quantifiedOp:
+		q := "ANY"
+		if n.all {
+			q = "ALL"
+		}
+		return formatOperand(n.left) + " " + n.op + " " + q + "(" + Format(n.right) + ")"
+	case *jsonGetOp:
+		op := "->"
+		if n.asText {
+			op = "->>"
+		}
+		return formatOperand(n.left) + op + formatOperand(n.key)
+	case *arithOp:
+		return formatOperand(n.left) + " " + n.op + " " + formatOperand(n.right)
+	case *concatOp:
+		return formatOperand(n.left) + " || " + formatOperand(n.right)
+	case *caseOp:
+		sb := &strings.Builder{}
+		sb.WriteString("CASE")
+		if n.operand != nil {
+			sb.WriteString(" " + Format(n.operand))
+		}
+		for _, w := range n.whens {
+			sb.WriteString(" WHEN " + Format(w.cond) + " THEN " + Format(w.result))
+		}
+		if n.elseExpr != nil {
+			sb.WriteString(" ELSE " + Format(n.elseExpr))
+		}
+		sb.WriteString(" END")
+		return sb.String()
+	case *castOp:
+		return "CAST(" + Format(n.child) + " AS " + string(n.typ) + ")"
+	}
+	return fmt.Sprintf("%v", e)
+}
+
+// FormatConstant renders a value as it would be written in SQL.
+func FormatConstant(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if t {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int, int64, float64, Numeric:
+		return formatText(t)
+	}
+	return "'" + strings.ReplaceAll(formatText(v), "'", "''") + "'"
+}
+
+func formatList(list []ValueExpr) string {
+	parts := make([]string, len(list))
+	for i, a := range list {
+		parts[i] = Format(a)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatOperand renders an operand, parenthesized when it is an operation.
+func formatOperand(e interface{}) string {
+	if t, ok := e.(*truthyOp); ok {
+		e = t.child
+	}
+	switch e.(type) {
+	case *binaryOp, *notOp, *compOp, *isNullOp, *distinctOp, *inOp, *betweenOp, *likeOp,
+		*quantifiedOp, *arithOp, *concatOp:
+		return "(" + Format(e) + ")"
+	}
+	return Format(e)
+}
+
+// formatSide renders an operand of AND or OR. Only the other of the two
+// needs parentheses; everything else binds tighter.
+func formatSide(e Expr, op string) string {
+	if b, ok := e.(*binaryOp); ok && !strings.EqualFold(b.op, op) {
+		return "(" + Format(b) + ")"
+	}
+	return Format(e)
+}
+
+// String renders the call as SQL text, as in UNNEST(tags) AS tag.
+func (c *TableCall) String() string {
+	s := c.Func.Name + "(" + formatList(c.Args) + ")"
+	if c.Alias != "" {
+		s += " AS " + c.Alias
+	}
+	return s
+}
@@ -0,0 +1,390 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// Kind classifies values for function signatures and parse-time type checks.
+type Kind int
+
+const (
+	KindAny Kind = iota
+	KindText
+	KindNumeric
+	KindInteger
+	KindBoolean
+	KindTimestamp
+	KindInterval
+	KindJSON
+	KindArray
+	KindBlob
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindText:
+		return "TEXT"
+	case KindNumeric:
+		return "NUMERIC"
+	case KindInteger:
+		return "INTEGER"
+	case KindBoolean:
+		return "BOOLEAN"
+	case KindTimestamp:
+		return "TIMESTAMP"
+	case KindInterval:
+		return "INTERVAL"
+	case KindJSON:
+		return "JSON"
+	case KindArray:
+		return "ARRAY"
+	case KindBlob:
+		return "BLOB"
+	}
+	return "ANY"
+}
+
+// Param is one declared parameter of a function.
+type Param struct {
+	Name     string
+	Kind     Kind
+	Optional bool // optional parameters may only follow required ones
+}
+
+// Function is a scalar function callable from SQL expressions. Arguments are
+// converted to the declared parameter kinds before Impl is called.
+type Function struct {
+	Name     string
+	Params   []Param
+	Variadic bool // the last parameter may repeat
+	Returns  Kind
+	// NullCall functions are called with NULL arguments; for all others any
+	// NULL argument makes the result NULL without calling Impl.
+	NullCall bool
+	Impl     func(args []interface{}) (interface{}, error)
+}
+
+// Signature renders the function's declared parameters, e.g. SUBSTR(string TEXT, start INTEGER [, length INTEGER]).
+func (f *Function) Signature() string {
+	var sb strings.Builder
+	sb.WriteString(f.Name)
+	sb.WriteString("(")
+	for i, p := range f.Params {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if p.Optional {
+			sb.WriteString("[")
+		}
+		sb.WriteString(p.Name + " " + p.Kind.String())
+		if p.Optional {
+			sb.WriteString("]")
+		}
+	}
+	if f.Variadic {
+		sb.WriteString(", ...")
+	}
+	sb.WriteString(") -> " + f.Returns.String())
+	return sb.String()
+}
+
+func (f *Function) minArgs() int {
+	n := 0
+	for _, p := range f.Params {
+		if !p.Optional {
+			n++
+		}
+	}
+	return n
+}
+
+// param returns the declared parameter for argument position i.
+func (f *Function) param(i int) Param {
+	if i >= len(f.Params) {
+		return f.Params[len(f.Params)-1]
+	}
+	return f.Params[i]
+}
+
+var builtinFunctions = map[string]*Function{}
+
+func registerBuiltin(f *Function) {
+	builtinFunctions[f.Name] = f
+}
+
+// LookupFunction returns the built-in or user-defined scalar function
+// registered under name.
+func LookupFunction(name string) (*Function, bool) {
+	name = strings.ToUpper(name)
+	if f, ok := builtinFunctions[name]; ok {
+		return f, true
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := userFunctions[name]
+	return f, ok
+}
+
+// BuiltinFunctions returns the built-in scalar functions sorted by name.
+func BuiltinFunctions() []*Function {
+	out := make([]*Function, 0, len(builtinFunctions))
+	for _, f := range builtinFunctions {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// scalar function call node
+type funcCall struct {
+	fn   *Function
+	args []ValueExpr
+}
+
+func newFuncCall(name string, args []ValueExpr) (ValueExpr, error) {
+	fn, ok := LookupFunction(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown function: %s", name)
+	}
+	if len(args) < fn.minArgs() || (!fn.Variadic && len(args) > len(fn.Params)) {
+		return nil, fmt.Errorf("%s expects %s, got %d argument(s)", fn.Name, arityText(fn), len(args))
+	}
+	for i, a := range args {
+		p := fn.param(i)
+		if err := checkKind(a, p.Kind, nil); err != nil {
+			return nil, fmt.Errorf("%s: argument %d (%s) %w", fn.Name, i+1, p.Name, err)
+		}
+	}
+	return &funcCall{fn: fn, args: args}, nil
+}
+
+func arityText(fn *Function) string {
+	min, max := fn.minArgs(), len(fn.Params)
+	switch {
+	case fn.Variadic:
+		return fmt.Sprintf("at least %d argument(s)", min)
+	case min == max:
+		return fmt.Sprintf("%d argument(s)", min)
+	}
+	return fmt.Sprintf("%d to %d arguments", min, max)
+}
+
+func (f *funcCall) EvalValue(row storage.Row) (interface{}, error) {
+	args := make([]interface{}, len(f.args))
+	for i, a := range f.args {
+		v, err := a.EvalValue(row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			if !f.fn.NullCall {
+				return nil, nil
+			}
+			continue
+		}
+		p := f.fn.param(i)
+		cv, err := coerceArg(v, p.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("%s: argument %d (%s) %w", f.fn.Name, i+1, p.Name, err)
+		}
+		args[i] = cv
+	}
+	v, err := f.fn.Impl(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.fn.Name, err)
+	}
+	return v, nil
+}
+
+func (f *funcCall) children() []ValueExpr { return f.args }
+
+// staticKind returns the kind an expression is known to produce without
+// evaluating it, using colKind for column references when it is not nil,
+// or KindAny when it depends on row data.
+func staticKind(v ValueExpr, colKind func(name string) Kind) Kind {
+	switch n := v.(type) {
+	case *colRef:
+		if colKind != nil {
+			return colKind(n.name)
+		}
+	case *literal:
+		return kindOf(n.val)
+	case *funcCall:
+		return n.fn.Returns
+	case *AggregateCall:
+		return n.Func.Returns
+	case *arithOp:
+		return arithKind(staticKind(n.left, colKind), staticKind(n.right, colKind))
+	case *concatOp:
+		return KindText
+	case *castOp:
+		return KindOfType(n.typ)
+	case *jsonGetOp:
+		if n.asText {
+			return KindText
+		}
+		return KindJSON
+	case *arrayCtor:
+		return KindArray
+	case Expr:
+		return KindBoolean
+	}
+	return KindAny
+}
+
+func kindOf(v interface{}) Kind {
+	switch v.(type) {
+	case string:
+		return KindText
+	case int, int64, int32:
+		return KindInteger
+	case float64, float32, Numeric:
+		return KindNumeric
+	case bool:
+		return KindBoolean
+	case time.Time, Date, TimestampTZ:
+		return KindTimestamp
+	case Interval:
+		return KindInterval
+	case JSON:
+		return KindJSON
+	case Array:
+		return KindArray
+	case storage.BlobRef:
+		return KindBlob
+	}
+	return KindAny
+}
+
+// arithKind returns the kind of an arithmetic result from its operands'
+// kinds: timestamps shifted by intervals stay timestamps, scaled intervals
+// stay intervals, and anything else involving either is known only at run
+// time.
+func arithKind(l, r Kind) Kind {
+	switch {
+	case l == KindTimestamp && r == KindInterval, l == KindInterval && r == KindTimestamp:
+		return KindTimestamp
+	case l == KindInterval && r == KindInterval,
+		l == KindInterval && (r == KindNumeric || r == KindInteger),
+		r == KindInterval && (l == KindNumeric || l == KindInteger):
+		return KindInterval
+	case l == KindTimestamp || r == KindTimestamp || l == KindInterval || r == KindInterval:
+		return KindAny
+	}
+	return KindNumeric
+}
+
+// checkKind rejects arguments whose kind is known at parse time to be
+// incompatible with the parameter, taking the kinds of columns from colKind
+// when it is not nil. Literals are checked by trying the same conversion
+// used at run time; only text is taken for TEXT.
+func checkKind(arg ValueExpr, want Kind, colKind func(name string) Kind) error {
+	if want == KindAny {
+		return nil
+	}
+	if l, ok := arg.(*literal); ok && want != KindText {
+		if l.val == nil {
+			return nil
+		}
+		_, err := coerceArg(l.val, want)
+		return err
+	}
+	got := staticKind(arg, colKind)
+	// a JSON element's type is only known once it is evaluated
+	if got == KindAny || got == want || got == KindJSON {
+		return nil
+	}
+	if want == KindText {
+		return fmt.Errorf("must be TEXT, got %s", got)
+	}
+	if want == KindNumeric && got == KindInteger || want == KindInteger && got == KindNumeric {
+		return nil
+	}
+	if (want == KindTimestamp || want == KindInterval || want == KindJSON || want == KindArray) && got == KindText {
+		return nil
+	}
+	return fmt.Errorf("must be %s, got %s", want, got)
+}
+
+// coerceArg converts a non-NULL argument value to the representation a
+// function implementation expects for kind k.
+func coerceArg(v interface{}, k Kind) (interface{}, error) {
+	if k != KindJSON && k != KindAny {
+		v = unwrapJSON(v)
+	}
+	switch k {
+	case KindText:
+		return formatText(v), nil
+	case KindNumeric:
+		if _, ok := v.(bool); ok {
+			return nil, fmt.Errorf("must be NUMERIC, got '%v'", v)
+		}
+		if n, ok := v.(Numeric); ok {
+			return n, nil
+		}
+		i, f, isInt, ok := toNumber(v)
+		if !ok {
+			return nil, fmt.Errorf("must be NUMERIC, got '%v'", v)
+		}
+		if isInt {
+			return i, nil
+		}
+		return f, nil
+	case KindInteger:
+		i, f, isInt, ok := toNumber(v)
+		if _, isBool := v.(bool); !ok || isBool {
+			return nil, fmt.Errorf("must be INTEGER, got '%v'", v)
+		}
+		if !isInt {
+			if f != math.Trunc(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("must be INTEGER, got '%v'", v)
+			}
+			i = int64(f)
+		}
+		return i, nil
+	case KindBoolean:
+		b, err := CastValue(v, schema.Boolean)
+		if err != nil {
+			return nil, fmt.Errorf("must be BOOLEAN, got '%v'", v)
+		}
+		return b, nil
+	case KindTimestamp:
+		t, ok := toTime(v)
+		if !ok {
+			return nil, fmt.Errorf("must be TIMESTAMP, got '%v'", v)
+		}
+		return t, nil
+	case KindInterval:
+		iv, ok := toInterval(v)
+		if !ok {
+			return nil, fmt.Errorf("must be INTERVAL, got '%v'", v)
+		}
+		return iv, nil
+	case KindJSON:
+		j, err := toJSON(v)
+		if err != nil {
+			return nil, fmt.Errorf("must be JSON, got '%v'", v)
+		}
+		return j, nil
+	case KindArray:
+		a, err := toArray(v)
+		if err != nil {
+			return nil, fmt.Errorf("must be ARRAY, got '%v'", v)
+		}
+		return a, nil
+	case KindBlob:
+		ref, ok := storage.BlobRefOf(v)
+		if !ok {
+			return nil, fmt.Errorf("must be BLOB, got '%v'", v)
+		}
+		return ref, nil
+	}
+	return v, nil
+}
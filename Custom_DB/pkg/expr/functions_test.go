@@ -0,0 +1,125 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"Custom_DB/pkg/storage"
+)
+
+func evalString(t *testing.T, raw string, row storage.Row) interface{} {
+	t.Helper()
+	e, err := ParseValue(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	v, err := e.EvalValue(row)
+	if err != nil {
+		t.Fatalf("eval %q: %v", raw, err)
+	}
+	return v
+}
+
+func TestStringFunctions(t *testing.T) {
+	row := storage.Row{"name": "  Ada Lovelace ", "email": "ada@example.com", "nick": nil}
+	cases := []struct {
+		raw  string
+		want interface{}
+	}{
+		{"UPPER(TRIM(name))", "ADA LOVELACE"},
+		{"lower('MiXeD')", "mixed"},
+		{"LENGTH(TRIM(name))", int64(12)},
+		{"SUBSTR(TRIM(name), 5)", "Lovelace"},
+		{"SUBSTR('abcdef', 2, 3)", "bcd"},
+		{"SUBSTR('abc', 0, 2)", "a"},
+		{"REPLACE(email, 'example', 'test')", "ada@test.com"},
+		{"SPLIT_PART(email, '@', 2)", "example.com"},
+		{"SPLIT_PART(email, '@', 5)", ""},
+		{"UPPER(nick)", nil},
+	}
+	for _, c := range cases {
+		if got := evalString(t, c.raw, row); got != c.want {
+			t.Fatalf("%q: got %#v, want %#v", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestMathFunctions(t *testing.T) {
+	row := storage.Row{"x": -2.5, "n": 7, "s": "3.14159"}
+	cases := []struct {
+		raw  string
+		want interface{}
+	}{
+		{"ABS(x)", 2.5},
+		{"ABS(-3)", int64(3)},
+		{"ROUND(s, 2)", 3.14},
+		{"ROUND(x)", -3.0},
+		{"FLOOR(x)", -3.0},
+		{"CEIL(x)", -2.0},
+		{"POWER(2, 10)", 1024.0},
+		{"MOD(n, 4)", int64(3)},
+	}
+	for _, c := range cases {
+		if got := evalString(t, c.raw, row); got != c.want {
+			t.Fatalf("%q: got %#v, want %#v", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestDateFunctions(t *testing.T) {
+	row := storage.Row{"ts": "2024-05-17T13:45:30Z"}
+	cases := []struct {
+		raw  string
+		want interface{}
+	}{
+		{"EXTRACT(YEAR FROM ts)", int64(2024)},
+		{"EXTRACT('month', ts)", int64(5)},
+		{"EXTRACT(QUARTER FROM ts)", int64(2)},
+		{"DATE_TRUNC('month', ts)", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"DATE_ADD(ts, 1, 'month')", time.Date(2024, 6, 17, 13, 45, 30, 0, time.UTC)},
+		{"DATE_ADD('2024-01-31', 1)", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		if got := evalString(t, c.raw, row); got != c.want {
+			t.Fatalf("%q: got %#v, want %#v", c.raw, got, c.want)
+		}
+	}
+	e, err := ParseExpression("ts > '2024-01-01' AND NOW() > ts")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if ok, err := e.Eval(storage.Row{"ts": time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)}); err != nil || !ok {
+		t.Fatalf("expected timestamp comparison to hold, got %v, %v", ok, err)
+	}
+}
+
+func TestFunctionSignatureErrors(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{"UPPER()", "UPPER expects 1 argument(s), got 0"},
+		{"UPPER(1)", "UPPER: argument 1 (string) must be TEXT, got INTEGER"},
+		{"SUBSTR('a', 1, 2, 3)", "SUBSTR expects 2 to 3 arguments, got 4"},
+		{"ROUND('abc')", "ROUND: argument 1 (value) must be NUMERIC"},
+		{"SUBSTR(name, 'x')", "SUBSTR: argument 2 (start) must be INTEGER"},
+		{"ABS(UPPER(name))", "ABS: argument 1 (value) must be NUMERIC, got TEXT"},
+		{"DATE_TRUNC('day', 'not a date')", "DATE_TRUNC: argument 2 (timestamp) must be TIMESTAMP"},
+	}
+	for _, c := range cases {
+		_, err := ParseValue(c.raw)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%q: expected error containing %q, got %v", c.raw, c.want, err)
+		}
+	}
+
+	e, err := ParseValue("ABS(name)")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	_, err = e.EvalValue(storage.Row{"name": "Bob"})
+	if err == nil || !strings.Contains(err.Error(), "ABS: argument 1 (value) must be NUMERIC, got 'Bob'") {
+		t.Fatalf("expected runtime type error naming the function and argument, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"Custom_DB/pkg/storage"
+)
+
+// GroupKey builds a composite key for a tuple of values. Parts are tagged
+// with their type so that the text '1' and the number 1 form different
+// groups, while numbers compare by value whatever their Go type, and NULLs
+// group together.
+func GroupKey(vals ...interface{}) string {
+	var sb strings.Builder
+	for i, v := range vals {
+		if i > 0 {
+			sb.WriteByte(0x1f)
+		}
+		switch t := v.(type) {
+		case nil:
+			sb.WriteString("N")
+		case bool:
+			sb.WriteString("b:" + strconv.FormatBool(t))
+		case string:
+			sb.WriteString("s:" + t)
+		case time.Time, Date, TimestampTZ:
+			ts, _ := toTime(t)
+			sb.WriteString("t:" + strconv.FormatInt(ts.UnixNano(), 10))
+		case Interval:
+			sb.WriteString("i:" + strconv.FormatInt(t.micros(), 10))
+		case Numeric:
+			sb.WriteString("n:" + t.canonical())
+		case JSON:
+			sb.WriteString("j:" + t.String())
+		case Array:
+			sb.WriteString("a:" + t.String())
+		case Struct:
+			sb.WriteString("r:" + t.String())
+		default:
+			if i, f, isInt, ok := toNumber(v); ok {
+				sb.WriteString("n:" + numberKey(i, f, isInt))
+			} else {
+				sb.WriteString(fmt.Sprintf("%T:%v", v, v))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// numberKey renders a number exactly, the way Numeric.canonical does, so
+// equal integers, floats and numerics share a key.
+func numberKey(i int64, f float64, isInt bool) string {
+	if isInt {
+		return strconv.FormatInt(i, 10)
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1e18 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	if n, ok := numericFromFloat(f, 64); ok {
+		return n.canonical()
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// GroupingCall is GROUPING(a, b, ...) in a grouped query. Its value is a
+// bit mask with one bit per argument, most significant first, set when the
+// argument is not part of the grouping set that produced the row. Like
+// AggregateCall the executor computes it per group and stores it under Slot.
+type GroupingCall struct {
+	Args     []ValueExpr
+	ArgTexts []string
+	Slot     string
+}
+
+func (g *GroupingCall) EvalValue(row storage.Row) (interface{}, error) {
+	if g.Slot != "" {
+		if v, ok := row[g.Slot]; ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("GROUPING is only allowed in grouped queries")
+}
+
+func (g *GroupingCall) children() []ValueExpr { return g.Args }
+
+// CollectGroupingCalls returns the GROUPING calls in an expression.
+func CollectGroupingCalls(e interface{}) []*GroupingCall {
+	out := []*GroupingCall{}
+	var walk func(interface{})
+	walk = func(x interface{}) {
+		if g, ok := x.(*GroupingCall); ok {
+			out = append(out, g)
+			return
+		}
+		if n, ok := x.(node); ok {
+			for _, ch := range n.children() {
+				if ch != nil {
+					walk(ch)
+				}
+			}
+		}
+	}
+	if e != nil {
+		walk(e)
+	}
+	return out
+}
+
+func (p *parser) parseGroupingCall() (ValueExpr, error) {
+	call := &GroupingCall{}
+	for {
+		start := p.pos
+		a, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, a)
+		call.ArgTexts = append(call.ArgTexts, joinTokens(p.toks[start:p.pos]))
+		if p.cur() != "," {
+			break
+		}
+		p.eat()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, fmt.Errorf("GROUPING: %w", err)
+	}
+	if len(call.Args) > 31 {
+		return nil, fmt.Errorf("GROUPING accepts at most 31 arguments")
+	}
+	return call, nil
+}
@@ -0,0 +1,87 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Equality is a condition of the form Expr = Value, where Value is a
+// constant, found among the AND-ed conditions of a WHERE clause.
+type Equality struct {
+	Expr  ValueExpr
+	Value interface{}
+}
+
+// Equalities returns the conditions of e, a WHERE expression, that compare
+// an expression with a constant using =. Every row that satisfies e
+// satisfies each of them, so an index on the expression can narrow the rows
+// to read before e itself is evaluated.
+func Equalities(e Expr) []Equality {
+	out := []Equality{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch n := v.(type) {
+		case *binaryOp:
+			if strings.EqualFold(n.op, "AND") {
+				walk(n.left)
+				walk(n.right)
+			}
+		case *compOp:
+			if n.op != "=" {
+				return
+			}
+			if c, ok := constantValue(n.right); ok {
+				out = append(out, Equality{Expr: n.left, Value: c})
+			} else if c, ok := constantValue(n.left); ok {
+				out = append(out, Equality{Expr: n.right, Value: c})
+			}
+		}
+	}
+	walk(e)
+	return out
+}
+
+// constantValue evaluates v when it refers to no columns and calls no
+// aggregates.
+func constantValue(v ValueExpr) (interface{}, bool) {
+	if len(CollectColumns(v)) > 0 || len(CollectAggregates(v)) > 0 || len(CollectGroupingCalls(v)) > 0 {
+		return nil, false
+	}
+	c, err := v.EvalValue(nil)
+	if err != nil {
+		return nil, false
+	}
+	return c, true
+}
+
+// SameExpr reports whether two parsed expressions are the same, such as an
+// indexed expression and one written in a query.
+func SameExpr(a, b ValueExpr) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// IndexKeys returns the keys an expression index files v under, and looks
+// a constant up by. Values that compare equal share at least one key: a
+// number is filed under its value and its text, so the TEXT '5' and the
+// number 5 meet, and JSON scalars are filed like the values they hold. NULL
+// has no keys. ok is false for dates, timestamps and intervals, which
+// compare with text by parsing it; an index that holds them is not used.
+func IndexKeys(v interface{}) (keys []string, ok bool) {
+	v = unwrapJSON(v)
+	switch v.(type) {
+	case nil:
+		return nil, true
+	case time.Time, Date, TimestampTZ, Interval:
+		return nil, false
+	}
+	keys = []string{"s:" + formatText(v)}
+	if _, isBool := v.(bool); !isBool {
+		if n, isNum := v.(Numeric); isNum {
+			keys = append(keys, "n:"+n.canonical())
+		} else if i, f, isInt, isNum := toNumber(v); isNum {
+			keys = append(keys, "n:"+numberKey(i, f, isInt))
+		}
+	}
+	return keys, true
+}
@@ -0,0 +1,402 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// JSON is the value of a JSON or JSONB column. It holds the document as
+// canonical text, with object keys sorted and no insignificant whitespace, so
+// equal documents compare, group and index alike. It is written to storage
+// as the nested JSON value itself rather than as a string.
+type JSON struct{ text string }
+
+// ParseJSON validates JSON text and returns it as a JSON value.
+func ParseJSON(s string) (JSON, error) {
+	doc, err := decodeJSON(s)
+	if err != nil {
+		return JSON{}, fmt.Errorf("invalid input syntax for type json: %w", err)
+	}
+	return jsonOf(doc)
+}
+
+// jsonOf encodes a decoded document made of maps, slices, strings, numbers,
+// bools and nil.
+func jsonOf(doc interface{}) (JSON, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return JSON{}, err
+	}
+	return JSON{text: strings.TrimSuffix(buf.String(), "\n")}, nil
+}
+
+// decodeJSON decodes JSON text, keeping numbers as json.Number so they stay
+// exact.
+func decodeJSON(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return doc, nil
+}
+
+// String returns the canonical JSON text.
+func (j JSON) String() string {
+	if j.text == "" {
+		return "null"
+	}
+	return j.text
+}
+
+// MarshalJSON writes the document itself, so stored rows keep it nested.
+func (j JSON) MarshalJSON() ([]byte, error) { return []byte(j.String()), nil }
+
+// doc decodes the document.
+func (j JSON) doc() interface{} {
+	doc, _ := decodeJSON(j.String())
+	return doc
+}
+
+// toJSON converts a value to JSON: JSON values as they are, and text parsed
+// as a JSON document so path operators also work on TEXT columns holding
+// JSON.
+func toJSON(v interface{}) (JSON, error) {
+	switch t := v.(type) {
+	case JSON:
+		return t, nil
+	case string:
+		return ParseJSON(t)
+	}
+	return JSON{}, fmt.Errorf("'%v' is not a JSON value", v)
+}
+
+// castJSON converts v to JSON for CAST and JSON columns: text is parsed as a
+// document, numbers and booleans become JSON scalars and other values become
+// JSON strings.
+func castJSON(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case JSON:
+		return t, nil
+	case string:
+		return ParseJSON(t)
+	case bool:
+		return jsonOf(t)
+	case Numeric:
+		return JSON{text: t.String()}, nil
+	}
+	if i, f, isInt, ok := toNumber(v); ok {
+		if isInt {
+			return jsonOf(i)
+		}
+		return jsonOf(f)
+	}
+	return jsonOf(formatText(v))
+}
+
+// jsonValue returns an element of a document as a SQL value: strings,
+// numbers and booleans as scalars, JSON null as NULL and objects and arrays
+// as JSON.
+func jsonValue(doc interface{}) interface{} {
+	switch t := doc.(type) {
+	case nil:
+		return nil
+	case string, bool:
+		return t
+	case json.Number:
+		return jsonNumber(t)
+	}
+	j, _ := jsonOf(doc)
+	return j
+}
+
+// jsonNumber converts a JSON number to int64 when it is a whole number that
+// fits, or to an exact NUMERIC otherwise.
+func jsonNumber(n json.Number) interface{} {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return i
+	}
+	if d, err := ParseNumeric(string(n)); err == nil {
+		return d
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// jsonText returns an element of a document as TEXT, the way ->> does:
+// strings without their quotes, JSON null as NULL and anything else as JSON
+// text.
+func jsonText(doc interface{}) interface{} {
+	switch t := doc.(type) {
+	case nil:
+		return nil
+	case string:
+		return t
+	}
+	j, _ := jsonOf(doc)
+	return j.String()
+}
+
+// unwrapJSON returns the SQL scalar held by a JSON string, number or
+// boolean, so doc->'n' = 5 and doc->'name' = 'x' compare the way they read.
+// Any other value is returned unchanged.
+func unwrapJSON(v interface{}) interface{} {
+	j, ok := v.(JSON)
+	if !ok {
+		return v
+	}
+	switch doc := j.doc().(type) {
+	case string, bool, json.Number:
+		return jsonValue(doc)
+	}
+	return v
+}
+
+// jsonTypeName names the JSON type of a document element.
+func jsonTypeName(doc interface{}) string {
+	switch doc.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// jsonStep returns the member named by key of an object, or the element at
+// key of an array; negative indexes count from the end. ok is false when
+// there is no such element.
+func jsonStep(doc interface{}, key interface{}) (interface{}, bool) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		name, isText := key.(string)
+		if !isText {
+			return nil, false
+		}
+		v, ok := d[name]
+		return v, ok
+	case []interface{}:
+		if _, isText := key.(string); isText {
+			return nil, false
+		}
+		i, f, isInt, ok := toNumber(key)
+		if !ok || !isInt && f != float64(int64(f)) {
+			return nil, false
+		}
+		if !isInt {
+			i = int64(f)
+		}
+		if i < 0 {
+			i += int64(len(d))
+		}
+		if i < 0 || i >= int64(len(d)) {
+			return nil, false
+		}
+		return d[i], true
+	}
+	return nil, false
+}
+
+// jsonPath walks a path such as $.a.b[0] or $."odd key"[2] through a
+// document. ok is false when an element along the path is missing.
+func jsonPath(doc interface{}, path string) (interface{}, bool, error) {
+	p := strings.TrimSpace(path)
+	if !strings.HasPrefix(p, "$") {
+		return nil, false, fmt.Errorf("JSON path '%s' must start with $", path)
+	}
+	p = p[1:]
+	found := true
+	for p != "" && found {
+		var key interface{}
+		switch p[0] {
+		case '.':
+			p = p[1:]
+			if strings.HasPrefix(p, `"`) {
+				end := strings.Index(p[1:], `"`)
+				if end == -1 {
+					return nil, false, fmt.Errorf("unterminated key in JSON path '%s'", path)
+				}
+				key, p = p[1:end+1], p[end+2:]
+				break
+			}
+			end := strings.IndexAny(p, ".[")
+			if end == -1 {
+				end = len(p)
+			}
+			if end == 0 {
+				return nil, false, fmt.Errorf("empty key in JSON path '%s'", path)
+			}
+			key, p = p[:end], p[end:]
+		case '[':
+			end := strings.Index(p, "]")
+			if end == -1 {
+				return nil, false, fmt.Errorf("unterminated index in JSON path '%s'", path)
+			}
+			inner := strings.TrimSpace(p[1:end])
+			p = p[end+1:]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				key = inner[1 : len(inner)-1]
+				break
+			}
+			i, err := strconv.ParseInt(inner, 10, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid index '%s' in JSON path '%s'", inner, path)
+			}
+			key = i
+		default:
+			return nil, false, fmt.Errorf("invalid JSON path '%s'", path)
+		}
+		doc, found = jsonStep(doc, key)
+	}
+	return doc, found, nil
+}
+
+// json -> key and json ->> key node
+type jsonGetOp struct {
+	left   ValueExpr
+	key    ValueExpr
+	asText bool // ->> returns TEXT rather than JSON
+}
+
+func (g *jsonGetOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := g.left.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := g.key.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil || kv == nil {
+		return nil, nil
+	}
+	j, err := toJSON(lv)
+	if err != nil {
+		return nil, fmt.Errorf("operator %s: %w", g.op(), err)
+	}
+	elem, ok := jsonStep(j.doc(), kv)
+	if !ok {
+		return nil, nil
+	}
+	if g.asText {
+		return jsonText(elem), nil
+	}
+	if elem == nil {
+		// a JSON null member is still a JSON value
+		return JSON{text: "null"}, nil
+	}
+	return jsonOf(elem)
+}
+
+func (g *jsonGetOp) op() string {
+	if g.asText {
+		return "->>"
+	}
+	return "->"
+}
+
+func (g *jsonGetOp) children() []ValueExpr { return []ValueExpr{g.left, g.key} }
+
+// parseJSONGet parses the -> or ->> operator and key following left.
+func (p *parser) parseJSONGet(left ValueExpr) (ValueExpr, error) {
+	op := p.eat()
+	var key ValueExpr
+	if p.cur() == "-" {
+		// a negative array index
+		p.eat()
+		n, ok := parseNumber(p.cur())
+		i, isInt := n.(int64)
+		if !ok || !isInt {
+			return nil, fmt.Errorf("operator %s expects a key or an index", op)
+		}
+		p.eat()
+		key = &literal{val: -i}
+	} else {
+		var err error
+		key, err = p.parsePrimary()
+		if err != nil {
+			return nil, fmt.Errorf("operator %s: %w", op, err)
+		}
+	}
+	return &jsonGetOp{left: left, key: key, asText: op == "->>"}, nil
+}
+
+func init() {
+	jsonParam := func(name string) Param { return Param{Name: name, Kind: KindJSON} }
+	text := func(name string) Param { return Param{Name: name, Kind: KindText} }
+
+	registerBuiltin(&Function{Name: "JSON_EXTRACT", Params: []Param{jsonParam("json"), text("path")}, Returns: KindAny,
+		Impl: func(args []interface{}) (interface{}, error) {
+			elem, ok, err := jsonPath(args[0].(JSON).doc(), args[1].(string))
+			if err != nil || !ok {
+				return nil, err
+			}
+			return jsonValue(elem), nil
+		}})
+	registerBuiltin(&Function{Name: "JSON_ARRAY_LENGTH", Params: []Param{jsonParam("json"), {Name: "path", Kind: KindText, Optional: true}}, Returns: KindInteger,
+		Impl: func(args []interface{}) (interface{}, error) {
+			doc := args[0].(JSON).doc()
+			if len(args) > 1 {
+				elem, ok, err := jsonPath(doc, args[1].(string))
+				if err != nil || !ok {
+					return nil, err
+				}
+				doc = elem
+			}
+			arr, _ := doc.([]interface{})
+			return int64(len(arr)), nil
+		}})
+	registerBuiltin(&Function{Name: "JSON_TYPEOF", Params: []Param{jsonParam("json")}, Returns: KindText,
+		Impl: func(args []interface{}) (interface{}, error) { return jsonTypeName(args[0].(JSON).doc()), nil }})
+
+	registerTableFunction(&TableFunction{
+		Name:   "JSON_EACH",
+		Params: []Param{jsonParam("json")},
+		// key is TEXT for object members and an integer index for array
+		// elements, so it has no fixed type
+		Columns: []schema.Column{{Name: "key"}, {Name: "value", Type: schema.JSON}, {Name: "type", Type: schema.Text}},
+		Impl: func(args []interface{}) ([]storage.Row, error) {
+			rows := []storage.Row{}
+			each := func(key interface{}, elem interface{}) {
+				v, _ := jsonOf(elem)
+				rows = append(rows, storage.Row{"key": key, "value": v, "type": jsonTypeName(elem)})
+			}
+			switch d := args[0].(JSON).doc().(type) {
+			case map[string]interface{}:
+				keys := make([]string, 0, len(d))
+				for k := range d {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					each(k, d[k])
+				}
+			case []interface{}:
+				for i, elem := range d {
+					each(int64(i), elem)
+				}
+			default:
+				return nil, fmt.Errorf("cannot expand a JSON %s", jsonTypeName(d))
+			}
+			return rows, nil
+		}})
+}
@@ -0,0 +1,192 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func TestParseJSON(t *testing.T) {
+	j, err := ParseJSON(` { "b": [1, 2.50, {"c": null}], "a": "x<y" } `)
+	if err != nil {
+		t.Fatalf("ParseJSON: %v", err)
+	}
+	if got := j.String(); got != `{"a":"x<y","b":[1,2.50,{"c":null}]}` {
+		t.Fatalf("canonical text = %s", got)
+	}
+	for _, bad := range []string{"", "{", "[1,]", "{} {}", "nope"} {
+		if _, err := ParseJSON(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestJSONExpressions(t *testing.T) {
+	doc, _ := ParseJSON(`{"name": "Ada", "age": 36, "tags": ["math", "engines"], "addr": {"city": "London", "zip": null}, "big": 12345678901234567890}`)
+	row := storage.Row{"doc": doc, "raw": `{"n": [10, 20, 30]}`}
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{"doc->'name'", `"Ada"`},
+		{"doc->>'name'", "Ada"},
+		{"doc->'addr'->>'city'", "London"},
+		{"doc->'addr'", `{"city":"London","zip":null}`},
+		{"doc->'addr'->'zip'", "null"},
+		{"doc->'addr'->>'zip'", "NULL"},
+		{"doc->'tags'->>0", "math"},
+		{"doc->'tags'->>-1", "engines"},
+		{"doc->'tags'->>5", "NULL"},
+		{"doc->'missing'->>'x'", "NULL"},
+		{"doc->'age' + 1", "37"},
+		{"doc->>'age' || '!'", "36!"},
+		{"raw->'n'->>1", "20"},
+		{"JSON_EXTRACT(doc, '$.addr.city')", "London"},
+		{"JSON_EXTRACT(doc, '$.tags[1]')", "engines"},
+		{"JSON_EXTRACT(doc, '$.tags')", `["math","engines"]`},
+		{"JSON_EXTRACT(doc, '$.big')", "12345678901234567890"},
+		{"JSON_EXTRACT(doc, '$.nope[0]')", "NULL"},
+		{"JSON_ARRAY_LENGTH(doc->'tags')", "2"},
+		{"JSON_ARRAY_LENGTH(doc, '$.tags')", "2"},
+		{"JSON_ARRAY_LENGTH(doc)", "0"},
+		{"JSON_TYPEOF(doc->'addr')", "object"},
+		{"CAST(doc->'age' AS INT) * 2", "72"},
+		{"CAST('[1, 2]' AS JSONB)", "[1,2]"},
+		{"UPPER(doc->'name')", "ADA"},
+	}
+	for _, c := range cases {
+		v, err := ParseValue(c.raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.raw, err)
+		}
+		got, err := v.EvalValue(row)
+		if err != nil {
+			t.Fatalf("eval %q: %v", c.raw, err)
+		}
+		if FormatValue(got) != c.want {
+			t.Fatalf("%q = %s, want %s", c.raw, FormatValue(got), c.want)
+		}
+	}
+
+	conds := map[string]bool{
+		"doc->'age' = 36":                                       true,
+		"doc->'age' > 40":                                       false,
+		"doc->'name' = 'Ada'":                                   true,
+		"doc->>'name' IN ('Ada', 'Bob')":                        true,
+		"doc->'name' IN ('Ada', 'Bob')":                         true,
+		"doc->'addr'->'zip' IS NULL":                            false,
+		"doc->'addr'->>'zip' IS NULL":                           true,
+		"doc->'tags' = CAST('[\"math\", \"engines\"]' AS JSON)": true,
+	}
+	for raw, want := range conds {
+		e, err := ParseExpression(raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", raw, err)
+		}
+		got, err := e.Eval(row)
+		if err != nil || got != want {
+			t.Fatalf("%q = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+
+	for _, raw := range []string{"JSON_EXTRACT(doc, 'tags')", "CAST('{' AS JSON)", "doc->'age'->'x' = (1 -> 'a')"} {
+		v, err := ParseValue(raw)
+		if err == nil {
+			_, err = v.EvalValue(row)
+		}
+		if err == nil {
+			t.Fatalf("expected an error for %q", raw)
+		}
+	}
+}
+
+func TestJSONStorageRoundTrip(t *testing.T) {
+	doc, _ := ParseJSON(`{"a": [1, {"b": true}]}`)
+	// stored rows hold the document itself; reading it back gives the same
+	// JSON value
+	decoded := DecodeValue(map[string]interface{}{"a": []interface{}{int64(1), map[string]interface{}{"b": true}}}, schema.JSON, time.UTC)
+	if decoded != doc {
+		t.Fatalf("DecodeValue = %#v, want %#v", decoded, doc)
+	}
+	if b, _ := doc.MarshalJSON(); string(b) != `{"a":[1,{"b":true}]}` {
+		t.Fatalf("MarshalJSON = %s", b)
+	}
+	if GroupKey(doc) != GroupKey(decoded) || GroupKey(doc) == GroupKey(doc.String()) {
+		t.Fatalf("JSON values should group by document, apart from text")
+	}
+}
+
+func TestJSONEach(t *testing.T) {
+	call, err := ParseTableCall(`JSON_EACH('{"b": [1], "a": 2}')`)
+	if err != nil {
+		t.Fatalf("ParseTableCall: %v", err)
+	}
+	rows, err := call.Rows(storage.Row{})
+	if err != nil {
+		t.Fatalf("Rows: %v", err)
+	}
+	got := []string{}
+	for _, r := range rows {
+		got = append(got, FormatValue(r["key"])+"="+FormatValue(r["value"])+":"+FormatValue(r["type"]))
+	}
+	if strings.Join(got, " ") != "a=2:number b=[1]:array" {
+		t.Fatalf("JSON_EACH rows = %q", got)
+	}
+
+	call, _ = ParseTableCall("JSON_EACH(doc->'items')")
+	rows, err = call.Rows(storage.Row{"doc": `{"items": ["x", "y"]}`})
+	if err != nil || len(rows) != 2 || rows[1]["key"] != int64(1) || FormatValue(rows[1]["value"]) != `"y"` {
+		t.Fatalf("JSON_EACH over an array = %v, %v", rows, err)
+	}
+	if rows, err := call.Rows(storage.Row{}); err != nil || len(rows) != 0 {
+		t.Fatalf("JSON_EACH(NULL) = %v, %v", rows, err)
+	}
+	if _, err := call.Rows(storage.Row{"doc": `{"items": 3}`}); err == nil {
+		t.Fatalf("expected an error expanding a JSON number")
+	}
+	for _, bad := range []string{"JSON_EACH()", "NOPE(1)", "JSON_EACH('[1]') x", "JSON_EACH(5)"} {
+		if _, err := ParseTableCall(bad); err == nil {
+			t.Fatalf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestIndexKeys(t *testing.T) {
+	five, _ := ParseJSON("5")
+	shares := func(a, b interface{}) bool {
+		ka, _ := IndexKeys(a)
+		kb, _ := IndexKeys(b)
+		for _, x := range ka {
+			for _, y := range kb {
+				if x == y {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, pair := range [][2]interface{}{{int64(5), "5"}, {five, int64(5)}, {5.0, NumericFromInt(5)}, {true, "true"}} {
+		if !shares(pair[0], pair[1]) {
+			t.Fatalf("%#v and %#v compare equal but share no index key", pair[0], pair[1])
+		}
+	}
+	if shares("a", "b") || shares(int64(1), int64(2)) {
+		t.Fatalf("distinct values should not share index keys")
+	}
+	if _, ok := IndexKeys(Date{}); ok {
+		t.Fatalf("dates are not filed by expression indexes")
+	}
+
+	where, _ := ParseExpression("doc->>'id' = 7 AND (x = 1 OR y = 2) AND 'k' = lower(name)")
+	eqs := Equalities(where)
+	if len(eqs) != 2 || eqs[0].Value != int64(7) || eqs[1].Value != "k" {
+		t.Fatalf("Equalities = %+v", eqs)
+	}
+	indexed, _ := ParseValue("doc->>'id'")
+	if !SameExpr(eqs[0].Expr, indexed) || SameExpr(eqs[1].Expr, indexed) {
+		t.Fatalf("SameExpr should match the indexed expression only")
+	}
+}
@@ -0,0 +1,458 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"Custom_DB/pkg/schema"
+)
+
+// Numeric is an exact decimal number, the value of NUMERIC and NUMERIC(p,s)
+// columns: an arbitrary-precision integer scaled by 10^-scale. The zero
+// value is 0. Numerics are immutable; arithmetic returns new values.
+type Numeric struct {
+	unscaled *big.Int
+	scale    int
+}
+
+// numericDivScale is the least number of decimal places kept by NUMERIC
+// division, and so by AVG over exact values.
+const numericDivScale = 16
+
+// maxNumericExponent bounds the exponent accepted by ParseNumeric, so a
+// literal like 1e999999999 cannot allocate a huge integer.
+const maxNumericExponent = 1000
+
+// ParseNumeric parses decimal text such as -12.50 or 1.5e3 exactly. The
+// scale is the number of digits written after the point.
+func ParseNumeric(s string) (Numeric, error) {
+	t := strings.TrimSpace(s)
+	mant, exp := t, 0
+	if i := strings.IndexAny(t, "eE"); i != -1 {
+		e, err := strconv.Atoi(t[i+1:])
+		if err != nil || e > maxNumericExponent || e < -maxNumericExponent {
+			return Numeric{}, fmt.Errorf("invalid numeric value '%s'", s)
+		}
+		mant, exp = t[:i], e
+	}
+	neg := false
+	if mant != "" && (mant[0] == '-' || mant[0] == '+') {
+		neg = mant[0] == '-'
+		mant = mant[1:]
+	}
+	intPart, frac := mant, ""
+	if i := strings.IndexByte(mant, '.'); i != -1 {
+		intPart, frac = mant[:i], mant[i+1:]
+	}
+	digits := intPart + frac
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return Numeric{}, fmt.Errorf("invalid numeric value '%s'", s)
+	}
+	u, _ := new(big.Int).SetString(digits, 10)
+	if neg {
+		u.Neg(u)
+	}
+	n := Numeric{unscaled: u, scale: len(frac) - exp}
+	if n.scale < 0 {
+		n = n.rescale(0)
+	}
+	return n, nil
+}
+
+// NumericFromInt returns i as a Numeric with scale 0.
+func NumericFromInt(i int64) Numeric {
+	return Numeric{unscaled: big.NewInt(i)}
+}
+
+// numericFromFloat converts a float through its shortest decimal form, so
+// the literal 0.1 becomes exactly 0.1. Infinities and NaN have no decimal
+// form.
+func numericFromFloat(f float64, bits int) (Numeric, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Numeric{}, false
+	}
+	n, err := ParseNumeric(strconv.FormatFloat(f, 'f', -1, bits))
+	return n, err == nil
+}
+
+// toNumeric converts integers, floats, numerics and numeric text to a
+// Numeric.
+func toNumeric(v interface{}) (Numeric, bool) {
+	switch t := v.(type) {
+	case Numeric:
+		return t, true
+	case int:
+		return NumericFromInt(int64(t)), true
+	case int64:
+		return NumericFromInt(t), true
+	case int32:
+		return NumericFromInt(int64(t)), true
+	case float64:
+		return numericFromFloat(t, 64)
+	case float32:
+		return numericFromFloat(float64(t), 32)
+	case string:
+		n, err := ParseNumeric(t)
+		return n, err == nil
+	}
+	return Numeric{}, false
+}
+
+func (n Numeric) int() *big.Int {
+	if n.unscaled == nil {
+		return new(big.Int)
+	}
+	return n.unscaled
+}
+
+// Scale is the number of digits after the decimal point.
+func (n Numeric) Scale() int { return n.scale }
+
+// Sign returns -1, 0 or 1.
+func (n Numeric) Sign() int { return n.int().Sign() }
+
+func (n Numeric) String() string {
+	s := new(big.Int).Abs(n.int()).String()
+	if n.scale > 0 {
+		if len(s) <= n.scale {
+			s = strings.Repeat("0", n.scale-len(s)+1) + s
+		}
+		s = s[:len(s)-n.scale] + "." + s[len(s)-n.scale:]
+	}
+	if n.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// MarshalJSON stores a Numeric as a JSON string, since JSON numbers are read
+// back as floats.
+func (n Numeric) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(n.String())), nil
+}
+
+// Float64 returns the nearest float64.
+func (n Numeric) Float64() float64 {
+	f, _ := strconv.ParseFloat(n.String(), 64)
+	return f
+}
+
+// canonical renders n without trailing fractional zeros, so 1.50 and 1.5
+// share one GROUP BY key.
+func (n Numeric) canonical() string {
+	s := n.String()
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// rescale returns n with the given number of decimal places, rounding half
+// away from zero when digits are dropped.
+func (n Numeric) rescale(scale int) Numeric {
+	u := n.int()
+	switch {
+	case scale == n.scale:
+		return n
+	case scale > n.scale:
+		return Numeric{unscaled: new(big.Int).Mul(u, pow10(scale-n.scale)), scale: scale}
+	}
+	d := pow10(n.scale - scale)
+	q, r := new(big.Int).QuoRem(u, d, new(big.Int))
+	if r.Abs(r).Lsh(r, 1).Cmp(d) >= 0 {
+		q.Add(q, big.NewInt(int64(u.Sign())))
+	}
+	return Numeric{unscaled: q, scale: scale}
+}
+
+// align returns the unscaled values of a and b at their common scale.
+func align(a, b Numeric) (*big.Int, *big.Int, int) {
+	s := a.scale
+	if b.scale > s {
+		s = b.scale
+	}
+	return a.rescale(s).int(), b.rescale(s).int(), s
+}
+
+// Cmp compares n and o by value, returning -1, 0 or 1.
+func (n Numeric) Cmp(o Numeric) int {
+	a, b, _ := align(n, o)
+	return a.Cmp(b)
+}
+
+func (n Numeric) Add(o Numeric) Numeric {
+	a, b, s := align(n, o)
+	return Numeric{unscaled: new(big.Int).Add(a, b), scale: s}
+}
+
+func (n Numeric) Sub(o Numeric) Numeric {
+	a, b, s := align(n, o)
+	return Numeric{unscaled: new(big.Int).Sub(a, b), scale: s}
+}
+
+func (n Numeric) Mul(o Numeric) Numeric {
+	return Numeric{unscaled: new(big.Int).Mul(n.int(), o.int()), scale: n.scale + o.scale}
+}
+
+// Quo divides n by o, keeping at least numericDivScale decimal places and
+// no fewer than either operand has.
+func (n Numeric) Quo(o Numeric) (Numeric, error) {
+	if o.Sign() == 0 {
+		return Numeric{}, fmt.Errorf("division by zero")
+	}
+	scale := numericDivScale
+	if n.scale > scale {
+		scale = n.scale
+	}
+	if o.scale > scale {
+		scale = o.scale
+	}
+	// one extra digit, truncated, is enough to round the last one
+	num := new(big.Int).Mul(n.int(), pow10(scale+1+o.scale-n.scale))
+	q := new(big.Int).Quo(num, o.int())
+	return Numeric{unscaled: q, scale: scale + 1}.rescale(scale), nil
+}
+
+// Rem returns the remainder of n / o truncated toward zero; it has the
+// sign of n.
+func (n Numeric) Rem(o Numeric) (Numeric, error) {
+	if o.Sign() == 0 {
+		return Numeric{}, fmt.Errorf("division by zero")
+	}
+	a, b, s := align(n, o)
+	return Numeric{unscaled: new(big.Int).Rem(a, b), scale: s}, nil
+}
+
+func (n Numeric) Neg() Numeric {
+	return Numeric{unscaled: new(big.Int).Neg(n.int()), scale: n.scale}
+}
+
+// Round rounds n to the given number of decimal places; negative places
+// round to tens, hundreds and so on.
+func (n Numeric) Round(places int) Numeric {
+	if places >= 0 {
+		if places >= n.scale {
+			return n
+		}
+		return n.rescale(places)
+	}
+	r := Numeric{unscaled: n.int(), scale: n.scale - places}.rescale(0)
+	return Numeric{unscaled: new(big.Int).Mul(r.int(), pow10(-places))}
+}
+
+// floorCeil rounds n to an integer toward negative or positive infinity.
+func (n Numeric) floorCeil(ceil bool) Numeric {
+	if n.scale <= 0 {
+		return n
+	}
+	d := pow10(n.scale)
+	q, m := new(big.Int).DivMod(n.int(), d, new(big.Int)) // Euclidean: q rounds down for d > 0
+	if ceil && m.Sign() != 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return Numeric{unscaled: q}
+}
+
+// fit rounds n to the scale of a NUMERIC(precision, scale) column and checks
+// that its integer digits fit.
+func (n Numeric) fit(precision, scale int) (Numeric, error) {
+	r := n.rescale(scale)
+	if digits := len(new(big.Int).Abs(r.int()).String()); r.Sign() != 0 && digits-scale > precision-scale {
+		return Numeric{}, fmt.Errorf("numeric field overflow: a field with precision %d, scale %d must round to an absolute value less than 10^%d", precision, scale, precision-scale)
+	}
+	return r, nil
+}
+
+// integerRange returns the bounds of an integer column type.
+func integerRange(t schema.DataType) (lo, hi int64) {
+	switch t {
+	case schema.SmallInt:
+		return math.MinInt16, math.MaxInt16
+	case schema.Integer:
+		return math.MinInt32, math.MaxInt32
+	}
+	return math.MinInt64, math.MaxInt64
+}
+
+// outOfRange is the overflow error of an integer or float type, worded like
+// "integer out of range".
+func outOfRange(t schema.DataType) error {
+	switch t {
+	case schema.SmallInt:
+		return fmt.Errorf("smallint out of range")
+	case schema.Integer:
+		return fmt.Errorf("integer out of range")
+	case schema.BigInt:
+		return fmt.Errorf("bigint out of range")
+	}
+	return fmt.Errorf("value out of range for type %s", t)
+}
+
+// castInteger converts v to an int64 in the range of integer type t.
+// Fractions round half away from zero.
+func castInteger(v interface{}, t schema.DataType) (interface{}, error) {
+	if b, ok := v.(bool); ok {
+		if b {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	}
+	var i int64
+	switch n := v.(type) {
+	case Numeric:
+		r := n.rescale(0).int()
+		if !r.IsInt64() {
+			return nil, outOfRange(t)
+		}
+		i = r.Int64()
+	default:
+		ni, f, isInt, ok := toNumber(v)
+		if !ok || math.IsNaN(f) {
+			return nil, fmt.Errorf("cannot cast '%v' to %s", v, t)
+		}
+		i = ni
+		if !isInt {
+			f = math.Round(f)
+			// 2^63 itself is the first float past MaxInt64
+			if f >= math.MaxInt64 || f < math.MinInt64 {
+				return nil, outOfRange(t)
+			}
+			i = int64(f)
+		}
+	}
+	if lo, hi := integerRange(t); i < lo || i > hi {
+		return nil, outOfRange(t)
+	}
+	return i, nil
+}
+
+// castFloat converts v to a float64, or to a float32 for REAL. A finite
+// value too large for the type is an error.
+func castFloat(v interface{}, t schema.DataType) (interface{}, error) {
+	var f float64
+	switch n := v.(type) {
+	case bool:
+		if n {
+			f = 1
+		}
+	case Numeric:
+		f = n.Float64()
+		if math.IsInf(f, 0) {
+			return nil, fmt.Errorf("value out of range: overflow")
+		}
+	default:
+		var ok bool
+		_, f, _, ok = toNumber(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot cast '%v' to %s", v, t)
+		}
+	}
+	if t == schema.Real {
+		f32 := float32(f)
+		if math.IsInf(float64(f32), 0) && !math.IsInf(f, 0) {
+			return nil, fmt.Errorf("value out of range: overflow")
+		}
+		return f32, nil
+	}
+	return f, nil
+}
+
+// castNumeric converts v to a Numeric, rounded and range-checked for a
+// NUMERIC(p,s) type.
+func castNumeric(v interface{}, t schema.DataType) (interface{}, error) {
+	if b, ok := v.(bool); ok {
+		v = 0
+		if b {
+			v = 1
+		}
+	}
+	n, ok := toNumeric(v)
+	if !ok {
+		return nil, fmt.Errorf("cannot cast '%v' to %s", v, t)
+	}
+	if p, s, ok := t.NumericModifiers(); ok {
+		return n.fit(p, s)
+	}
+	return n, nil
+}
+
+// numericArith applies an arithmetic operator exactly when one operand is a
+// Numeric and the other is a Numeric, an integer or a float, which is read
+// through its shortest decimal form. ok is false for other operands.
+func numericArith(op string, lv, rv interface{}) (result interface{}, ok bool, err error) {
+	_, lnum := lv.(Numeric)
+	_, rnum := rv.(Numeric)
+	if !lnum && !rnum {
+		return nil, false, nil
+	}
+	if _, isStr := lv.(string); isStr {
+		return nil, false, nil
+	}
+	if _, isStr := rv.(string); isStr {
+		return nil, false, nil
+	}
+	l, lok := toNumeric(lv)
+	r, rok := toNumeric(rv)
+	if !lok || !rok {
+		return nil, false, nil
+	}
+	switch op {
+	case "+":
+		return l.Add(r), true, nil
+	case "-":
+		return l.Sub(r), true, nil
+	case "*":
+		return l.Mul(r), true, nil
+	case "/":
+		q, err := l.Quo(r)
+		return q, true, err
+	case "%":
+		m, err := l.Rem(r)
+		return m, true, err
+	}
+	return nil, true, fmt.Errorf("unsupported arithmetic op: %s", op)
+}
+
+// isExactNumber reports whether v is an integer or a Numeric.
+func isExactNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int64, int32, Numeric:
+		return true
+	}
+	return false
+}
+
+// compareExact compares two numbers without going through float64 when that
+// could lose precision: integers with integers, and a Numeric with any
+// integer, float or Numeric. ok is false for other operands.
+func compareExact(a, b interface{}) (int, bool) {
+	ai, aInt := a.(int64)
+	bi, bInt := b.(int64)
+	if aInt && bInt {
+		return cmpInt64(ai, bi), true
+	}
+	_, aNum := a.(Numeric)
+	_, bNum := b.(Numeric)
+	if !(isExactNumber(a) && isExactNumber(b)) && !aNum && !bNum {
+		return 0, false
+	}
+	if _, s := a.(string); s {
+		return 0, false
+	}
+	if _, s := b.(string); s {
+		return 0, false
+	}
+	an, aok := toNumeric(a)
+	bn, bok := toNumeric(b)
+	if !aok || !bok {
+		return 0, false
+	}
+	return an.Cmp(bn), true
+}
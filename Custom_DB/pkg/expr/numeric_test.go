@@ -0,0 +1,170 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func TestParseNumeric(t *testing.T) {
+	cases := map[string]string{
+		"12.50":    "12.50",
+		"-0.001":   "-0.001",
+		"+7":       "7",
+		".5":       "0.5",
+		"1.5e3":    "1500",
+		"12e-4":    "0.0012",
+		"00042.10": "42.10",
+	}
+	for in, want := range cases {
+		n, err := ParseNumeric(in)
+		if err != nil {
+			t.Fatalf("ParseNumeric(%q): %v", in, err)
+		}
+		if n.String() != want {
+			t.Fatalf("ParseNumeric(%q) = %s, want %s", in, n, want)
+		}
+	}
+	for _, bad := range []string{"", "-", "1.2.3", "abc", "1e", "1e99999"} {
+		if _, err := ParseNumeric(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestNumericExpressions(t *testing.T) {
+	price, _ := ParseNumeric("19.99")
+	row := storage.Row{
+		"price": price,
+		"big":   int64(9007199254740993), // 2^53 + 1
+		"qty":   int64(3),
+		"ratio": 0.5,
+	}
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{"price * qty", "59.97"},
+		{"price + 0.01", "20.00"},
+		{"CAST(0.1 AS NUMERIC) + 0.2", "0.3"},
+		{"price / 3", "6.6633333333333333"},
+		{"price % 1", "0.99"},
+		{"-price", "-19.99"},
+		{"ROUND(price, 1)", "20.0"},
+		{"ROUND(price, -1)", "20"},
+		{"FLOOR(-price)", "-20"},
+		{"CEIL(price)", "20"},
+		{"ABS(-price)", "19.99"},
+		{"big + 1", "9007199254740994"},
+		{"123456789012345678901234567890 + 1", "123456789012345678901234567891"},
+		{"CAST(price AS INT)", "20"},
+		{"CAST(price AS REAL)", "19.99"},
+		{"CAST('1.005' AS NUMERIC(4,2))", "1.01"},
+		{"CAST(-2.5 AS NUMERIC(3,0))", "-3"},
+		{"CAST(big AS DOUBLE PRECISION)", "9007199254740992"},
+	}
+	for _, c := range cases {
+		v, err := ParseValue(c.raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.raw, err)
+		}
+		got, err := v.EvalValue(row)
+		if err != nil {
+			t.Fatalf("eval %q: %v", c.raw, err)
+		}
+		if FormatValue(got) != c.want {
+			t.Fatalf("%q = %s, want %s", c.raw, FormatValue(got), c.want)
+		}
+	}
+
+	conds := map[string]bool{
+		"big = 9007199254740992":  false,
+		"big > 9007199254740992":  true,
+		"price = 19.990":          true,
+		"price > ratio":           true,
+		"price BETWEEN 19 AND 20": true,
+	}
+	for raw, want := range conds {
+		e, err := ParseExpression(raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", raw, err)
+		}
+		got, err := e.Eval(row)
+		if err != nil || got != want {
+			t.Fatalf("%q = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+
+	if GroupKey(price) != GroupKey(19.99) || GroupKey(NumericFromInt(2)) != GroupKey(int64(2)) {
+		t.Fatalf("equal numbers should share a group key")
+	}
+	if GroupKey(int64(9007199254740993)) == GroupKey(int64(9007199254740992)) {
+		t.Fatalf("distinct bigints should have distinct group keys")
+	}
+}
+
+func TestNumericOverflow(t *testing.T) {
+	errs := []struct {
+		raw  string
+		want string
+	}{
+		{"9223372036854775807 + 1", "bigint out of range"},
+		{"-9223372036854775807 - 2", "bigint out of range"},
+		{"4611686018427387904 * 2", "bigint out of range"},
+		{"CAST(40000 AS SMALLINT)", "smallint out of range"},
+		{"CAST(3000000000 AS INT)", "integer out of range"},
+		{"CAST(99999999999999999999 AS BIGINT)", "bigint out of range"},
+		{"CAST(1e300 * 1e300 AS DOUBLE)", "value out of range"},
+		{"CAST(1e39 AS REAL)", "value out of range"},
+		{"CAST(1000 AS NUMERIC(5,2))", "numeric field overflow"},
+		{"CAST(1 AS NUMERIC) / 0", "division by zero"},
+	}
+	for _, c := range errs {
+		v, err := ParseValue(c.raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.raw, err)
+		}
+		_, err = v.EvalValue(storage.Row{})
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%q: got error %v, want %q", c.raw, err, c.want)
+		}
+	}
+	if v, err := CastValue("999.995", schema.NumericType(5, 2)); err == nil {
+		t.Fatalf("999.995 rounds past NUMERIC(5,2), got %v", v)
+	}
+	if v, err := CastValue(int64(-32768), schema.SmallInt); err != nil || v != int64(-32768) {
+		t.Fatalf("SMALLINT minimum: %v, %v", v, err)
+	}
+}
+
+func TestSumAvgResultTypes(t *testing.T) {
+	dime, _ := ParseNumeric("0.10")
+	cases := []struct {
+		raw  string
+		rows []storage.Row
+		want interface{}
+	}{
+		{"SUM(x)", []storage.Row{{"x": int64(1)}, {"x": int64(2)}}, int64(3)},
+		{"SUM(x)", []storage.Row{{"x": int64(9223372036854775807)}, {"x": int64(10)}}, "9223372036854775817"},
+		{"SUM(x)", []storage.Row{{"x": dime}, {"x": dime}, {"x": dime}}, "0.30"},
+		{"AVG(x)", []storage.Row{{"x": int64(1)}, {"x": int64(2)}}, "1.5000000000000000"},
+		{"SUM(x)", []storage.Row{{"x": float32(0.5)}, {"x": float32(0.25)}}, float32(0.75)},
+		{"SUM(x)", []storage.Row{{"x": int64(1)}, {"x": 0.5}}, 1.5},
+		{"MAX(x)", []storage.Row{{"x": int64(9007199254740993)}, {"x": int64(9007199254740992)}}, int64(9007199254740993)},
+	}
+	for _, c := range cases {
+		got := aggregate(t, c.raw, c.rows)
+		if s, ok := c.want.(string); ok {
+			n, isNum := got.(Numeric)
+			if !isNum || n.String() != s {
+				t.Fatalf("%s over %v = %#v, want NUMERIC %s", c.raw, c.rows, got, s)
+			}
+			continue
+		}
+		if got != c.want {
+			t.Fatalf("%s over %v = %#v, want %#v", c.raw, c.rows, got, c.want)
+		}
+	}
+}
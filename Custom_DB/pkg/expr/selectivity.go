@@ -0,0 +1,237 @@
+package expr
+
+import (
+	"strings"
+	"time"
+
+	"Custom_DB/pkg/stats"
+)
+
+// Selectivities assumed for conditions statistics cannot inform, as when
+// a column has not been analyzed.
+const (
+	defaultEqSel      = 0.005
+	defaultIneqSel    = 1.0 / 3
+	defaultRangeSel   = 1.0 / 9
+	defaultMatchSel   = 0.05
+	defaultNullSel    = 0.005
+	defaultUnknownSel = 1.0 / 3
+)
+
+// StatValue returns v as statistics order it: numbers as float64, and
+// text, dates and timestamps as text. It returns nil for NULL and for
+// values with no such order, such as JSON or arrays.
+func StatValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int, int64, float64:
+		_, f, _, _ := toNumber(t)
+		return f
+	case Numeric:
+		return t.Float64()
+	case string:
+		return t
+	case TimestampTZ:
+		// in UTC, so bounds compare alike whatever the session time zone
+		return TimestampTZ{t.UTC()}.String()
+	case Date, time.Time:
+		return formatText(t)
+	}
+	return nil
+}
+
+// Selectivity estimates the fraction of rows that satisfy e, a WHERE
+// condition, from the statistics column returns for a column, or nil for
+// one without. Conditions comparing a column with constants are estimated
+// from the column's distinct count, NULL fraction and histogram; AND, OR
+// and NOT combine their operands' estimates as if they were independent.
+func Selectivity(e interface{}, column func(name string) *stats.ColumnStats) float64 {
+	s := selectivity(e, column)
+	switch {
+	case s < 0:
+		return 0
+	case s > 1:
+		return 1
+	}
+	return s
+}
+
+func selectivity(e interface{}, column func(name string) *stats.ColumnStats) float64 {
+	colStats := func(v ValueExpr) (*stats.ColumnStats, bool) {
+		name, ok := ColumnName(v)
+		if !ok {
+			return nil, false
+		}
+		return column(name), true
+	}
+	switch n := e.(type) {
+	case *truthyOp:
+		if c, ok := Constant(n); ok {
+			if c == true {
+				return 1
+			}
+			return 0
+		}
+	case *binaryOp:
+		l, r := selectivity(n.left, column), selectivity(n.right, column)
+		if strings.EqualFold(n.op, "AND") {
+			return l * r
+		}
+		return l + r - l*r
+	case *notOp:
+		return 1 - selectivity(n.child, column)
+	case *compOp:
+		left, right, op := n.left, n.right, n.op
+		c, ok := constantValue(right)
+		if !ok {
+			if c, ok = constantValue(left); !ok {
+				break
+			}
+			left, op = right, flipComparison(op)
+		}
+		cs, isCol := colStats(left)
+		if !isCol {
+			break
+		}
+		return compareSel(cs, op, c)
+	case *isNullOp:
+		cs, isCol := colStats(n.child)
+		if !isCol {
+			break
+		}
+		sel := defaultNullSel
+		if cs != nil {
+			sel = cs.NullFrac
+		}
+		if n.negate {
+			return 1 - sel
+		}
+		return sel
+	case *inOp:
+		cs, isCol := colStats(n.left)
+		if !isCol {
+			break
+		}
+		sum := 0.0
+		for _, el := range n.list {
+			c, ok := constantValue(el)
+			if !ok {
+				return defaultIneqSel
+			}
+			sum += eqSel(cs, c)
+		}
+		return sum
+	case *betweenOp:
+		cs, isCol := colStats(n.left)
+		lo, okLo := constantValue(n.lo)
+		hi, okHi := constantValue(n.hi)
+		if !isCol || !okLo || !okHi {
+			break
+		}
+		below, ok1 := belowSel(cs, lo, false)
+		upTo, ok2 := belowSel(cs, hi, true)
+		if !ok1 || !ok2 {
+			return defaultRangeSel
+		}
+		return upTo - below
+	case *likeOp:
+		cs, isCol := colStats(n.left)
+		if !isCol {
+			break
+		}
+		prefix := n.pattern
+		if i := strings.IndexAny(prefix, "%_"); i != -1 {
+			prefix = prefix[:i]
+		} else {
+			return eqSel(cs, n.pattern)
+		}
+		if prefix != "" {
+			below, ok1 := belowSel(cs, prefix, false)
+			past, ok2 := belowSel(cs, prefix+"\U0010FFFF", false)
+			if ok1 && ok2 {
+				return past - below
+			}
+		}
+		return defaultMatchSel
+	}
+	return defaultUnknownSel
+}
+
+// compareSel estimates the selectivity of column op c.
+func compareSel(cs *stats.ColumnStats, op string, c interface{}) float64 {
+	if c == nil {
+		return 0
+	}
+	nonNull := 1.0
+	if cs != nil {
+		nonNull = 1 - cs.NullFrac
+	}
+	switch op {
+	case "=":
+		return eqSel(cs, c)
+	case "!=", "<>":
+		return nonNull - eqSel(cs, c)
+	case "<", "<=":
+		if s, ok := belowSel(cs, c, op == "<="); ok {
+			return s
+		}
+	case ">", ">=":
+		if s, ok := belowSel(cs, c, op == ">"); ok {
+			return nonNull - s
+		}
+	}
+	return defaultIneqSel
+}
+
+// eqSel estimates the selectivity of column = c: the share of one of the
+// column's distinct values.
+func eqSel(cs *stats.ColumnStats, c interface{}) float64 {
+	switch {
+	case c == nil:
+		return 0
+	case cs == nil:
+		return defaultEqSel
+	case cs.Distinct == 0:
+		return 0
+	}
+	if v := StatValue(c); v != nil && cs.Min != nil && cs.Max != nil &&
+		(stats.Compare(v, cs.Min) < 0 || stats.Compare(v, cs.Max) > 0) {
+		if _, sameOrder := cs.FractionBelow(v); sameOrder {
+			return 0
+		}
+	}
+	return (1 - cs.NullFrac) / float64(cs.Distinct)
+}
+
+// belowSel estimates the selectivity of column < c, or column <= c when
+// inclusive. ok is false when the column has no histogram c can be placed
+// in.
+func belowSel(cs *stats.ColumnStats, c interface{}, inclusive bool) (float64, bool) {
+	if cs == nil {
+		return 0, false
+	}
+	frac, ok := cs.FractionBelow(StatValue(c))
+	if !ok {
+		return 0, false
+	}
+	s := frac * (1 - cs.NullFrac)
+	if inclusive {
+		s += eqSel(cs, c)
+	}
+	return s, true
+}
+
+// flipComparison returns the operator that compares the other way, for
+// c < x read as x > c.
+func flipComparison(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case ">":
+		return "<"
+	case "<=":
+		return ">="
+	case ">=":
+		return "<="
+	}
+	return op
+}
@@ -0,0 +1,51 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"Custom_DB/pkg/stats"
+)
+
+func TestSelectivity(t *testing.T) {
+	hist := []interface{}{}
+	for i := 0; i <= 10; i++ {
+		hist = append(hist, float64(i*10))
+	}
+	cols := map[string]*stats.ColumnStats{
+		"n":    {Distinct: 100, NullFrac: 0.2, Min: 0.0, Max: 100.0, Histogram: hist},
+		"name": {Distinct: 4, Min: "ann", Max: "zoe", Histogram: []interface{}{"ann", "bob", "max", "zoe"}},
+	}
+	column := func(name string) *stats.ColumnStats { return cols[name] }
+	cases := map[string]float64{
+		"n = 5":                  0.008,
+		"5 = n":                  0.008,
+		"n = 500":                0,
+		"n = NULL":               0,
+		"n IS NULL":              0.2,
+		"n IS NOT NULL":          0.8,
+		"n < 25":                 0.2,
+		"25 > n":                 0.2,
+		"n >= 25":                0.6,
+		"n BETWEEN 10 AND 30":    0.168,
+		"n IN (1, 2, 3)":         0.024,
+		"name = 'bob'":           0.25,
+		"name <> 'bob'":          0.75,
+		"n = 5 AND name = 'bob'": 0.002,
+		"n = 5 OR name = 'bob'":  0.008 + 0.25 - 0.002,
+		"NOT n IS NULL":          0.8,
+		"x = 1":                  defaultEqSel,
+		"x > 1":                  defaultIneqSel,
+		"UPPER(name) = 'BOB'":    defaultUnknownSel,
+		"1 = 1":                  1,
+	}
+	for src, want := range cases {
+		e, err := ParseExpression(src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		if got := Selectivity(FoldExpr(e), column); math.Abs(got-want) > 1e-9 {
+			t.Errorf("Selectivity(%q) = %v, want %v", src, got, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// TableFunction is a set-returning function called in the FROM clause, such
+// as JSON_EACH. Impl returns one row per output tuple, keyed by the names in
+// Columns. Arguments are converted to the declared parameter kinds first,
+// and a NULL argument produces no rows.
+type TableFunction struct {
+	Name    string
+	Params  []Param
+	Columns []schema.Column
+	Impl    func(args []interface{}) ([]storage.Row, error)
+}
+
+var tableFunctions = map[string]*TableFunction{}
+
+func registerTableFunction(f *TableFunction) {
+	tableFunctions[f.Name] = f
+}
+
+// LookupTableFunction returns the table function registered under name.
+func LookupTableFunction(name string) (*TableFunction, bool) {
+	f, ok := tableFunctions[strings.ToUpper(name)]
+	return f, ok
+}
+
+// TableCall is a table function call parsed from a FROM clause. Its
+// arguments may refer to columns of a table listed before it, which makes
+// the call lateral: it is evaluated once per row of that table.
+type TableCall struct {
+	Func  *TableFunction
+	Args  []ValueExpr
+	Alias string // renames the column of a function returning one column
+}
+
+// ParseTableCall parses text such as JSON_EACH(doc->'items') or
+// UNNEST(tags) AS tag as a table function call.
+func ParseTableCall(text string) (*TableCall, error) {
+	p := &parser{toks: tokenizeExpr(text)}
+	name := p.eat()
+	fn, ok := LookupTableFunction(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown table function: %s", name)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name, err)
+	}
+	call := &TableCall{Func: fn}
+	if p.cur() != ")" {
+		for {
+			a, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, a)
+			if p.cur() != "," {
+				break
+			}
+			p.eat()
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name, err)
+	}
+	if strings.EqualFold(p.cur(), "AS") {
+		p.eat()
+		if p.cur() == "" {
+			return nil, fmt.Errorf("missing alias after %s(...) AS", fn.Name)
+		}
+	}
+	if p.cur() != "" && len(fn.Columns) == 1 && !isOperatorToken(p.cur()) {
+		call.Alias = strings.Trim(p.eat(), "`\"")
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected token '%s' after %s(...)", p.cur(), fn.Name)
+	}
+	min := 0
+	for _, prm := range fn.Params {
+		if !prm.Optional {
+			min++
+		}
+	}
+	if len(call.Args) < min || len(call.Args) > len(fn.Params) {
+		return nil, fmt.Errorf("%s expects %d to %d argument(s), got %d", fn.Name, min, len(fn.Params), len(call.Args))
+	}
+	for i, a := range call.Args {
+		if err := checkKind(a, fn.Params[i].Kind, nil); err != nil {
+			return nil, fmt.Errorf("%s: argument %d (%s) %w", fn.Name, i+1, fn.Params[i].Name, err)
+		}
+	}
+	return call, nil
+}
+
+// Rows evaluates the arguments against row, the current row of the table
+// the call is joined to or an empty row, and returns the function's rows.
+func (c *TableCall) Rows(row storage.Row) ([]storage.Row, error) {
+	args := make([]interface{}, len(c.Args))
+	for i, a := range c.Args {
+		v, err := a.EvalValue(row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, nil
+		}
+		p := c.Func.Params[i]
+		if args[i], err = coerceArg(v, p.Kind); err != nil {
+			return nil, fmt.Errorf("%s: argument %d (%s) %w", c.Func.Name, i+1, p.Name, err)
+		}
+	}
+	rows, err := c.Func.Impl(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Func.Name, err)
+	}
+	if name := c.Func.Columns[0].Name; c.Alias != "" && c.Alias != name {
+		for _, r := range rows {
+			r[c.Alias] = r[name]
+			delete(r, name)
+		}
+	}
+	return rows, nil
+}
+
+// OutputColumns returns the columns the call produces, with the alias
+// applied.
+func (c *TableCall) OutputColumns() []schema.Column {
+	cols := append([]schema.Column(nil), c.Func.Columns...)
+	if c.Alias != "" {
+		cols[0].Name = c.Alias
+	}
+	return cols
+}
+
+// Columns returns the names of the columns the call's arguments refer to.
+func (c *TableCall) Columns() []string {
+	cols := []string{}
+	for _, a := range c.Args {
+		cols = append(cols, CollectColumns(a)...)
+	}
+	return cols
+}
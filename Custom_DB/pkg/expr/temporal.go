@@ -0,0 +1,581 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // named time zones resolve without a system zoneinfo
+
+	"Custom_DB/pkg/schema"
+)
+
+// Temporal values. TIMESTAMP values are plain time.Time wall-clock times
+// (held in UTC); DATE and TIMESTAMPTZ use the types below so they keep their
+// own rendering, and INTERVAL is a calendar-aware duration.
+
+// DateLayout is the layout dates are rendered with as text.
+const DateLayout = "2006-01-02"
+
+// TimestampTZLayout is the layout TIMESTAMPTZ values are rendered with, in
+// their time zone.
+const TimestampTZLayout = "2006-01-02 15:04:05.999999-07:00"
+
+// Date is a calendar date, held as midnight UTC.
+type Date struct{ time.Time }
+
+// NewDate returns the date of t's wall clock.
+func NewDate(t time.Time) Date {
+	y, m, d := t.Date()
+	return Date{time.Date(y, m, d, 0, 0, 0, 0, time.UTC)}
+}
+
+func (d Date) String() string { return d.Format(DateLayout) }
+
+// MarshalJSON stores a date as its text form.
+func (d Date) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+// TimestampTZ is an absolute point in time. Its Time is in the time zone of
+// the session that read it, which it is rendered in.
+type TimestampTZ struct{ time.Time }
+
+func (t TimestampTZ) String() string { return t.Format(TimestampTZLayout) }
+
+// MarshalJSON stores a TIMESTAMPTZ in UTC.
+func (t TimestampTZ) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(time.RFC3339Nano))
+}
+
+// Interval is a span of months, days and clock time, kept apart because
+// months and days vary in length: adding 1 month to January 31 lands on the
+// last day of February, and 1 day across a DST change is not 24 hours.
+type Interval struct {
+	Months   int
+	Days     int
+	Duration time.Duration
+}
+
+// MarshalJSON stores an interval as its text form.
+func (iv Interval) MarshalJSON() ([]byte, error) { return json.Marshal(iv.String()) }
+
+// String renders an interval such as "1 year 2 mons 3 days 04:05:06".
+func (iv Interval) String() string {
+	parts := []string{}
+	unit := func(n int, one, many string) {
+		if n == 1 || n == -1 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, one))
+		} else if n != 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, many))
+		}
+	}
+	unit(iv.Months/12, "year", "years")
+	unit(iv.Months%12, "mon", "mons")
+	unit(iv.Days, "day", "days")
+	if iv.Duration != 0 || len(parts) == 0 {
+		d := iv.Duration
+		sign := ""
+		if d < 0 {
+			sign, d = "-", -d
+		}
+		h := d / time.Hour
+		m := (d % time.Hour) / time.Minute
+		s := (d % time.Minute) / time.Second
+		clock := fmt.Sprintf("%s%02d:%02d:%02d", sign, h, m, s)
+		if us := (d % time.Second) / time.Microsecond; us != 0 {
+			clock += strings.TrimRight(fmt.Sprintf(".%06d", us), "0")
+		}
+		parts = append(parts, clock)
+	}
+	return strings.Join(parts, " ")
+}
+
+// micros approximates the interval's length in microseconds, counting a
+// month as 30 days, to order and group intervals.
+func (iv Interval) micros() int64 {
+	return (int64(iv.Months)*30+int64(iv.Days))*int64(24*time.Hour/time.Microsecond) + iv.Duration.Microseconds()
+}
+
+func (iv Interval) negate() Interval {
+	return Interval{Months: -iv.Months, Days: -iv.Days, Duration: -iv.Duration}
+}
+
+// scale multiplies an interval by f, carrying fractional months into days
+// and fractional days into clock time.
+func (iv Interval) scale(f float64) Interval {
+	m := float64(iv.Months) * f
+	wm := math.Trunc(m)
+	d := float64(iv.Days)*f + (m-wm)*30
+	wd := math.Trunc(d)
+	dur := time.Duration(float64(iv.Duration)*f) + time.Duration((d-wd)*float64(24*time.Hour))
+	return Interval{Months: int(wm), Days: int(wd), Duration: dur}
+}
+
+// intervalUnits maps the unit names accepted in interval text to a number
+// of months, days or a duration per unit.
+var intervalUnits = map[string]struct {
+	months, days int
+	dur          time.Duration
+}{
+	"millennium": {months: 12000}, "century": {months: 1200}, "decade": {months: 120},
+	"year": {months: 12}, "yr": {months: 12}, "y": {months: 12},
+	"month": {months: 1}, "mon": {months: 1},
+	"week": {days: 7}, "w": {days: 7},
+	"day": {days: 1}, "d": {days: 1},
+	"hour": {dur: time.Hour}, "hr": {dur: time.Hour}, "h": {dur: time.Hour},
+	"minute": {dur: time.Minute}, "min": {dur: time.Minute}, "m": {dur: time.Minute},
+	"second": {dur: time.Second}, "sec": {dur: time.Second}, "s": {dur: time.Second},
+	"millisecond": {dur: time.Millisecond}, "ms": {dur: time.Millisecond},
+	"microsecond": {dur: time.Microsecond}, "us": {dur: time.Microsecond},
+}
+
+// addUnit adds n units to iv, carrying fractions into smaller fields.
+func (iv *Interval) addUnit(n float64, name string) error {
+	name = strings.ToLower(name)
+	u, ok := intervalUnits[name]
+	if !ok {
+		u, ok = intervalUnits[strings.TrimSuffix(name, "s")]
+	}
+	if !ok && name == "centuries" {
+		u, ok = intervalUnits["century"]
+	}
+	if !ok && name == "millennia" {
+		u, ok = intervalUnits["millennium"]
+	}
+	if !ok {
+		return fmt.Errorf("unknown interval unit '%s'", name)
+	}
+	add := Interval{Days: u.days, Duration: u.dur, Months: u.months}.scale(n)
+	iv.Months += add.Months
+	iv.Days += add.Days
+	iv.Duration += add.Duration
+	return nil
+}
+
+// ParseInterval reads interval text: either a list of amounts and units such
+// as "1 year 2 months", "-3 days 04:00:00" or "2 hours ago", or an ISO-8601
+// duration such as "P1Y2M3DT4H".
+func ParseInterval(s string) (Interval, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Interval{}, fmt.Errorf("invalid interval ''")
+	}
+	neg := strings.HasPrefix(s, "-P") || strings.HasPrefix(s, "-p")
+	if iso := strings.TrimPrefix(s, "-"); strings.HasPrefix(strings.ToUpper(iso), "P") {
+		iv, err := parseISOInterval(strings.ToUpper(iso))
+		if err != nil {
+			return Interval{}, fmt.Errorf("invalid interval '%s': %w", s, err)
+		}
+		if neg {
+			iv = iv.negate()
+		}
+		return iv, nil
+	}
+
+	var iv Interval
+	fields := strings.Fields(s)
+	ago := false
+	if len(fields) > 0 && strings.EqualFold(fields[len(fields)-1], "ago") {
+		ago, fields = true, fields[:len(fields)-1]
+	}
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.Contains(f, ":") {
+			d, err := parseClock(f)
+			if err != nil {
+				return Interval{}, fmt.Errorf("invalid interval '%s': %w", s, err)
+			}
+			iv.Duration += d
+			continue
+		}
+		// the number may carry its unit, as in "3days"
+		split := len(f)
+		for j, r := range f {
+			if (r < '0' || r > '9') && r != '.' && r != '-' && r != '+' {
+				split = j
+				break
+			}
+		}
+		n, err := strconv.ParseFloat(f[:split], 64)
+		if err != nil {
+			return Interval{}, fmt.Errorf("invalid interval '%s'", s)
+		}
+		unit := f[split:]
+		if unit == "" {
+			if i+1 >= len(fields) {
+				// a bare number counts seconds
+				unit = "second"
+			} else {
+				i++
+				unit = fields[i]
+			}
+		}
+		if err := iv.addUnit(n, unit); err != nil {
+			return Interval{}, fmt.Errorf("invalid interval '%s': %w", s, err)
+		}
+	}
+	if ago {
+		iv = iv.negate()
+	}
+	return iv, nil
+}
+
+// parseClock reads [-]HH:MM[:SS[.frac]].
+func parseClock(s string) (time.Duration, error) {
+	neg := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimLeft(s, "+-"), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("bad time '%s'", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec := 0.0
+	var err3 error
+	if len(parts) == 3 {
+		sec, err3 = strconv.ParseFloat(parts[2], 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("bad time '%s'", s)
+	}
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(math.Round(sec*1e6))*time.Microsecond
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// parseISOInterval reads an upper-cased ISO-8601 duration: P[nY][nM][nW][nD][T[nH][nM][nS]].
+func parseISOInterval(s string) (Interval, error) {
+	var iv Interval
+	inTime := false
+	num := ""
+	for _, r := range s[1:] {
+		switch {
+		case r == 'T':
+			inTime = true
+		case (r >= '0' && r <= '9') || r == '.' || r == '-':
+			num += string(r)
+		default:
+			n, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return Interval{}, fmt.Errorf("missing amount before '%c'", r)
+			}
+			unit := map[rune]string{'Y': "year", 'M': "month", 'W': "week", 'D': "day"}[r]
+			if inTime {
+				unit = map[rune]string{'H': "hour", 'M': "minute", 'S': "second"}[r]
+			}
+			if unit == "" {
+				return Interval{}, fmt.Errorf("unknown designator '%c'", r)
+			}
+			if err := iv.addUnit(n, unit); err != nil {
+				return Interval{}, err
+			}
+			num = ""
+		}
+	}
+	if num != "" {
+		return Interval{}, fmt.Errorf("amount %s has no designator", num)
+	}
+	return iv, nil
+}
+
+// ----- time zones -----
+
+// LoadTimeZone returns the time zone called name: LOCAL, UTC, an IANA name
+// such as Europe/Berlin, or a fixed offset such as +05:30. A session reads
+// and renders TIMESTAMPTZ values in the zone SET TIME ZONE chose, or LOCAL.
+func LoadTimeZone(name string) (*time.Location, error) {
+	return loadZone(strings.Trim(strings.TrimSpace(name), "'\""))
+}
+
+// SessionTimeZone returns the time zone of the session of db, LOCAL until
+// SetSessionTimeZone chooses another.
+func SessionTimeZone(db *schema.Database) *time.Location {
+	name, ok := db.Setting("time_zone")
+	if !ok {
+		return time.Local
+	}
+	loc, err := LoadTimeZone(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// SetSessionTimeZone sets the time zone of the session of db only to the
+// zone called name; see LoadTimeZone.
+func SetSessionTimeZone(db *schema.Database, name string) error {
+	name = strings.Trim(strings.TrimSpace(name), "'\"")
+	if _, err := LoadTimeZone(name); err != nil {
+		return err
+	}
+	db.SetSetting("time_zone", name)
+	return nil
+}
+
+// BindTimeZone sets the session time zone of the casts in e to loc, so they
+// read timestamps without a zone, and give TIMESTAMPTZ values, in loc.
+func BindTimeZone(e interface{}, loc *time.Location) {
+	if c, ok := e.(*castOp); ok {
+		c.loc = loc
+	}
+	if n, ok := e.(node); ok {
+		for _, ch := range n.children() {
+			if ch != nil {
+				BindTimeZone(ch, loc)
+			}
+		}
+	}
+}
+
+func loadZone(name string) (*time.Location, error) {
+	switch strings.ToUpper(name) {
+	case "LOCAL", "DEFAULT":
+		return time.Local, nil
+	case "UTC", "GMT", "Z":
+		return time.UTC, nil
+	}
+	if name != "" && (name[0] == '+' || name[0] == '-') {
+		if t, err := time.Parse("-07:00", name); err == nil {
+			_, off := t.Zone()
+			return time.FixedZone(name, off), nil
+		}
+		if t, err := time.Parse("-07", name); err == nil {
+			_, off := t.Zone()
+			return time.FixedZone(name, off), nil
+		}
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone '%s'", name)
+	}
+	return loc, nil
+}
+
+// ----- conversions -----
+
+// isTemporal reports whether v is a DATE, TIMESTAMP or TIMESTAMPTZ value.
+func isTemporal(v interface{}) bool {
+	switch v.(type) {
+	case time.Time, Date, TimestampTZ:
+		return true
+	}
+	return false
+}
+
+// parseTimeText reads ISO-8601 style text. Text without an offset is taken
+// as wall-clock time in loc; zoned reports whether the text had one.
+func parseTimeText(s string, loc *time.Location) (t time.Time, zoned, ok bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if ts, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return ts, strings.Contains(layout, "Z07"), true
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// wallClock returns t's wall-clock time as a TIMESTAMP.
+func wallClock(t time.Time) time.Time {
+	y, mo, d := t.Date()
+	h, mi, s := t.Clock()
+	return time.Date(y, mo, d, h, mi, s, t.Nanosecond(), time.UTC)
+}
+
+// castTemporal converts a non-NULL value to a DATE, TIMESTAMP, TIMESTAMPTZ
+// or INTERVAL. A TIMESTAMPTZ becomes a wall-clock time in its time zone, a
+// timestamp without a zone is read in loc, and a TIMESTAMPTZ result is in
+// loc.
+func castTemporal(v interface{}, t schema.DataType, loc *time.Location) (interface{}, error) {
+	fail := fmt.Errorf("cannot cast '%v' to %s", v, t)
+	if t == schema.Interval {
+		switch x := v.(type) {
+		case Interval:
+			return x, nil
+		case string:
+			iv, err := ParseInterval(x)
+			if err != nil {
+				return nil, err
+			}
+			return iv, nil
+		}
+		return nil, fail
+	}
+
+	var wall time.Time // wall-clock reading of v
+	var instant time.Time
+	hasInstant := false
+	switch x := v.(type) {
+	case Date:
+		wall = x.Time
+	case time.Time:
+		wall = wallClock(x)
+	case TimestampTZ:
+		instant, hasInstant = x.Time, true
+		wall = wallClock(x.Time)
+	case string:
+		ts, zoned, ok := parseTimeText(x, time.UTC)
+		if !ok {
+			return nil, fail
+		}
+		// a DATE or TIMESTAMP keeps the clock time as written
+		wall = wallClock(ts)
+		if zoned {
+			instant, hasInstant = ts, true
+		}
+	default:
+		return nil, fail
+	}
+
+	switch t {
+	case schema.Date:
+		return NewDate(wall), nil
+	case schema.Timestamp:
+		return wall, nil
+	case schema.TimestampTZ:
+		if !hasInstant {
+			instant = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), loc)
+		}
+		return TimestampTZ{instant.In(loc)}, nil
+	}
+	return nil, fail
+}
+
+// toInterval converts an Interval or interval text to an Interval.
+func toInterval(v interface{}) (Interval, bool) {
+	switch x := v.(type) {
+	case Interval:
+		return x, true
+	case string:
+		iv, err := ParseInterval(x)
+		return iv, err == nil
+	}
+	return Interval{}, false
+}
+
+// addInterval shifts a temporal value by iv, keeping its type; a DATE moved
+// by clock time becomes a TIMESTAMP. A TIMESTAMPTZ is moved in its time zone
+// so "1 day" keeps the local clock time across DST changes.
+func addInterval(v interface{}, iv Interval) interface{} {
+	switch x := v.(type) {
+	case Date:
+		if iv.Duration == 0 {
+			return Date{x.AddDate(0, iv.Months, iv.Days)}
+		}
+		return x.Time.AddDate(0, iv.Months, iv.Days).Add(iv.Duration)
+	case TimestampTZ:
+		return TimestampTZ{x.AddDate(0, iv.Months, iv.Days).Add(iv.Duration)}
+	case time.Time:
+		return x.AddDate(0, iv.Months, iv.Days).Add(iv.Duration)
+	}
+	return nil
+}
+
+// temporalArith applies an arithmetic operator when either operand is a
+// date, timestamp or interval. ok is false when neither is, so the caller
+// falls back to numeric arithmetic.
+func temporalArith(op string, lv, rv interface{}) (result interface{}, ok bool, err error) {
+	li, lIsIv := lv.(Interval)
+	ri, rIsIv := rv.(Interval)
+	lTemporal, rTemporal := isTemporal(lv), isTemporal(rv)
+	if !lIsIv && !rIsIv && !lTemporal && !rTemporal {
+		return nil, false, nil
+	}
+	fail := fmt.Errorf("cannot apply '%s' to '%v' and '%v'", op, formatText(lv), formatText(rv))
+
+	switch {
+	case lIsIv && rIsIv:
+		switch op {
+		case "+":
+			return Interval{Months: li.Months + ri.Months, Days: li.Days + ri.Days, Duration: li.Duration + ri.Duration}, true, nil
+		case "-":
+			return Interval{Months: li.Months - ri.Months, Days: li.Days - ri.Days, Duration: li.Duration - ri.Duration}, true, nil
+		}
+	case lIsIv || rIsIv:
+		iv, other := li, rv
+		if rIsIv {
+			iv, other = ri, lv
+		}
+		if _, f, _, isNum := toNumber(other); isNum {
+			switch {
+			case op == "*":
+				return iv.scale(f), true, nil
+			case op == "/" && lIsIv:
+				if f == 0 {
+					return nil, true, fmt.Errorf("division by zero")
+				}
+				return iv.scale(1 / f), true, nil
+			case op == "-" && rIsIv && f == 0:
+				// unary minus is parsed as 0 - x
+				return iv.negate(), true, nil
+			}
+			return nil, true, fail
+		}
+		if op == "-" && lIsIv {
+			return nil, true, fail
+		}
+		if op != "+" && op != "-" {
+			return nil, true, fail
+		}
+		if s, isText := other.(string); isText {
+			ts, parsed := toTime(s)
+			if !parsed {
+				return nil, true, fail
+			}
+			other = ts
+		}
+		if !isTemporal(other) {
+			return nil, true, fail
+		}
+		if op == "-" {
+			iv = iv.negate()
+		}
+		return addInterval(other, iv), true, nil
+	case lTemporal && rTemporal:
+		if op != "-" {
+			return nil, true, fail
+		}
+		ld, lDate := lv.(Date)
+		rd, rDate := rv.(Date)
+		if lDate && rDate {
+			// date - date counts days
+			return int64(ld.Sub(rd.Time) / (24 * time.Hour)), true, nil
+		}
+		lt, rt, same := asTimes(lv, rv)
+		if !same {
+			return nil, true, fail
+		}
+		d := lt.Sub(rt)
+		return Interval{Days: int(d / (24 * time.Hour)), Duration: d % (24 * time.Hour)}, true, nil
+	default:
+		// date + integer and date - integer move by whole days
+		if d, isDate := lv.(Date); isDate && (op == "+" || op == "-") {
+			if i, _, isInt, isNum := toNumber(rv); isNum && isInt {
+				if op == "-" {
+					i = -i
+				}
+				return Date{d.AddDate(0, 0, int(i))}, true, nil
+			}
+		}
+		if d, isDate := rv.(Date); isDate && op == "+" {
+			if i, _, isInt, isNum := toNumber(lv); isNum && isInt {
+				return Date{d.AddDate(0, 0, int(i))}, true, nil
+			}
+		}
+		// text on the other side of a subtraction is read as a timestamp
+		if op == "-" {
+			if s, isText := rv.(string); isText {
+				if ts, parsed := toTime(s); parsed {
+					return temporalArith(op, lv, ts)
+				}
+			}
+			if s, isText := lv.(string); isText {
+				if ts, parsed := toTime(s); parsed {
+					return temporalArith(op, ts, rv)
+				}
+			}
+		}
+	}
+	return nil, true, fail
+}
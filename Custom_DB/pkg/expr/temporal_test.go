@@ -0,0 +1,163 @@
+package expr
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func loadTimeZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := LoadTimeZone(name)
+	if err != nil {
+		t.Fatalf("load time zone: %v", err)
+	}
+	return loc
+}
+
+func TestParseInterval(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"1 year 2 months 3 days 04:05:06", "1 year 2 mons 3 days 04:05:06"},
+		{"2 hours 30 minutes", "02:30:00"},
+		{"1.5 days", "1 day 12:00:00"},
+		{"3 weeks", "21 days"},
+		{"2 days ago", "-2 days"},
+		{"-01:00:00.25", "-01:00:00.25"},
+		{"P1Y2M3DT4H5M6S", "1 year 2 mons 3 days 04:05:06"},
+		{"PT90M", "01:30:00"},
+		{"0 seconds", "00:00:00"},
+	}
+	for _, c := range cases {
+		iv, err := ParseInterval(c.in)
+		if err != nil {
+			t.Fatalf("ParseInterval(%q): %v", c.in, err)
+		}
+		if got := iv.String(); got != c.want {
+			t.Fatalf("ParseInterval(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+	for _, bad := range []string{"", "3 fortnights", "P1X", "abc"} {
+		if _, err := ParseInterval(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestTemporalExpressions(t *testing.T) {
+	utc := loadTimeZone(t, "UTC")
+	row := storage.Row{
+		"d":  NewDate(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)),
+		"ts": time.Date(2024, 3, 10, 8, 30, 0, 0, time.UTC),
+		"s":  "2024/03/09",
+	}
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{"d + INTERVAL '1 month'", "2024-03-02"},
+		{"d + 1", "2024-02-01"},
+		{"d - DATE '2024-01-01'", "30"},
+		{"d + INTERVAL '6 hours'", "2024-01-31 06:00:00"},
+		{"ts - INTERVAL '1 day 00:30:00'", "2024-03-09 08:00:00"},
+		{"ts - TIMESTAMP '2024-03-08 06:00:00'", "2 days 02:30:00"},
+		{"INTERVAL '3' DAY * 2", "6 days"},
+		{"-INTERVAL '1 hour'", "-01:00:00"},
+		{"INTERVAL '1 day' / 4", "06:00:00"},
+		{"CAST('2024-03-10T08:30:00Z' AS DATE)", "2024-03-10"},
+		{"CAST(ts AS TIMESTAMP WITH TIME ZONE)", "2024-03-10 08:30:00+00:00"},
+		{"EXTRACT(DAY FROM d + INTERVAL '1 day')", "1"},
+	}
+	for _, c := range cases {
+		v, err := ParseValue(c.raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.raw, err)
+		}
+		BindTimeZone(v, utc)
+		got, err := v.EvalValue(row)
+		if err != nil {
+			t.Fatalf("eval %q: %v", c.raw, err)
+		}
+		if FormatValue(got) != c.want {
+			t.Fatalf("%q = %q, want %q", c.raw, FormatValue(got), c.want)
+		}
+	}
+
+	conds := map[string]bool{
+		"ts > s":             true,
+		"s BETWEEN d AND ts": true,
+		"d = '2024-01-31'":   true,
+		"ts BETWEEN '2024-03-10 08:00' AND '2024-03-10 09:00'": true,
+		"INTERVAL '1 day' = INTERVAL '24 hours'":               true,
+		"INTERVAL '1 month' > INTERVAL '29 days'":              true,
+	}
+	for raw, want := range conds {
+		e, err := ParseExpression(raw)
+		if err != nil {
+			t.Fatalf("parse %q: %v", raw, err)
+		}
+		got, err := e.Eval(row)
+		if err != nil || got != want {
+			t.Fatalf("%q = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+
+	for _, raw := range []string{"DATE '2024-13-01'", "INTERVAL 'soon'", "d * 2", "INTERVAL '1 day' - ts"} {
+		v, err := ParseValue(raw)
+		if err != nil {
+			continue
+		}
+		if _, err := v.EvalValue(row); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestTimestampTZRendering(t *testing.T) {
+	v, err := CastValueIn("2024-07-01 12:00:00", schema.TimestampTZ, loadTimeZone(t, "America/New_York"))
+	if err != nil {
+		t.Fatalf("cast: %v", err)
+	}
+	// text without an offset is read in the session time zone
+	if got := FormatValue(v); got != "2024-07-01 12:00:00-04:00" {
+		t.Fatalf("rendered %q", got)
+	}
+	if got := v.(TimestampTZ).UTC().Hour(); got != 16 {
+		t.Fatalf("stored UTC hour %d, want 16", got)
+	}
+	// another session reads the stored value in its own time zone
+	stored, _ := json.Marshal(v)
+	var text string
+	json.Unmarshal(stored, &text)
+	v = DecodeValue(text, schema.TimestampTZ, loadTimeZone(t, "+05:30"))
+	if got := FormatValue(v); got != "2024-07-01 21:30:00+05:30" {
+		t.Fatalf("rendered %q in another session", got)
+	}
+	// a zoned value compares with a plain timestamp read in the session zone
+	ok, err := compareValues("=", v, time.Date(2024, 7, 1, 21, 30, 0, 0, time.UTC))
+	if err != nil || !ok {
+		t.Fatalf("TIMESTAMPTZ = TIMESTAMP in session zone: %v, %v", ok, err)
+	}
+	cast, err := ParseValue("TIMESTAMPTZ '2024-07-01 21:30:00'")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, name := range []string{"+05:30", "UTC"} {
+		loc := loadTimeZone(t, name)
+		BindTimeZone(cast, loc)
+		got, err := cast.EvalValue(nil)
+		if err != nil {
+			t.Fatalf("eval: %v", err)
+		}
+		if want := time.Date(2024, 7, 1, 21, 30, 0, 0, loc); !got.(TimestampTZ).Equal(want) {
+			t.Fatalf("literal in %s = %v, want %v", name, got, want)
+		}
+	}
+	if _, err := LoadTimeZone("Mars/Olympus"); err == nil {
+		t.Fatalf("expected error for unknown time zone")
+	}
+}
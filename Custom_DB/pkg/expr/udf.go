@@ -0,0 +1,165 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// User-defined functions are registered from Go by programs embedding the
+// database, e.g.
+//
+//	expr.RegisterFunction(&expr.Function{
+//		Name:    "normalize_phone",
+//		Params:  []expr.Param{{Name: "phone", Kind: expr.KindText}},
+//		Returns: expr.KindText,
+//		Impl:    func(args []interface{}) (interface{}, error) { ... },
+//	})
+//
+// Once registered they can be called by name from any SQL expression.
+// Names are case-insensitive and may not shadow a built-in. A query may
+// run on parallel workers, so Impl must be safe to call concurrently.
+
+var (
+	registryMu      sync.RWMutex
+	userFunctions   = map[string]*Function{}
+	userAggregates  = map[string]*AggregateFunction{}
+	functionNameRe  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	reservedFuncIDs = map[string]bool{"CASE": true, "CAST": true, "NOT": true, "AND": true, "OR": true,
+		"IN": true, "LIKE": true, "BETWEEN": true, "NULL": true, "TRUE": true, "FALSE": true, "GROUPING": true}
+)
+
+// RegisterFunction adds a user-defined scalar function. Registering a name
+// that already belongs to a user function replaces it.
+func RegisterFunction(f *Function) error {
+	if f == nil || f.Impl == nil {
+		return fmt.Errorf("function must have an implementation")
+	}
+	name, err := checkFunctionName(f.Name, f.Params, f.Variadic)
+	if err != nil {
+		return err
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := userAggregates[name]; ok {
+		return fmt.Errorf("function %s is already registered as an aggregate", name)
+	}
+	fn := *f
+	fn.Name = name
+	userFunctions[name] = &fn
+	return nil
+}
+
+// RegisterAggregate adds a user-defined aggregate function. Registering a
+// name that already belongs to a user aggregate replaces it.
+func RegisterAggregate(a *AggregateFunction) error {
+	if a == nil || a.New == nil {
+		return fmt.Errorf("aggregate must have an accumulator constructor")
+	}
+	if len(a.Params) == 0 && !a.AllowStar {
+		return fmt.Errorf("aggregate %s must declare at least one parameter", a.Name)
+	}
+	name, err := checkFunctionName(a.Name, a.Params, false)
+	if err != nil {
+		return err
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := userFunctions[name]; ok {
+		return fmt.Errorf("function %s is already registered as a scalar function", name)
+	}
+	agg := *a
+	agg.Name = name
+	userAggregates[name] = &agg
+	return nil
+}
+
+// UnregisterFunction removes a user-defined scalar or aggregate function.
+// It reports whether anything was removed.
+func UnregisterFunction(name string) bool {
+	name = strings.ToUpper(name)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	_, scalar := userFunctions[name]
+	_, agg := userAggregates[name]
+	delete(userFunctions, name)
+	delete(userAggregates, name)
+	return scalar || agg
+}
+
+func checkFunctionName(raw string, params []Param, variadic bool) (string, error) {
+	if !functionNameRe.MatchString(raw) {
+		return "", fmt.Errorf("invalid function name '%s'", raw)
+	}
+	name := strings.ToUpper(raw)
+	if reservedFuncIDs[name] {
+		return "", fmt.Errorf("function name %s is a reserved word", name)
+	}
+	if _, ok := builtinFunctions[name]; ok {
+		return "", fmt.Errorf("function %s is a built-in and cannot be redefined", name)
+	}
+	if _, ok := builtinAggregates[name]; ok {
+		return "", fmt.Errorf("function %s is a built-in and cannot be redefined", name)
+	}
+	optional := false
+	for i, p := range params {
+		if p.Name == "" {
+			return "", fmt.Errorf("%s: parameter %d has no name", name, i+1)
+		}
+		if p.Optional {
+			optional = true
+		} else if optional {
+			return "", fmt.Errorf("%s: required parameter %s follows an optional one", name, p.Name)
+		}
+	}
+	if variadic && len(params) == 0 {
+		return "", fmt.Errorf("%s: variadic functions need at least one parameter", name)
+	}
+	return name, nil
+}
+
+// LookupAggregate returns the built-in or user-defined aggregate registered
+// under name.
+func LookupAggregate(name string) (*AggregateFunction, bool) {
+	name = strings.ToUpper(name)
+	if a, ok := builtinAggregates[name]; ok {
+		return a, true
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	a, ok := userAggregates[name]
+	return a, ok
+}
+
+// FunctionInfo describes one callable function for listings such as
+// SHOW FUNCTIONS.
+type FunctionInfo struct {
+	Name      string
+	Kind      string // "scalar" or "aggregate"
+	Builtin   bool
+	Signature string
+}
+
+// ListFunctions returns every built-in and user-defined function, scalar and
+// aggregate, sorted by name.
+func ListFunctions() []FunctionInfo {
+	out := []FunctionInfo{}
+	for _, f := range BuiltinFunctions() {
+		out = append(out, FunctionInfo{Name: f.Name, Kind: "scalar", Builtin: true, Signature: f.Signature()})
+	}
+	for _, a := range sortedAggregates(builtinAggregates) {
+		out = append(out, FunctionInfo{Name: a.Name, Kind: "aggregate", Builtin: true, Signature: a.Signature()})
+	}
+	registryMu.RLock()
+	for _, f := range userFunctions {
+		out = append(out, FunctionInfo{Name: f.Name, Kind: "scalar", Signature: f.Signature()})
+	}
+	for _, a := range userAggregates {
+		out = append(out, FunctionInfo{Name: a.Name, Kind: "aggregate", Signature: a.Signature()})
+	}
+	registryMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
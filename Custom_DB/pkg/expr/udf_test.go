@@ -0,0 +1,110 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/storage"
+)
+
+type concatAcc struct{ parts []string }
+
+func (c *concatAcc) Step(args []interface{}) error {
+	c.parts = append(c.parts, args[0].(string))
+	return nil
+}
+
+func (c *concatAcc) Result() (interface{}, error) { return strings.Join(c.parts, ","), nil }
+
+func TestRegisterScalarFunction(t *testing.T) {
+	err := RegisterFunction(&Function{
+		Name:    "normalize_phone",
+		Params:  []Param{{Name: "phone", Kind: KindText}},
+		Returns: KindText,
+		Impl: func(args []interface{}) (interface{}, error) {
+			digits := strings.Map(func(r rune) rune {
+				if r >= '0' && r <= '9' {
+					return r
+				}
+				return -1
+			}, args[0].(string))
+			return digits, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	t.Cleanup(func() { UnregisterFunction("normalize_phone") })
+
+	got := evalString(t, "NORMALIZE_PHONE(phone)", storage.Row{"phone": "(555) 010-9999"})
+	if got != "5550109999" {
+		t.Fatalf("got %#v", got)
+	}
+	if _, err := ParseValue("normalize_phone(1, 2)"); err == nil || !strings.Contains(err.Error(), "expects 1 argument") {
+		t.Fatalf("expected arity error, got %v", err)
+	}
+}
+
+func TestRegisterFunctionErrors(t *testing.T) {
+	impl := func(args []interface{}) (interface{}, error) { return nil, nil }
+	cases := []*Function{
+		{Name: "upper", Params: []Param{{Name: "s", Kind: KindText}}, Impl: impl},
+		{Name: "count", Params: []Param{{Name: "s", Kind: KindText}}, Impl: impl},
+		{Name: "bad name", Impl: impl},
+		{Name: "nothing"},
+		{Name: "f", Params: []Param{{Name: "a", Optional: true}, {Name: "b"}}, Impl: impl},
+	}
+	for _, f := range cases {
+		if err := RegisterFunction(f); err == nil {
+			UnregisterFunction(f.Name)
+			t.Fatalf("expected error registering %q", f.Name)
+		}
+	}
+}
+
+func TestRegisterAggregate(t *testing.T) {
+	err := RegisterAggregate(&AggregateFunction{
+		Name:    "join_text",
+		Params:  []Param{{Name: "value", Kind: KindText}},
+		Returns: KindText,
+		New:     func() Accumulator { return &concatAcc{} },
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	t.Cleanup(func() { UnregisterFunction("join_text") })
+
+	v, err := ParseValue("JOIN_TEXT(name) || '!'")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	calls := CollectAggregates(v)
+	if len(calls) != 1 || calls[0].ArgText != "name" {
+		t.Fatalf("unexpected aggregate calls: %#v", calls)
+	}
+	calls[0].Slot = "__agg0"
+	acc := calls[0].NewAccumulator()
+	for _, r := range []storage.Row{{"name": "a"}, {"name": nil}, {"name": 2}} {
+		if err := calls[0].Step(acc, r); err != nil {
+			t.Fatalf("step: %v", err)
+		}
+	}
+	res, _ := acc.Result()
+	got, err := v.EvalValue(storage.Row{"__agg0": res})
+	if err != nil || got != "a,2!" {
+		t.Fatalf("got %#v, %v", got, err)
+	}
+	if _, err := ParseValue("JOIN_TEXT(COUNT(*))"); err == nil {
+		t.Fatalf("expected nested aggregate error")
+	}
+
+	found := false
+	for _, f := range ListFunctions() {
+		if f.Name == "JOIN_TEXT" {
+			found = f.Kind == "aggregate" && !f.Builtin
+		}
+	}
+	if !found {
+		t.Fatalf("JOIN_TEXT missing from ListFunctions")
+	}
+}
@@ -0,0 +1,404 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// toNumber converts a value to either an int64 or a float64. Numeric strings
+// are accepted so arithmetic works on imported TEXT columns.
+func toNumber(v interface{}) (i int64, f float64, isInt bool, ok bool) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), float64(t), true, true
+	case int64:
+		return t, float64(t), true, true
+	case int32:
+		return int64(t), float64(t), true, true
+	case float64:
+		return 0, t, false, true
+	case float32:
+		return 0, float64(t), false, true
+	case Numeric:
+		return 0, t.Float64(), false, true
+	case string:
+		s := strings.TrimSpace(t)
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, float64(n), true, true
+		}
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return 0, n, false, true
+		}
+	}
+	return 0, 0, false, false
+}
+
+// arithmetic node: + - * / %
+type arithOp struct {
+	op    string
+	left  ValueExpr
+	right ValueExpr
+}
+
+func (a *arithOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := a.left.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := a.right.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	return applyArith(a.op, lv, rv)
+}
+
+func (a *arithOp) children() []ValueExpr { return []ValueExpr{a.left, a.right} }
+
+// applyArith evaluates one arithmetic operator; NULL operands yield NULL.
+func applyArith(op string, lv, rv interface{}) (interface{}, error) {
+	if lv == nil || rv == nil {
+		return nil, nil
+	}
+	lv, rv = unwrapJSON(lv), unwrapJSON(rv)
+	if r, ok, err := temporalArith(op, lv, rv); ok {
+		return r, err
+	}
+	if r, ok, err := numericArith(op, lv, rv); ok {
+		return r, err
+	}
+	li, lf, lint, lok := toNumber(lv)
+	if !lok {
+		return nil, fmt.Errorf("cannot apply '%s' to non-numeric value '%v'", op, lv)
+	}
+	ri, rf, rint, rok := toNumber(rv)
+	if !rok {
+		return nil, fmt.Errorf("cannot apply '%s' to non-numeric value '%v'", op, rv)
+	}
+	bothInt := lint && rint
+	switch op {
+	case "+":
+		if bothInt {
+			return checkedInt(li+ri, (li >= 0) == (ri >= 0) && (li+ri >= 0) != (li >= 0))
+		}
+		return checkedFloat(lf+rf, lf, rf)
+	case "-":
+		if bothInt {
+			return checkedInt(li-ri, (li >= 0) != (ri >= 0) && (li-ri >= 0) != (li >= 0))
+		}
+		return checkedFloat(lf-rf, lf, rf)
+	case "*":
+		if bothInt {
+			p := li * ri
+			return checkedInt(p, li != 0 && (p/li != ri || li == -1 && ri == math.MinInt64))
+		}
+		return checkedFloat(lf*rf, lf, rf)
+	case "/":
+		// division always yields a decimal; stored numbers come back from
+		// JSON as floats, so integer division would depend on the source
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return checkedFloat(lf/rf, lf, rf)
+	case "%":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if bothInt {
+			return li % ri, nil
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("unsupported arithmetic op: %s", op)
+}
+
+// checkedInt returns an integer arithmetic result, or an error when the
+// operation overflowed 64 bits.
+func checkedInt(r int64, overflow bool) (interface{}, error) {
+	if overflow {
+		return nil, fmt.Errorf("bigint out of range")
+	}
+	return r, nil
+}
+
+// checkedFloat returns a float arithmetic result, or an error when finite
+// operands overflowed to infinity.
+func checkedFloat(r, l, rv float64) (interface{}, error) {
+	if math.IsInf(r, 0) && !math.IsInf(l, 0) && !math.IsInf(rv, 0) {
+		return nil, fmt.Errorf("value out of range: overflow")
+	}
+	return r, nil
+}
+
+// string concatenation node: a || b
+type concatOp struct {
+	left  ValueExpr
+	right ValueExpr
+}
+
+func (c *concatOp) EvalValue(row storage.Row) (interface{}, error) {
+	lv, err := c.left.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := c.right.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil || rv == nil {
+		return nil, nil
+	}
+	return formatText(lv) + formatText(rv), nil
+}
+
+func (c *concatOp) children() []ValueExpr { return []ValueExpr{c.left, c.right} }
+
+type whenClause struct {
+	cond   ValueExpr
+	result ValueExpr
+}
+
+// CASE node; operand is nil for the searched form (CASE WHEN cond THEN ...)
+type caseOp struct {
+	operand  ValueExpr
+	whens    []whenClause
+	elseExpr ValueExpr
+}
+
+func (c *caseOp) EvalValue(row storage.Row) (interface{}, error) {
+	var subject interface{}
+	if c.operand != nil {
+		v, err := c.operand.EvalValue(row)
+		if err != nil {
+			return nil, err
+		}
+		subject = v
+	}
+	for _, w := range c.whens {
+		cv, err := w.cond.EvalValue(row)
+		if err != nil {
+			return nil, err
+		}
+		matched := false
+		if c.operand != nil {
+			if subject != nil && cv != nil {
+				matched, err = compareValues("=", subject, cv)
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			matched = truthy(cv)
+		}
+		if matched {
+			return w.result.EvalValue(row)
+		}
+	}
+	if c.elseExpr != nil {
+		return c.elseExpr.EvalValue(row)
+	}
+	return nil, nil
+}
+
+func (c *caseOp) children() []ValueExpr {
+	out := []ValueExpr{c.operand}
+	for _, w := range c.whens {
+		out = append(out, w.cond, w.result)
+	}
+	return append(out, c.elseExpr)
+}
+
+// CAST(x AS type) node
+type castOp struct {
+	child ValueExpr
+	typ   schema.DataType
+	loc   *time.Location // the session time zone, once bound; see BindTimeZone
+}
+
+func (c *castOp) EvalValue(row storage.Row) (interface{}, error) {
+	v, err := c.child.EvalValue(row)
+	if err != nil {
+		return nil, err
+	}
+	loc := c.loc
+	if loc == nil {
+		loc = time.Local
+	}
+	return CastValueIn(v, c.typ, loc)
+}
+
+func (c *castOp) children() []ValueExpr { return []ValueExpr{c.child} }
+
+// CastValue converts v to the Go representation used for column type t.
+// NULL (nil) casts to NULL for every type. TIMESTAMPTZ values are cast in
+// the local time zone of the process; see CastValueIn.
+func CastValue(v interface{}, t schema.DataType) (interface{}, error) {
+	return CastValueIn(v, t, time.Local)
+}
+
+// CastValueIn is CastValue in the session time zone loc: a timestamp
+// without a zone is read in loc, and a TIMESTAMPTZ result is in loc.
+func CastValueIn(v interface{}, t schema.DataType, loc *time.Location) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch t.Base() {
+	case schema.JSON:
+		return castJSON(v)
+	case schema.Array, schema.Struct:
+		return castNested(v, t)
+	case schema.Blob, schema.Image:
+		// blob content only enters through the blob store; IMAGE columns of
+		// older databases hold file paths as text
+		if ref, ok := storage.BlobRefOf(v); ok {
+			return ref, nil
+		}
+		if t.Base() == schema.Blob {
+			return nil, fmt.Errorf("cannot cast '%v' to %s", v, t)
+		}
+		return formatText(v), nil
+	case schema.Text:
+	default:
+		// a JSON scalar casts like the value it holds
+		v = unwrapJSON(v)
+	}
+	switch t.Base() {
+	case schema.Integer, schema.SmallInt, schema.BigInt:
+		return castInteger(v, t)
+	case schema.Decimal, schema.Double, schema.Real:
+		return castFloat(v, t)
+	case schema.Numeric:
+		return castNumeric(v, t)
+	case schema.Boolean:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			pb, err := strconv.ParseBool(strings.TrimSpace(b))
+			if err != nil {
+				return nil, fmt.Errorf("cannot cast '%v' to %s", v, t)
+			}
+			return pb, nil
+		}
+		_, f, _, ok := toNumber(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot cast '%v' to %s", v, t)
+		}
+		return f != 0, nil
+	case schema.Text:
+		return formatText(v), nil
+	case schema.Date, schema.Timestamp, schema.TimestampTZ, schema.Interval:
+		return castTemporal(v, t, loc)
+	}
+	return nil, fmt.Errorf("unsupported cast target type: %s", t)
+}
+
+// DecodeValue converts a value read from storage to the representation used
+// for column type t. DATE, TIMESTAMP, TIMESTAMPTZ, INTERVAL and NUMERIC
+// values come back from JSON as text, and whole numbers in float columns as
+// integers. A value that does not convert, such as one stored before the
+// column was typed, is returned unchanged. JSON documents come back decoded
+// and are wrapped as JSON again; a document that is just null reads as NULL.
+// Arrays and structs come back as JSON arrays and objects and convert
+// element by element, and blob references come back as JSON objects.
+// TIMESTAMPTZ values come back in loc, the session time zone.
+func DecodeValue(v interface{}, t schema.DataType, loc *time.Location) interface{} {
+	if v == nil {
+		return nil
+	}
+	var cv interface{}
+	var err error
+	switch t.Base() {
+	case schema.Date, schema.Timestamp, schema.TimestampTZ, schema.Interval:
+		if tz, ok := v.(TimestampTZ); ok {
+			return TimestampTZ{tz.In(loc)}
+		}
+		s, ok := v.(string)
+		if !ok {
+			return v
+		}
+		cv, err = castTemporal(s, t, loc)
+	case schema.Integer, schema.SmallInt, schema.BigInt:
+		s, ok := v.(string)
+		if !ok {
+			return v
+		}
+		i, perr := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if perr != nil {
+			return v
+		}
+		cv = i
+	case schema.Decimal, schema.Double, schema.Real:
+		if _, ok := v.(bool); ok {
+			return v
+		}
+		cv, err = castFloat(v, t)
+	case schema.JSON:
+		if j, ok := v.(JSON); ok {
+			return j
+		}
+		cv, err = jsonOf(v)
+	case schema.Array, schema.Struct:
+		switch v.(type) {
+		case Array, Struct:
+			return v
+		}
+		cv, err = fromDoc(v, t)
+	case schema.Blob, schema.Image:
+		if ref, ok := storage.BlobRefOf(v); ok {
+			return ref
+		}
+		return v
+	case schema.Numeric:
+		n, ok := toNumeric(v)
+		if !ok {
+			return v
+		}
+		cv = n
+		if _, s, ok := t.NumericModifiers(); ok && n.Scale() < s {
+			cv = n.rescale(s)
+		}
+	default:
+		return v
+	}
+	if err != nil {
+		return v
+	}
+	return cv
+}
+
+// FormatValue renders a value for display; NULL is shown as NULL.
+func FormatValue(v interface{}) string {
+	if v == nil {
+		return "NULL"
+	}
+	return formatText(v)
+}
+
+// formatText renders a value the way it appears when converted to TEXT.
+func formatText(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case time.Time:
+		return t.Format(TimestampLayout)
+	case Date, TimestampTZ, Interval, Numeric, JSON, Array, Struct:
+		return fmt.Sprint(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32)
+	case []interface{}:
+		// arrays render as JSON, the same way they are stored
+		if b, err := json.Marshal(t); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,557 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"Custom_DB/pkg/storage"
+)
+
+// Conditions can also be evaluated a batch of rows at a time. A Batch
+// turns the values of a column into a Vector, a slice of int64, float64 or
+// string when all of them have that type, and a condition compiled by
+// CompileFilter narrows a selection vector, the indexes of the rows still
+// selected, with loops specialized to those types rather than a type
+// switch per value. Parts of a condition without such a loop, and columns
+// whose values have mixed types, are evaluated row at a time with Eval on
+// the selected rows, so a Filter selects exactly the rows Eval is true on.
+
+// BatchSize is the number of rows evaluated together.
+const BatchSize = 1024
+
+// VectorKind is the type of the values of a Vector.
+type VectorKind int
+
+const (
+	VecAny VectorKind = iota // mixed types, in Any
+	VecInt
+	VecFloat
+	VecString
+)
+
+// Vector holds the values of one column of a Batch. Nulls marks the NULL
+// rows, whose typed values are zero.
+type Vector struct {
+	Kind    VectorKind
+	Ints    []int64
+	Floats  []float64
+	Strings []string
+	Any     []interface{}
+	Nulls   []bool
+}
+
+// Batch is a batch of rows whose columns are made into vectors as they are
+// needed.
+type Batch struct {
+	Rows []storage.Row
+	cols map[string]*Vector
+}
+
+// NewBatch returns a batch of rows.
+func NewBatch(rows []storage.Row) *Batch {
+	return &Batch{Rows: rows, cols: map[string]*Vector{}}
+}
+
+// All returns a selection vector of every row of the batch.
+func (b *Batch) All() []int {
+	sel := make([]int, len(b.Rows))
+	for i := range sel {
+		sel[i] = i
+	}
+	return sel
+}
+
+// Column returns the vector of a column. A missing key reads as NULL.
+func (b *Batch) Column(name string) *Vector {
+	if v, ok := b.cols[name]; ok {
+		return v
+	}
+	n := len(b.Rows)
+	v := &Vector{Nulls: make([]bool, n), Any: make([]interface{}, n)}
+	// the kind of the first value, unless another differs
+	kind, seen := VecAny, false
+	for i, r := range b.Rows {
+		x := r[name]
+		v.Any[i] = x
+		k := VecAny
+		switch x.(type) {
+		case nil:
+			v.Nulls[i] = true
+			continue
+		case int, int32, int64:
+			k = VecInt
+		case float64, float32:
+			k = VecFloat
+		case string:
+			k = VecString
+		}
+		if !seen {
+			kind, seen = k, true
+		} else if k != kind {
+			kind = VecAny
+		}
+	}
+	switch kind {
+	case VecInt:
+		v.Ints = make([]int64, n)
+		for i, x := range v.Any {
+			v.Ints[i], _ = asInt64(x)
+		}
+	case VecFloat:
+		v.Floats = make([]float64, n)
+		for i, x := range v.Any {
+			v.Floats[i], _ = toFloat(x)
+		}
+	case VecString:
+		v.Strings = make([]string, n)
+		for i, x := range v.Any {
+			v.Strings[i], _ = x.(string)
+		}
+	}
+	v.Kind = kind
+	b.cols[name] = v
+	return v
+}
+
+// asInt64 returns the value of a Go integer.
+func asInt64(v interface{}) (int64, bool) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), true
+	case int32:
+		return int64(t), true
+	case int64:
+		return t, true
+	}
+	return 0, false
+}
+
+// Filter is a condition compiled for batches.
+type Filter struct {
+	root selector
+}
+
+// selector narrows a selection vector of a batch to the rows a condition
+// is true on, keeping their order.
+type selector interface {
+	selectRows(b *Batch, sel []int) ([]int, error)
+}
+
+// CompileFilter compiles a condition for evaluation on batches.
+func CompileFilter(e Expr) *Filter {
+	return &Filter{root: compileSelector(e)}
+}
+
+// Select returns the rows of sel, a selection vector of b, that the
+// condition is true on.
+func (f *Filter) Select(b *Batch, sel []int) ([]int, error) {
+	return f.root.selectRows(b, sel)
+}
+
+// Vectorized reports whether every part of the condition has a loop of its
+// own, as opposed to being evaluated row at a time.
+func (f *Filter) Vectorized() bool {
+	var walk func(s selector) bool
+	walk = func(s selector) bool {
+		switch t := s.(type) {
+		case *rowSelector:
+			return false
+		case *andSelector:
+			return walk(t.left) && walk(t.right)
+		case *orSelector:
+			return walk(t.left) && walk(t.right)
+		}
+		return true
+	}
+	return walk(f.root)
+}
+
+func compileSelector(e Expr) selector {
+	switch t := e.(type) {
+	case *truthyOp:
+		if inner, ok := t.child.(Expr); ok && isCondition(inner) {
+			return compileSelector(inner)
+		}
+	case *binaryOp:
+		switch strings.ToUpper(t.op) {
+		case "AND":
+			return &andSelector{left: compileSelector(t.left), right: compileSelector(t.right)}
+		case "OR":
+			return &orSelector{left: compileSelector(t.left), right: compileSelector(t.right)}
+		}
+	case *compOp:
+		if s := compileComparison(t.op, t.left, t.right, e); s != nil {
+			return s
+		}
+	case *betweenOp:
+		if col, ok := t.left.(*colRef); ok {
+			lo, lok := t.lo.(*literal)
+			hi, hok := t.hi.(*literal)
+			if lok && hok {
+				return &andSelector{
+					left:  &compareSelector{op: ">=", left: col.name, lit: lo.val, row: &rowSelector{e}},
+					right: &compareSelector{op: "<=", left: col.name, lit: hi.val, row: &rowSelector{e}},
+				}
+			}
+		}
+	case *isNullOp:
+		if col, ok := t.child.(*colRef); ok {
+			return &nullSelector{col: col.name, negate: t.negate}
+		}
+	case *inOp:
+		if col, ok := t.left.(*colRef); ok {
+			s := &inSelector{col: col.name, set: map[string]bool{}, row: &rowSelector{e}}
+			for _, it := range t.list {
+				lit, ok := it.(*literal)
+				if !ok {
+					return &rowSelector{e}
+				}
+				if lit.val != nil {
+					s.set[fmt.Sprintf("%v", unwrapJSON(lit.val))] = true
+				}
+			}
+			return s
+		}
+	case *likeOp:
+		if col, ok := t.left.(*colRef); ok {
+			return &likeSelector{col: col.name, pattern: t.pattern, row: &rowSelector{e}}
+		}
+	}
+	return &rowSelector{e}
+}
+
+// isCondition reports whether e is a node whose value is TRUE, FALSE or
+// NULL.
+func isCondition(e Expr) bool {
+	switch e.(type) {
+	case *binaryOp, *compOp, *betweenOp, *isNullOp, *inOp, *likeOp, *notOp, *distinctOp:
+		return true
+	}
+	return false
+}
+
+// compileComparison compiles left op right when one side is a column and
+// the other a column or a constant.
+func compileComparison(op string, left, right ValueExpr, e Expr) selector {
+	switch op {
+	case "=", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil
+	}
+	if lit, ok := left.(*literal); ok {
+		left, right, op = right, lit, flipComparison(op)
+	}
+	col, ok := left.(*colRef)
+	if !ok {
+		return nil
+	}
+	switch r := right.(type) {
+	case *colRef:
+		return &compareSelector{op: op, left: col.name, right: r.name, row: &rowSelector{e}}
+	case *literal:
+		return &compareSelector{op: op, left: col.name, lit: r.val, row: &rowSelector{e}}
+	}
+	return nil
+}
+
+// rowSelector evaluates a condition row at a time.
+type rowSelector struct{ e Expr }
+
+func (s *rowSelector) selectRows(b *Batch, sel []int) ([]int, error) {
+	out := make([]int, 0, len(sel))
+	for _, i := range sel {
+		ok, err := s.e.Eval(b.Rows[i])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, i)
+		}
+	}
+	return out, nil
+}
+
+// andSelector selects the rows both sides select. The right side sees only
+// the rows the left one selected: unlike Eval it is skipped where the left
+// side is NULL, so an error it would raise on such a row is not raised.
+type andSelector struct{ left, right selector }
+
+func (s *andSelector) selectRows(b *Batch, sel []int) ([]int, error) {
+	sel, err := s.left.selectRows(b, sel)
+	if err != nil || len(sel) == 0 {
+		return sel, err
+	}
+	return s.right.selectRows(b, sel)
+}
+
+// orSelector selects the rows either side selects; the right side sees
+// the rows the left one did not select.
+type orSelector struct{ left, right selector }
+
+func (s *orSelector) selectRows(b *Batch, sel []int) ([]int, error) {
+	left, err := s.left.selectRows(b, sel)
+	if err != nil {
+		return nil, err
+	}
+	rest := make([]int, 0, len(sel)-len(left))
+	j := 0
+	for _, i := range sel {
+		if j < len(left) && left[j] == i {
+			j++
+			continue
+		}
+		rest = append(rest, i)
+	}
+	right, err := s.right.selectRows(b, rest)
+	if err != nil {
+		return nil, err
+	}
+	// merge the two, both in the order of sel
+	out := make([]int, 0, len(left)+len(right))
+	j, k := 0, 0
+	for j < len(left) || k < len(right) {
+		if k == len(right) || (j < len(left) && left[j] < right[k]) {
+			out = append(out, left[j])
+			j++
+		} else {
+			out = append(out, right[k])
+			k++
+		}
+	}
+	return out, nil
+}
+
+// compareSelector compares a column with a constant or another column.
+// Pairs of types without a loop of their own are compared row at a time.
+type compareSelector struct {
+	op    string
+	left  string
+	right string      // the other column, or empty
+	lit   interface{} // the constant, when right is empty
+	row   *rowSelector
+}
+
+func (s *compareSelector) selectRows(b *Batch, sel []int) ([]int, error) {
+	lv := b.Column(s.left)
+	if s.right != "" {
+		return s.compareColumns(b, lv, b.Column(s.right), sel)
+	}
+	lit := unwrapJSON(s.lit)
+	if lit == nil {
+		// comparing with NULL is never true
+		return []int{}, nil
+	}
+	out := make([]int, 0, len(sel))
+	li, isInt := asInt64(lit)
+	lf, isFloat := lit.(float64)
+	ls, isString := lit.(string)
+	switch {
+	case lv.Kind == VecInt && isInt:
+		for _, i := range sel {
+			if !lv.Nulls[i] && intHolds(s.op, lv.Ints[i], li) {
+				out = append(out, i)
+			}
+		}
+	case lv.Kind == VecInt && isFloat:
+		for _, i := range sel {
+			if !lv.Nulls[i] && floatHolds(s.op, float64(lv.Ints[i]), lf) {
+				out = append(out, i)
+			}
+		}
+	case lv.Kind == VecFloat && (isInt || isFloat):
+		if isInt {
+			lf = float64(li)
+		}
+		for _, i := range sel {
+			if !lv.Nulls[i] && floatHolds(s.op, lv.Floats[i], lf) {
+				out = append(out, i)
+			}
+		}
+	case lv.Kind == VecString && isString:
+		// text that reads as numbers on both sides compares as numbers
+		nf, numeric := toFloat(ls)
+		for _, i := range sel {
+			if lv.Nulls[i] {
+				continue
+			}
+			if numeric {
+				if f, err := strconv.ParseFloat(lv.Strings[i], 64); err == nil {
+					if floatHolds(s.op, f, nf) {
+						out = append(out, i)
+					}
+					continue
+				}
+			}
+			if stringHolds(s.op, lv.Strings[i], ls) {
+				out = append(out, i)
+			}
+		}
+	default:
+		return s.row.selectRows(b, sel)
+	}
+	return out, nil
+}
+
+func (s *compareSelector) compareColumns(b *Batch, lv, rv *Vector, sel []int) ([]int, error) {
+	out := make([]int, 0, len(sel))
+	switch {
+	case lv.Kind == VecInt && rv.Kind == VecInt:
+		for _, i := range sel {
+			if !lv.Nulls[i] && !rv.Nulls[i] && intHolds(s.op, lv.Ints[i], rv.Ints[i]) {
+				out = append(out, i)
+			}
+		}
+	case (lv.Kind == VecInt || lv.Kind == VecFloat) && (rv.Kind == VecInt || rv.Kind == VecFloat):
+		for _, i := range sel {
+			if !lv.Nulls[i] && !rv.Nulls[i] && floatHolds(s.op, vectorFloat(lv, i), vectorFloat(rv, i)) {
+				out = append(out, i)
+			}
+		}
+	case lv.Kind == VecString && rv.Kind == VecString:
+		for _, i := range sel {
+			if lv.Nulls[i] || rv.Nulls[i] {
+				continue
+			}
+			l, r := lv.Strings[i], rv.Strings[i]
+			lf, lerr := strconv.ParseFloat(l, 64)
+			rf, rerr := strconv.ParseFloat(r, 64)
+			var ok bool
+			if lerr == nil && rerr == nil {
+				ok = floatHolds(s.op, lf, rf)
+			} else {
+				ok = stringHolds(s.op, l, r)
+			}
+			if ok {
+				out = append(out, i)
+			}
+		}
+	default:
+		return s.row.selectRows(b, sel)
+	}
+	return out, nil
+}
+
+// vectorFloat returns row i of a numeric vector as a float64.
+func vectorFloat(v *Vector, i int) float64 {
+	if v.Kind == VecInt {
+		return float64(v.Ints[i])
+	}
+	return v.Floats[i]
+}
+
+func intHolds(op string, a, b int64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+func floatHolds(op string, a, b float64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+func stringHolds(op string, a, b string) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+// nullSelector is IS [NOT] NULL on a column.
+type nullSelector struct {
+	col    string
+	negate bool
+}
+
+func (s *nullSelector) selectRows(b *Batch, sel []int) ([]int, error) {
+	v := b.Column(s.col)
+	out := make([]int, 0, len(sel))
+	for _, i := range sel {
+		if v.Nulls[i] != s.negate {
+			out = append(out, i)
+		}
+	}
+	return out, nil
+}
+
+// inSelector is a column IN a list of constants, which IN compares as
+// text; set holds the text of the constants.
+type inSelector struct {
+	col string
+	set map[string]bool
+	row *rowSelector
+}
+
+func (s *inSelector) selectRows(b *Batch, sel []int) ([]int, error) {
+	v := b.Column(s.col)
+	out := make([]int, 0, len(sel))
+	switch v.Kind {
+	case VecInt:
+		for _, i := range sel {
+			if !v.Nulls[i] && s.set[strconv.FormatInt(v.Ints[i], 10)] {
+				out = append(out, i)
+			}
+		}
+	case VecString:
+		for _, i := range sel {
+			if !v.Nulls[i] && s.set[v.Strings[i]] {
+				out = append(out, i)
+			}
+		}
+	default:
+		return s.row.selectRows(b, sel)
+	}
+	return out, nil
+}
+
+// likeSelector is a column LIKE a pattern.
+type likeSelector struct {
+	col     string
+	pattern string
+	row     *rowSelector
+}
+
+func (s *likeSelector) selectRows(b *Batch, sel []int) ([]int, error) {
+	v := b.Column(s.col)
+	if v.Kind != VecString {
+		return s.row.selectRows(b, sel)
+	}
+	out := make([]int, 0, len(sel))
+	for _, i := range sel {
+		if !v.Nulls[i] && likeMatch(v.Strings[i], s.pattern) {
+			out = append(out, i)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,91 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+
+	"Custom_DB/pkg/storage"
+)
+
+func TestFilterMatchesEval(t *testing.T) {
+	rows := []storage.Row{}
+	for i := 0; i < 50; i++ {
+		r := storage.Row{
+			"id":    int64(i),
+			"score": float64(i%9) + 0.5,
+			"name":  []string{"al", "bo", "cy", "10", "9", "2.5"}[i%6],
+			"code":  []string{"x1", "y2", "x3"}[i%3],
+			"mixed": []interface{}{int64(3), "3", 2.5, true}[i%4],
+		}
+		if i%7 == 0 {
+			r["score"], r["name"] = nil, nil
+		}
+		if i%11 == 0 {
+			delete(r, "id")
+		}
+		rows = append(rows, r)
+	}
+	cases := []struct {
+		src        string
+		vectorized bool
+	}{
+		{"id > 20", true},
+		{"20 >= id", true},
+		{"id = 7.0", true},
+		{"score < 4", true},
+		{"score != 2.5", true},
+		{"id < score * 5", false},
+		{"id > score", true},
+		{"name = 'al' OR id < 5", true},
+		{"name > '5'", true},
+		{"name < 'b'", true},
+		{"name >= code", true},
+		{"mixed = 3", true},
+		{"name = NULL", true},
+		{"id BETWEEN 10 AND 30 AND score IS NOT NULL", true},
+		{"name IS NULL OR code LIKE 'x%'", true},
+		{"id IN (1, 2, 3, 40, NULL)", true},
+		{"name IN ('al', 'cy')", true},
+		{"mixed IN (3, 'x')", true},
+		{"NOT (id > 20) AND code LIKE '%2'", false},
+		{"(id > 10 OR score > 5) AND (name = 'bo' OR name IS NULL)", true},
+		{"UPPER(name) = 'AL'", false},
+	}
+	b := NewBatch(rows)
+	for _, c := range cases {
+		e, err := ParseExpression(c.src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.src, err)
+		}
+		e = FoldExpr(e)
+		want := []int{}
+		for i, r := range rows {
+			if ok, err := e.Eval(r); err != nil {
+				t.Fatalf("eval %q: %v", c.src, err)
+			} else if ok {
+				want = append(want, i)
+			}
+		}
+		f := CompileFilter(e)
+		got, err := f.Select(b, b.All())
+		if err != nil {
+			t.Fatalf("select %q: %v", c.src, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: selected %v, want %v", c.src, got, want)
+		}
+		if f.Vectorized() != c.vectorized {
+			t.Errorf("%s: Vectorized() = %v", c.src, f.Vectorized())
+		}
+		// a selection vector limits the rows looked at
+		if got, _ := f.Select(b, []int{1, 2, 3}); len(got) > 3 {
+			t.Errorf("%s: selected %v from 3 rows", c.src, got)
+		}
+	}
+	if v := b.Column("id"); v.Kind != VecInt || !v.Nulls[0] || v.Ints[12] != 12 {
+		t.Errorf("id vector = %+v", v)
+	}
+	if v := b.Column("mixed"); v.Kind != VecAny {
+		t.Errorf("mixed vector kind = %v", v.Kind)
+	}
+}
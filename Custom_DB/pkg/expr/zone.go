@@ -0,0 +1,134 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ZoneRange is what the zone map of a part of a table tells of a column:
+// the least and greatest of its values, nil when they are not known, and
+// how many of its Rows values are NULL.
+type ZoneRange struct {
+	Min, Max    interface{}
+	Nulls, Rows int
+}
+
+// ZoneExcludes reports whether no row of a part of a table can satisfy e,
+// a WHERE condition, given the zone ranges zone returns for its columns; ok
+// is false for a column without one. Only conditions comparing a column
+// with numbers, or with text that is not a number, are judged by the
+// bounds, as the order of other values depends on more than their bounds.
+func ZoneExcludes(e Expr, zone func(column string) (ZoneRange, bool)) bool {
+	switch n := e.(type) {
+	case *binaryOp:
+		l, r := ZoneExcludes(n.left, zone), ZoneExcludes(n.right, zone)
+		if strings.EqualFold(n.op, "AND") {
+			return l || r
+		}
+		return l && r
+	case *compOp:
+		left, right, op := n.left, n.right, n.op
+		c, ok := constantValue(right)
+		if !ok {
+			if c, ok = constantValue(left); !ok {
+				return false
+			}
+			left, op = right, flipComparison(op)
+		}
+		z, ok := columnZone(left, zone)
+		if !ok {
+			return false
+		}
+		return zoneExcludesComparison(z, op, c)
+	case *betweenOp:
+		z, ok := columnZone(n.left, zone)
+		lo, okLo := constantValue(n.lo)
+		hi, okHi := constantValue(n.hi)
+		if !ok || !okLo || !okHi {
+			return false
+		}
+		return zoneExcludesComparison(z, ">=", lo) || zoneExcludesComparison(z, "<=", hi)
+	case *inOp:
+		z, ok := columnZone(n.left, zone)
+		if !ok {
+			return false
+		}
+		for _, el := range n.list {
+			c, ok := constantValue(el)
+			if !ok || !zoneExcludesComparison(z, "=", c) {
+				return false
+			}
+		}
+		return true
+	case *isNullOp:
+		z, ok := columnZone(n.child, zone)
+		if !ok {
+			return false
+		}
+		if n.negate {
+			return z.Nulls == z.Rows
+		}
+		return z.Nulls == 0
+	}
+	return false
+}
+
+// columnZone returns the zone range of v when it is a column that has one.
+func columnZone(v ValueExpr, zone func(column string) (ZoneRange, bool)) (ZoneRange, bool) {
+	name, ok := ColumnName(v)
+	if !ok {
+		return ZoneRange{}, false
+	}
+	return zone(name)
+}
+
+// zoneExcludesComparison reports whether no value in z satisfies
+// value op c.
+func zoneExcludesComparison(z ZoneRange, op string, c interface{}) bool {
+	if z.Nulls == z.Rows {
+		// comparisons with NULL are never true
+		return true
+	}
+	if c == nil || !zoneOrdered(z.Min, c) || !zoneOrdered(z.Max, c) {
+		return false
+	}
+	less := func(a, b interface{}) bool {
+		lt, err := compareValues("<", a, b)
+		return err == nil && lt
+	}
+	switch op {
+	case "=":
+		return less(c, z.Min) || less(z.Max, c)
+	case "!=":
+		return !less(z.Min, c) && !less(c, z.Min) && !less(z.Max, c) && !less(c, z.Max)
+	case "<":
+		return !less(z.Min, c)
+	case "<=":
+		return less(c, z.Min)
+	case ">":
+		return !less(c, z.Max)
+	case ">=":
+		return less(z.Max, c)
+	}
+	return false
+}
+
+// zoneOrdered reports whether comparing bound with c orders them the way
+// bounds are found: both numbers, or both text with c not a number, which
+// compares as text with every value.
+func zoneOrdered(bound, c interface{}) bool {
+	switch c := c.(type) {
+	case int64, float64:
+		switch bound.(type) {
+		case int64, float64:
+			return true
+		}
+	case string:
+		if _, isText := bound.(string); !isText {
+			return false
+		}
+		_, err := strconv.ParseFloat(c, 64)
+		return err != nil
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package expr
+
+import (
+	"testing"
+
+	"Custom_DB/pkg/storage"
+)
+
+// TestZoneExcludes checks that a condition excludes a part of a table only
+// when no row of it satisfies the condition.
+func TestZoneExcludes(t *testing.T) {
+	parts := [][]storage.Row{}
+	for p := 0; p < 6; p++ {
+		part := []storage.Row{}
+		for i := 0; i < 10; i++ {
+			r := storage.Row{
+				"id":    int64(p*10 + i),
+				"score": float64(p) + float64(i)/10,
+				"name":  []string{"al", "bo", "cy", "dee"}[(p+i)%4] + string(rune('a'+p)),
+				"gone":  nil,
+			}
+			if p == 2 {
+				r["score"] = nil
+			}
+			part = append(part, r)
+		}
+		parts = append(parts, part)
+	}
+	zoneOf := func(part []storage.Row) func(string) (ZoneRange, bool) {
+		return func(col string) (ZoneRange, bool) {
+			z := ZoneRange{Rows: len(part)}
+			for _, r := range part {
+				v := r[col]
+				if v == nil {
+					z.Nulls++
+					continue
+				}
+				if z.Min == nil || less(v, z.Min) {
+					z.Min = v
+				}
+				if z.Max == nil || less(z.Max, v) {
+					z.Max = v
+				}
+			}
+			return z, true
+		}
+	}
+	cases := []struct {
+		src      string
+		excluded int // parts excluded
+	}{
+		{"id < 10", 5},
+		{"10 > id", 5},
+		{"id >= 55", 5},
+		{"id = 23", 5},
+		{"id != 23", 0},
+		{"id BETWEEN 12 AND 31", 3},
+		{"id IN (3, 44)", 4},
+		{"id < 5 OR id > 54", 4},
+		{"id < 30 AND score > 1", 5},
+		{"score > 4.5", 4},
+		{"score IS NULL", 5},
+		{"score IS NOT NULL", 1},
+		{"gone = 1", 6},
+		{"name < 'alb'", 5},
+		{"name > 'zz'", 6},
+		{"name = '1'", 0},
+		{"NOT id < 10", 0},
+		{"id + 1 < 10", 0},
+	}
+	for _, c := range cases {
+		e, err := ParseExpression(c.src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.src, err)
+		}
+		excluded := 0
+		for _, part := range parts {
+			if !ZoneExcludes(e, zoneOf(part)) {
+				continue
+			}
+			excluded++
+			for _, r := range part {
+				if ok, _ := e.Eval(r); ok {
+					t.Errorf("%q excludes a part holding %v", c.src, r)
+				}
+			}
+		}
+		if excluded != c.excluded {
+			t.Errorf("%q: %d parts excluded, want %d", c.src, excluded, c.excluded)
+		}
+	}
+}
+
+func less(a, b interface{}) bool {
+	lt, _ := compareValues("<", a, b)
+	return lt
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/stats"
+	"Custom_DB/pkg/storage"
+)
+
+// HandleAnalyze processes ANALYZE [table], which gathers the statistics the
+// planner estimates row counts with, of one table or of every table, and
+// saves them in the database's stats.json. Statistics are not kept up to
+// date as rows change; ANALYZE again after large changes.
+func HandleAnalyze(cmd parser.Command, db *schema.Database) (string, error) {
+	var names []string
+	switch len(cmd.Tokens) {
+	case 1:
+		names = db.GetAllTableNames()
+		sort.Strings(names)
+	case 2:
+		if _, exists := db.GetTable(cmd.Tokens[1]); !exists {
+			return "", fmt.Errorf("table '%s' does not exist", cmd.Tokens[1])
+		}
+		names = []string{cmd.Tokens[1]}
+	default:
+		return "", fmt.Errorf("invalid ANALYZE syntax. Example: ANALYZE users;")
+	}
+
+	all, err := stats.Load(db.GetDBPath())
+	if err != nil {
+		return "", err
+	}
+	// statistics are kept by storage name, so a temporary table's never
+	// pass for those of the table it hides
+	kept := map[string]bool{}
+	for _, name := range db.GetAllTableNames() {
+		table, _ := db.GetTable(name)
+		kept[table.StorageName()] = true
+	}
+	for name := range all {
+		if !kept[name] {
+			delete(all, name)
+		}
+	}
+	sb := &strings.Builder{}
+	for _, name := range names {
+		table, _ := db.GetTable(name)
+		ts, err := analyzeTable(db, table)
+		if err != nil {
+			return "", err
+		}
+		all[table.StorageName()] = ts
+		sb.WriteString(fmt.Sprintf("✅ Analyzed table '%s': %d rows\n", name, ts.Rows))
+	}
+	if err := stats.Save(db.GetDBPath(), all); err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "No tables to analyze.", nil
+	}
+	return strings.TrimSuffix(sb.String(), "\n"), nil
+}
+
+// analyzeTable reads every row of table and returns its statistics.
+func analyzeTable(db *schema.Database, table schema.Table) (*stats.TableStats, error) {
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+	if err != nil {
+		return nil, err
+	}
+	stamp, err := tableFile.Stamp()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tableFile.Scan(nil, storage.ScanOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	collectors := make([]*stats.Collector, len(table.Columns))
+	for i := range collectors {
+		collectors[i] = stats.NewCollector()
+	}
+	ts := &stats.TableStats{Stamp: stamp, Columns: map[string]*stats.ColumnStats{}}
+	loc := expr.SessionTimeZone(db)
+	for {
+		r, _, err := rows.Next()
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			break
+		}
+		ts.Rows++
+		decodeRows([]storage.Row{r}, table.Columns, loc)
+		for i, c := range table.Columns {
+			v := r[c.Name]
+			if v == nil {
+				collectors[i].AddNull()
+				continue
+			}
+			collectors[i].Add(expr.GroupKey(v), expr.StatValue(v))
+		}
+	}
+	for i, c := range table.Columns {
+		ts.Columns[c.Name] = collectors[i].Stats()
+	}
+	return ts, nil
+}
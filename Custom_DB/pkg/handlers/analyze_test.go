@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/stats"
+)
+
+func TestHandleAnalyze(t *testing.T) {
+	db := newNumbersDB(t, 200, 151)
+	if out := runHandler(t, "ANALYZE numbers;", HandleAnalyze, db); out != "✅ Analyzed table 'numbers': 200 rows" {
+		t.Errorf("ANALYZE = %q", out)
+	}
+	all, err := stats.Load(db.GetDBPath())
+	if err != nil {
+		t.Fatalf("load stats: %v", err)
+	}
+	ts := all["numbers"]
+	if ts == nil || ts.Rows != 200 {
+		t.Fatalf("stats = %+v", ts)
+	}
+	n, code := ts.Columns["n"], ts.Columns["code"]
+	if n.Distinct != 200 || n.Min != 1.0 || n.Max != 200.0 || n.NullFrac != 0 {
+		t.Errorf("n stats = %+v", n)
+	}
+	// codes are 1..150 then "x" for the rest; distinct counts are estimates
+	if code.Distinct < 145 || code.Distinct > 155 || code.Min != "1" || code.Max != "x" {
+		t.Errorf("code stats = %+v", code)
+	}
+
+	// estimates appear in EXPLAIN, and the more selective condition is
+	// evaluated first
+	out := runHandler(t, "EXPLAIN SELECT n FROM numbers WHERE n > 10 AND code = 'x';", HandleExplain, db)
+	want := "" +
+		"Project n (est. rows=1)\n" +
+		"-> Filter WHERE code = 'x' AND n > 10 (est. rows=1)\n" +
+		"  -> Seq Scan on numbers (est. rows=200)\n"
+	if out != want {
+		t.Errorf("EXPLAIN =\n%s\nwant\n%s", out, want)
+	}
+	out = runHandler(t, "EXPLAIN SELECT code, COUNT(*) FROM numbers WHERE n <= 100 GROUP BY code;", HandleExplain, db)
+	if !strings.Contains(out, "Group By code: COUNT(*) (est. rows=101)") || !strings.Contains(out, "Filter WHERE n <= 100 (est. rows=101)") {
+		t.Errorf("EXPLAIN =\n%s", out)
+	}
+
+	// ANALYZE with no table analyzes all of them
+	if out := runHandler(t, "ANALYZE;", HandleAnalyze, db); !strings.Contains(out, "'numbers': 200 rows") {
+		t.Errorf("ANALYZE = %q", out)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+)
+
+func newTripsDB(t *testing.T) *schema.Database {
+	t.Helper()
+	return newTestDB(t, "trips (id INT, tags ARRAY<TEXT>, scores INT[], stop STRUCT<city TEXT, zip INT>)",
+		`(1, '["sea", "sun"]', ARRAY[7, 9], '{"city": "Nice", "zip": 6000}')`,
+		`(2, ARRAY['snow'], '[3, 4, 5]', '{"city": "Chamonix, FR", "zip": "74400"}')`,
+		`(3, '[]', NULL, NULL)`,
+	)
+}
+
+func TestArrayAndStructColumns(t *testing.T) {
+	db := newTripsDB(t)
+
+	data, err := os.ReadFile(filepath.Join(db.GetDBPath(), "trips.dat"))
+	if err != nil {
+		t.Fatalf("read data file: %v", err)
+	}
+	if !strings.Contains(string(data), `"stop":{"city":"Chamonix, FR","zip":74400}`) || !strings.Contains(string(data), `"scores":[7,9]`) {
+		t.Fatalf("expected nested values in storage:\n%s", data)
+	}
+
+	out := runSelect(t, db, "SELECT id, tags[1] AS first, stop.city AS city, scores[2] + 1 AS s FROM trips ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, "|") != "1 sea Nice 10|2 snow Chamonix, FR 5|3 NULL NULL NULL" {
+		t.Fatalf("element access: got %q", got)
+	}
+
+	out = runSelect(t, db, "SELECT id FROM trips WHERE 4 = ANY(scores) OR stop.zip > 6000 ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, ",") != "2" {
+		t.Fatalf("ANY: got %q", got)
+	}
+	out = runSelect(t, db, "SELECT id FROM trips WHERE 5 < ALL(scores) ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, ",") != "1" {
+		t.Fatalf("ALL: got %q", got)
+	}
+
+	out = runSelect(t, db, "SELECT id, tag FROM trips, UNNEST(tags) AS tag ORDER BY tag;")
+	if got := dataLines(out); strings.Join(got, "|") != "1 sea|2 snow|1 sun" {
+		t.Fatalf("UNNEST: got %q", got)
+	}
+	out = runSelect(t, db, "SELECT SUM(unnest) AS total, COUNT(*) AS n FROM trips, UNNEST(scores);")
+	if got := dataLines(out); strings.Join(got, "|") != "28 5" {
+		t.Fatalf("aggregate over UNNEST: got %q", got)
+	}
+	out = runSelect(t, db, "SELECT unnest FROM UNNEST(ARRAY[3, 1, 2]) ORDER BY unnest DESC;")
+	if got := dataLines(out); strings.Join(got, ",") != "3,2,1" {
+		t.Fatalf("UNNEST of a constructor: got %q", got)
+	}
+	out = runSelect(t, db, "SELECT CARDINALITY(tags) AS n, COUNT(*) AS c FROM trips GROUP BY CARDINALITY(tags) ORDER BY n;")
+	if got := dataLines(out); strings.Join(got, "|") != "0 1|1 1|2 1" {
+		t.Fatalf("GROUP BY CARDINALITY: got %q", got)
+	}
+
+	for _, sql := range []string{
+		"INSERT INTO trips (id, scores) VALUES (4, '[\"x\"]');",
+		"INSERT INTO trips (id, stop) VALUES (4, '{\"town\": \"Oslo\"}');",
+		"SELECT id FROM trips, UNNEST(tags) AS id;",
+		"SELECT stop.country FROM trips;",
+	} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		handle := HandleSelect
+		if strings.HasPrefix(sql, "INSERT") {
+			handle = HandleInsert
+		}
+		if _, err := handle(cmd, db); err == nil {
+			t.Fatalf("expected an error for %q", sql)
+		}
+	}
+}
+
+func TestInsertTextArray(t *testing.T) {
+	db := newTripsDB(t)
+	runHandler(t, "INSERT INTO trips (id, tags) VALUES (4, ARRAY['x', 'y]', 'z']);", HandleInsert, db)
+	runHandler(t, "INSERT INTO trips (id, tags) VALUES (5, ARRAY['[a', 'b']);", HandleInsert, db)
+	out := runSelect(t, db, "SELECT id, CARDINALITY(tags) AS n, tags[2] AS second FROM trips WHERE id > 3 ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, "|") != "4 3 y]|5 2 b" {
+		t.Fatalf("text arrays: got %q", got)
+	}
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/imaging"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// imageExtensions are the extensions tried when an IMAGE value names a file
+// of the image directory without its extension.
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".webp"}
+
+// blobContent is the content of a BLOB or IMAGE value before it is put in
+// the blob store. name is the name images are indexed under.
+type blobContent struct {
+	data []byte
+	name string
+}
+
+// parseBlobLiteral parses a BLOB value written as a hex literal such as
+// X'89504e47' or as quoted text, whose bytes are stored as they are.
+func parseBlobLiteral(s string) (blobContent, error) {
+	if len(s) > 2 && (s[0] == 'X' || s[0] == 'x') && s[1] == '\'' && s[len(s)-1] == '\'' {
+		data, err := hex.DecodeString(s[2 : len(s)-1])
+		if err != nil {
+			return blobContent{}, fmt.Errorf("invalid hex literal %s: %w", s, err)
+		}
+		return blobContent{data: data}, nil
+	}
+	if len(s) > 1 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return blobContent{data: []byte(strings.ReplaceAll(s[1:len(s)-1], "''", "'"))}, nil
+	}
+	return blobContent{}, fmt.Errorf("BLOB values are written as X'<hex>' or as quoted text, got %s", s)
+}
+
+// readImage reads the image file named by identifier; see findImagePath and
+// imageContent.
+func readImage(identifier, imageDir string) (blobContent, error) {
+	path, err := findImagePath(identifier, imageDir)
+	if err != nil {
+		return blobContent{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return blobContent{}, fmt.Errorf("failed to read image file: %w", err)
+	}
+	return imageContent(data, path)
+}
+
+// imageContent checks that data, the content of the file at path, holds an
+// image format the blob store recognizes, and names the image after the file.
+func imageContent(data []byte, path string) (blobContent, error) {
+	if mime := storage.DetectMIME(data); !strings.HasPrefix(mime, "image/") {
+		return blobContent{}, fmt.Errorf("file %s is not an image (%s)", path, mime)
+	}
+	if _, err := imaging.ReadMetadata(data); err != nil {
+		return blobContent{}, fmt.Errorf("file %s is not a readable image: %w", path, err)
+	}
+	return blobContent{data: data, name: imageName(path)}, nil
+}
+
+// imageName returns the name an image file is indexed under: its base name
+// without the extension.
+func imageName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// storeBlob puts content in the blob store and returns its reference, with
+// the format, dimensions, EXIF fields and perceptual hash of images
+// recorded. Named images are added to the store's image index.
+func storeBlob(store *storage.BlobStore, content blobContent) (storage.BlobRef, error) {
+	ref, err := store.Put(content.data)
+	if err != nil || !strings.HasPrefix(ref.MIME, "image/") {
+		return ref, err
+	}
+	if m, err := imaging.ReadMetadata(content.data); err == nil {
+		ref.Format, ref.Width, ref.Height = m.Format, m.Width, m.Height
+		if len(m.EXIF) > 0 {
+			exif, _ := json.Marshal(m.EXIF)
+			ref.EXIF = string(exif)
+		}
+	}
+	if h, err := imaging.HashImage(content.data); err == nil {
+		ref.PHash = imaging.FormatHash(h)
+	}
+	if err := store.IndexImage(content.name, ref); err != nil {
+		store.Release(ref)
+		return storage.BlobRef{}, err
+	}
+	return ref, nil
+}
+
+// findImagePath returns the file an IMAGE value names: a file of imageDir,
+// given with or without its extension, or else a path to a file. Names must
+// match exactly, and a name matching files with different extensions is
+// ambiguous.
+func findImagePath(identifier, imageDir string) (string, error) {
+	if identifier == "" {
+		return "", fmt.Errorf("empty image identifier")
+	}
+	isFile := func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Mode().IsRegular()
+	}
+	if imageDir != "" {
+		var found []string
+		if path := filepath.Join(imageDir, identifier); isFile(path) {
+			found = append(found, path)
+		} else {
+			for _, ext := range imageExtensions {
+				if path := filepath.Join(imageDir, identifier+ext); isFile(path) {
+					found = append(found, path)
+				}
+			}
+		}
+		if len(found) > 1 {
+			return "", fmt.Errorf("image identifier '%s' is ambiguous: %s", identifier, strings.Join(found, ", "))
+		}
+		if len(found) == 1 {
+			return found[0], nil
+		}
+	}
+	if isFile(identifier) {
+		return identifier, nil
+	}
+	return "", fmt.Errorf("image file not found for identifier: %s", identifier)
+}
+
+// putBlobs puts the blob contents among the values of row in the blob store
+// and replaces them by their references. It returns the references added,
+// for releaseBlobs to drop if the row is not written after all.
+func putBlobs(db *schema.Database, row storage.Row) ([]storage.BlobRef, error) {
+	var store *storage.BlobStore
+	var added []storage.BlobRef
+	for col, v := range row {
+		data, ok := v.(blobContent)
+		if !ok {
+			continue
+		}
+		if store == nil {
+			var err error
+			if store, err = storage.NewBlobStore(db.GetDBPath()); err != nil {
+				return nil, err
+			}
+		}
+		ref, err := storeBlob(store, data)
+		if err != nil {
+			releaseBlobs(db, added)
+			return nil, err
+		}
+		row[col] = ref
+		added = append(added, ref)
+	}
+	return added, nil
+}
+
+// acquireBlob returns the value to store in BLOB or IMAGE column of type t
+// for v, taking a reference in the blob store: a reference copied from
+// another value is retained, text is stored as content for BLOB and names
+// an image file for IMAGE.
+func acquireBlob(db *schema.Database, v interface{}, t schema.DataType, imageDir string) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	store, err := storage.NewBlobStore(db.GetDBPath())
+	if err != nil {
+		return nil, err
+	}
+	if ref, ok := storage.BlobRefOf(v); ok {
+		if err := store.Retain(ref); err != nil {
+			return nil, err
+		}
+		return ref, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot store '%v' in a %s column", v, t)
+	}
+	content := blobContent{data: []byte(s)}
+	if t == schema.Image {
+		if content, err = readImage(s, imageDir); err != nil {
+			return nil, err
+		}
+	}
+	return storeBlob(store, content)
+}
+
+// bindImageRefs resolves the image names used as references to the image
+// similarity functions in e through the database's image index.
+func bindImageRefs(db *schema.Database, e interface{}) error {
+	var store *storage.BlobStore
+	return expr.BindImageRefs(e, func(name string) (string, bool, error) {
+		if store == nil {
+			var err error
+			if store, err = storage.NewBlobStore(db.GetDBPath()); err != nil {
+				return "", false, err
+			}
+		}
+		return store.LookupImage(name)
+	})
+}
+
+// releaseBlobs drops one reference to each of refs.
+func releaseBlobs(db *schema.Database, refs []storage.BlobRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	store, err := storage.NewBlobStore(db.GetDBPath())
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := store.Release(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowBlobs returns the blob references held by the BLOB and IMAGE columns
+// of decoded rows.
+func rowBlobs(rows []storage.Row, columns []schema.Column) []storage.BlobRef {
+	var refs []storage.BlobRef
+	for _, r := range rows {
+		for _, c := range columns {
+			if !c.Type.IsBlob() {
+				continue
+			}
+			if ref, ok := r[c.Name].(storage.BlobRef); ok {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
+
+// DropTableBlobs releases the blob references held by the rows of a table
+// that is being dropped. It is called before the table's data file is
+// deleted.
+func DropTableBlobs(db *schema.Database, table schema.Table) error {
+	hasBlobs := false
+	for _, c := range table.Columns {
+		hasBlobs = hasBlobs || c.Type.IsBlob()
+	}
+	if !hasBlobs {
+		return nil
+	}
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+	if err != nil {
+		return fmt.Errorf("error accessing table file: %s", err)
+	}
+	rows, err := storage.ReadAll(tableFile)
+	if err != nil {
+		return fmt.Errorf("error reading table data: %s", err)
+	}
+	decodeRows(rows, table.Columns, expr.SessionTimeZone(db))
+	return releaseBlobs(db, rowBlobs(rows, table.Columns))
+}
@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// writePNG writes a w x h PNG image filled with c.
+func writePNG(t *testing.T, path string, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create %s: %v", path, err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatalf("encode %s: %v", path, err)
+	}
+	data, _ := os.ReadFile(path)
+	return data
+}
+
+func TestBlobAndImageColumns(t *testing.T) {
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	cols, _ := schema.ParseColumnDefs("id INT, img IMAGE, data BLOB")
+	if err := db.AddTable(schema.Table{Name: "photos", Columns: cols}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	imageDir := t.TempDir()
+	red := writePNG(t, filepath.Join(imageDir, "photo001.png"), 4, 3, color.RGBA{R: 255, A: 255})
+	writePNG(t, filepath.Join(imageDir, "photo0010.png"), 2, 2, color.RGBA{B: 255, A: 255})
+	writePNG(t, filepath.Join(imageDir, "copy of 001.png"), 4, 3, color.RGBA{R: 255, A: 255})
+	os.WriteFile(filepath.Join(imageDir, "notes.txt"), []byte("not an image"), 0644)
+
+	insert := func(sql string) error {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		_, err = HandleInsertWithImages(cmd, db, imageDir)
+		return err
+	}
+	for _, sql := range []string{
+		"INSERT INTO photos (id, img) VALUES (1, 'photo001');",
+		"INSERT INTO photos (id, img) VALUES (2, 'photo0010.png');",
+		"INSERT INTO photos (id, img, data) VALUES (3, 'copy of 001', X'00ff10');",
+		"INSERT INTO photos (id, data) VALUES (4, 'hello');",
+	} {
+		if err := insert(sql); err != nil {
+			t.Fatalf("%q failed: %v", sql, err)
+		}
+	}
+	// names match exactly: photo00 is not a prefix match for photo001
+	for _, sql := range []string{
+		"INSERT INTO photos (id, img) VALUES (5, 'photo00');",
+		"INSERT INTO photos (id, img) VALUES (5, 'notes.txt');",
+		"INSERT INTO photos (id, data) VALUES (5, X'0g');",
+	} {
+		if err := insert(sql); err == nil {
+			t.Fatalf("expected an error for %q", sql)
+		}
+	}
+
+	// the images live in the database now
+	os.RemoveAll(imageDir)
+	out := runSelect(t, db, "SELECT id, BLOB_MIME(img) AS mime, BLOB_SIZE(data) AS size FROM photos ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, "|") != "1 image/png NULL|2 image/png NULL|3 image/png 3|4 NULL 5" {
+		t.Fatalf("blob metadata: got %q", got)
+	}
+	out = runSelect(t, db, "SELECT COUNT(*) AS n FROM photos GROUP BY img ORDER BY n DESC;")
+	if got := dataLines(out); strings.Join(got, ",") != "2,1,1" {
+		t.Fatalf("equal images should group together: got %q", got)
+	}
+
+	store, _ := storage.NewBlobStore(db.GetDBPath())
+	ref, ok := photoBlob(t, db, 1, "img")
+	if !ok {
+		t.Fatalf("row 1 should hold a blob reference")
+	}
+	if data, err := store.Read(ref); err != nil || string(data) != string(red) {
+		t.Fatalf("stored content differs from the file: %v", err)
+	}
+	refs := func() int {
+		n, err := store.Refs(ref.SHA256)
+		if err != nil {
+			t.Fatalf("refs: %v", err)
+		}
+		return n
+	}
+	if refs() != 2 {
+		t.Fatalf("identical images should be stored once with 2 references, got %d", refs())
+	}
+
+	runHandler(t, "DELETE FROM photos WHERE id = 1;", HandleDelete, db)
+	if refs() != 1 {
+		t.Fatalf("refs after deleting one row = %d, want 1", refs())
+	}
+	runHandler(t, "UPDATE photos SET data = img WHERE id = 3;", HandleUpdate, db)
+	runHandler(t, "UPDATE photos SET img = NULL WHERE id = 3;", HandleUpdate, db)
+	if refs() != 1 {
+		t.Fatalf("refs after moving the image to another column = %d, want 1", refs())
+	}
+	runHandler(t, "DELETE FROM photos WHERE id = 3;", HandleDelete, db)
+	if _, err := os.Stat(store.Path(ref.SHA256)); !os.IsNotExist(err) {
+		t.Fatalf("a blob no row uses should be removed, stat err = %v", err)
+	}
+
+	table, _ := db.GetTable("photos")
+	if err := DropTableBlobs(db, table); err != nil {
+		t.Fatalf("DropTableBlobs: %v", err)
+	}
+	left, _ := filepath.Glob(filepath.Join(db.GetDBPath(), "blobs", "*", "*"))
+	if len(left) != 0 {
+		t.Fatalf("blobs left after dropping the table: %v", left)
+	}
+}
+
+func TestMigrateImages(t *testing.T) {
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	cols, _ := schema.ParseColumnDefs("id INT, img IMAGE")
+	if err := db.AddTable(schema.Table{Name: "photos", Columns: cols}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "old.png")
+	writePNG(t, path, 1, 1, color.White)
+	tf, _ := storage.NewTableFile(db.GetDBPath(), "photos")
+	tf.AppendRow(storage.Row{"id": 1, "img": path})
+	tf.AppendRow(storage.Row{"id": 2, "img": "/gone/photo.png"})
+
+	out := runHandler(t, "MIGRATE IMAGES photos;", HandleMigrate, db)
+	if got := dataLines(out); strings.Join(got, ",") != "photos 1 1" {
+		t.Fatalf("MIGRATE IMAGES: got %q", got)
+	}
+	if _, ok := photoBlob(t, db, 1, "img"); !ok {
+		t.Fatalf("the path should be replaced by a blob reference")
+	}
+}
+
+// photoBlob returns the blob reference held by column col of the photos
+// row with the given id.
+func photoBlob(t *testing.T, db *schema.Database, id int64, col string) (storage.BlobRef, bool) {
+	t.Helper()
+	table, _ := db.GetTable("photos")
+	tf, _ := storage.NewTableFile(db.GetDBPath(), "photos")
+	rows, err := tf.ReadAllRows()
+	if err != nil {
+		t.Fatalf("read rows: %v", err)
+	}
+	decodeRows(rows, table.Columns, time.UTC)
+	for _, r := range rows {
+		if r["id"] == id {
+			ref, ok := r[col].(storage.BlobRef)
+			return ref, ok
+		}
+	}
+	return storage.BlobRef{}, false
+}
+
+func TestImageMetadata(t *testing.T) {
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	cols, _ := schema.ParseColumnDefs("id INT, img IMAGE, raw BLOB")
+	if err := db.AddTable(schema.Table{Name: "photos", Columns: cols}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	imageDir := t.TempDir()
+	writePNG(t, filepath.Join(imageDir, "wide.png"), 2400, 10, color.Black)
+	writePNG(t, filepath.Join(imageDir, "small.png"), 16, 9, color.White)
+	for _, sql := range []string{
+		"INSERT INTO photos (id, img) VALUES (1, 'wide');",
+		"INSERT INTO photos (id, img, raw) VALUES (2, 'small', 'text');",
+	} {
+		cmd, _ := parser.Parse(sql)
+		if _, err := HandleInsertWithImages(cmd, db, imageDir); err != nil {
+			t.Fatalf("%q failed: %v", sql, err)
+		}
+	}
+
+	out := runSelect(t, db, "SELECT id, IMAGE_HEIGHT(img) AS h, IMAGE_FORMAT(img) AS f FROM photos WHERE IMAGE_WIDTH(img) > 1920;")
+	if got := dataLines(out); strings.Join(got, "|") != "1 10 png" {
+		t.Fatalf("IMAGE_WIDTH filter: got %q", got)
+	}
+	out = runSelect(t, db, "SELECT img.width AS w, img.height * 2 AS h2, raw.width AS rw, IMAGE_EXIF(img, 'Make') AS make FROM photos WHERE id = 2;")
+	if got := dataLines(out); strings.Join(got, "|") != "16 18 NULL NULL" {
+		t.Fatalf("virtual columns: got %q", got)
+	}
+	for _, sql := range []string{
+		"SELECT img.depth FROM photos;",
+		"SELECT IMAGE_WIDTH(id) FROM photos;",
+	} {
+		cmd, _ := parser.Parse(sql)
+		if _, err := HandleSelect(cmd, db); err == nil {
+			t.Fatalf("expected an error for %q", sql)
+		}
+	}
+}
+
+// writeGradient writes a w x h image that darkens from left to right, or
+// from right to left when mirrored, as PNG or, for a .jpg path, as JPEG.
+func writeGradient(t *testing.T, path string, w, h int, mirrored bool) {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx := x
+			if mirrored {
+				sx = w - 1 - x
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(255 - sx*255/w + y*40/h)})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create %s: %v", path, err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(path, ".jpg") {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 60})
+	} else {
+		err = png.Encode(f, img)
+	}
+	if err != nil {
+		t.Fatalf("encode %s: %v", path, err)
+	}
+}
+
+func TestImageSimilarity(t *testing.T) {
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	cols, _ := schema.ParseColumnDefs("id INT, img IMAGE")
+	if err := db.AddTable(schema.Table{Name: "photos", Columns: cols}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	imageDir := t.TempDir()
+	writeGradient(t, filepath.Join(imageDir, "photo001.png"), 320, 200, false)
+	writeGradient(t, filepath.Join(imageDir, "photo001_small.jpg"), 80, 50, false)
+	writeGradient(t, filepath.Join(imageDir, "photo002.png"), 320, 200, true)
+	for i, name := range []string{"photo001", "photo001_small", "photo002"} {
+		cmd, _ := parser.Parse(fmt.Sprintf("INSERT INTO photos (id, img) VALUES (%d, '%s');", i+1, name))
+		if _, err := HandleInsertWithImages(cmd, db, imageDir); err != nil {
+			t.Fatalf("insert %s: %v", name, err)
+		}
+	}
+
+	out := runSelect(t, db, "SELECT id FROM photos WHERE IMAGE_SIMILAR(img, 'photo001', 6) ORDER BY IMAGE_DISTANCE(img, 'photo001') DESC;")
+	if got := dataLines(out); strings.Join(got, ",") != "2,1" {
+		t.Fatalf("IMAGE_SIMILAR: got %q", got)
+	}
+	out = runSelect(t, db, "SELECT id, IMAGE_DISTANCE(img, 'photo002') > 32 AS far FROM photos ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, "|") != "1 true|2 true|3 false" {
+		t.Fatalf("IMAGE_DISTANCE: got %q", got)
+	}
+	// a hash given directly works like a name
+	ref, _ := photoBlob(t, db, 3, "img")
+	out = runSelect(t, db, "SELECT id FROM photos WHERE IMAGE_DISTANCE(img, '"+ref.PHash+"') = 0;")
+	if got := dataLines(out); strings.Join(got, ",") != "3" {
+		t.Fatalf("IMAGE_DISTANCE by hash: got %q", got)
+	}
+
+	// deleting the last row holding an image drops it from the index
+	runHandler(t, "DELETE FROM photos WHERE IMAGE_SIMILAR(img, 'photo002', 0);", HandleDelete, db)
+	cmd, _ := parser.Parse("SELECT id FROM photos WHERE IMAGE_SIMILAR(img, 'photo002');")
+	if _, err := HandleSelect(cmd, db); err == nil || !strings.Contains(err.Error(), "unknown image 'photo002'") {
+		t.Fatalf("expected an unknown image error, got %v", err)
+	}
+}
+
+func TestUploadedBlobs(t *testing.T) {
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	cols, _ := schema.ParseColumnDefs("id INT, img IMAGE, raw BLOB")
+	if err := db.AddTable(schema.Table{Name: "photos", Columns: cols}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	dir := t.TempDir()
+	red := writePNG(t, filepath.Join(dir, "red.png"), 300, 200, color.RGBA{R: 255, A: 255})
+	blue := writePNG(t, filepath.Join(dir, "blue.png"), 8, 8, color.RGBA{B: 255, A: 255})
+
+	for i := 1; i <= 2; i++ {
+		rowid, err := InsertUploadedRow(db, "photos", map[string]string{"id": fmt.Sprint(i)},
+			map[string]BlobUpload{"img": {Filename: "red.png", Data: red}})
+		if err != nil || rowid != i-1 {
+			t.Fatalf("insert %d: rowid %d, %v", i, rowid, err)
+		}
+	}
+	ref, err := RowBlob(db, "photos", 1, "img")
+	if err != nil || ref.Width != 300 || ref.MIME != "image/png" {
+		t.Fatalf("RowBlob: %+v, %v", ref, err)
+	}
+	out := runSelect(t, db, "SELECT id FROM photos WHERE IMAGE_SIMILAR(img, 'red', 0);")
+	if got := dataLines(out); strings.Join(got, ",") != "1,2" {
+		t.Fatalf("uploaded images should be indexed by file name: got %q", got)
+	}
+
+	thumb, err := ImageThumbnail(db, ref, 30)
+	if err != nil {
+		t.Fatalf("thumbnail: %v", err)
+	}
+	if cfg, _, err := image.DecodeConfig(strings.NewReader(string(thumb))); err != nil || cfg.Width != 30 || cfg.Height != 20 {
+		t.Fatalf("thumbnail: %+v, %v", cfg, err)
+	}
+	store, _ := storage.NewBlobStore(db.GetDBPath())
+	if cached, ok, _ := store.ReadThumbnail(ref, 30); !ok || string(cached) != string(thumb) {
+		t.Fatalf("the thumbnail should be cached")
+	}
+
+	// replacing one row's image keeps the content the other row uses
+	if err := UpdateRowBlob(db, "photos", 0, "img", BlobUpload{Filename: "blue.png", Data: blue}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got, _ := RowBlob(db, "photos", 0, "img"); got.Width != 8 {
+		t.Fatalf("row 0 should hold the new image, got %+v", got)
+	}
+	if n, _ := store.Refs(ref.SHA256); n != 1 {
+		t.Fatalf("the replaced image should have 1 reference left, got %d", n)
+	}
+	if err := UpdateRowBlob(db, "photos", 1, "img", BlobUpload{Filename: "blue.png", Data: blue}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, ok, _ := store.ReadThumbnail(ref, 30); ok {
+		t.Fatalf("thumbnails should be removed with their image")
+	}
+
+	if rowid, err := InsertUploadedRow(db, "photos", map[string]string{"id": "3"},
+		map[string]BlobUpload{"raw": {Filename: "notes.txt", Data: []byte("hello")}}); err != nil || rowid != 2 {
+		t.Fatalf("insert BLOB: rowid %d, %v", rowid, err)
+	}
+	for name, err := range map[string]error{
+		"no such row":    UpdateRowBlob(db, "photos", 7, "img", BlobUpload{Data: blue}),
+		"not a blob":     UpdateRowBlob(db, "photos", 0, "id", BlobUpload{Data: blue}),
+		"not an image":   UpdateRowBlob(db, "photos", 0, "img", BlobUpload{Filename: "a.png", Data: []byte("text")}),
+		"NULL image":     errOf(RowBlob(db, "photos", 2, "img")),
+		"unknown table":  errOf(RowBlob(db, "albums", 0, "img")),
+		"bad value":      errOf(InsertUploadedRow(db, "photos", map[string]string{"id": "x"}, nil)),
+		"text for blob":  errOf(InsertUploadedRow(db, "photos", map[string]string{"raw": "x"}, nil)),
+		"unknown column": errOf(InsertUploadedRow(db, "photos", nil, map[string]BlobUpload{"pic": {Data: blue}})),
+	} {
+		if err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestInsertUploadedRow_Rowids checks that the rowid of an uploaded row is
+// the line it was inserted at, with inserts running side by side and after
+// a DELETE moved the rows up.
+func TestInsertUploadedRow_Rowids(t *testing.T) {
+	for _, format := range []string{"", schema.FormatColumnar, schema.FormatMemory} {
+		db, err := schema.NewDatabase(t.TempDir())
+		if err != nil {
+			t.Fatalf("new db: %v", err)
+		}
+		cols, _ := schema.ParseColumnDefs("id INT, raw BLOB")
+		if err := db.AddTable(schema.Table{Name: "files", Columns: cols, Format: format}); err != nil {
+			t.Fatalf("add table: %v", err)
+		}
+		insert := func(id int) int {
+			rowid, err := InsertUploadedRow(db, "files", map[string]string{"id": fmt.Sprint(id)},
+				map[string]BlobUpload{"raw": {Filename: "a.txt", Data: []byte(fmt.Sprint(id))}})
+			if err != nil {
+				t.Errorf("%s: insert %d: %v", format, id, err)
+			}
+			return rowid
+		}
+		checkRowid := func(id, rowid int) {
+			table, _ := db.GetTable("files")
+			tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+			if err != nil {
+				t.Fatalf("open table: %v", err)
+			}
+			rows, err := tableFile.ReadRowsAt([]int{rowid})
+			if err != nil || len(rows) != 1 || fmt.Sprint(rows[0]["id"]) != fmt.Sprint(id) {
+				t.Errorf("%s: row %d has rowid %d, where the row is %v (%v)", format, id, rowid, rows, err)
+			}
+		}
+
+		rowids := make([]int, 20)
+		var wg sync.WaitGroup
+		for i := range rowids {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				rowids[i] = insert(i)
+			}(i)
+		}
+		wg.Wait()
+		for i, rowid := range rowids {
+			checkRowid(i, rowid)
+		}
+
+		runHandler(t, "DELETE FROM files WHERE id < 5;", HandleDelete, db)
+		if rowid := insert(100); rowid != 15 {
+			t.Errorf("%s: rowid %d after DELETE, want 15", format, rowid)
+		}
+		checkRowid(100, 15)
+	}
+}
+
+// errOf returns the error of a call that also returns a value.
+func errOf(_ interface{}, err error) error { return err }
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"fmt"
+
+	"Custom_DB/pkg/storage"
+)
+
+// HandleShowStats lists the counters of the buffer pool table files are
+// read through, the one all sessions share.
+func HandleShowStats() (string, error) {
+	st := storage.SharedBuffers.Stats()
+	rows := [][]interface{}{
+		{"buffer_pool_capacity", st.Capacity},
+		{"buffer_pool_pages", st.Pages},
+		{"buffer_pool_pinned", st.Pinned},
+		{"buffer_pool_dirty", st.Dirty},
+		{"buffer_pool_hits", st.Hits},
+		{"buffer_pool_misses", st.Misses},
+		{"buffer_pool_hit_ratio", fmt.Sprintf("%.3f", st.HitRatio())},
+		{"buffer_pool_evictions", st.Evictions},
+		{"buffer_pool_writes", st.Writes},
+	}
+	return formatResult([]string{"statistic", "value"}, rows), nil
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// setTestBufferPool gives the test a buffer pool of its own of the given
+// number of pages.
+func setTestBufferPool(t *testing.T, pages int) {
+	t.Helper()
+	old := storage.SharedBuffers
+	storage.SharedBuffers = storage.NewBufferPool(pages)
+	t.Cleanup(func() { storage.SharedBuffers = old })
+}
+
+func TestBufferPool_RepeatedScansHit(t *testing.T) {
+	db := newBigOrdersDB(t)
+	setTestBufferPool(t, 1024)
+	setTestParallelism(t, 1, morselSize)
+
+	runSelect(t, db, "SELECT COUNT(*) FROM orders;")
+	first := storage.SharedBuffers.Stats()
+	if first.Misses == 0 || first.Pages == 0 || first.Pinned != 0 {
+		t.Fatalf("first scan: %+v", first)
+	}
+	runSelect(t, db, "SELECT SUM(price) FROM orders WHERE qty > 3;")
+	second := storage.SharedBuffers.Stats()
+	if second.Misses != first.Misses || second.Hits <= first.Hits {
+		t.Errorf("second scan read pages again: %+v after %+v", second, first)
+	}
+
+	out, err := HandleShowStats()
+	if err != nil {
+		t.Fatalf("show stats: %v", err)
+	}
+	ratio := fmt.Sprintf("buffer_pool_hit_ratio %.3f", second.HitRatio())
+	if got := dataLines(out); !contains(got, ratio) || !contains(got, "buffer_pool_capacity 1024") {
+		t.Errorf("unexpected stats %q", got)
+	}
+}
+
+// TestBufferPool_SmallPool checks that a pool too small for a table still
+// gives the rows on disk, evicting and writing back pages as it goes.
+func TestBufferPool_SmallPool(t *testing.T) {
+	db := newBigOrdersDB(t)
+	copyOrders(t, db, "morders", schema.FormatMemory)
+	setTestBufferPool(t, 8)
+	setTestParallelism(t, 4, 16<<10)
+
+	long := strings.Repeat("x", 3*storage.PageSize)
+	for _, sql := range []string{
+		"INSERT INTO orders (id, item, price, qty) VALUES (3001, 'late', 2.5, NULL);",
+		fmt.Sprintf("INSERT INTO orders (id, item, price, qty) VALUES (3002, '%s', 1, 1);", long),
+		"UPDATE orders SET qty = qty + 1 WHERE item > 'item-300';",
+		"INSERT INTO orders (id, item, price, qty) VALUES (3003, 'later', 3, 3);",
+		"DELETE FROM orders WHERE id BETWEEN 700 AND 1800;",
+	} {
+		handle := map[string]func(string){
+			"INSERT": func(s string) { runHandler(t, s, HandleInsert, db) },
+			"UPDATE": func(s string) { runHandler(t, s, HandleUpdate, db) },
+			"DELETE": func(s string) { runHandler(t, s, HandleDelete, db) },
+		}[strings.Fields(sql)[0]]
+		handle(sql)
+		handle(strings.ReplaceAll(sql, "orders", "morders"))
+	}
+	for _, q := range []string{
+		"SELECT * FROM orders;",
+		"SELECT qty, COUNT(*), SUM(price) FROM orders GROUP BY qty ORDER BY qty;",
+		"SELECT id, LENGTH(item) FROM orders WHERE id > 3000 ORDER BY id;",
+	} {
+		sameOnCopy(t, db, "morders", q)
+	}
+	st := storage.SharedBuffers.Stats()
+	if st.Evictions == 0 || st.Writes == 0 || st.Dirty != 0 || st.Pinned != 0 {
+		t.Errorf("unexpected pool stats %+v", st)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"time"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// decodeRows converts stored values in place to the representation their
+// column types use in expressions, such as time values for TIMESTAMP
+// columns. TIMESTAMPTZ values are read in loc, the session time zone.
+func decodeRows(rows []storage.Row, columns []schema.Column, loc *time.Location) {
+	for _, r := range rows {
+		for _, c := range columns {
+			if v, ok := r[c.Name]; ok {
+				r[c.Name] = expr.DecodeValue(v, c.Type, loc)
+			}
+		}
+	}
+}
+
+// columnKinds returns the kinds of the values of columns by name, for
+// expr.CheckArgKinds and expr.InferKind.
+func columnKinds(columns []schema.Column) func(name string) expr.Kind {
+	return func(name string) expr.Kind {
+		if c, ok := getColumnDefinition(columns, name); ok {
+			return expr.KindOfType(c.Type)
+		}
+		return expr.KindAny
+	}
+}
+
+// bindExpr binds e to the session of db: its image names to the blobs they
+// name and its casts to the session time zone.
+func bindExpr(db *schema.Database, e interface{}) error {
+	expr.BindTimeZone(e, expr.SessionTimeZone(db))
+	return bindImageRefs(db, e)
+}
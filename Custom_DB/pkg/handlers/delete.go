@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"Custom_DB/pkg/expr"
 	"Custom_DB/pkg/parser"
 	"Custom_DB/pkg/schema"
 	"Custom_DB/pkg/storage"
@@ -22,71 +23,50 @@ func HandleDelete(cmd parser.Command, db *schema.Database) (string, error) {
 		return "", fmt.Errorf("table '%s' does not exist", tableName)
 	}
 
-	// Parse DELETE FROM ... WHERE ...
-	fullCommand := strings.Join(tokens, " ")
-	whereIndex := strings.Index(strings.ToUpper(fullCommand), " WHERE ")
-
-	var wherePart string
-	if whereIndex != -1 {
-		wherePart = strings.TrimSpace(fullCommand[whereIndex+7:])
-	} else {
+	whereIdx := parser.IndexOfTopLevelKeyword(cmd, "WHERE")
+	if whereIdx == -1 {
 		return "", fmt.Errorf("DELETE without WHERE clause is not allowed for safety. Use WHERE clause to specify which records to delete")
 	}
-
-	// Load table data
-	tableFile, err := storage.NewTableFile(db.GetDBPath(), tableName)
+	whereExpr, err := expr.ParseExpression(joinExprTokens(tokens[whereIdx+1:]))
 	if err != nil {
-		return "", fmt.Errorf("error accessing table file: %s", err)
+		return "", fmt.Errorf("invalid WHERE expression: %w", err)
+	}
+	for _, c := range expr.CollectColumns(whereExpr) {
+		if _, ok := getColumnDefinition(table.Columns, c); !ok {
+			return "", fmt.Errorf("WHERE references unknown column '%s'", c)
+		}
+	}
+	if err := expr.CheckArgKinds(whereExpr, columnKinds(table.Columns)); err != nil {
+		return "", err
+	}
+	if err := bindExpr(db, whereExpr); err != nil {
+		return "", err
 	}
 
-	rows, err := tableFile.ReadAllRows()
+	// Load table data
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
 	if err != nil {
-		return "", fmt.Errorf("error reading table data: %s", err)
+		return "", fmt.Errorf("error accessing table file: %s", err)
 	}
 
-	// Filter out rows that match the WHERE clause
-	var remainingRows []storage.Row
-	deletedCount := 0
-
-	for _, row := range rows {
-		shouldDelete := evaluateWhereClauseDelete(wherePart, row, table.Columns)
-
-		if shouldDelete {
-			deletedCount++
-		} else {
-			remainingRows = append(remainingRows, row)
+	// Delete the rows that match the WHERE clause; rows where it is NULL stay
+	loc := expr.SessionTimeZone(db)
+	deletedRows, err := tableFile.Delete(func(row storage.Row) (bool, error) {
+		decodeRows([]storage.Row{row}, table.Columns, loc)
+		shouldDelete, err := whereExpr.Eval(row)
+		if err != nil {
+			return false, fmt.Errorf("error evaluating WHERE: %w", err)
 		}
+		return shouldDelete, nil
+	})
+	if err != nil {
+		return "", err
 	}
-
-	// Save remaining data
-	if err := tableFile.RewriteFile(remainingRows); err != nil {
-		return "", fmt.Errorf("error saving data after deletion: %s", err)
+	deletedCount := len(deletedRows)
+	// drop the deleted rows' references to blobs no longer used
+	if err := releaseBlobs(db, rowBlobs(deletedRows, table.Columns)); err != nil {
+		return "", fmt.Errorf("error releasing deleted blobs: %s", err)
 	}
 
 	return fmt.Sprintf("✅ %d row(s) deleted from table '%s'", deletedCount, tableName), nil
 }
-
-// Simple WHERE clause evaluation for DELETE
-func evaluateWhereClauseDelete(whereClause string, row storage.Row, columns []schema.Column) bool {
-	// Simple pattern: column = 'value'
-	if strings.Contains(whereClause, "=") {
-		parts := strings.Split(whereClause, "=")
-		if len(parts) == 2 {
-			col := strings.TrimSpace(parts[0])
-			val := strings.TrimSpace(parts[1])
-			val = strings.Trim(val, "'\"") // Remove quotes
-
-			// Check if row has this column and value matches
-			for _, column := range columns {
-				if strings.EqualFold(column.Name, col) {
-					if rowVal, exists := row[col]; exists {
-						rowValStr := fmt.Sprintf("%v", rowVal)
-						return strings.EqualFold(rowValStr, val)
-					}
-					break
-				}
-			}
-		}
-	}
-	return false
-}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func TestEngine_MemorySameResults(t *testing.T) {
+	db := newBigOrdersDB(t)
+	copyOrders(t, db, "morders", schema.FormatMemory)
+	for _, sql := range []string{
+		"",
+		"INSERT INTO orders (id, item, price, qty) VALUES (3001, 'late', 2.5, NULL);",
+		"UPDATE orders SET qty = qty + 1 WHERE item > 'item-300';",
+		"DELETE FROM orders WHERE id BETWEEN 700 AND 1800;",
+	} {
+		if sql != "" {
+			handle := map[string]func(string){
+				"INSERT": func(s string) { runHandler(t, s, HandleInsert, db) },
+				"UPDATE": func(s string) { runHandler(t, s, HandleUpdate, db) },
+				"DELETE": func(s string) { runHandler(t, s, HandleDelete, db) },
+			}[strings.Fields(sql)[0]]
+			handle(sql)
+			handle(strings.ReplaceAll(sql, "orders", "morders"))
+		}
+		for _, q := range []string{
+			"SELECT * FROM orders;",
+			"SELECT id, item FROM orders WHERE id < 600 OR id >= 2990;",
+			"SELECT qty, COUNT(*), SUM(price) FROM orders GROUP BY qty ORDER BY qty;",
+		} {
+			sameOnCopy(t, db, "morders", q)
+		}
+	}
+
+	runHandler(t, "CREATE INDEX idx_item ON orders (item);", HandleCreateIndex, db)
+	runHandler(t, "CREATE INDEX idx_mitem ON morders (item);", HandleCreateIndex, db)
+	out := runHandler(t, "EXPLAIN SELECT id FROM morders WHERE item = 'item-042';", HandleExplain, db)
+	if !strings.Contains(out, "Index Scan on morders using idx_mitem") {
+		t.Errorf("expected an index scan:\n%s", out)
+	}
+	sameOnCopy(t, db, "morders", "SELECT * FROM orders WHERE item = 'item-042';")
+
+	if _, err := os.Stat(filepath.Join(db.GetDBPath(), "morders.dat")); !os.IsNotExist(err) {
+		t.Errorf("memory table written to disk: %v", err)
+	}
+}
+
+// TestEngine_TruncateAndStats checks that every engine counts the rows it
+// holds and removes them all on Truncate.
+func TestEngine_TruncateAndStats(t *testing.T) {
+	db := newBigOrdersDB(t)
+	copyOrders(t, db, "corders", schema.FormatColumnar)
+	copyOrders(t, db, "morders", schema.FormatMemory)
+	for _, name := range []string{"orders", "corders", "morders"} {
+		table, _ := db.GetTable(name)
+		tf, err := storage.OpenTable(db.GetDBPath(), table)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		st, err := tf.Stats()
+		if err != nil || st.Rows != 3000 || st.Bytes == 0 {
+			t.Errorf("%s: stats %+v, %v", name, st, err)
+		}
+		if err := tf.Truncate(); err != nil {
+			t.Fatalf("truncate %s: %v", name, err)
+		}
+		if st, err := tf.Stats(); err != nil || st.Rows != 0 {
+			t.Errorf("%s: stats after truncate %+v, %v", name, st, err)
+		}
+		out := runHandler(t, "SELECT COUNT(*) FROM "+name+";", HandleSelect, db)
+		if got := dataLines(out); len(got) != 1 || got[0] != "0" {
+			t.Errorf("%s: count after truncate %q", name, got)
+		}
+	}
+
+	if _, err := storage.OpenTable(db.GetDBPath(), schema.Table{Name: "x", Format: "paged"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
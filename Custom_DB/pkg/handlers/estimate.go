@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"math"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/stats"
+)
+
+// Row counts assumed where statistics say nothing: the rows a table
+// function yields per input row and the distinct values of a computed
+// GROUP BY item.
+const (
+	defaultCallRows = 10
+	defaultDistinct = 200
+)
+
+// columnStats returns a lookup of the statistics of ts's columns, for
+// expr.Selectivity; ts may be nil.
+func columnStats(ts *stats.TableStats) func(name string) *stats.ColumnStats {
+	return func(name string) *stats.ColumnStats {
+		if ts == nil {
+			return nil
+		}
+		return ts.Columns[name]
+	}
+}
+
+// tableStatsOf returns the statistics of the table a plan reads, or nil
+// when it has not been analyzed.
+func tableStatsOf(n planNode) *stats.TableStats {
+	switch t := n.(type) {
+	case *scanNode:
+		return t.tableStats
+	case *indexScanNode:
+		return t.tableStats
+	}
+	for _, in := range n.inputs() {
+		if ts := tableStatsOf(in); ts != nil {
+			return ts
+		}
+	}
+	return nil
+}
+
+// estimateRows estimates the rows each node of a plan yields from the
+// statistics of its table. It returns nil when the table has not been
+// analyzed.
+func estimateRows(root planNode) map[planNode]float64 {
+	ts := tableStatsOf(root)
+	if ts == nil {
+		return nil
+	}
+	col := columnStats(ts)
+	est := map[planNode]float64{}
+	var walk func(n planNode) float64
+	walk = func(n planNode) float64 {
+		in := 0.0
+		for _, i := range n.inputs() {
+			in = walk(i)
+		}
+		rows := in
+		switch t := n.(type) {
+		case *scanNode:
+			rows = float64(ts.Rows)
+		case *indexScanNode:
+			rows = float64(len(t.lines))
+		case *emptyNode:
+			rows = 0
+		case *oneRowNode:
+			rows = 1
+		case *joinNode:
+			rows = in * defaultCallRows
+		case *filterNode:
+			rows = in * expr.Selectivity(t.cond, col)
+		case *aggregateNode:
+			rows = groupCount(t.agg.gb, in, col)
+		case *sortNode:
+			if t.limit >= 0 {
+				rows = math.Min(in, float64(t.limit))
+			}
+		case *limitNode:
+			rows = math.Max(in-float64(t.offset), 0)
+			if t.limit >= 0 {
+				rows = math.Min(rows, float64(t.limit))
+			}
+		}
+		est[n] = rows
+		return rows
+	}
+	walk(root)
+	return est
+}
+
+// groupCount estimates the groups of a GROUP BY over in rows: per grouping
+// set, the product of its items' distinct counts, at most in.
+func groupCount(gb *groupBy, in float64, col func(string) *stats.ColumnStats) float64 {
+	total := 0.0
+	for _, set := range gb.sets {
+		groups := 1.0
+		for _, idx := range set {
+			it := gb.items[idx]
+			d := float64(defaultDistinct)
+			if cs := col(it.col); it.col != "" && cs != nil {
+				d = float64(cs.Distinct)
+				if cs.NullFrac > 0 {
+					d++
+				}
+			}
+			groups *= d
+		}
+		if len(set) > 0 {
+			groups = math.Min(groups, in)
+		}
+		total += groups
+	}
+	return total
+}
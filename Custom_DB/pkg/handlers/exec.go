@@ -0,0 +1,788 @@
+package handlers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// A SELECT runs as a tree of operators, each pulling rows from its inputs
+// one at a time: a scan reads the table file row by row, and joins,
+// projections and limits pass rows on as they arrive. Filters take rows a
+// batch at a time, and sorts and aggregations all of them, so a query such
+// as SELECT * FROM t WHERE x > 1 LIMIT 10 stops reading the table after the
+// batch holding its tenth match.
+
+// rowIter is an operator of a query's execution tree.
+type rowIter interface {
+	// Next returns the next row, or nil once the operator has no more.
+	Next() (storage.Row, error)
+	// Close releases the operator and its inputs.
+	Close() error
+}
+
+// scanIter reads the rows of a table file, decoded for its columns. With
+// keep set it drops the values of other columns.
+type scanIter struct {
+	rows    storage.RowReader
+	columns []schema.Column
+	keep    map[string]bool
+	loc     *time.Location
+}
+
+func (s *scanIter) Next() (storage.Row, error) {
+	r, _, err := s.rows.Next()
+	if r == nil || err != nil {
+		return nil, err
+	}
+	if s.keep != nil {
+		pruneRow(r, s.keep)
+	}
+	decodeRows([]storage.Row{r}, s.columns, s.loc)
+	return r, nil
+}
+
+func (s *scanIter) Close() error { return s.rows.Close() }
+
+// skipper is a scan that may skip parts of a table by their zone maps;
+// skipped describes what it read and skipped, for EXPLAIN ANALYZE, or is
+// empty.
+type skipper interface {
+	skipped() string
+}
+
+func (s *scanIter) skipped() string {
+	cr, ok := s.rows.(*storage.ColumnReader)
+	if !ok {
+		return ""
+	}
+	read, skipped := cr.Segments()
+	return fmt.Sprintf("segments: %d read, %d skipped", read, skipped)
+}
+
+// sliceIter yields rows already in memory.
+type sliceIter struct {
+	rows []storage.Row
+	pos  int
+}
+
+func (s *sliceIter) Next() (storage.Row, error) {
+	if s.pos >= len(s.rows) {
+		return nil, nil
+	}
+	s.pos++
+	return s.rows[s.pos-1], nil
+}
+
+func (s *sliceIter) Close() error { return nil }
+
+// filterIter passes on the rows of its input that satisfy cond; clause
+// names the clause cond comes from in errors. It reads its input in
+// batches of expr.BatchSize rows and evaluates cond on each batch at once
+// (see expr.CompileFilter). When that fails it evaluates the batch again
+// row by row, so the error is raised on the row that causes it only once
+// the rows before it have been passed on; an error reading the input
+// likewise comes after the rows read before it.
+type filterIter struct {
+	in     rowIter
+	cond   expr.Expr
+	filter *expr.Filter
+	clause string
+	out    []storage.Row // rows of the batch that satisfy cond, to pass on
+	rows   []storage.Row // rows of the batch to evaluate row by row
+	err    error         // the error the input ended with
+	done   bool
+}
+
+func (f *filterIter) Next() (storage.Row, error) {
+	for {
+		if len(f.out) > 0 {
+			r := f.out[0]
+			f.out = f.out[1:]
+			return r, nil
+		}
+		for len(f.rows) > 0 {
+			r := f.rows[0]
+			f.rows = f.rows[1:]
+			ok, err := f.cond.Eval(r)
+			if err != nil {
+				f.rows, f.done = nil, true
+				return nil, fmt.Errorf("error evaluating %s: %w", f.clause, err)
+			}
+			if ok {
+				return r, nil
+			}
+		}
+		if f.err != nil {
+			err := f.err
+			f.err, f.done = nil, true
+			return nil, err
+		}
+		if f.done {
+			return nil, nil
+		}
+		rows := make([]storage.Row, 0, expr.BatchSize)
+		for len(rows) < expr.BatchSize {
+			r, err := f.in.Next()
+			if err != nil {
+				f.err = err
+				break
+			}
+			if r == nil {
+				f.done = true
+				break
+			}
+			rows = append(rows, r)
+		}
+		b := expr.NewBatch(rows)
+		sel, err := f.filter.Select(b, b.All())
+		if err != nil {
+			f.rows = rows
+			continue
+		}
+		for _, i := range sel {
+			f.out = append(f.out, rows[i])
+		}
+	}
+}
+
+func (f *filterIter) Close() error { return f.in.Close() }
+
+// joinIter joins each row of its input to the rows a table function call
+// produces for it, as in FROM t, UNNEST(tags) AS tag.
+type joinIter struct {
+	in      rowIter
+	call    *expr.TableCall
+	left    storage.Row
+	pending []storage.Row
+}
+
+func (j *joinIter) Next() (storage.Row, error) {
+	for len(j.pending) == 0 {
+		left, err := j.in.Next()
+		if left == nil || err != nil {
+			return nil, err
+		}
+		if j.pending, err = j.call.Rows(left); err != nil {
+			return nil, err
+		}
+		j.left = left
+	}
+	p := j.pending[0]
+	j.pending = j.pending[1:]
+	nr := make(storage.Row, len(j.left)+len(p))
+	for k, v := range j.left {
+		nr[k] = v
+	}
+	for k, v := range p {
+		nr[k] = v
+	}
+	return nr, nil
+}
+
+func (j *joinIter) Close() error { return j.in.Close() }
+
+// sortIter reads all of its input and yields it ordered by keys. With
+// limit 0 or more it yields only the first limit rows, and holds at most
+// twice that many: whenever it has more it sorts them and drops the rest.
+// When the rows it holds exceed the query's memory budget it sorts them
+// and writes them to a run file, then merges the runs at the end.
+type sortIter struct {
+	in    rowIter
+	keys  []sortKey
+	limit int
+	mem   *memBudget
+	held  int64 // bytes of the rows held, counted in mem
+	runs  []*spillFile
+	// what was spilled, for EXPLAIN ANALYZE
+	spilledRuns  int
+	spilledBytes int64
+	sorted       rowIter
+}
+
+// maxMergeRuns is how many runs a sort merges at once; more are first
+// merged into one.
+const maxMergeRuns = 64
+
+func (s *sortIter) Next() (storage.Row, error) {
+	if s.sorted == nil {
+		if err := s.sort(); err != nil {
+			return nil, err
+		}
+	}
+	return s.sorted.Next()
+}
+
+// sort reads the input and sets sorted.
+func (s *sortIter) sort() error {
+	rows := []storage.Row{}
+	for {
+		r, err := s.in.Next()
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			break
+		}
+		rows = append(rows, r)
+		n := rowBytes(r)
+		s.held += n
+		within := s.mem.grow(n)
+		if s.limit >= 0 && len(rows) > 2*s.limit {
+			if rows, err = s.top(rows); err != nil {
+				return err
+			}
+			kept := int64(0)
+			for _, r := range rows {
+				kept += rowBytes(r)
+			}
+			s.mem.release(s.held - kept)
+			s.held = kept
+			within = s.mem.grow(0)
+		}
+		if !within && len(rows) > 1 {
+			if err := s.spill(rows); err != nil {
+				return err
+			}
+			rows = []storage.Row{}
+		}
+	}
+	rows, err := s.top(rows)
+	if err != nil {
+		return err
+	}
+	if len(s.runs) == 0 {
+		s.sorted = &sliceIter{rows: rows}
+		return nil
+	}
+	merge, err := s.merge()
+	if err != nil {
+		return err
+	}
+	merge.inputs = append(merge.inputs, &sliceIter{rows: rows})
+	s.sorted = merge
+	return nil
+}
+
+// top sorts rows and returns the first limit of them, or all of them
+// without a limit. The sort is stable, so sorting the rows kept so far
+// with those read since gives the order sorting every row would.
+func (s *sortIter) top(rows []storage.Row) ([]storage.Row, error) {
+	if err := sortRows(rows, s.keys); err != nil {
+		return nil, fmt.Errorf("error evaluating ORDER BY: %w", err)
+	}
+	if s.limit >= 0 && len(rows) > s.limit {
+		rows = rows[:s.limit]
+	}
+	return rows, nil
+}
+
+// spill writes rows, sorted, to a new run and releases their memory.
+func (s *sortIter) spill(rows []storage.Row) error {
+	rows, err := s.top(rows)
+	if err != nil {
+		return err
+	}
+	if len(s.runs) == maxMergeRuns {
+		// too many runs to merge at once: merge them into one
+		merge, err := s.merge()
+		if err != nil {
+			return err
+		}
+		run, err := s.writeRun(merge)
+		if err != nil {
+			return err
+		}
+		for _, old := range s.runs {
+			old.Close()
+		}
+		s.runs = []*spillFile{run}
+	}
+	run, err := s.writeRun(&sliceIter{rows: rows})
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	s.mem.release(s.held)
+	s.held = 0
+	return nil
+}
+
+// writeRun writes the rows of it to a new run file.
+func (s *sortIter) writeRun(it rowIter) (*spillFile, error) {
+	run, err := newSpillFile(s.mem)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		r, err := it.Next()
+		if err != nil {
+			run.Close()
+			return nil, err
+		}
+		if r == nil {
+			break
+		}
+		if err := run.write(r, 0); err != nil {
+			run.Close()
+			return nil, err
+		}
+	}
+	s.spilledRuns++
+	s.spilledBytes += run.bytes
+	return run, nil
+}
+
+// merge returns a merge of the runs written so far.
+func (s *sortIter) merge() (*mergeIter, error) {
+	m := &mergeIter{keys: s.keys}
+	for _, run := range s.runs {
+		r, err := run.reader()
+		if err != nil {
+			return nil, err
+		}
+		m.inputs = append(m.inputs, r)
+	}
+	return m, nil
+}
+
+func (s *sortIter) spilled() string {
+	if s.spilledRuns == 0 {
+		return ""
+	}
+	return fmt.Sprintf("external merge of %d runs, %d kB spilled", s.spilledRuns, (s.spilledBytes+1023)/1024)
+}
+
+func (s *sortIter) Close() error {
+	for _, run := range s.runs {
+		run.Close()
+	}
+	s.runs = nil
+	s.mem.release(s.held)
+	s.held = 0
+	return s.in.Close()
+}
+
+// limitIter skips the first offset rows of its input and yields at most
+// limit of the rest, or all of them when limit is negative. It stops
+// pulling rows from its input once it has yielded limit.
+type limitIter struct {
+	in            rowIter
+	offset, limit int
+	yielded       int
+}
+
+func (l *limitIter) Next() (storage.Row, error) {
+	for ; l.offset > 0; l.offset-- {
+		r, err := l.in.Next()
+		if r == nil || err != nil {
+			return nil, err
+		}
+	}
+	if l.limit >= 0 && l.yielded >= l.limit {
+		return nil, nil
+	}
+	r, err := l.in.Next()
+	if r != nil {
+		l.yielded++
+	}
+	return r, err
+}
+
+func (l *limitIter) Close() error { return l.in.Close() }
+
+// outputKey is the key projectIter stores the i-th projection under.
+// Projections are kept apart by position, as two may have the same name.
+func outputKey(i int) string { return strconv.Itoa(i) }
+
+// projectIter computes the projections of each row of its input. Rows of a
+// grouped query already hold their projections under their output names.
+type projectIter struct {
+	in      rowIter
+	projs   []projSpec
+	grouped bool
+}
+
+func (p *projectIter) Next() (storage.Row, error) {
+	r, err := p.in.Next()
+	if r == nil || err != nil {
+		return nil, err
+	}
+	out := make(storage.Row, len(p.projs))
+	for i, ps := range p.projs {
+		switch {
+		case p.grouped:
+			out[outputKey(i)] = r[ps.outName]
+		case ps.expr != nil:
+			v, err := ps.expr.EvalValue(r)
+			if err != nil {
+				return nil, fmt.Errorf("error evaluating %s: %w", ps.raw, err)
+			}
+			out[outputKey(i)] = v
+		default:
+			out[outputKey(i)] = r[ps.col]
+		}
+	}
+	return out, nil
+}
+
+func (p *projectIter) Close() error { return p.in.Close() }
+
+// outputValues returns the n projections of a row made by projectIter.
+func outputValues(r storage.Row, n int) []interface{} {
+	vals := make([]interface{}, n)
+	for i := range vals {
+		vals[i] = r[outputKey(i)]
+	}
+	return vals
+}
+
+// distinctIter drops the projected rows of its input that repeat an
+// earlier one. It remembers one key per distinct row.
+type distinctIter struct {
+	in   rowIter
+	n    int // number of projections
+	seen map[string]struct{}
+}
+
+func (d *distinctIter) Next() (storage.Row, error) {
+	for {
+		r, err := d.in.Next()
+		if r == nil || err != nil {
+			return nil, err
+		}
+		key := expr.GroupKey(outputValues(r, d.n)...)
+		if _, dup := d.seen[key]; !dup {
+			d.seen[key] = struct{}{}
+			return r, nil
+		}
+	}
+}
+
+func (d *distinctIter) Close() error { return d.in.Close() }
+
+// aggregation is what an aggregateIter computes for each group: every
+// aggregate call of the query, the GROUPING calls with the group items
+// their arguments name, and the projections.
+type aggregation struct {
+	gb            *groupBy
+	calls         []*expr.AggregateCall
+	groupingCalls []*expr.GroupingCall
+	groupingArgs  [][]int
+	projs         []projSpec
+	projItem      []int // the group item each projection selects, or -1
+}
+
+// group is the state of one group of an aggregation.
+type group struct {
+	set  int           // index of the grouping set
+	key  string        // the set and the values of its items
+	vals []interface{} // group item values; NULL for items outside the set
+	rep  storage.Row   // first row seen, for grouped projections
+	accs []expr.Accumulator
+}
+
+// partialGroupKey is the key under which a partial aggregation passes
+// each of its groups to the aggregation finalizing them, in a row of its
+// own.
+const partialGroupKey = "\x00group"
+
+// aggregateIter reads all of its input into groups and yields a row per
+// group. It holds one row and the accumulators of each group, not the
+// input. Once its groups exceed the query's memory budget, rows of groups
+// it does not hold yet are written to partition files by a hash of their
+// group, and each partition is aggregated on its own after the groups in
+// memory are yielded, so those come first. The state of an aggregate
+// itself, such as the array ARRAY_AGG builds, is not counted.
+//
+// A parallel aggregation runs in two steps: a partial aggregateIter per
+// morsel of the table yields its groups as they are, and one finalizing
+// them merges the groups of all the morsels, in order. Groups being
+// finalized are never spilled.
+type aggregateIter struct {
+	in       rowIter
+	agg      *aggregation
+	mem      *memBudget
+	partial  bool // yield the groups unfinished, for a finalizing aggregateIter
+	finalize bool // merge the groups of partial aggregateIters
+	// set for the aggregation of a partition: the rows are read from it,
+	// each for the grouping set its tag names
+	partition *spillReader
+	depth     int
+	// groups by grouping set, each in order of first appearance
+	groups [][]*group
+	held   int64 // bytes of the groups, counted in mem
+	set    int
+	pos    int
+	done   bool
+
+	spilling bool
+	parts    []*spillFile
+	part     int
+	child    *aggregateIter
+	// what was spilled, for EXPLAIN ANALYZE
+	spilledRows  int
+	spilledBytes int64
+}
+
+const (
+	// aggPartitions is how many partitions an aggregation spills to.
+	aggPartitions = 16
+	// maxSpillDepth bounds how many times the rows of a group are
+	// partitioned again; past it a partition is aggregated in memory
+	// whatever its size.
+	maxSpillDepth = 4
+)
+
+func (a *aggregateIter) Next() (storage.Row, error) {
+	if !a.done {
+		if err := a.accumulate(); err != nil {
+			return nil, err
+		}
+		a.done = true
+	}
+	for a.set < len(a.groups) {
+		if a.pos < len(a.groups[a.set]) {
+			a.pos++
+			if a.partial {
+				return storage.Row{partialGroupKey: a.groups[a.set][a.pos-1]}, nil
+			}
+			return a.agg.result(a.groups[a.set][a.pos-1])
+		}
+		a.set, a.pos = a.set+1, 0
+	}
+	// the groups held are done with; aggregate the partitions
+	a.groups = nil
+	a.mem.release(a.held)
+	a.held = 0
+	for {
+		if a.child != nil {
+			r, err := a.child.Next()
+			if r != nil || err != nil {
+				return r, err
+			}
+			a.spilledRows += a.child.spilledRows
+			a.spilledBytes += a.child.spilledBytes
+			a.child.Close()
+			a.child = nil
+		}
+		for a.part < len(a.parts) && a.parts[a.part] == nil {
+			a.part++
+		}
+		if a.part == len(a.parts) {
+			return nil, nil
+		}
+		rd, err := a.parts[a.part].reader()
+		if err != nil {
+			return nil, err
+		}
+		a.part++
+		a.child = &aggregateIter{in: rd, partition: rd, agg: a.agg, mem: a.mem, depth: a.depth + 1}
+	}
+}
+
+func (a *aggregateIter) spilled() string {
+	if a.spilledRows == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d rows spilled to partitions, %d kB", a.spilledRows, (a.spilledBytes+1023)/1024)
+}
+
+func (a *aggregateIter) Close() error {
+	if a.child != nil {
+		a.child.Close()
+	}
+	for _, p := range a.parts {
+		if p != nil {
+			p.Close()
+		}
+	}
+	a.parts = nil
+	a.mem.release(a.held)
+	a.held = 0
+	return a.in.Close()
+}
+
+// accumulate steps the aggregates of each input row's group in every
+// grouping set.
+func (a *aggregateIter) accumulate() error {
+	agg := a.agg
+	gb := agg.gb
+	newGroup := func(set int, key string, vals []interface{}, rep storage.Row) *group {
+		g := &group{set: set, key: key, vals: vals, rep: rep, accs: make([]expr.Accumulator, len(agg.calls))}
+		for i, c := range agg.calls {
+			g.accs[i] = c.NewAccumulator()
+		}
+		a.groups[set] = append(a.groups[set], g)
+		return g
+	}
+	index := map[string]*group{}
+	a.groups = make([][]*group, len(gb.sets))
+	allSets := make([]int, len(gb.sets))
+	for si, set := range gb.sets {
+		allSets[si] = si
+		if len(set) == 0 && a.partition == nil {
+			// an empty grouping set yields one row even over no input
+			key := fmt.Sprintf("%d|", si)
+			index[key] = newGroup(si, key, make([]interface{}, len(gb.items)), storage.Row{})
+		}
+	}
+	for {
+		r, err := a.in.Next()
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return nil
+		}
+		if a.finalize {
+			if err := a.merge(index, r[partialGroupKey].(*group)); err != nil {
+				return err
+			}
+			continue
+		}
+		items, err := gb.values(r)
+		if err != nil {
+			return err
+		}
+		sets := allSets
+		if a.partition != nil {
+			sets = []int{a.partition.tag}
+		}
+		for _, si := range sets {
+			set := gb.sets[si]
+			vals := make([]interface{}, len(gb.items))
+			keyParts := make([]interface{}, len(set))
+			for j, idx := range set {
+				vals[idx] = items[idx]
+				keyParts[j] = vals[idx]
+			}
+			key := fmt.Sprintf("%d|", si) + expr.GroupKey(keyParts...)
+			g, ok := index[key]
+			if !ok {
+				if a.spilling {
+					if err := a.spillRow(r, si, key); err != nil {
+						return err
+					}
+					continue
+				}
+				g = newGroup(si, key, vals, r)
+				index[key] = g
+				size := rowBytes(r) + int64(16*len(vals)+64*len(g.accs)+len(key))
+				a.held += size
+				if !a.mem.grow(size) && a.depth < maxSpillDepth {
+					a.spilling = true
+				}
+			}
+			for i, c := range agg.calls {
+				if err := c.Step(g.accs[i], r); err != nil {
+					return fmt.Errorf("error evaluating %s: %w", c.Text, err)
+				}
+			}
+		}
+	}
+}
+
+// merge adds partial group p to the group of the same key, or holds it as
+// that group when there is none yet.
+func (a *aggregateIter) merge(index map[string]*group, p *group) error {
+	g, ok := index[p.key]
+	if !ok {
+		index[p.key] = p
+		a.groups[p.set] = append(a.groups[p.set], p)
+		size := rowBytes(p.rep) + int64(16*len(p.vals)+64*len(p.accs)+len(p.key))
+		a.held += size
+		a.mem.grow(size)
+		return nil
+	}
+	for i, c := range a.agg.calls {
+		if err := g.accs[i].(expr.Merger).Merge(p.accs[i]); err != nil {
+			return fmt.Errorf("error evaluating %s: %w", c.Text, err)
+		}
+	}
+	return nil
+}
+
+// spillRow writes row r, for grouping set si, to the partition of its
+// group key.
+func (a *aggregateIter) spillRow(r storage.Row, si int, key string) error {
+	if a.parts == nil {
+		a.parts = make([]*spillFile, aggPartitions)
+	}
+	h := fnv.New32a()
+	// each depth partitions by different bits of the hash
+	fmt.Fprintf(h, "%d|%s", a.depth, key)
+	p := h.Sum32() % aggPartitions
+	if a.parts[p] == nil {
+		f, err := newSpillFile(a.mem)
+		if err != nil {
+			return err
+		}
+		a.parts[p] = f
+	}
+	before := a.parts[p].bytes
+	if err := a.parts[p].write(r, si); err != nil {
+		return err
+	}
+	a.spilledRows++
+	a.spilledBytes += a.parts[p].bytes - before
+	return nil
+}
+
+// result builds the row of a group: its representative row with the group
+// columns set to the group's values, plus the aggregate and GROUPING slots,
+// then every projection under its output name.
+func (agg *aggregation) result(g *group) (storage.Row, error) {
+	gb := agg.gb
+	nr := make(storage.Row, len(g.rep)+len(agg.calls)+len(agg.projs))
+	for c, v := range g.rep {
+		nr[c] = v
+	}
+	for i, it := range gb.items {
+		if it.col != "" {
+			nr[it.col] = g.vals[i]
+		}
+	}
+	for i, c := range agg.calls {
+		v, err := g.accs[i].Result()
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating %s: %w", c.Text, err)
+		}
+		nr[c.Slot] = v
+	}
+	inSet := map[int]bool{}
+	for _, idx := range gb.sets[g.set] {
+		inSet[idx] = true
+	}
+	for i, gc := range agg.groupingCalls {
+		mask := 0
+		for _, idx := range agg.groupingArgs[i] {
+			mask <<= 1
+			if !inSet[idx] {
+				mask |= 1
+			}
+		}
+		nr[gc.Slot] = mask
+	}
+	vals := make([]interface{}, len(agg.projs))
+	for i, ps := range agg.projs {
+		if agg.projItem[i] != -1 {
+			vals[i] = g.vals[agg.projItem[i]]
+			continue
+		}
+		v, err := ps.expr.EvalValue(nr)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating %s: %w", ps.raw, err)
+		}
+		vals[i] = v
+	}
+	for i, ps := range agg.projs {
+		nr[ps.outName] = vals[i]
+	}
+	return nr, nil
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+)
+
+// HandleExplain processes EXPLAIN SELECT ..., which shows the plan the
+// SELECT would run with, and the rows each step is estimated to yield once
+// the table has been analyzed, and EXPLAIN ANALYZE SELECT ..., which runs it,
+// discarding the result, and shows the rows each plan node yielded and the
+// time spent in it, its inputs included.
+func HandleExplain(cmd parser.Command, db *schema.Database) (string, error) {
+	tokens := cmd.Tokens[1:]
+	analyze := len(tokens) > 0 && strings.EqualFold(tokens[0], "ANALYZE")
+	if analyze {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 || !strings.EqualFold(tokens[0], "SELECT") {
+		return "", fmt.Errorf("invalid EXPLAIN syntax. Example: EXPLAIN [ANALYZE] SELECT * FROM users WHERE id = 1;")
+	}
+	q, err := parseSelect(parser.Command{Type: "SELECT", Tokens: tokens, Raw: cmd.Raw}, db)
+	if err != nil {
+		return "", err
+	}
+	plan, err := planSelect(q, db)
+	if err != nil {
+		return "", err
+	}
+	if !analyze {
+		return explainPlan(plan, estimateRows(plan), nil), nil
+	}
+
+	c := newExecContext(db)
+	c.stats = map[planNode]*nodeStats{}
+	start := time.Now()
+	root, err := c.open(plan)
+	if err != nil {
+		return "", err
+	}
+	rows := 0
+	for {
+		r, err := root.Next()
+		if err != nil {
+			root.Close()
+			return "", err
+		}
+		if r == nil {
+			break
+		}
+		rows++
+	}
+	// closing waits for parallel workers, and adds their counts
+	if err := root.Close(); err != nil {
+		return "", err
+	}
+	elapsed := time.Since(start)
+	return explainPlan(plan, estimateRows(plan), c.stats) + fmt.Sprintf("Result: %d rows\nExecution time: %s\n", rows, formatDuration(elapsed)), nil
+}
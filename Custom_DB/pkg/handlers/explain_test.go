@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+)
+
+func TestHandleExplain(t *testing.T) {
+	db := newOrdersDB(t)
+	runHandler(t, "CREATE INDEX idx_item ON orders (item);", HandleCreateIndex, db)
+
+	cases := map[string]string{
+		"EXPLAIN SELECT item FROM orders WHERE qty > 1 + 1 AND 1 = 1 ORDER BY qty DESC LIMIT 1 OFFSET 1;": "" +
+			"Limit 1 offset 1\n" +
+			"-> Project item\n" +
+			"  -> Top-N Sort by qty DESC (limit 2)\n" +
+			"    -> Filter WHERE qty > 2\n" +
+			"      -> Seq Scan on orders (columns: item, qty)\n",
+		"EXPLAIN SELECT * FROM orders WHERE item = 'p' || 'en';": "" +
+			"Project id, item, price, qty\n" +
+			"-> Filter WHERE item = 'pen'\n" +
+			"  -> Index Scan on orders using idx_item (item = 'pen')\n",
+		"EXPLAIN SELECT item, COUNT(*) FROM orders WHERE 1 = 0 GROUP BY item;": "" +
+			"Project item, count\n" +
+			"-> Group By item: COUNT(*)\n" +
+			"  -> Empty Result (WHERE is never true)\n",
+		"EXPLAIN SELECT DISTINCT item FROM orders ORDER BY item LIMIT 1;": "" +
+			"Limit 1\n" +
+			"-> Distinct\n" +
+			"  -> Project item\n" +
+			"    -> Sort by item\n" +
+			"      -> Seq Scan on orders (columns: item)\n",
+	}
+	for sql, want := range cases {
+		if got := runHandler(t, sql, HandleExplain, db); got != want {
+			t.Errorf("%s\ngot:\n%s\nwant:\n%s", sql, got, want)
+		}
+	}
+}
+
+func TestHandleExplain_Analyze(t *testing.T) {
+	db := newOrdersDB(t)
+	out := runHandler(t, "EXPLAIN ANALYZE SELECT item FROM orders WHERE qty > 2 ORDER BY qty LIMIT 1;", HandleExplain, db)
+	for _, want := range []string{
+		"Limit 1 (rows=1 time=",
+		"-> Top-N Sort by qty (limit 1) (rows=1 time=",
+		"-> Filter WHERE qty > 2 (rows=2 time=",
+		"-> Seq Scan on orders (columns: item, qty) (rows=3 time=",
+		"Result: 1 rows\nExecution time: ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestHandleExplain_Invalid(t *testing.T) {
+	db := newOrdersDB(t)
+	for _, sql := range []string{"EXPLAIN;", "EXPLAIN ANALYZE DELETE FROM orders;", "EXPLAIN SELECT * FROM missing;"} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if _, err := HandleExplain(cmd, db); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
+
+func TestHandleSelect_TopN(t *testing.T) {
+	db := newNumbersDB(t, 50, 100)
+	all := dataLines(runSelect(t, db, "SELECT n FROM numbers ORDER BY n % 7 DESC, n;"))
+	for _, lim := range []struct {
+		sql      string
+		from, to int
+	}{
+		{"SELECT n FROM numbers ORDER BY n % 7 DESC, n LIMIT 5;", 0, 5},
+		{"SELECT n FROM numbers ORDER BY n % 7 DESC, n LIMIT 4 OFFSET 9;", 9, 13},
+		{"SELECT n FROM numbers ORDER BY n % 7 DESC, n LIMIT 0;", 0, 0},
+	} {
+		got := strings.Join(dataLines(runSelect(t, db, lim.sql)), ",")
+		if want := strings.Join(all[lim.from:lim.to], ","); got != want {
+			t.Errorf("%s = %s, want %s", lim.sql, got, want)
+		}
+	}
+}
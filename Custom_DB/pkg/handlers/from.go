@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/schema"
+)
+
+// fromClause is what a SELECT reads: a table, a table function call such
+// as JSON_EACH('[1,2]'), or a table followed by a call over its columns,
+// FROM t, UNNEST(tags) AS tag, which yields each row of t once per row
+// the call produces for it.
+type fromClause struct {
+	table schema.Table    // the table read, with no name for a bare call
+	call  *expr.TableCall // nil when reading only a table
+	// columns visible to the rest of the query: the table's, then the call's
+	columns []schema.Column
+}
+
+// parseFrom parses the tokens of a FROM clause.
+func parseFrom(tokens []string, db *schema.Database) (*fromClause, error) {
+	items := splitTopLevel(tokens)
+	if len(items) > 2 || len(items[0]) == 0 {
+		return nil, fmt.Errorf("unsupported FROM clause '%s': expected a table, a table function or a table followed by a table function", joinExprTokens(tokens))
+	}
+	isCall := func(item []string) bool { return len(item) > 1 && item[1] == "(" }
+	f := &fromClause{}
+	callItem := []string(nil)
+	switch {
+	case len(items) == 1 && isCall(items[0]):
+		callItem = items[0]
+	case len(items) == 2 && isCall(items[1]) && !isCall(items[0]):
+		callItem = items[1]
+		fallthrough
+	case len(items) == 1:
+		table, exists := db.GetTable(items[0][0])
+		if !exists {
+			return nil, fmt.Errorf("table '%s' does not exist", items[0][0])
+		}
+		f.table = table
+		f.columns = append(f.columns, table.Columns...)
+	default:
+		return nil, fmt.Errorf("unsupported FROM clause '%s': expected a table, a table function or a table followed by a table function", joinExprTokens(tokens))
+	}
+	if callItem == nil {
+		return f, nil
+	}
+
+	call, err := expr.ParseTableCall(joinExprTokens(callItem))
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range call.Args {
+		expr.BindTimeZone(a, expr.SessionTimeZone(db))
+	}
+	for _, c := range call.Columns() {
+		if _, ok := getColumnDefinition(f.table.Columns, c); !ok {
+			return nil, fmt.Errorf("%s references unknown column '%s'", call.Func.Name, c)
+		}
+	}
+	for _, c := range call.OutputColumns() {
+		if _, clash := getColumnDefinition(f.columns, c.Name); clash {
+			return nil, fmt.Errorf("column '%s' of %s conflicts with a column of table '%s'", c.Name, call.Func.Name, f.table.Name)
+		}
+	}
+	f.call = call
+	f.columns = append(f.columns, call.OutputColumns()...)
+	return f, nil
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"Custom_DB/pkg/expr"
+)
+
+// HandleShowFunctions lists the built-in and user-defined functions callable
+// from SQL, scalar and aggregate, with their signatures.
+func HandleShowFunctions() (string, error) {
+	rows := [][]interface{}{}
+	for _, f := range expr.ListFunctions() {
+		origin := "user"
+		if f.Builtin {
+			origin = "builtin"
+		}
+		rows = append(rows, []interface{}{f.Name, f.Kind, origin, f.Signature})
+	}
+	return formatResult([]string{"name", "kind", "origin", "signature"}, rows), nil
+}
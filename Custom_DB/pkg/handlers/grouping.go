@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// maxCubeItems bounds CUBE, which expands to 2^n grouping sets.
+const maxCubeItems = 12
+
+// groupItem is one distinct grouping expression of a GROUP BY clause.
+type groupItem struct {
+	text string         // normalized source text
+	col  string         // column name when the item is a plain column
+	expr expr.ValueExpr // computed item otherwise
+}
+
+// groupBy is a parsed GROUP BY clause: its distinct items and the grouping
+// sets built from them, each a list of item indexes. A plain GROUP BY a, b
+// has the single set {a, b}; ROLLUP, CUBE and GROUPING SETS add more.
+type groupBy struct {
+	items []groupItem
+	sets  [][]int
+}
+
+// itemFor returns the index of the item a column name or expression text
+// refers to, or -1.
+func (g *groupBy) itemFor(col, text string) int {
+	for i, it := range g.items {
+		if (col != "" && strings.EqualFold(it.col, col)) || strings.EqualFold(it.text, text) {
+			return i
+		}
+	}
+	return -1
+}
+
+// values returns the values of the items for row r.
+func (g *groupBy) values(r storage.Row) ([]interface{}, error) {
+	vals := make([]interface{}, len(g.items))
+	for i, it := range g.items {
+		if it.expr == nil {
+			vals[i] = r[it.col]
+			continue
+		}
+		v, err := it.expr.EvalValue(r)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating GROUP BY: %w", err)
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// parseGroupBy parses the tokens following GROUP BY. resolve maps a
+// projection alias to its column or expression so GROUP BY can name it.
+func parseGroupBy(tokens []string, columns []schema.Column, resolve func(text string) (string, expr.ValueExpr)) (*groupBy, error) {
+	g := &groupBy{}
+
+	addItem := func(toks []string) (int, error) {
+		text := joinExprTokens(toks)
+		col, ve := resolve(text)
+		if col == "" && ve == nil {
+			parsed, err := expr.ParseValue(text)
+			if err != nil {
+				return -1, fmt.Errorf("invalid GROUP BY expression: %w", err)
+			}
+			if len(expr.CollectAggregates(parsed)) > 0 {
+				return -1, fmt.Errorf("aggregate functions are not allowed in GROUP BY")
+			}
+			if c, isCol := expr.ColumnName(parsed); isCol {
+				col = c
+			} else {
+				ve = parsed
+			}
+		}
+		if col != "" {
+			if _, ok := getColumnDefinition(columns, col); !ok {
+				return -1, fmt.Errorf("GROUP BY references unknown column '%s'", col)
+			}
+		}
+		for _, c := range expr.CollectColumns(ve) {
+			if _, ok := getColumnDefinition(columns, c); !ok {
+				return -1, fmt.Errorf("GROUP BY references unknown column '%s'", c)
+			}
+		}
+		if i := g.itemFor(col, text); i != -1 {
+			return i, nil
+		}
+		g.items = append(g.items, groupItem{text: text, col: col, expr: ve})
+		return len(g.items) - 1, nil
+	}
+
+	// itemList parses either a single item or a parenthesized list, which may be empty
+	itemList := func(toks []string) ([]int, error) {
+		if len(toks) >= 2 && toks[0] == "(" && toks[len(toks)-1] == ")" && enclosed(toks) {
+			out := []int{}
+			if len(toks) == 2 {
+				return out, nil
+			}
+			for _, part := range splitTopLevel(toks[1 : len(toks)-1]) {
+				i, err := addItem(part)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, i)
+			}
+			return out, nil
+		}
+		i, err := addItem(toks)
+		if err != nil {
+			return nil, err
+		}
+		return []int{i}, nil
+	}
+
+	// inner returns the tokens between the parentheses following a keyword
+	inner := func(toks []string, skip int, name string) ([]string, error) {
+		if len(toks) < skip+2 || toks[skip] != "(" || toks[len(toks)-1] != ")" || !enclosed(toks[skip:]) {
+			return nil, fmt.Errorf("%s expects a parenthesized list", name)
+		}
+		return toks[skip+1 : len(toks)-1], nil
+	}
+
+	g.sets = [][]int{{}}
+	for _, elem := range splitTopLevel(tokens) {
+		if len(elem) == 0 {
+			return nil, fmt.Errorf("empty GROUP BY element")
+		}
+		var elemSets [][]int
+		switch {
+		case strings.EqualFold(elem[0], "ROLLUP"), strings.EqualFold(elem[0], "CUBE"):
+			name := strings.ToUpper(elem[0])
+			in, err := inner(elem, 1, name)
+			if err != nil {
+				return nil, err
+			}
+			lists := [][]int{}
+			for _, part := range splitTopLevel(in) {
+				l, err := itemList(part)
+				if err != nil {
+					return nil, err
+				}
+				lists = append(lists, l)
+			}
+			if name == "ROLLUP" {
+				// every prefix, longest first
+				for n := len(lists); n >= 0; n-- {
+					elemSets = append(elemSets, flatten(lists[:n]))
+				}
+			} else {
+				if len(lists) > maxCubeItems {
+					return nil, fmt.Errorf("CUBE supports at most %d elements", maxCubeItems)
+				}
+				for mask := (1 << len(lists)) - 1; mask >= 0; mask-- {
+					chosen := [][]int{}
+					for i := range lists {
+						if mask&(1<<(len(lists)-1-i)) != 0 {
+							chosen = append(chosen, lists[i])
+						}
+					}
+					elemSets = append(elemSets, flatten(chosen))
+				}
+			}
+		case strings.EqualFold(elem[0], "GROUPING") && len(elem) > 1 && strings.EqualFold(elem[1], "SETS"):
+			in, err := inner(elem, 2, "GROUPING SETS")
+			if err != nil {
+				return nil, err
+			}
+			for _, part := range splitTopLevel(in) {
+				l, err := itemList(part)
+				if err != nil {
+					return nil, err
+				}
+				elemSets = append(elemSets, l)
+			}
+		default:
+			l, err := itemList(elem)
+			if err != nil {
+				return nil, err
+			}
+			elemSets = [][]int{l}
+		}
+		// several elements combine as the cross product of their sets
+		combined := [][]int{}
+		for _, a := range g.sets {
+			for _, b := range elemSets {
+				combined = append(combined, flatten([][]int{a, b}))
+			}
+		}
+		g.sets = combined
+	}
+	return g, nil
+}
+
+// flatten concatenates item index lists, dropping duplicates.
+func flatten(lists [][]int) []int {
+	out := []int{}
+	seen := map[int]bool{}
+	for _, l := range lists {
+		for _, i := range l {
+			if !seen[i] {
+				seen[i] = true
+				out = append(out, i)
+			}
+		}
+	}
+	return out
+}
+
+// enclosed reports whether the opening parenthesis at toks[0] closes at the
+// last token, so (a) + (b) is not mistaken for one parenthesized list.
+func enclosed(toks []string) bool {
+	depth := 0
+	for i, t := range toks {
+		switch t {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 && i != len(toks)-1 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
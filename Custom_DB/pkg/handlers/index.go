@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// indexFile is the on-disk form of an expression index: the data file lines
+// holding each key, as filed by expr.IndexKeys. Stamp records the data file
+// the index was built from; a stale index is rebuilt.
+type indexFile struct {
+	Stamp    string           `json:"stamp"`
+	Expr     string           `json:"expr"`
+	Temporal bool             `json:"temporal"` // holds values expr.IndexKeys cannot file
+	Entries  map[string][]int `json:"entries"`
+}
+
+// HandleCreateIndex processes CREATE INDEX name ON table (expression). The
+// expression may be a column or any expression over the table's columns,
+// such as doc->>'email'. SELECT uses the index for WHERE conditions of the
+// form expression = constant.
+func HandleCreateIndex(cmd parser.Command, db *schema.Database) (string, error) {
+	tokens := cmd.Tokens
+	usage := fmt.Errorf("invalid CREATE INDEX syntax. Example: CREATE INDEX users_email ON users (doc->>'email');")
+	if len(tokens) < 7 || !strings.EqualFold(tokens[1], "INDEX") || !strings.EqualFold(tokens[3], "ON") ||
+		tokens[5] != "(" || tokens[len(tokens)-1] != ")" {
+		return "", usage
+	}
+	name, tableName := tokens[2], tokens[4]
+	table, exists := db.GetTable(tableName)
+	if !exists {
+		return "", fmt.Errorf("table '%s' does not exist", tableName)
+	}
+	text := joinExprTokens(tokens[6 : len(tokens)-1])
+	ve, err := expr.ParseValue(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid index expression '%s': %w", text, err)
+	}
+	if len(expr.CollectAggregates(ve)) > 0 {
+		return "", fmt.Errorf("aggregate functions are not allowed in index expressions")
+	}
+	for _, c := range expr.CollectColumns(ve) {
+		if _, ok := getColumnDefinition(table.Columns, c); !ok {
+			return "", fmt.Errorf("index expression references unknown column '%s'", c)
+		}
+	}
+	idx := schema.Index{Name: name, Expr: text}
+	if err := db.AddIndex(table.Name, idx); err != nil {
+		return "", err
+	}
+	table.Indexes = append(table.Indexes, idx)
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+	if err != nil {
+		return "", fmt.Errorf("error opening table file: %s", err)
+	}
+	f, err := buildIndex(tableFile, table, idx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("✅ Index '%s' created on '%s' (%d distinct keys)", name, table.Name, len(f.Entries)), nil
+}
+
+// HandleDropIndex processes DROP INDEX name.
+func HandleDropIndex(cmd parser.Command, db *schema.Database) (string, error) {
+	tokens := cmd.Tokens
+	if len(tokens) != 3 || !strings.EqualFold(tokens[1], "INDEX") {
+		return "", fmt.Errorf("invalid DROP INDEX syntax. Example: DROP INDEX users_email;")
+	}
+	tableName, err := db.RemoveIndex(tokens[2])
+	if err != nil {
+		return "", err
+	}
+	table, _ := db.GetTable(tableName)
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+	if err != nil {
+		return "", fmt.Errorf("error opening table file: %s", err)
+	}
+	if err := tableFile.RemoveIndex(tokens[2]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("✅ Index '%s' dropped.", tokens[2]), nil
+}
+
+// buildIndex evaluates the index expression over every row of the table
+// and writes the index file.
+func buildIndex(tableFile storage.Table, table schema.Table, idx schema.Index) (*indexFile, error) {
+	ve, err := expr.ParseValue(idx.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression of index '%s': %w", idx.Name, err)
+	}
+	stamp, err := tableFile.Stamp()
+	if err != nil {
+		return nil, err
+	}
+	f := &indexFile{Stamp: stamp, Expr: idx.Expr, Entries: map[string][]int{}}
+	// the index is shared by every session, so it reads times in UTC
+	err = storage.ScanAll(tableFile, func(line int, row storage.Row) error {
+		decodeRows([]storage.Row{row}, table.Columns, time.UTC)
+		v, err := ve.EvalValue(row)
+		if err != nil {
+			return fmt.Errorf("error evaluating index '%s': %w", idx.Name, err)
+		}
+		keys, ok := expr.IndexKeys(v)
+		f.Temporal = f.Temporal || !ok
+		for _, k := range keys {
+			f.Entries[k] = append(f.Entries[k], line)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	path := tableFile.IndexPath(idx.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create index directory: %w", err)
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal index '%s': %w", idx.Name, err)
+	}
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write index '%s': %w", idx.Name, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return nil, fmt.Errorf("failed to write index '%s': %w", idx.Name, err)
+	}
+	return f, nil
+}
+
+// loadIndex reads an index file, rebuilding it when it is missing or was
+// built from other data.
+func loadIndex(tableFile storage.Table, table schema.Table, idx schema.Index) (*indexFile, error) {
+	data, err := os.ReadFile(tableFile.IndexPath(idx.Name))
+	if err == nil {
+		var f indexFile
+		stamp, serr := tableFile.Stamp()
+		if json.Unmarshal(data, &f) == nil && serr == nil && f.Stamp == stamp && f.Expr == idx.Expr {
+			return &f, nil
+		}
+	}
+	return buildIndex(tableFile, table, idx)
+}
+
+// chooseIndex picks an index that narrows the rows of table satisfying
+// where, using the first condition of the form indexed-expression =
+// constant. It returns nil when no index applies and every row must be
+// read.
+func chooseIndex(tableFile storage.Table, table schema.Table, where expr.Expr) (*indexScanNode, error) {
+	if where == nil || len(table.Indexes) == 0 {
+		return nil, nil
+	}
+	for _, eq := range expr.Equalities(where) {
+		keys, filed := expr.IndexKeys(eq.Value)
+		if !filed {
+			continue
+		}
+		for _, idx := range table.Indexes {
+			ie, err := expr.ParseValue(idx.Expr)
+			if err != nil || !expr.SameExpr(ie, eq.Expr) {
+				continue
+			}
+			f, err := loadIndex(tableFile, table, idx)
+			if err != nil {
+				return nil, err
+			}
+			if f.Temporal {
+				continue
+			}
+			seen := map[int]bool{}
+			lines := []int{}
+			for _, k := range keys {
+				for _, l := range f.Entries[k] {
+					if !seen[l] {
+						seen[l] = true
+						lines = append(lines, l)
+					}
+				}
+			}
+			sort.Ints(lines)
+			return &indexScanNode{
+				tableFile: tableFile,
+				table:     table,
+				index:     idx,
+				cond:      expr.Format(ie) + " = " + expr.FormatConstant(eq.Value),
+				lines:     lines,
+			}, nil
+		}
+	}
+	return nil, nil
+}
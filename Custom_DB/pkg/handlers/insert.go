@@ -1,12 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"Custom_DB/pkg/expr"
 	"Custom_DB/pkg/parser"
 	"Custom_DB/pkg/schema"
 	"Custom_DB/pkg/storage"
@@ -14,6 +15,13 @@ import (
 
 // HandleInsert processes an INSERT INTO command
 func HandleInsert(cmd parser.Command, db *schema.Database) (string, error) {
+	return HandleInsertWithImages(cmd, db, "")
+}
+
+// HandleInsertWithImages processes an INSERT INTO command with image support.
+// IMAGE values name a file of imageDir or give a path to one; the file's
+// bytes are copied into the database's blob store.
+func HandleInsertWithImages(cmd parser.Command, db *schema.Database, imageDir string) (string, error) {
 	tokens := cmd.Tokens
 	if len(tokens) < 4 || strings.ToUpper(tokens[1]) != "INTO" {
 		return "", fmt.Errorf("invalid insert syntax. example: INSERT INTO users (id, name) VALUES (1, 'Alice');")
@@ -25,28 +33,17 @@ func HandleInsert(cmd parser.Command, db *schema.Database) (string, error) {
 		return "", fmt.Errorf("table '%s' does not exist", tableName)
 	}
 
-	// extract columns + values (naive parser, assumes correct SQL form)
-	full := strings.Join(tokens, " ")
-	openParenCols := strings.Index(full, "(")
-	closeParenCols := strings.Index(full, ")")
-	openParenVals := strings.LastIndex(full, "(")
-	closeParenVals := strings.LastIndex(full, ")")
-
-	if openParenCols == -1 || closeParenCols == -1 || openParenVals == -1 || closeParenVals == -1 {
-		return "", fmt.Errorf("invalid insert syntax: missing column or value list")
+	cols, vals, err := insertLists(tokens)
+	if err != nil {
+		return "", err
 	}
 
-	colsStr := full[openParenCols+1 : closeParenCols]
-	valsStr := full[openParenVals+1 : closeParenVals]
-
-	cols := strings.Split(colsStr, ",")
-	vals := strings.Split(valsStr, ",")
-
 	if len(cols) != len(vals) {
 		return "", fmt.Errorf("column count does not match value count")
 	}
 
 	row := make(map[string]interface{})
+	loc := expr.SessionTimeZone(db)
 	for i := range cols {
 		colName := strings.TrimSpace(cols[i])
 		valStr := strings.TrimSpace(vals[i])
@@ -56,86 +53,78 @@ func HandleInsert(cmd parser.Command, db *schema.Database) (string, error) {
 			return "", fmt.Errorf("unknown column '%s' in table '%s'", colName, tableName)
 		}
 
-		val, err := coerceValue(valStr, column.Type)
+		val, err := coerceValueWithImages(valStr, column.Type, imageDir, loc)
 		if err != nil {
 			return "", fmt.Errorf("error parsing value for column '%s': %s", colName, err)
 		}
 		row[colName] = val
 	}
 
-	tableFile, err := storage.NewTableFile(db.GetDBPath(), tableName)
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
 	if err != nil {
 		return "", fmt.Errorf("error opening table file: %s", err)
 	}
 
-	if err := tableFile.AppendRow(row); err != nil {
+	blobs, err := putBlobs(db, row)
+	if err != nil {
+		return "", fmt.Errorf("failed to store blob: %s", err)
+	}
+	if _, err := tableFile.Insert(row); err != nil {
+		releaseBlobs(db, blobs)
 		return "", fmt.Errorf("failed to insert row: %s", err)
 	}
 
 	return fmt.Sprintf("1 row inserted into '%s'", tableName), nil
 }
 
-// HandleInsertWithImages processes an INSERT INTO command with image support
-func HandleInsertWithImages(cmd parser.Command, db *schema.Database, imageDir string) (string, error) {
-	tokens := cmd.Tokens
-	if len(tokens) < 4 || strings.ToUpper(tokens[1]) != "INTO" {
-		return "", fmt.Errorf("invalid insert syntax. example: INSERT INTO users (id, name) VALUES (1, 'Alice');")
-	}
-
-	tableName := tokens[2]
-	table, exists := db.GetTable(tableName)
-	if !exists {
-		return "", fmt.Errorf("table '%s' does not exist", tableName)
-	}
-
-	// extract columns + values (naive parser, assumes correct SQL form)
-	full := strings.Join(tokens, " ")
-	openParenCols := strings.Index(full, "(")
-	closeParenCols := strings.Index(full, ")")
-	openParenVals := strings.LastIndex(full, "(")
-	closeParenVals := strings.LastIndex(full, ")")
-
-	if openParenCols == -1 || closeParenCols == -1 || openParenVals == -1 || closeParenVals == -1 {
-		return "", fmt.Errorf("invalid insert syntax: missing column or value list")
+// insertLists returns the column list and the value list of an INSERT
+// command. Values are split on the commas between them, so a quoted string
+// such as a JSON document may contain commas and parentheses.
+func insertLists(tokens []string) ([]string, []string, error) {
+	valuesIdx := -1
+	for i, t := range tokens {
+		if strings.EqualFold(t, "VALUES") {
+			valuesIdx = i
+			break
+		}
 	}
-
-	colsStr := full[openParenCols+1 : closeParenCols]
-	valsStr := full[openParenVals+1 : closeParenVals]
-
-	cols := strings.Split(colsStr, ",")
-	vals := strings.Split(valsStr, ",")
-
-	if len(cols) != len(vals) {
-		return "", fmt.Errorf("column count does not match value count")
+	if valuesIdx == -1 {
+		return nil, nil, fmt.Errorf("invalid insert syntax: missing VALUES")
 	}
-
-	row := make(map[string]interface{})
-	for i := range cols {
-		colName := strings.TrimSpace(cols[i])
-		valStr := strings.TrimSpace(vals[i])
-
-		column, found := getColumnDefinition(table.Columns, colName)
-		if !found {
-			return "", fmt.Errorf("unknown column '%s' in table '%s'", colName, tableName)
+	list := func(toks []string) ([]string, bool) {
+		if len(toks) < 2 || toks[0] != "(" {
+			return nil, false
 		}
-
-		val, err := coerceValueWithImages(valStr, column.Type, imageDir)
-		if err != nil {
-			return "", fmt.Errorf("error parsing value for column '%s': %s", colName, err)
+		items := []string{}
+		cur := []string{}
+		depth := 0
+		for _, t := range toks[1:] {
+			depth += bracketDepth(t)
+			switch t {
+			case "(":
+				depth++
+			case ")":
+				if depth == 0 {
+					return append(items, strings.Join(cur, " ")), true
+				}
+				depth--
+			case ",":
+				if depth == 0 {
+					items = append(items, strings.Join(cur, " "))
+					cur = []string{}
+					continue
+				}
+			}
+			cur = append(cur, t)
 		}
-		row[colName] = val
-	}
-
-	tableFile, err := storage.NewTableFile(db.GetDBPath(), tableName)
-	if err != nil {
-		return "", fmt.Errorf("error opening table file: %s", err)
+		return nil, false
 	}
-
-	if err := tableFile.AppendRow(row); err != nil {
-		return "", fmt.Errorf("failed to insert row: %s", err)
+	cols, okCols := list(tokens[3:valuesIdx])
+	vals, okVals := list(tokens[valuesIdx+1:])
+	if !okCols || !okVals {
+		return nil, nil, fmt.Errorf("invalid insert syntax: missing column or value list")
 	}
-
-	return fmt.Sprintf("1 row inserted into '%s'", tableName), nil
+	return cols, vals, nil
 }
 
 // Helper function to get column definition
@@ -148,84 +137,75 @@ func getColumnDefinition(columns []schema.Column, colName string) (schema.Column
 	return schema.Column{}, false
 }
 
-// Coerce value with image support
-func coerceValueWithImages(valStr string, targetType schema.DataType, imageDir string) (interface{}, error) {
+// Coerce value with image support. Times without a zone of their own are
+// read in loc.
+func coerceValueWithImages(valStr string, targetType schema.DataType, imageDir string, loc *time.Location) (interface{}, error) {
 	trimmedVal := strings.TrimSpace(valStr)
 
+	// an unquoted NULL stores a real NULL in any column; 'NULL' is text
+	if strings.EqualFold(trimmedVal, "NULL") {
+		return nil, nil
+	}
+
 	if targetType == schema.Text && len(trimmedVal) > 1 && trimmedVal[0] == '\'' && trimmedVal[len(trimmedVal)-1] == '\'' {
 		trimmedVal = trimmedVal[1 : len(trimmedVal)-1]
 	}
 
-	switch targetType {
-	case schema.Integer:
-		return strconv.Atoi(trimmedVal)
+	switch targetType.Base() {
+	case schema.Integer, schema.SmallInt, schema.BigInt:
+		// integers too large for 64 bits are reported as out of range
+		if _, err := strconv.ParseInt(trimmedVal, 10, 64); err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, err
+		}
+		return expr.CastValue(trimmedVal, targetType)
 	case schema.Decimal:
 		return strconv.ParseFloat(trimmedVal, 64)
+	case schema.Real, schema.Double:
+		f, err := strconv.ParseFloat(trimmedVal, 64)
+		if err != nil {
+			return nil, err
+		}
+		return expr.CastValue(f, targetType)
+	case schema.Numeric:
+		return expr.CastValue(strings.Trim(trimmedVal, "'\""), targetType)
 	case schema.Boolean:
 		return strconv.ParseBool(trimmedVal)
 	case schema.Text:
 		return trimmedVal, nil
-	case schema.Image:
-		// For image type, find the actual image path
-		// Remove quotes from the identifier first
-		cleanedIdentifier := strings.Trim(trimmedVal, "'\"")
-		imagePath := findImagePath(cleanedIdentifier, imageDir)
-		if imagePath == "" {
-			return "", fmt.Errorf("image file not found for identifier: %s", cleanedIdentifier)
+	case schema.Date, schema.Timestamp, schema.TimestampTZ, schema.Interval:
+		return expr.CastValueIn(strings.Trim(trimmedVal, "'\""), targetType, loc)
+	case schema.JSON:
+		// a quoted value is JSON text; 5, true or [1] may also appear bare
+		if len(trimmedVal) > 1 && trimmedVal[0] == '\'' && trimmedVal[len(trimmedVal)-1] == '\'' {
+			trimmedVal = strings.ReplaceAll(trimmedVal[1:len(trimmedVal)-1], "''", "'")
 		}
-		return imagePath, nil
-	default:
-		return nil, fmt.Errorf("unsupported data type for coercion: %s", targetType)
-	}
-}
-
-// Function to find image path based on identifier
-func findImagePath(identifier string, imageDir string) string {
-	fmt.Printf("DEBUG: Looking for image '%s' in directory '%s'\n", identifier, imageDir)
-	
-	if imageDir == "" {
-		fmt.Println("DEBUG: Image directory is empty")
-		return ""
-	}
-	
-	imageExts := []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".webp"}
-	
-	// Try exact match first
-	for _, ext := range imageExts {
-		fullPath := filepath.Join(imageDir, identifier+ext)
-		fmt.Printf("DEBUG: Checking path: %s\n", fullPath)
-		if _, err := os.Stat(fullPath); err == nil {
-			fmt.Printf("DEBUG: Found exact match: %s\n", fullPath)
-			return fullPath
+		return expr.CastValue(trimmedVal, targetType)
+	case schema.Array, schema.Struct:
+		// a quoted value is JSON text, such as '[1, 2]' or '{"city": "Oslo"}';
+		// anything else is an expression such as ARRAY[1, 2]
+		if len(trimmedVal) > 1 && trimmedVal[0] == '\'' && trimmedVal[len(trimmedVal)-1] == '\'' {
+			return expr.CastValue(strings.ReplaceAll(trimmedVal[1:len(trimmedVal)-1], "''", "'"), targetType)
 		}
-	}
-	
-	// Try partial match (file containing the identifier)
-	files, err := os.ReadDir(imageDir)
-	if err != nil {
-		fmt.Printf("DEBUG: Error reading directory: %s\n", err)
-		return ""
-	}
-	
-	fmt.Printf("DEBUG: Found %d files in directory\n", len(files))
-	for _, file := range files {
-		fmt.Printf("DEBUG: Checking file: %s\n", file.Name())
-		if !file.IsDir() && strings.Contains(file.Name(), identifier) {
-			for _, ext := range imageExts {
-				if strings.HasSuffix(strings.ToLower(file.Name()), ext) {
-					fullPath := filepath.Join(imageDir, file.Name())
-					fmt.Printf("DEBUG: Found partial match: %s\n", fullPath)
-					return fullPath
-				}
-			}
+		ve, err := expr.ParseValue(trimmedVal)
+		if err != nil {
+			return nil, err
 		}
+		expr.BindTimeZone(ve, loc)
+		v, err := ve.EvalValue(nil)
+		if err != nil {
+			return nil, err
+		}
+		return expr.CastValueIn(v, targetType, loc)
+	case schema.Blob:
+		return parseBlobLiteral(trimmedVal)
+	case schema.Image:
+		return readImage(strings.Trim(trimmedVal, "'\""), imageDir)
+	default:
+		return nil, fmt.Errorf("unsupported data type for coercion: %s", targetType)
 	}
-	
-	fmt.Printf("DEBUG: No image found for identifier: %s\n", identifier)
-	return ""
 }
 
 // Keep the original coerceValue for backward compatibility
 func coerceValue(valStr string, targetType schema.DataType) (interface{}, error) {
-	return coerceValueWithImages(valStr, targetType, "")
+	return coerceValueWithImages(valStr, targetType, "", time.Local)
 }
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+)
+
+func newDocsDB(t *testing.T) *schema.Database {
+	t.Helper()
+	return newTestDB(t, "docs (id INT, doc JSONB)",
+		`(1, '{"user": {"name": "ann", "id": 7}, "tags": ["a", "b"], "note": "it''s (fine)"}')`,
+		`(2, '{"user": {"name": "bob", "id": "8"}, "tags": ["b"]}')`,
+		`(3, '{"user": {"name": "cy", "id": 7}, "tags": []}')`,
+		`(4, NULL)`,
+	)
+}
+
+func TestJSONColumn(t *testing.T) {
+	db := newDocsDB(t)
+
+	data, err := os.ReadFile(filepath.Join(db.GetDBPath(), "docs.dat"))
+	if err != nil {
+		t.Fatalf("read data file: %v", err)
+	}
+	if !strings.Contains(string(data), `"doc":{"note":"it's (fine)","tags":["a","b"],"user":{"id":7,"name":"ann"}}`) {
+		t.Fatalf("expected the document stored nested:\n%s", data)
+	}
+
+	out := runSelect(t, db, "SELECT id, doc->'user'->>'name' AS name, JSON_ARRAY_LENGTH(doc->'tags') AS n FROM docs WHERE doc->'user'->'id' = 7 ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, "|") != "1 ann 2|3 cy 0" {
+		t.Fatalf("path query: got %q", got)
+	}
+
+	out = runSelect(t, db, "SELECT JSON_EXTRACT(doc, '$.user.id') AS uid, COUNT(*) AS n FROM docs GROUP BY JSON_EXTRACT(doc, '$.user.id') ORDER BY n DESC, uid;")
+	if got := dataLines(out); strings.Join(got, "|") != "7 2|8 1|NULL 1" {
+		t.Fatalf("GROUP BY a JSON path: got %q", got)
+	}
+
+	out = runSelect(t, db, "SELECT id, value FROM docs, JSON_EACH(doc->'tags') WHERE value = 'b' ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, "|") != `1 "b"|2 "b"` {
+		t.Fatalf("lateral JSON_EACH: got %q", got)
+	}
+
+	out = runSelect(t, db, `SELECT key, value, type FROM JSON_EACH('{"x": [1, 2], "y": null}');`)
+	if got := dataLines(out); strings.Join(got, "|") != "x [1,2] array|y null null" {
+		t.Fatalf("JSON_EACH of a literal: got %q", got)
+	}
+
+	for _, sql := range []string{
+		"INSERT INTO docs (id, doc) VALUES (5, '{oops}');",
+		"SELECT key FROM docs, JSON_EACH(nope);",
+		"SELECT id FROM docs, JSON_EACH(doc), JSON_EACH(doc);",
+	} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		handle := HandleSelect
+		if strings.HasPrefix(sql, "INSERT") {
+			handle = HandleInsert
+		}
+		if _, err := handle(cmd, db); err == nil {
+			t.Fatalf("expected an error for %q", sql)
+		}
+	}
+}
+
+func TestExpressionIndex(t *testing.T) {
+	db := newDocsDB(t)
+	out := runHandler(t, "CREATE INDEX docs_user_id ON docs (doc->'user'->>'id');", HandleCreateIndex, db)
+	if !strings.Contains(out, "docs_user_id") {
+		t.Fatalf("CREATE INDEX: %q", out)
+	}
+	indexPath := filepath.Join(db.GetDBPath(), "indexes", "docs", "docs_user_id.idx")
+	if _, err := os.Stat(indexPath); err != nil {
+		t.Fatalf("expected an index file: %v", err)
+	}
+
+	query := "SELECT id FROM docs WHERE doc->'user'->>'id' = 7 ORDER BY id;"
+	if got := dataLines(runSelect(t, db, query)); strings.Join(got, ",") != "1,3" {
+		t.Fatalf("indexed lookup: got %q", got)
+	}
+	// the index holds the text '8', which still equals the number 8
+	if got := dataLines(runSelect(t, db, "SELECT id FROM docs WHERE doc->'user'->>'id' = 8 AND id > 0;")); strings.Join(got, ",") != "2" {
+		t.Fatalf("indexed lookup across types: got %q", got)
+	}
+
+	// writes drop the index file; the next lookup rebuilds it
+	runHandler(t, `INSERT INTO docs (id, doc) VALUES (6, '{"user": {"id": 7}}');`, HandleInsert, db)
+	if _, err := os.Stat(indexPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the index file to be dropped by INSERT, got %v", err)
+	}
+	runHandler(t, "DELETE FROM docs WHERE id = 1;", HandleDelete, db)
+	if got := dataLines(runSelect(t, db, query)); strings.Join(got, ",") != "3,6" {
+		t.Fatalf("lookup after writes: got %q", got)
+	}
+	if _, err := os.Stat(indexPath); err != nil {
+		t.Fatalf("expected the index to be rebuilt: %v", err)
+	}
+
+	for _, sql := range []string{
+		"CREATE INDEX docs_user_id ON docs (id);",
+		"CREATE INDEX bad ON docs (nope);",
+		"CREATE INDEX bad ON docs (COUNT(*));",
+		"CREATE INDEX bad ON missing (id);",
+	} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if _, err := HandleCreateIndex(cmd, db); err == nil {
+			t.Fatalf("expected an error for %q", sql)
+		}
+	}
+
+	runHandler(t, "DROP INDEX docs_user_id;", HandleDropIndex, db)
+	if table, _ := db.GetTable("docs"); len(table.Indexes) != 0 {
+		t.Fatalf("index still in the schema: %+v", table.Indexes)
+	}
+	if _, err := os.Stat(indexPath); !os.IsNotExist(err) {
+		t.Fatalf("expected DROP INDEX to remove the file, got %v", err)
+	}
+	if got := dataLines(runSelect(t, db, query)); strings.Join(got, ",") != "3,6" {
+		t.Fatalf("lookup without the index: got %q", got)
+	}
+}
+
+func TestJSONColumn_BigNumbers(t *testing.T) {
+	db := newDocsDB(t)
+	runHandler(t, `INSERT INTO docs (id, doc) VALUES (5, '{"n": 12345678901234567890, "xs": [0.1000000000000000055511151231257827]}');`, HandleInsert, db)
+	out := runSelect(t, db, "SELECT doc, doc->'n' FROM docs WHERE id = 5;")
+	if got := dataLines(out); len(got) != 1 || got[0] != `{"n":12345678901234567890,"xs":[0.1000000000000000055511151231257827]} 12345678901234567890` {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// HandleMigrate processes a MIGRATE command. MIGRATE NULLS [table] converts
+// the "NULL" strings older versions stored in place of NULL into real NULLs,
+// in one table or in every table of the database. MIGRATE IMAGES [table]
+// copies the files older versions referenced by path from IMAGE columns into
+// the blob store.
+func HandleMigrate(cmd parser.Command, db *schema.Database) (string, error) {
+	tokens := cmd.Tokens
+	if len(tokens) < 2 || len(tokens) > 3 || !strings.EqualFold(tokens[1], "NULLS") && !strings.EqualFold(tokens[1], "IMAGES") {
+		return "", fmt.Errorf("invalid MIGRATE syntax. Example: MIGRATE NULLS; or MIGRATE IMAGES table_name;")
+	}
+
+	tables := db.GetAllTableNames()
+	sort.Strings(tables)
+	if len(tokens) == 3 {
+		table, exists := db.GetTable(tokens[2])
+		if !exists {
+			return "", fmt.Errorf("table '%s' does not exist", tokens[2])
+		}
+		tables = []string{table.Name}
+	}
+
+	if strings.EqualFold(tokens[1], "IMAGES") {
+		return migrateImages(db, tables)
+	}
+
+	rows := [][]interface{}{}
+	for _, name := range tables {
+		table, _ := db.GetTable(name)
+		tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+		if err != nil {
+			return "", fmt.Errorf("error accessing table file: %s", err)
+		}
+		n, err := storage.MigrateLegacyNulls(tableFile)
+		if err != nil {
+			return "", err
+		}
+		rows = append(rows, []interface{}{name, n})
+	}
+	return formatResult([]string{"table", "converted"}, rows), nil
+}
+
+// migrateImages replaces the file paths stored in the IMAGE columns of
+// tables by references to copies of the files in the blob store. Paths whose
+// file is gone are left as they are and counted as missing.
+func migrateImages(db *schema.Database, tables []string) (string, error) {
+	out := [][]interface{}{}
+	for _, name := range tables {
+		table, _ := db.GetTable(name)
+		tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+		if err != nil {
+			return "", fmt.Errorf("error accessing table file: %s", err)
+		}
+		converted, missing := 0, 0
+		var added []storage.BlobRef
+		loc := expr.SessionTimeZone(db)
+		_, err = tableFile.Update(func(line int, row storage.Row) (bool, error) {
+			decodeRows([]storage.Row{row}, table.Columns, loc)
+			changed := false
+			for _, c := range table.Columns {
+				path, ok := row[c.Name].(string)
+				if c.Type != schema.Image || !ok {
+					continue
+				}
+				data, err := readImage(path, "")
+				if err != nil {
+					missing++
+					continue
+				}
+				row[c.Name] = data
+				converted++
+				changed = true
+			}
+			if !changed {
+				return false, nil
+			}
+			refs, err := putBlobs(db, row)
+			added = append(added, refs...)
+			if err != nil {
+				return false, fmt.Errorf("failed to store blob: %s", err)
+			}
+			return true, nil
+		})
+		if err != nil {
+			releaseBlobs(db, added)
+			return "", fmt.Errorf("failed to migrate images in '%s': %s", name, err)
+		}
+		out = append(out, []interface{}{name, converted, missing})
+	}
+	return formatResult([]string{"table", "converted", "missing"}, out), nil
+}
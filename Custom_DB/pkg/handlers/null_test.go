@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func runHandler(t *testing.T, sql string, handle func(parser.Command, *schema.Database) (string, error), db *schema.Database) string {
+	t.Helper()
+	cmd, err := parser.Parse(sql)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	out, err := handle(cmd, db)
+	if err != nil {
+		t.Fatalf("%q failed: %v", sql, err)
+	}
+	return out
+}
+
+func TestNullInsertAndPredicates(t *testing.T) {
+	db := newOrdersDB(t)
+	runHandler(t, "INSERT INTO orders (id, item, price, qty) VALUES (4, NULL, NULL, 2);", HandleInsert, db)
+	runHandler(t, "INSERT INTO orders (id, item, price, qty) VALUES (5, 'NULL', 3, 1);", HandleInsert, db)
+
+	data, err := os.ReadFile(filepath.Join(db.GetDBPath(), "orders.dat"))
+	if err != nil {
+		t.Fatalf("read data file: %v", err)
+	}
+	if !strings.Contains(string(data), `"item":null`) || !strings.Contains(string(data), `"item":"NULL"`) {
+		t.Fatalf("expected a JSON null and a 'NULL' string stored:\n%s", data)
+	}
+
+	out := runSelect(t, db, "SELECT id FROM orders WHERE item IS NULL;")
+	if got := dataLines(out); len(got) != 1 || got[0] != "4" {
+		t.Fatalf("IS NULL: got %q", got)
+	}
+	// a comparison with NULL is unknown, so neither side of it matches row 4
+	out = runSelect(t, db, "SELECT id FROM orders WHERE price > 2 OR NOT price > 2 ORDER BY id;")
+	if got := dataLines(out); len(got) != 4 || strings.Contains(out, "\n4") {
+		t.Fatalf("comparison with NULL should not match: got %q", got)
+	}
+	out = runSelect(t, db, "SELECT COUNT(*) AS n, COUNT(price) AS priced, SUM(price) AS total FROM orders WHERE id > 3 AND item IS NULL;")
+	if got := dataLines(out); len(got) != 1 || got[0] != "1 0 NULL" {
+		t.Fatalf("aggregates over NULL: got %q", got)
+	}
+
+	out = runHandler(t, "DELETE FROM orders WHERE price IS NULL;", HandleDelete, db)
+	if !strings.Contains(out, "1 row(s)") {
+		t.Fatalf("expected one row deleted, got: %s", out)
+	}
+	out = runHandler(t, "DELETE FROM orders WHERE price = NULL;", HandleDelete, db)
+	if !strings.Contains(out, "0 row(s)") {
+		t.Fatalf("= NULL should match nothing, got: %s", out)
+	}
+}
+
+func TestMigrateLegacyNulls(t *testing.T) {
+	db := newOrdersDB(t)
+	// rows written by older versions stored NULL as a string
+	path := filepath.Join(db.GetDBPath(), "orders.dat")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open data file: %v", err)
+	}
+	if _, err := f.WriteString(`{"id":9,"item":"NULL","price":"NULL","qty":1}` + "\n"); err != nil {
+		t.Fatalf("write legacy row: %v", err)
+	}
+	f.Close()
+
+	out := runHandler(t, "MIGRATE NULLS orders;", HandleMigrate, db)
+	if got := dataLines(out); len(got) != 1 || got[0] != "orders 2" {
+		t.Fatalf("unexpected migration report: %q", got)
+	}
+	out = runSelect(t, db, "SELECT id FROM orders WHERE item IS NULL AND price IS NULL;")
+	if got := dataLines(out); len(got) != 1 || got[0] != "9" {
+		t.Fatalf("migrated row not NULL: got %q", got)
+	}
+	tf, err := storage.NewTableFile(db.GetDBPath(), "orders")
+	if err != nil {
+		t.Fatalf("new table file: %v", err)
+	}
+	if n, err := tf.MigrateLegacyNulls(); err != nil || n != 0 {
+		t.Fatalf("second migration: got %d, %v", n, err)
+	}
+
+	for _, sql := range []string{"MIGRATE;", "MIGRATE NULLS nosuch;"} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if _, err := HandleMigrate(cmd, db); err == nil {
+			t.Fatalf("expected error for %q", sql)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+)
+
+func newLedgerDB(t *testing.T) *schema.Database {
+	t.Helper()
+	return newTestDB(t, "ledger (id BIGINT, qty SMALLINT, amount NUMERIC(10, 2), rate REAL, score DOUBLE PRECISION)",
+		"(9007199254740993, 1, 0.10, 0.5, 1.25)",
+		"(9007199254740992, 2, 0.1, 0.25, 2.5)",
+		"(1, 3, '0.105', 0.125, 0.25)",
+	)
+}
+
+func TestNumericColumns(t *testing.T) {
+	db := newLedgerDB(t)
+
+	data, err := os.ReadFile(filepath.Join(db.GetDBPath(), "ledger.dat"))
+	if err != nil {
+		t.Fatalf("read data file: %v", err)
+	}
+	if !strings.Contains(string(data), `"id":9007199254740993`) || !strings.Contains(string(data), `"amount":"0.11"`) {
+		t.Fatalf("expected an exact BIGINT and a rounded NUMERIC stored:\n%s", data)
+	}
+
+	out := runSelect(t, db, "SELECT id, amount FROM ledger WHERE id = 9007199254740993;")
+	if got := dataLines(out); len(got) != 1 || got[0] != "9007199254740993 0.10" {
+		t.Fatalf("BIGINT lookup: got %q", got)
+	}
+
+	out = runSelect(t, db, "SELECT SUM(amount) AS total, AVG(amount) AS mean, SUM(qty) AS n, AVG(qty) AS avg_qty, SUM(rate) AS r, SUM(score) AS s FROM ledger;")
+	want := "0.31 0.1033333333333333 6 2.0000000000000000 0.875 4"
+	if got := dataLines(out); len(got) != 1 || got[0] != want {
+		t.Fatalf("aggregates: got %q, want %q", got, want)
+	}
+
+	out = runSelect(t, db, "SELECT amount, COUNT(*) AS n FROM ledger GROUP BY amount ORDER BY amount;")
+	if got := dataLines(out); strings.Join(got, "|") != "0.10 2|0.11 1" {
+		t.Fatalf("GROUP BY NUMERIC: got %q", got)
+	}
+
+	runHandler(t, "UPDATE ledger SET amount = amount / 3 WHERE qty = 1;", HandleUpdate, db)
+	out = runSelect(t, db, "SELECT amount FROM ledger WHERE qty = 1;")
+	if got := dataLines(out); len(got) != 1 || got[0] != "0.03" {
+		t.Fatalf("UPDATE rounds to the column scale: got %q", got)
+	}
+
+	for sql, msg := range map[string]string{
+		"INSERT INTO ledger (id, qty) VALUES (4, 40000);":              "smallint out of range",
+		"INSERT INTO ledger (id) VALUES (99999999999999999999);":       "bigint out of range",
+		"INSERT INTO ledger (id, amount) VALUES (5, 123456789.5);":     "numeric field overflow",
+		"INSERT INTO ledger (id, rate) VALUES (6, 1e39);":              "value out of range",
+		"UPDATE ledger SET qty = qty * 20000;":                         "smallint out of range",
+		"UPDATE ledger SET id = id * 9007199254740993 WHERE id > 100;": "bigint out of range",
+	} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		handle := HandleInsert
+		if strings.HasPrefix(sql, "UPDATE") {
+			handle = HandleUpdate
+		}
+		if _, err := handle(cmd, db); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%q: got error %v, want %q", sql, err, msg)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/storage"
+)
+
+// sortKey is one ORDER BY key: an output column or an expression evaluated
+// against the rows being sorted.
+type sortKey struct {
+	text       string
+	col        string         // row key to read when expr is nil
+	expr       expr.ValueExpr // computed key
+	kind       expr.Kind      // how values are compared
+	desc       bool
+	nullsFirst bool
+	collation  expr.Collation
+}
+
+// value returns the key's value for row r.
+func (k *sortKey) value(r storage.Row) (interface{}, error) {
+	if k.expr != nil {
+		return k.expr.EvalValue(r)
+	}
+	return r[k.col], nil
+}
+
+// orderTarget resolves the text of one ORDER BY key, after its modifiers
+// have been removed, to the column or expression to sort on.
+type orderTarget func(text string, ordinal int) (sortKey, error)
+
+// parseOrderBy parses the tokens following ORDER BY: a comma-separated list
+// of keys, each an expression, projection alias or 1-based projection
+// ordinal, optionally followed by ASC or DESC, NULLS FIRST or NULLS LAST and
+// COLLATE name. NULLs sort last ascending and first descending by default.
+func parseOrderBy(tokens []string, resolve orderTarget) ([]sortKey, error) {
+	keys := []sortKey{}
+	for _, item := range splitTopLevel(tokens) {
+		if len(item) == 0 {
+			return nil, fmt.Errorf("empty ORDER BY key")
+		}
+		desc, nullsSet, nullsFirst := false, false, false
+		collation := expr.CollateBinary
+		// modifiers are peeled off the end of the key
+		for len(item) > 1 {
+			last := strings.ToUpper(item[len(item)-1])
+			prev := strings.ToUpper(item[len(item)-2])
+			switch {
+			case (last == "FIRST" || last == "LAST") && prev == "NULLS":
+				nullsSet, nullsFirst = true, last == "FIRST"
+				item = item[:len(item)-2]
+				continue
+			case prev == "COLLATE":
+				c, err := expr.ParseCollation(item[len(item)-1])
+				if err != nil {
+					return nil, err
+				}
+				collation = c
+				item = item[:len(item)-2]
+				continue
+			case last == "DESC":
+				desc = true
+				item = item[:len(item)-1]
+				continue
+			case last == "ASC":
+				item = item[:len(item)-1]
+				continue
+			}
+			break
+		}
+		if !nullsSet {
+			nullsFirst = desc
+		}
+		text := joinExprTokens(item)
+		ordinal := 0
+		if n, err := strconv.Atoi(text); err == nil {
+			ordinal = n
+		}
+		k, err := resolve(text, ordinal)
+		if err != nil {
+			return nil, err
+		}
+		k.text, k.desc, k.nullsFirst, k.collation = text, desc, nullsFirst, collation
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// sortRows orders rows by keys. Each key's values are computed once and
+// compared as the key's kind; the sort is stable.
+func sortRows(rows []storage.Row, keys []sortKey) error {
+	if len(keys) == 0 || len(rows) < 2 {
+		return nil
+	}
+	vals := make([][]interface{}, len(rows))
+	for i, r := range rows {
+		vals[i] = make([]interface{}, len(keys))
+		for j := range keys {
+			v, err := keys[j].value(r)
+			if err != nil {
+				return err
+			}
+			vals[i][j] = v
+		}
+	}
+	idx := make([]int, len(rows))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		for j, k := range keys {
+			c := compareKey(vals[idx[a]][j], vals[idx[b]][j], k)
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	sorted := make([]storage.Row, len(rows))
+	for i, k := range idx {
+		sorted[i] = rows[k]
+	}
+	copy(rows, sorted)
+	return nil
+}
+
+// compareKey orders two values of one key, applying its direction and NULL
+// placement.
+func compareKey(a, b interface{}, k sortKey) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		if k.nullsFirst {
+			return -1
+		}
+		return 1
+	case b == nil:
+		if k.nullsFirst {
+			return 1
+		}
+		return -1
+	}
+	c := expr.CompareAs(a, b, k.kind, k.collation)
+	if k.desc {
+		return -c
+	}
+	return c
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// A large table is scanned in parallel. Its data file is split into
+// morsels, byte ranges of morselSize, and a pool of workers each takes the
+// next morsel, runs the part of the plan below a Gather node over its rows
+// and hands the result to the Gather, which yields the morsels' rows in
+// file order. Filters, table function joins and, when nothing sorts or
+// groups the rows first, the SELECT list are computed by the workers. A
+// query with a LIMIT but no ORDER BY or grouping is scanned serially, as it
+// may need only the first rows. An
+// aggregation whose aggregates can merge their state is split in two: the
+// workers group the rows of each morsel, and the groups of all the morsels
+// are merged above the Gather.
+
+// parallelWorkers is the number of workers a scan may be split across in
+// a session that has not SET PARALLEL_WORKERS; 1 runs every query serially.
+var parallelWorkers = runtime.NumCPU()
+
+// sessionParallelWorkers returns the number of workers a scan may be split
+// across in the session of db.
+func sessionParallelWorkers(db *schema.Database) int {
+	if v, ok := db.Setting("parallel_workers"); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return parallelWorkers
+}
+
+// morselSize is the bytes of the data file a worker reads at a time. A
+// table of one morsel is scanned serially.
+var morselSize int64 = 1 << 20
+
+// maxParallelWorkers is the most workers SET PARALLEL_WORKERS accepts.
+const maxParallelWorkers = 256
+
+// gatherNode runs its input over each morsel of a table on a pool of
+// workers and yields the rows in the order of the morsels.
+type gatherNode struct {
+	in      planNode
+	scan    *scanNode // the scan the morsels are of, below in
+	workers int
+}
+
+func (n *gatherNode) describe() string { return fmt.Sprintf("Gather (workers: %d)", n.workers) }
+
+func (n *gatherNode) inputs() []planNode { return []planNode{n.in} }
+
+func (n *gatherNode) open(c *execContext) (rowIter, error) {
+	morsels, err := n.scan.tableFile.Morsels(morselSize)
+	if err != nil {
+		return nil, err
+	}
+	g := &gatherIter{
+		morsels: morsels,
+		results: make([]*morselResult, len(morsels)),
+		tokens:  make(chan struct{}, 2*n.workers),
+		freed:   make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		mem:     c.mem,
+	}
+	g.openMorsel = func(m storage.Morsel) (rowIter, map[planNode]*nodeStats, error) {
+		// the operators of a worker hold at most a morsel's rows, outside
+		// the budget; the rows it hands on are charged to it
+		wc := &execContext{db: c.db, morsel: &m}
+		if c.stats != nil {
+			wc.stats = map[planNode]*nodeStats{}
+		}
+		it, err := wc.open(n.in)
+		return it, wc.stats, err
+	}
+	if c.stats != nil {
+		g.stats, g.into = map[planNode]*nodeStats{}, c.stats
+	}
+	for i := range g.results {
+		g.results[i] = &morselResult{done: make(chan struct{})}
+	}
+	g.start(n.workers)
+	return g, nil
+}
+
+// gatherIter runs the workers of a gatherNode. Workers get at most twice
+// as many morsels ahead of the one the Gather is yielding as there are
+// workers, which bounds the rows waiting to be yielded. The rows waiting
+// are charged to the query's budget, and while it is exceeded no morsel is
+// handed out but the one the Gather is waiting for.
+type gatherIter struct {
+	morsels    []storage.Morsel
+	openMorsel func(m storage.Morsel) (rowIter, map[planNode]*nodeStats, error)
+	results    []*morselResult
+	tokens     chan struct{} // one per morsel handed out and not yet yielded
+	freed      chan struct{} // signalled when a morsel's rows are released
+	quit       chan struct{} // closed when the Gather is closed
+	mem        *memBudget
+	workers    sync.WaitGroup
+	next       int   // the morsel whose rows are yielded next
+	held       int64 // the bytes charged for rows, the morsel's being yielded
+	rows       []storage.Row
+	closed     bool
+
+	// for EXPLAIN ANALYZE: the counts of the nodes the workers ran, summed
+	// over the morsels, and where Close adds them
+	mu    sync.Mutex
+	stats map[planNode]*nodeStats
+	into  map[planNode]*nodeStats
+}
+
+// morselResult is the rows a worker produced from one morsel and the bytes
+// charged for them; done is closed once they are all there.
+type morselResult struct {
+	rows  []storage.Row
+	bytes int64
+	err   error
+	done  chan struct{}
+}
+
+func (g *gatherIter) start(workers int) {
+	work := make(chan int)
+	g.workers.Add(1)
+	go func() {
+		defer g.workers.Done()
+		defer close(work)
+		for i := range g.morsels {
+			select {
+			case g.tokens <- struct{}{}:
+			case <-g.quit:
+				return
+			}
+			// over budget, wait until the morsels ahead are yielded
+			for !g.mem.within() && len(g.tokens) > 1 {
+				select {
+				case <-g.freed:
+				case <-g.quit:
+					return
+				}
+			}
+			select {
+			case work <- i:
+			case <-g.quit:
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		g.workers.Add(1)
+		go func() {
+			defer g.workers.Done()
+			for i := range work {
+				g.run(i)
+			}
+		}()
+	}
+}
+
+// run computes the rows of morsel i.
+func (g *gatherIter) run(i int) {
+	res := g.results[i]
+	defer close(res.done)
+	it, stats, err := g.openMorsel(g.morsels[i])
+	if err != nil {
+		res.err = err
+		return
+	}
+	defer it.Close()
+	for {
+		select {
+		case <-g.quit:
+			return
+		default:
+		}
+		r, err := it.Next()
+		if err != nil {
+			res.err = err
+			return
+		}
+		if r == nil {
+			break
+		}
+		n := rowBytes(r)
+		g.mem.grow(n)
+		res.bytes += n
+		res.rows = append(res.rows, r)
+	}
+	if stats != nil {
+		g.mu.Lock()
+		for n, s := range stats {
+			t := g.stats[n]
+			if t == nil {
+				t = &nodeStats{}
+				g.stats[n] = t
+			}
+			t.rows += s.rows
+			t.elapsed += s.elapsed
+		}
+		g.mu.Unlock()
+	}
+}
+
+func (g *gatherIter) Next() (storage.Row, error) {
+	for len(g.rows) == 0 {
+		g.mem.release(g.held)
+		g.held = 0
+		if g.next == len(g.results) {
+			return nil, nil
+		}
+		res := g.results[g.next]
+		<-res.done
+		if res.err != nil {
+			return nil, res.err
+		}
+		g.rows, g.held = res.rows, res.bytes
+		g.results[g.next] = nil
+		g.next++
+		// a worker may start on another morsel
+		<-g.tokens
+		select {
+		case g.freed <- struct{}{}:
+		default:
+		}
+	}
+	r := g.rows[0]
+	g.rows = g.rows[1:]
+	return r, nil
+}
+
+// Close stops the workers and waits for them.
+func (g *gatherIter) Close() error {
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+	close(g.quit)
+	g.workers.Wait()
+	g.mem.release(g.held)
+	g.held = 0
+	for _, res := range g.results {
+		if res != nil {
+			g.mem.release(res.bytes)
+		}
+	}
+	for n, s := range g.stats {
+		g.into[n] = s
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+)
+
+// setTestParallelism runs the scans of a test on workers over small morsels.
+func setTestParallelism(t *testing.T, workers int, morsel int64) {
+	t.Helper()
+	oldWorkers, oldMorsel := parallelWorkers, morselSize
+	parallelWorkers, morselSize = workers, morsel
+	t.Cleanup(func() { parallelWorkers, morselSize = oldWorkers, oldMorsel })
+}
+
+func TestParallel_SameResults(t *testing.T) {
+	db := newBigOrdersDB(t)
+	queries := []string{
+		"SELECT * FROM orders;",
+		"SELECT id, qty * 2 AS double FROM orders WHERE item LIKE 'item-01%';",
+		"SELECT id FROM orders WHERE qty > 5 LIMIT 7 OFFSET 100;",
+		"SELECT DISTINCT qty FROM orders;",
+		"SELECT item, COUNT(*), SUM(qty), AVG(price), MAX(id) FROM orders GROUP BY item;",
+		"SELECT qty, STRING_AGG(item, ','), ARRAY_AGG(id) FROM orders WHERE id < 500 GROUP BY qty;",
+		"SELECT COUNT(*), MIN(item), BOOL_AND(qty < 13) FROM orders;",
+		"SELECT item, qty, COUNT(*) FROM orders GROUP BY ROLLUP (item, qty) HAVING COUNT(*) > 1;",
+		"SELECT qty, COUNT(DISTINCT item) FROM orders GROUP BY qty ORDER BY qty DESC;",
+		"SELECT COUNT(*) FROM orders WHERE id > 5000;",
+	}
+	setTestParallelism(t, 1, morselSize)
+	want := map[string]string{}
+	for _, q := range queries {
+		want[q] = runHandler(t, q, HandleSelect, db)
+	}
+	setTestParallelism(t, 4, 4<<10)
+	for _, q := range queries {
+		if got := runHandler(t, q, HandleSelect, db); got != want[q] {
+			t.Errorf("%s: parallel result differs\ngot:\n%.500s\nwant:\n%.500s", q, got, want[q])
+		}
+	}
+}
+
+func TestParallel_Explain(t *testing.T) {
+	db := newBigOrdersDB(t)
+	setTestParallelism(t, 4, 4<<10)
+	cases := map[string]string{
+		"EXPLAIN SELECT id FROM orders WHERE qty = 3;": "" +
+			"Gather (workers: 4)\n" +
+			"-> Project id\n" +
+			"  -> Filter WHERE qty = 3\n" +
+			"    -> Parallel Seq Scan on orders (columns: id, qty)\n",
+		"EXPLAIN SELECT item, COUNT(*) FROM orders GROUP BY item;": "" +
+			"Project item, count\n" +
+			"-> Finalize Group By item: COUNT(*)\n" +
+			"  -> Gather (workers: 4)\n" +
+			"    -> Partial Group By item: COUNT(*)\n" +
+			"      -> Parallel Seq Scan on orders (columns: item)\n",
+		"EXPLAIN SELECT item, COUNT(DISTINCT qty) FROM orders GROUP BY item ORDER BY item;": "" +
+			"Project item, count_distinct_qty\n" +
+			"-> Sort by item\n" +
+			"  -> Group By item: COUNT(DISTINCT qty)\n" +
+			"    -> Gather (workers: 4)\n" +
+			"      -> Parallel Seq Scan on orders (columns: item, qty)\n",
+	}
+	for sql, want := range cases {
+		if got := runHandler(t, sql, HandleExplain, db); got != want {
+			t.Errorf("%s\ngot:\n%s\nwant:\n%s", sql, got, want)
+		}
+	}
+
+	out := runHandler(t, "EXPLAIN ANALYZE SELECT id FROM orders WHERE qty = 3;", HandleExplain, db)
+	for _, want := range []string{
+		"Gather (workers: 4) (rows=230 time=",
+		"-> Filter WHERE qty = 3 (rows=230 time=",
+		"-> Parallel Seq Scan on orders (columns: id, qty) (rows=3000 time=",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+
+	// a LIMIT without ORDER BY stops a serial scan early; with one the
+	// scan is still split
+	if out := runHandler(t, "EXPLAIN SELECT id FROM orders LIMIT 10;", HandleExplain, db); strings.Contains(out, "Gather") {
+		t.Errorf("expected a serial plan:\n%s", out)
+	}
+	if out := runHandler(t, "EXPLAIN SELECT id FROM orders ORDER BY qty LIMIT 10;", HandleExplain, db); !strings.Contains(out, "Gather") {
+		t.Errorf("expected a parallel plan:\n%s", out)
+	}
+
+	// one worker, or a table of one morsel, is scanned serially
+	setTestParallelism(t, 1, 4<<10)
+	if out := runHandler(t, "EXPLAIN SELECT id FROM orders;", HandleExplain, db); strings.Contains(out, "Gather") {
+		t.Errorf("expected a serial plan:\n%s", out)
+	}
+	setTestParallelism(t, 4, 1<<30)
+	if out := runHandler(t, "EXPLAIN SELECT id FROM orders;", HandleExplain, db); strings.Contains(out, "Gather") {
+		t.Errorf("expected a serial plan:\n%s", out)
+	}
+}
+
+func TestParallel_GatherBudget(t *testing.T) {
+	db := newBigOrdersDB(t)
+	setTestParallelism(t, 4, 4<<10)
+	cmd, err := parser.Parse("SELECT * FROM orders;")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	q, err := parseSelect(cmd, db)
+	if err != nil {
+		t.Fatalf("parse select: %v", err)
+	}
+	plan, err := planSelect(q, db)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if _, ok := plan.(*gatherNode); !ok {
+		t.Fatalf("expected a Gather, got %s", plan.describe())
+	}
+	c := newExecContext(db)
+	c.mem.limit = 1
+	it, err := c.open(plan)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	rows := 0
+	for {
+		r, err := it.Next()
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		if r == nil {
+			break
+		}
+		if rows++; rows == 1 && c.mem.within() {
+			t.Error("the rows of the workers are not charged to the budget")
+		}
+	}
+	it.Close()
+	if rows != 3000 {
+		t.Errorf("got %d rows, want 3000", rows)
+	}
+	if c.mem.used != 0 {
+		t.Errorf("%d bytes still charged after the Gather closed", c.mem.used)
+	}
+}
+
+func TestHandleSet_ParallelWorkers(t *testing.T) {
+	db := newOrdersDB(t)
+	cmd, _ := parser.Parse("SET PARALLEL_WORKERS TO 3;")
+	if got, err := HandleSet(cmd, db); err != nil || got != "✅ Parallel workers set to 3" || sessionParallelWorkers(db) != 3 {
+		t.Errorf("got %q, %v (workers %d)", got, err, sessionParallelWorkers(db))
+	}
+	for _, sql := range []string{"SET PARALLEL_WORKERS 0;", "SET PARALLEL_WORKERS many;", "SET PARALLEL_WORKERS;"} {
+		cmd, _ := parser.Parse(sql)
+		if _, err := HandleSet(cmd, db); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
+
+// TestHandleSet_PerSession checks that WORK_MEM and PARALLEL_WORKERS set in
+// one session leave the others as they were.
+func TestHandleSet_PerSession(t *testing.T) {
+	db := newBigOrdersDB(t)
+	setTestParallelism(t, 4, 4<<10)
+	s1, s2 := db.Session("one"), db.Session("two")
+	for _, sql := range []string{"SET PARALLEL_WORKERS 1;", "SET WORK_MEM '64kB';"} {
+		runHandler(t, sql, HandleSet, s1)
+	}
+	if sessionParallelWorkers(s2) != 4 || sessionWorkMem(s2) != workMem || sessionWorkMem(s1) != 64<<10 {
+		t.Errorf("workers %d, %d; work_mem %d, %d", sessionParallelWorkers(s1), sessionParallelWorkers(s2), sessionWorkMem(s1), sessionWorkMem(s2))
+	}
+	if out := runHandler(t, "EXPLAIN SELECT id FROM orders;", HandleExplain, s1); strings.Contains(out, "Gather") {
+		t.Errorf("expected a serial plan in the session that set one worker:\n%s", out)
+	}
+	if out := runHandler(t, "EXPLAIN SELECT id FROM orders;", HandleExplain, s2); !strings.Contains(out, "Gather (workers: 4)") {
+		t.Errorf("expected a parallel plan in the other session:\n%s", out)
+	}
+}
@@ -0,0 +1,749 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/stats"
+	"Custom_DB/pkg/storage"
+)
+
+// A SELECT is planned before it runs. planSelect builds a tree of plan
+// nodes from the parsed query, the logical plan, then rewrites it:
+//
+//   - constant parts of expressions are computed once (expr.Fold), and a
+//     WHERE that can never hold reads no rows at all;
+//   - WHERE conditions over the table alone are applied right above its
+//     scan, below a table function join, the ones that pass the fewest rows
+//     by the table's statistics first (see ANALYZE);
+//   - a condition of the form indexed-expression = constant turns the scan
+//     into an index scan;
+//   - the scan keeps only the columns the query uses;
+//   - a LIMIT over an ORDER BY becomes a top-N sort that holds only the
+//     rows that can still make the limit;
+//   - the scan of a large table runs on parallel workers, with what can be
+//     computed row by row above it (see parallel.go).
+//
+// Each node opens as one operator of the execution tree in exec.go. EXPLAIN
+// prints the tree with the rows each node is estimated to yield when the
+// table has statistics; EXPLAIN ANALYZE runs it and adds what each node did.
+
+// planNode is a node of a query plan.
+type planNode interface {
+	// describe returns the one-line text EXPLAIN shows for the node.
+	describe() string
+	// inputs returns the nodes the node reads rows from.
+	inputs() []planNode
+	// open returns the operator executing the node.
+	open(c *execContext) (rowIter, error)
+}
+
+// execContext is what opening a plan needs: the database and the memory
+// budget of the query, and for a parallel worker the morsel of the table
+// it reads. With stats set every operator is counted and timed for
+// EXPLAIN ANALYZE.
+type execContext struct {
+	db     *schema.Database
+	mem    *memBudget
+	morsel *storage.Morsel
+	stats  map[planNode]*nodeStats
+}
+
+func newExecContext(db *schema.Database) *execContext {
+	return &execContext{db: db, mem: newMemBudget(db.GetDBPath(), sessionWorkMem(db))}
+}
+
+// open opens the operator of n.
+func (c *execContext) open(n planNode) (rowIter, error) {
+	if c.stats == nil {
+		return n.open(c)
+	}
+	s := &nodeStats{}
+	c.stats[n] = s
+	start := time.Now()
+	it, err := n.open(c)
+	s.elapsed += time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	s.op = it
+	return &analyzeIter{in: it, stats: s}, nil
+}
+
+// nodeStats is what EXPLAIN ANALYZE reports for a node: the rows it
+// yielded and the time spent in it and its inputs. Below a Gather both are
+// summed over the workers.
+type nodeStats struct {
+	rows    int
+	elapsed time.Duration
+	op      rowIter
+}
+
+// analyzeIter counts and times the rows of an operator.
+type analyzeIter struct {
+	in    rowIter
+	stats *nodeStats
+}
+
+func (a *analyzeIter) Next() (storage.Row, error) {
+	start := time.Now()
+	r, err := a.in.Next()
+	a.stats.elapsed += time.Since(start)
+	if r != nil {
+		a.stats.rows++
+	}
+	return r, err
+}
+
+func (a *analyzeIter) Close() error { return a.in.Close() }
+
+// scanNode reads every row of a table. columns lists the columns the query
+// uses, or is nil when it uses them all. A parallel scan reads the morsel
+// of the worker opening it. A scan of a columnar table reads only those
+// columns, and skips the segments whose zone maps rule out cond, the
+// WHERE conditions on the table.
+type scanNode struct {
+	tableFile  storage.Table
+	table      schema.Table
+	tableStats *stats.TableStats // nil when the table has not been analyzed
+	columns    []string
+	cond       expr.Expr
+	parallel   bool
+}
+
+func (n *scanNode) describe() string {
+	s := "Seq Scan on " + n.table.Name + describeColumns(n.columns)
+	if n.table.Format == schema.FormatColumnar {
+		s = "Columnar Scan on " + n.table.Name + describeColumns(n.columns)
+	}
+	if n.parallel {
+		s = "Parallel " + s
+	}
+	return s
+}
+
+func (n *scanNode) inputs() []planNode { return nil }
+
+func (n *scanNode) open(c *execContext) (rowIter, error) {
+	var m *storage.Morsel
+	if n.parallel {
+		m = c.morsel
+	}
+	rows, err := n.tableFile.Scan(m, storage.ScanOptions{Columns: n.columns, Skip: zoneSkip(n.table, n.cond)})
+	if err != nil {
+		return nil, err
+	}
+	return &scanIter{rows: rows, columns: keptColumns(n.table.Columns, n.columns), keep: keepSet(n.columns), loc: expr.SessionTimeZone(c.db)}, nil
+}
+
+// zoneSkip returns the function a scan of a columnar table skips segments
+// by: true for those whose zone maps rule out cond. Bounds are only used
+// for columns whose values compare as stored, numbers in integer and
+// DOUBLE columns and strings in TEXT ones.
+func zoneSkip(table schema.Table, cond expr.Expr) func(zones map[string]storage.Zone) bool {
+	if cond == nil {
+		return nil
+	}
+	return func(zones map[string]storage.Zone) bool {
+		return expr.ZoneExcludes(cond, func(name string) (expr.ZoneRange, bool) {
+			z, ok := zones[name]
+			col, known := getColumnDefinition(table.Columns, name)
+			if !ok || !known {
+				return expr.ZoneRange{}, false
+			}
+			r := expr.ZoneRange{Nulls: z.Nulls, Rows: z.Rows}
+			switch col.Type.Base() {
+			case schema.Integer, schema.SmallInt, schema.BigInt, schema.Double:
+				if _, text := z.Min.(string); !text && z.Min != nil {
+					r.Min, r.Max = expr.DecodeValue(z.Min, col.Type, time.UTC), expr.DecodeValue(z.Max, col.Type, time.UTC)
+				}
+			case schema.Text:
+				if _, text := z.Min.(string); text {
+					r.Min, r.Max = z.Min, z.Max
+				}
+			}
+			return r, true
+		})
+	}
+}
+
+// indexScanNode reads the rows of a table an index files under the value
+// of a condition; the condition itself is still applied above it.
+type indexScanNode struct {
+	tableFile  storage.Table
+	table      schema.Table
+	tableStats *stats.TableStats
+	index      schema.Index
+	cond       string // the condition the index answers
+	lines      []int  // data file lines to read
+	columns    []string
+}
+
+func (n *indexScanNode) describe() string {
+	return fmt.Sprintf("Index Scan on %s using %s (%s)%s", n.table.Name, n.index.Name, n.cond, describeColumns(n.columns))
+}
+
+func (n *indexScanNode) inputs() []planNode { return nil }
+
+func (n *indexScanNode) open(c *execContext) (rowIter, error) {
+	rows, err := n.tableFile.ReadRowsAt(n.lines)
+	if err != nil {
+		return nil, err
+	}
+	if keep := keepSet(n.columns); keep != nil {
+		for _, r := range rows {
+			pruneRow(r, keep)
+		}
+	}
+	decodeRows(rows, keptColumns(n.table.Columns, n.columns), expr.SessionTimeZone(c.db))
+	return &sliceIter{rows: rows}, nil
+}
+
+// emptyNode yields no rows, for a WHERE that is never true.
+type emptyNode struct{}
+
+func (n *emptyNode) describe() string { return "Empty Result (WHERE is never true)" }
+
+func (n *emptyNode) inputs() []planNode { return nil }
+
+func (n *emptyNode) open(c *execContext) (rowIter, error) { return &sliceIter{}, nil }
+
+// oneRowNode yields one row with no columns, which a table function called
+// on its own is joined to.
+type oneRowNode struct{}
+
+func (n *oneRowNode) describe() string { return "Result (one row)" }
+
+func (n *oneRowNode) inputs() []planNode { return nil }
+
+func (n *oneRowNode) open(c *execContext) (rowIter, error) {
+	return &sliceIter{rows: []storage.Row{{}}}, nil
+}
+
+// joinNode joins each row of its input to the rows of a table function
+// call over it.
+type joinNode struct {
+	in   planNode
+	call *expr.TableCall
+}
+
+func (n *joinNode) describe() string { return "Table Function Join " + n.call.String() }
+
+func (n *joinNode) inputs() []planNode { return []planNode{n.in} }
+
+func (n *joinNode) open(c *execContext) (rowIter, error) {
+	in, err := c.open(n.in)
+	if err != nil {
+		return nil, err
+	}
+	return &joinIter{in: in, call: n.call}, nil
+}
+
+// filterNode passes on the rows satisfying the condition of a WHERE or
+// HAVING clause.
+type filterNode struct {
+	in     planNode
+	cond   expr.Expr
+	clause string
+}
+
+func (n *filterNode) describe() string {
+	return fmt.Sprintf("Filter %s %s", n.clause, expr.Format(n.cond))
+}
+
+func (n *filterNode) inputs() []planNode { return []planNode{n.in} }
+
+func (n *filterNode) open(c *execContext) (rowIter, error) {
+	in, err := c.open(n.in)
+	if err != nil {
+		return nil, err
+	}
+	return &filterIter{in: in, cond: n.cond, filter: expr.CompileFilter(n.cond), clause: n.clause}, nil
+}
+
+// aggregateNode groups its input and computes the aggregates of each group.
+// A parallel aggregation is a partial aggregateNode below a Gather and a
+// finalizing one above it.
+type aggregateNode struct {
+	in       planNode
+	agg      *aggregation
+	partial  bool
+	finalize bool
+}
+
+func (n *aggregateNode) describe() string {
+	gb := n.agg.gb
+	s := "Aggregate"
+	if len(gb.items) > 0 {
+		items := make([]string, len(gb.items))
+		for i, it := range gb.items {
+			items[i] = it.text
+		}
+		s = "Group By " + strings.Join(items, ", ")
+		if len(gb.sets) > 1 {
+			s += fmt.Sprintf(" (%d grouping sets)", len(gb.sets))
+		}
+	}
+	if len(n.agg.calls) > 0 {
+		calls := make([]string, len(n.agg.calls))
+		for i, c := range n.agg.calls {
+			calls[i] = c.Text
+		}
+		s += ": " + strings.Join(calls, ", ")
+	}
+	switch {
+	case n.partial:
+		s = "Partial " + s
+	case n.finalize:
+		s = "Finalize " + s
+	}
+	return s
+}
+
+func (n *aggregateNode) inputs() []planNode { return []planNode{n.in} }
+
+func (n *aggregateNode) open(c *execContext) (rowIter, error) {
+	in, err := c.open(n.in)
+	if err != nil {
+		return nil, err
+	}
+	return &aggregateIter{in: in, agg: n.agg, mem: c.mem, partial: n.partial, finalize: n.finalize}, nil
+}
+
+// sortNode orders its input. With limit set, 0 or more, only the first
+// limit rows are wanted, and the sort keeps no more than twice as many.
+type sortNode struct {
+	in    planNode
+	keys  []sortKey
+	limit int
+}
+
+func (n *sortNode) describe() string {
+	keys := make([]string, len(n.keys))
+	for i, k := range n.keys {
+		keys[i] = k.text
+		if k.desc {
+			keys[i] += " DESC"
+		}
+	}
+	if n.limit >= 0 {
+		return fmt.Sprintf("Top-N Sort by %s (limit %d)", strings.Join(keys, ", "), n.limit)
+	}
+	return "Sort by " + strings.Join(keys, ", ")
+}
+
+func (n *sortNode) inputs() []planNode { return []planNode{n.in} }
+
+func (n *sortNode) open(c *execContext) (rowIter, error) {
+	in, err := c.open(n.in)
+	if err != nil {
+		return nil, err
+	}
+	return &sortIter{in: in, keys: n.keys, limit: n.limit, mem: c.mem}, nil
+}
+
+// projectNode computes the SELECT list.
+type projectNode struct {
+	in      planNode
+	projs   []projSpec
+	grouped bool
+}
+
+func (n *projectNode) describe() string {
+	names := make([]string, len(n.projs))
+	for i, ps := range n.projs {
+		names[i] = ps.outName
+	}
+	return "Project " + strings.Join(names, ", ")
+}
+
+func (n *projectNode) inputs() []planNode { return []planNode{n.in} }
+
+func (n *projectNode) open(c *execContext) (rowIter, error) {
+	in, err := c.open(n.in)
+	if err != nil {
+		return nil, err
+	}
+	return &projectIter{in: in, projs: n.projs, grouped: n.grouped}, nil
+}
+
+// distinctNode drops repeated result rows.
+type distinctNode struct {
+	in planNode
+	n  int
+}
+
+func (n *distinctNode) describe() string { return "Distinct" }
+
+func (n *distinctNode) inputs() []planNode { return []planNode{n.in} }
+
+func (n *distinctNode) open(c *execContext) (rowIter, error) {
+	in, err := c.open(n.in)
+	if err != nil {
+		return nil, err
+	}
+	return &distinctIter{in: in, n: n.n, seen: map[string]struct{}{}}, nil
+}
+
+// limitNode applies LIMIT and OFFSET.
+type limitNode struct {
+	in            planNode
+	offset, limit int
+}
+
+func (n *limitNode) describe() string {
+	s := "Limit"
+	if n.limit >= 0 {
+		s += fmt.Sprintf(" %d", n.limit)
+	}
+	if n.offset > 0 {
+		s += fmt.Sprintf(" offset %d", n.offset)
+	}
+	return s
+}
+
+func (n *limitNode) inputs() []planNode { return []planNode{n.in} }
+
+func (n *limitNode) open(c *execContext) (rowIter, error) {
+	in, err := c.open(n.in)
+	if err != nil {
+		return nil, err
+	}
+	return &limitIter{in: in, offset: n.offset, limit: n.limit}, nil
+}
+
+// describeColumns is the suffix naming the columns a scan keeps.
+func describeColumns(columns []string) string {
+	if columns == nil {
+		return ""
+	}
+	return " (columns: " + strings.Join(columns, ", ") + ")"
+}
+
+// keepSet returns the set of names, or nil for nil, meaning every column.
+func keepSet(names []string) map[string]bool {
+	if names == nil {
+		return nil
+	}
+	keep := make(map[string]bool, len(names))
+	for _, c := range names {
+		keep[c] = true
+	}
+	return keep
+}
+
+// keptColumns returns the columns a scan keeping names decodes.
+func keptColumns(columns []schema.Column, names []string) []schema.Column {
+	if names == nil {
+		return columns
+	}
+	keep := keepSet(names)
+	kept := []schema.Column{}
+	for _, c := range columns {
+		if keep[c.Name] {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// pruneRow removes the values of r that are not kept.
+func pruneRow(r storage.Row, keep map[string]bool) {
+	for c := range r {
+		if !keep[c] {
+			delete(r, c)
+		}
+	}
+}
+
+// planSelect builds the plan of a parsed SELECT and rewrites it.
+func planSelect(q *selectQuery, db *schema.Database) (planNode, error) {
+	// constant folding
+	where := expr.FoldExpr(q.where)
+	having := expr.FoldExpr(q.having)
+	for i := range q.projs {
+		if q.projs[i].expr != nil {
+			q.projs[i].expr = expr.Fold(q.projs[i].expr)
+		}
+	}
+	for i := range q.orderKeys {
+		if q.orderKeys[i].expr != nil {
+			q.orderKeys[i].expr = expr.Fold(q.orderKeys[i].expr)
+		}
+	}
+
+	// WHERE conditions that are always true are dropped; one that is never
+	// true leaves nothing to read
+	conds := []expr.Expr{}
+	never := false
+	if where != nil {
+		for _, c := range expr.Conjuncts(where) {
+			if v, ok := expr.Constant(c); ok {
+				if v != true {
+					never = true
+				}
+				continue
+			}
+			conds = append(conds, c)
+		}
+	}
+
+	// predicate pushdown: conditions over the table's columns alone are
+	// applied to its rows before they are joined to a table function's
+	from := q.from
+	var tableConds, joinConds []expr.Expr
+	for _, c := range conds {
+		if from.call != nil && !onlyColumns(c, from.table.Columns) {
+			joinConds = append(joinConds, c)
+		} else {
+			tableConds = append(tableConds, c)
+		}
+	}
+	var ts *stats.TableStats
+	if from.table.Name != "" {
+		all, err := stats.Load(db.GetDBPath())
+		if err != nil {
+			return nil, err
+		}
+		ts = all[from.table.StorageName()]
+	}
+	if ts != nil {
+		// AND stops at the first false condition, so the most selective go
+		// first; the index is chosen by the first equality too
+		col := columnStats(ts)
+		sort.SliceStable(tableConds, func(i, j int) bool {
+			return expr.Selectivity(tableConds[i], col) < expr.Selectivity(tableConds[j], col)
+		})
+	}
+
+	var root planNode
+	var scan *scanNode
+	switch {
+	case never:
+		root = &emptyNode{}
+	case from.table.Name == "":
+		root = &oneRowNode{}
+	default:
+		tableFile, err := storage.OpenTable(db.GetDBPath(), from.table)
+		if err != nil {
+			return nil, err
+		}
+		columns := usedColumns(q, from.table.Columns)
+		is, err := chooseIndex(tableFile, from.table, expr.And(tableConds))
+		if err != nil {
+			return nil, err
+		}
+		if is != nil {
+			is.columns, is.tableStats = columns, ts
+			root = is
+		} else {
+			scan = &scanNode{tableFile: tableFile, table: from.table, tableStats: ts, columns: columns, cond: expr.And(tableConds)}
+			root = scan
+		}
+	}
+	if !never {
+		if cond := expr.And(tableConds); cond != nil {
+			root = &filterNode{in: root, cond: cond, clause: "WHERE"}
+		}
+		if from.call != nil {
+			root = &joinNode{in: root, call: from.call}
+		}
+		if cond := expr.And(joinConds); cond != nil {
+			root = &filterNode{in: root, cond: cond, clause: "WHERE"}
+		}
+	}
+
+	workers := 0
+	if scan != nil {
+		w, err := parallelism(scan, sessionParallelWorkers(db))
+		if err != nil {
+			return nil, err
+		}
+		workers = w
+	}
+	switch {
+	case workers < 2:
+	case !q.grouping && len(q.orderKeys) == 0 && q.limit >= 0:
+		// a LIMIT stops a serial scan after its rows; workers would read
+		// morsels ahead of it
+	case q.grouping && parallelAggregate(q.agg, ts, sessionWorkMem(db)):
+		scan.parallel = true
+		root = &aggregateNode{in: root, agg: q.agg, partial: true}
+		root = &gatherNode{in: root, scan: scan, workers: workers}
+	case !q.grouping && len(q.orderKeys) == 0:
+		scan.parallel = true
+		root = &projectNode{in: root, projs: q.projs}
+		root = &gatherNode{in: root, scan: scan, workers: workers}
+	default:
+		scan.parallel = true
+		root = &gatherNode{in: root, scan: scan, workers: workers}
+	}
+
+	if q.grouping {
+		finalize := false
+		if g, ok := root.(*gatherNode); ok {
+			_, finalize = g.in.(*aggregateNode)
+		}
+		root = &aggregateNode{in: root, agg: q.agg, finalize: finalize}
+		if having != nil {
+			root = &filterNode{in: root, cond: having, clause: "HAVING"}
+		}
+	}
+	if len(q.orderKeys) > 0 {
+		sn := &sortNode{in: root, keys: q.orderKeys, limit: -1}
+		// limit pushdown: without DISTINCT the first offset+limit sorted
+		// rows are all the limit can take
+		if q.limit >= 0 && !q.distinct {
+			sn.limit = q.offset + q.limit
+		}
+		root = sn
+	}
+	if g, ok := root.(*gatherNode); !ok || !isProject(g.in) {
+		root = &projectNode{in: root, projs: q.projs, grouped: q.grouping}
+	}
+	if q.distinct {
+		root = &distinctNode{in: root, n: len(q.projs)}
+	}
+	if q.offset > 0 || q.limit >= 0 {
+		root = &limitNode{in: root, offset: q.offset, limit: q.limit}
+	}
+	return root, nil
+}
+
+// parallelism returns the workers a scan runs on: as many as the session
+// allows, workers, but no more than the table has morsels.
+func parallelism(scan *scanNode, workers int) (int, error) {
+	if workers < 2 {
+		return workers, nil
+	}
+	morsels, err := scan.tableFile.Morsels(morselSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(morsels) < workers {
+		return len(morsels), nil
+	}
+	return workers, nil
+}
+
+// parallelAggregate reports whether an aggregation can be split into
+// partial aggregations by parallel workers and one merging their groups.
+// Every aggregate must merge, and the groups must be expected to fit in
+// workMem, the session's WORK_MEM, as the merged groups are not spilled.
+func parallelAggregate(agg *aggregation, ts *stats.TableStats, workMem int64) bool {
+	for _, c := range agg.calls {
+		if !c.Mergeable() {
+			return false
+		}
+	}
+	rows := math.Inf(1)
+	if ts != nil {
+		rows = float64(ts.Rows)
+	}
+	return groupCount(agg.gb, rows, columnStats(ts))*float64(groupBytes) <= float64(workMem)
+}
+
+// groupBytes is the memory a group is expected to hold when planning.
+const groupBytes = 256
+
+// isProject reports whether n is a projectNode.
+func isProject(n planNode) bool {
+	_, ok := n.(*projectNode)
+	return ok
+}
+
+// onlyColumns reports whether e refers to no columns but those given.
+func onlyColumns(e expr.Expr, columns []schema.Column) bool {
+	for _, c := range expr.CollectColumns(e) {
+		if _, ok := getColumnDefinition(columns, c); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// usedColumns returns the names of the table columns q refers to, in table
+// order, or nil when it refers to all of them.
+func usedColumns(q *selectQuery, columns []schema.Column) []string {
+	used := map[string]bool{}
+	add := func(names ...string) {
+		for _, n := range names {
+			used[strings.ToLower(n)] = true
+		}
+	}
+	for _, ps := range q.projs {
+		add(ps.col)
+		add(expr.CollectColumns(ps.expr)...)
+	}
+	add(expr.CollectColumns(q.where)...)
+	add(expr.CollectColumns(q.having)...)
+	for _, k := range q.orderKeys {
+		add(k.col)
+		add(expr.CollectColumns(k.expr)...)
+	}
+	if q.agg != nil {
+		for _, it := range q.agg.gb.items {
+			add(it.col)
+			add(expr.CollectColumns(it.expr)...)
+		}
+	}
+	if q.from.call != nil {
+		add(q.from.call.Columns()...)
+	}
+	names := []string{}
+	for _, c := range columns {
+		if used[strings.ToLower(c.Name)] {
+			names = append(names, c.Name)
+		}
+	}
+	if len(names) == len(columns) {
+		return nil
+	}
+	return names
+}
+
+// explainPlan renders a plan as an indented tree, each input under the
+// node reading it, with the rows each node is estimated to yield when est
+// has them. With measured it adds each node's row count and time.
+func explainPlan(root planNode, est map[planNode]float64, measured map[planNode]*nodeStats) string {
+	sb := &strings.Builder{}
+	var walk func(n planNode, depth int)
+	walk = func(n planNode, depth int) {
+		if depth > 0 {
+			sb.WriteString(strings.Repeat("  ", depth-1) + "-> ")
+		}
+		sb.WriteString(n.describe())
+		if rows, ok := est[n]; ok {
+			sb.WriteString(fmt.Sprintf(" (est. rows=%.0f)", rows))
+		}
+		if measured != nil {
+			if s, ok := measured[n]; ok {
+				sb.WriteString(fmt.Sprintf(" (rows=%d time=%s)", s.rows, formatDuration(s.elapsed)))
+				if sp, ok := s.op.(spiller); ok && sp.spilled() != "" {
+					sb.WriteString(" [" + sp.spilled() + "]")
+				}
+				if sk, ok := s.op.(skipper); ok && sk.skipped() != "" {
+					sb.WriteString(" [" + sk.skipped() + "]")
+				}
+			} else {
+				sb.WriteString(" (never executed)")
+			}
+		}
+		sb.WriteString("\n")
+		for _, in := range n.inputs() {
+			walk(in, depth+1)
+		}
+	}
+	walk(root, 0)
+	return sb.String()
+}
+
+// formatDuration renders a duration in milliseconds.
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(d)/float64(time.Millisecond))
+}
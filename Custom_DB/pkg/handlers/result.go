@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"Custom_DB/pkg/expr"
+)
+
+// streamFitRows is how many rows StreamSelect reads to fit the result's
+// column widths before it starts writing.
+const streamFitRows = 1000
+
+// resultWriter renders result rows as fixed-width text columns under a
+// header line. Columns are 20 wide unless a value needs more, such as a
+// TIMESTAMPTZ. Widths are fitted to the first fit rows, or to every row when
+// fit is 0; after that rows are written as they come, and a value too wide
+// for its column only widens it on its own line.
+type resultWriter struct {
+	w       io.Writer
+	fit     int
+	header  []string
+	widths  []int
+	pending [][]string
+	started bool
+	err     error
+}
+
+func newResultWriter(w io.Writer, header []string, fit int) *resultWriter {
+	rw := &resultWriter{w: w, header: header, fit: fit, widths: make([]int, len(header))}
+	for i := range rw.widths {
+		rw.widths[i] = 20
+		if len(header[i]) >= rw.widths[i] {
+			rw.widths[i] = len(header[i]) + 1
+		}
+	}
+	return rw
+}
+
+// write adds a row.
+func (rw *resultWriter) write(vals []interface{}) error {
+	cells := make([]string, len(vals))
+	for j, v := range vals {
+		cells[j] = expr.FormatValue(v)
+	}
+	if rw.started {
+		rw.writeLine(cells)
+		return rw.err
+	}
+	for j, c := range cells {
+		if j < len(rw.widths) && len(c) >= rw.widths[j] {
+			rw.widths[j] = len(c) + 1
+		}
+	}
+	rw.pending = append(rw.pending, cells)
+	if rw.fit > 0 && len(rw.pending) >= rw.fit {
+		rw.start()
+	}
+	return rw.err
+}
+
+// close writes what is left: the header and buffered rows of a result with
+// fewer than fit rows.
+func (rw *resultWriter) close() error {
+	if !rw.started {
+		rw.start()
+	}
+	return rw.err
+}
+
+// start writes the header and the buffered rows.
+func (rw *resultWriter) start() {
+	rw.started = true
+	total := 0
+	sb := &strings.Builder{}
+	for i, c := range rw.header {
+		sb.WriteString(fmt.Sprintf("%-*s", rw.widths[i], c))
+		total += rw.widths[i]
+	}
+	sb.WriteString("\n")
+	sb.WriteString(strings.Repeat("-", total))
+	sb.WriteString("\n")
+	rw.emit(sb.String())
+	for _, cells := range rw.pending {
+		rw.writeLine(cells)
+	}
+	rw.pending = nil
+}
+
+func (rw *resultWriter) writeLine(cells []string) {
+	sb := &strings.Builder{}
+	for j, c := range cells {
+		w := 20
+		if j < len(rw.widths) {
+			w = rw.widths[j]
+		}
+		if len(c) >= w {
+			w = len(c) + 1
+		}
+		sb.WriteString(fmt.Sprintf("%-*s", w, c))
+	}
+	sb.WriteString("\n")
+	rw.emit(sb.String())
+}
+
+func (rw *resultWriter) emit(s string) {
+	if rw.err == nil {
+		_, rw.err = io.WriteString(rw.w, s)
+	}
+}
+
+// formatResult renders rows as fixed-width text columns under a header line.
+func formatResult(headerCols []string, rows [][]interface{}) string {
+	sb := &strings.Builder{}
+	rw := newResultWriter(sb, headerCols, 0)
+	for _, r := range rows {
+		rw.write(r)
+	}
+	rw.close()
+	return sb.String()
+}
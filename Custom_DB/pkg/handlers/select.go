@@ -2,130 +2,192 @@ package handlers
 
 import (
 	"fmt"
-	"sort"
+	"io"
 	"strconv"
 	"strings"
 
 	"Custom_DB/pkg/expr"
 	"Custom_DB/pkg/parser"
 	"Custom_DB/pkg/schema"
-	"Custom_DB/pkg/storage"
 )
 
+// projSpec is one item of a SELECT list.
+type projSpec struct {
+	raw     string         // original text
+	isAgg   bool           // expr contains aggregate calls
+	outName string         // output column name to produce
+	col     string         // simple column name when not aggregate
+	expr    expr.ValueExpr // computed projection when not a plain column
+	alias   string         // alias if provided
+}
+
 // HandleSelect executes a SELECT command represented by parser.Command against db and
 // returns a printable result string.
 func HandleSelect(cmd parser.Command, db *schema.Database) (string, error) {
+	sb := &strings.Builder{}
+	if err := executeSelect(cmd, db, sb, 0); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// StreamSelect executes a SELECT command and writes its result to w as its
+// rows are produced, so a result larger than memory can be printed. Column
+// widths are fitted to the first rows. On an error part of the result may
+// already have been written.
+func StreamSelect(cmd parser.Command, db *schema.Database, w io.Writer) error {
+	return executeSelect(cmd, db, w, streamFitRows)
+}
+
+// executeSelect executes a SELECT command, writing its result to w with
+// column widths fitted to the first fit rows; see resultWriter.
+func executeSelect(cmd parser.Command, db *schema.Database, w io.Writer, fit int) error {
+	root, header, err := openSelect(cmd, db)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	out := newResultWriter(w, header, fit)
+	for {
+		r, err := root.Next()
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			break
+		}
+		if err := out.write(outputValues(r, len(header))); err != nil {
+			return err
+		}
+	}
+	return out.close()
+}
+
+// selectQuery is a parsed SELECT, checked against the schema.
+type selectQuery struct {
+	from      *fromClause
+	distinct  bool
+	projs     []projSpec
+	where     expr.Expr
+	grouping  bool
+	agg       *aggregation // set when grouping
+	having    expr.Expr
+	orderKeys []sortKey
+	offset    int
+	limit     int // -1 without a LIMIT
+	header    []string
+}
+
+// openSelect plans a SELECT command and returns the root of the operator
+// tree that executes it, which yields rows made by projectIter, and the
+// names of the result columns.
+func openSelect(cmd parser.Command, db *schema.Database) (rowIter, []string, error) {
+	q, err := parseSelect(cmd, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := planSelect(q, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := newExecContext(db).open(plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, q.header, nil
+}
+
+// parseSelect parses a SELECT command.
+func parseSelect(cmd parser.Command, db *schema.Database) (*selectQuery, error) {
 	tokens := cmd.Tokens
 	if len(tokens) == 0 {
-		return "", fmt.Errorf("empty command")
+		return nil, fmt.Errorf("empty command")
 	}
 
 	// find clause indices
-	fromIdx := parser.IndexOfKeyword(cmd, "FROM")
+	fromIdx := parser.IndexOfTopLevelKeyword(cmd, "FROM")
 	if fromIdx == -1 || fromIdx+1 >= len(tokens) {
-		return "", fmt.Errorf("missing FROM or table name")
+		return nil, fmt.Errorf("missing FROM or table name")
 	}
 
 	// find WHERE, GROUP, HAVING, ORDER, LIMIT/OFFSET
-	whereIdx := parser.IndexOfKeyword(cmd, "WHERE")
-	groupIdx := parser.IndexOfKeyword(cmd, "GROUP")
-	// ensure GROUP is followed by BY
-	if groupIdx != -1 && groupIdx+1 < len(tokens) && strings.ToUpper(tokens[groupIdx+1]) != "BY" {
-		groupIdx = -1
-	}
-	orderIdx := parser.IndexOfKeyword(cmd, "ORDER")
-	if orderIdx != -1 && orderIdx+1 < len(tokens) && strings.ToUpper(tokens[orderIdx+1]) != "BY" {
-		orderIdx = -1
-	}
-	havingIdx := parser.IndexOfKeyword(cmd, "HAVING")
-	limitIdx := parser.IndexOfKeyword(cmd, "LIMIT")
-	offsetIdx := parser.IndexOfKeyword(cmd, "OFFSET")
+	whereIdx := parser.IndexOfTopLevelKeyword(cmd, "WHERE")
+	groupIdx := parser.IndexOfTopLevelClause(cmd, "GROUP", "BY")
+	orderIdx := parser.IndexOfTopLevelClause(cmd, "ORDER", "BY")
+	havingIdx := parser.IndexOfTopLevelKeyword(cmd, "HAVING")
+	limitIdx := parser.IndexOfTopLevelKeyword(cmd, "LIMIT")
+	offsetIdx := parser.IndexOfTopLevelKeyword(cmd, "OFFSET")
 
 	// select expression tokens: tokens[1:fromIdx]
 	selTokens := tokens[1:fromIdx]
-	selExpr := strings.Join(selTokens, " ")
-	selExpr = strings.TrimSpace(selExpr)
-
-	// table name
-	tableName := tokens[fromIdx+1]
 
-	table, exists := db.GetTable(tableName)
-	if !exists {
-		return "", fmt.Errorf("table '%s' does not exist", tableName)
+	// the table, table function or both the query reads
+	from, err := parseFrom(tokens[fromIdx+1:clauseEnd(tokens, fromIdx, whereIdx, groupIdx, havingIdx, orderIdx, limitIdx, offsetIdx)], db)
+	if err != nil {
+		return nil, err
 	}
+	table := schema.Table{Name: from.table.Name, Columns: from.columns}
+	colKind := columnKinds(table.Columns)
 
 	distinct := false
-	upSel := strings.ToUpper(selExpr)
-	if strings.HasPrefix(upSel, "DISTINCT ") {
+	if len(selTokens) > 0 && strings.EqualFold(selTokens[0], "DISTINCT") {
 		distinct = true
-		selExpr = strings.TrimSpace(selExpr[len("DISTINCT "):])
+		selTokens = selTokens[1:]
 	}
 
-	// parse projection columns into specs (support aggregates)
-	type projSpec struct {
-		raw     string // original text
-		isAgg   bool
-		aggFunc string // COUNT, SUM, AVG, MIN, MAX
-		aggCol  string // column for agg (or "*")
-		outName string // output column name to produce
-		col     string // simple column name when not aggregate
-		alias   string // alias if provided
-	}
+	// parse projection columns into specs (support aggregates and expressions)
 	projSpecs := []projSpec{}
-	if selExpr == "*" {
+	if len(selTokens) == 1 && selTokens[0] == "*" {
 		for _, c := range table.Columns {
 			projSpecs = append(projSpecs, projSpec{raw: c.Name, isAgg: false, col: c.Name, outName: c.Name})
 		}
 	} else {
-		for _, part := range strings.Split(selExpr, ",") {
-			p := strings.TrimSpace(part)
-			if p == "" {
+		for _, item := range splitTopLevel(selTokens) {
+			if len(item) == 0 {
 				continue
 			}
 			// handle alias via AS
-			asParts := strings.SplitN(p, " AS ", 2)
-			if len(asParts) == 1 {
-				asParts = strings.SplitN(p, " as ", 2)
-			}
-			exprText := strings.TrimSpace(asParts[0])
 			alias := ""
-			if len(asParts) == 2 {
-				alias = strings.TrimSpace(asParts[1])
-				alias = strings.Trim(alias, "`\"")
+			if len(item) >= 3 && strings.EqualFold(item[len(item)-2], "AS") {
+				alias = strings.Trim(item[len(item)-1], "`\"")
+				item = item[:len(item)-2]
 			}
-			// normalize spacing: remove space before/after parentheses to handle tokenizer spacing
-			exprText = strings.ReplaceAll(exprText, " (", "(")
-			exprText = strings.ReplaceAll(exprText, "( ", "(")
-			exprText = strings.ReplaceAll(exprText, " )", ")")
-			up := strings.ToUpper(exprText)
+			exprText := joinExprTokens(item)
 			spec := projSpec{raw: exprText, alias: alias}
-			// detect aggregate forms COUNT(*), COUNT(col), SUM(col), AVG(col), MIN(col), MAX(col)
-			if strings.HasPrefix(up, "COUNT(") {
-				spec.isAgg = true
-				spec.aggFunc = "COUNT"
-				inside := strings.TrimSpace(exprText[6 : len(exprText)-1])
-				spec.aggCol = strings.TrimSpace(inside)
-				if spec.aggCol == "*" {
-					spec.outName = "count"
-				} else {
-					spec.outName = "count_" + strings.Trim(spec.aggCol, "`\"")
-				}
-			} else if strings.HasPrefix(up, "SUM(") || strings.HasPrefix(up, "AVG(") || strings.HasPrefix(up, "MIN(") || strings.HasPrefix(up, "MAX(") {
-				// generic parse: FUNC(col)
-				idx := strings.Index(up, "(")
-				fn := strings.ToUpper(up[:idx])
-				inside := strings.TrimSpace(exprText[idx+1 : len(exprText)-1])
-				spec.isAgg = true
-				spec.aggFunc = fn
-				spec.aggCol = strings.Trim(inside, "`\"")
-				spec.outName = strings.ToLower(fn) + "_" + spec.aggCol
-			} else {
+			ve, err := expr.ParseValue(exprText)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SELECT expression '%s': %w", exprText, err)
+			}
+			if col, isCol := expr.ColumnName(ve); isCol {
 				// simple column
-				fields := strings.Fields(exprText)
-				col := strings.Trim(fields[0], "`\"")
-				spec.isAgg = false
 				spec.col = col
 				spec.outName = col
+			} else {
+				for _, c := range expr.CollectColumns(ve) {
+					if _, ok := getColumnDefinition(table.Columns, c); !ok {
+						return nil, fmt.Errorf("SELECT references unknown column '%s'", c)
+					}
+				}
+				if err := expr.CheckArgKinds(ve, colKind); err != nil {
+					return nil, err
+				}
+				if err := bindExpr(db, ve); err != nil {
+					return nil, err
+				}
+				spec.expr = ve
+				spec.outName = exprText
+				spec.isAgg = len(expr.CollectAggregates(ve)) > 0
+				if call, ok := ve.(*expr.AggregateCall); ok {
+					// a bare aggregate is named like count or sum_price
+					switch {
+					case call.Star:
+						spec.outName = strings.ToLower(call.Func.Name)
+					case call.Distinct:
+						spec.outName = strings.ToLower(call.Func.Name) + "_distinct_" + strings.Trim(call.ArgText, "`\"")
+					default:
+						spec.outName = strings.ToLower(call.Func.Name) + "_" + strings.Trim(call.ArgText, "`\"")
+					}
+				}
 			}
 			if spec.alias != "" {
 				spec.outName = spec.alias
@@ -149,25 +211,51 @@ func HandleSelect(cmd parser.Command, db *schema.Database) (string, error) {
 		raw := strings.Join(tokens[whereIdx+1:endWhere], " ")
 		e, perr := expr.ParseExpression(raw)
 		if perr != nil {
-			return "", fmt.Errorf("invalid WHERE expression: %w", perr)
+			return nil, fmt.Errorf("invalid WHERE expression: %w", perr)
 		}
 		// validate referenced columns exist in table schema
 		cols := expr.CollectColumns(e)
 		for _, c := range cols {
 			if _, ok := getColumnDefinition(table.Columns, c); !ok {
-				return "", fmt.Errorf("WHERE references unknown column '%s'", c)
+				return nil, fmt.Errorf("WHERE references unknown column '%s'", c)
 			}
 		}
+		if len(expr.CollectAggregates(e)) > 0 {
+			return nil, fmt.Errorf("aggregate functions are not allowed in WHERE")
+		}
+		if err := expr.CheckArgKinds(e, colKind); err != nil {
+			return nil, err
+		}
+		if err := bindExpr(db, e); err != nil {
+			return nil, err
+		}
 		whereExpr = e
 	}
 
-	// GROUP BY handling
+	// GROUP BY handling: a list of columns and expressions, optionally with
+	// ROLLUP, CUBE or GROUPING SETS
 	var grouping bool
-	var groupCol string
+	gb := &groupBy{sets: [][]int{{}}}
 	if groupIdx != -1 {
-		// group by column expected at groupIdx+2
-		if groupIdx+2 < len(tokens) {
-			groupCol = strings.Trim(tokens[groupIdx+2], "`\"")
+		endGroup := clauseEnd(tokens, groupIdx, havingIdx, orderIdx, limitIdx, offsetIdx)
+		if groupIdx+2 < endGroup {
+			// GROUP BY may name a projection alias
+			resolve := func(text string) (string, expr.ValueExpr) {
+				for _, ps := range projSpecs {
+					if !ps.isAgg && ps.alias != "" && strings.EqualFold(ps.alias, text) {
+						return ps.col, ps.expr
+					}
+				}
+				return "", nil
+			}
+			parsed, err := parseGroupBy(tokens[groupIdx+2:endGroup], table.Columns, resolve)
+			if err != nil {
+				return nil, err
+			}
+			for _, it := range parsed.items {
+				expr.BindTimeZone(it.expr, expr.SessionTimeZone(db))
+			}
+			gb = parsed
 			grouping = true
 		}
 	}
@@ -181,459 +269,299 @@ func HandleSelect(cmd parser.Command, db *schema.Database) (string, error) {
 	}
 	if !grouping && hasAggProj {
 		grouping = true
-		groupCol = "" // empty key for global aggregation
 	}
 
 	// if user explicitly provided GROUP BY but no aggregate projections, be lenient and add COUNT(*) automatically
 	if grouping && !hasAggProj {
 		// add COUNT(*) default
-		projSpecs = append(projSpecs, projSpec{raw: "COUNT(*)", isAgg: true, aggFunc: "COUNT", aggCol: "*", outName: "count"})
+		countAll, _ := expr.ParseValue("COUNT(*)")
+		projSpecs = append(projSpecs, projSpec{raw: "COUNT(*)", isAgg: true, expr: countAll, outName: "count"})
 		hasAggProj = true
 	}
 
-	// ORDER BY handling
-	var orderCol string
-	orderAsc := true
-	if orderIdx != -1 {
-		if orderIdx+2 < len(tokens) {
-			orderCol = strings.Trim(tokens[orderIdx+2], "`\"")
-			if orderIdx+3 < len(tokens) && strings.ToUpper(tokens[orderIdx+3]) == "DESC" {
-				orderAsc = false
-			}
+	// projItem maps each projection to the group item it selects, or -1
+	projItem := make([]int, len(projSpecs))
+	for i, ps := range projSpecs {
+		projItem[i] = -1
+		if ps.isAgg {
+			continue
 		}
-	}
-
-	// read rows
-	tableFile, err := storage.NewTableFile(db.GetDBPath(), tableName)
-	if err != nil {
-		return "", err
-	}
-	rows, err := tableFile.ReadAllRows()
-	if err != nil {
-		return "", err
-	}
-
-	// apply WHERE via AST evaluator
-	if whereExpr != nil {
-		filtered := make([]storage.Row, 0, len(rows))
-		for _, r := range rows {
-			ok, err := whereExpr.Eval(r)
-			if err != nil {
-				return "", fmt.Errorf("error evaluating WHERE: %w", err)
-			}
-			if ok {
-				filtered = append(filtered, r)
-			}
+		projItem[i] = gb.itemFor(ps.col, ps.raw)
+		if projItem[i] == -1 && ps.alias != "" {
+			projItem[i] = gb.itemFor("", ps.alias)
 		}
-		rows = filtered
 	}
-	// GROUP BY / Aggregation handling
+	// outside of aggregate calls a projection in a grouped query may only use
+	// a grouping expression itself or grouping columns
+	isGroupedCol := func(c string) bool {
+		i := gb.itemFor(c, "")
+		return i != -1 && gb.items[i].col != ""
+	}
 	if grouping {
-		// validate group column exists (unless global aggregation with empty groupCol)
-		if groupCol != "" {
-			if _, ok := getColumnDefinition(table.Columns, groupCol); !ok {
-				return "", fmt.Errorf("GROUP BY references unknown column '%s'", groupCol)
-			}
-		}
-
-		// prepare aggregation maps keyed by group key string
-		counts := make(map[string]int)
-		sums := make(map[string]map[string]float64) // group -> outName -> sum
-		mins := make(map[string]map[string]float64)
-		maxs := make(map[string]map[string]float64)
-		cntsForAvg := make(map[string]map[string]int)
-		sample := make(map[string]map[string]interface{}) // group -> sample values (including groupCol)
-
-		for _, r := range rows {
-			var key string
-			if groupCol == "" {
-				key = "__global__"
-			} else {
-				key = fmt.Sprintf("%v", r[groupCol])
-			}
-			counts[key]++
-			if _, ok := sample[key]; !ok {
-				sample[key] = make(map[string]interface{})
-				if groupCol != "" {
-					sample[key][groupCol] = r[groupCol]
-				}
+		for i, ps := range projSpecs {
+			if projItem[i] != -1 {
+				continue
 			}
-			// initialize maps
-			if _, ok := sums[key]; !ok {
-				sums[key] = map[string]float64{}
-				mins[key] = map[string]float64{}
-				maxs[key] = map[string]float64{}
-				cntsForAvg[key] = map[string]int{}
+			if ps.col != "" {
+				return nil, fmt.Errorf("cannot select non-aggregated column '%s' without grouping", ps.col)
 			}
-			// update aggregates per projSpec
-			for _, ps := range projSpecs {
-				if !ps.isAgg {
-					continue
-				}
-				switch ps.aggFunc {
-				case "COUNT":
-					// count handled by counts[key] or count of specific column if provided
-					if ps.aggCol != "*" && ps.aggCol != "" {
-						if _, ok := r[ps.aggCol]; ok {
-							// treat non-null as count
-							sums[key][ps.outName] = sums[key][ps.outName] + 1
-						}
-					} else {
-						// COUNT(*) -> counts[key] later
-						// we'll set outName from counts map
-					}
-				case "SUM", "AVG":
-					if v, ok := r[ps.aggCol]; ok {
-						if f, okf := toFloat(v); okf {
-							sums[key][ps.outName] += f
-							cntsForAvg[key][ps.outName]++
-							if _, has := mins[key][ps.outName]; !has || f < mins[key][ps.outName] {
-								mins[key][ps.outName] = f
-							}
-							if _, has := maxs[key][ps.outName]; !has || f > maxs[key][ps.outName] {
-								maxs[key][ps.outName] = f
-							}
-						}
-					}
-				case "MIN":
-					if v, ok := r[ps.aggCol]; ok {
-						if f, okf := toFloat(v); okf {
-							if _, has := mins[key][ps.outName]; !has || f < mins[key][ps.outName] {
-								mins[key][ps.outName] = f
-							}
-						}
-					}
-				case "MAX":
-					if v, ok := r[ps.aggCol]; ok {
-						if f, okf := toFloat(v); okf {
-							if _, has := maxs[key][ps.outName]; !has || f > maxs[key][ps.outName] {
-								maxs[key][ps.outName] = f
-							}
-						}
-					}
+			for _, c := range expr.FreeColumns(ps.expr) {
+				if !isGroupedCol(c) {
+					return nil, fmt.Errorf("expression '%s' must appear in GROUP BY or be used in an aggregate", ps.raw)
 				}
 			}
 		}
+	}
 
-		// build aggregated rows
-		aggRows := make([]storage.Row, 0, len(counts))
-		for k, cnt := range counts {
-			nr := make(storage.Row)
-			if groupCol != "" {
-				nr[groupCol] = sample[k][groupCol]
-			}
-			// set COUNT(*) if requested
+	// HAVING filters groups; it may call aggregates and refer to the grouping
+	// column or any projection by its output name
+	var havingExpr expr.Expr
+	if havingIdx != -1 {
+		if !grouping {
+			return nil, fmt.Errorf("HAVING requires GROUP BY or an aggregate")
+		}
+		endHaving := clauseEnd(tokens, havingIdx, orderIdx, limitIdx, offsetIdx)
+		he, herr := expr.ParseExpression(joinExprTokens(tokens[havingIdx+1 : endHaving]))
+		if herr != nil {
+			return nil, fmt.Errorf("invalid HAVING expression: %w", herr)
+		}
+		for _, c := range expr.FreeColumns(he) {
+			known := isGroupedCol(c)
 			for _, ps := range projSpecs {
-				if ps.isAgg && ps.aggFunc == "COUNT" {
-					if ps.aggCol == "*" || ps.aggCol == "" {
-						nr[ps.outName] = cnt
-					} else {
-						// we used sums map to count non-* counts
-						if v, ok := sums[k][ps.outName]; ok {
-							nr[ps.outName] = int(v)
-						} else {
-							nr[ps.outName] = 0
-						}
-					}
-				}
-				if ps.isAgg && (ps.aggFunc == "SUM" || ps.aggFunc == "AVG") {
-					s := sums[k][ps.outName]
-					if ps.aggFunc == "SUM" {
-						nr[ps.outName] = s
-					} else {
-						cntv := cntsForAvg[k][ps.outName]
-						if cntv == 0 {
-							nr[ps.outName] = 0
-						} else {
-							nr[ps.outName] = s / float64(cntv)
-						}
-					}
-				}
-				if ps.isAgg && ps.aggFunc == "MIN" {
-					if v, ok := mins[k][ps.outName]; ok {
-						nr[ps.outName] = v
-					} else {
-						nr[ps.outName] = nil
-					}
-				}
-				if ps.isAgg && ps.aggFunc == "MAX" {
-					if v, ok := maxs[k][ps.outName]; ok {
-						nr[ps.outName] = v
-					} else {
-						nr[ps.outName] = nil
-					}
-				}
+				known = known || ps.outName == c
 			}
-			aggRows = append(aggRows, nr)
+			if !known {
+				return nil, fmt.Errorf("HAVING references unknown aggregate/column '%s'", c)
+			}
+		}
+		if err := bindExpr(db, he); err != nil {
+			return nil, err
 		}
+		havingExpr = he
+	}
 
-		// HAVING: if present, evaluate against aggregated rows. We allow HAVING to reference aggregates by the same function syntax;
-		// we rewrite occurrences of aggregate function calls to the generated outName before parsing.
-		if havingIdx != -1 {
-			// determine having bounds
-			endHaving := len(tokens)
-			hCandidates := []int{orderIdx, limitIdx, offsetIdx}
-			for _, v := range hCandidates {
-				if v != -1 && v < endHaving {
-					endHaving = v
-				}
-			}
-			rawHaving := strings.Join(tokens[havingIdx+1:endHaving], " ")
-			// rewrite aggregate function references to produced outNames
-			rewrite := func(s string) string {
-				out := s
-				for _, ps := range projSpecs {
-					if !ps.isAgg {
-						continue
-					}
-					// replace function call text with outName (case-insensitive)
-					old := ps.raw
-					// if COUNT(*) normalize old to COUNT(*)
-					if strings.ToUpper(old) == "COUNT(*)" {
-						old = "COUNT(*)"
-					}
-					out = replaceIgnoreCase(out, old, ps.outName)
-					// also support COUNT(col) variations
-					// attempt to replace function style occurrences by constructing pattern
-					patt := strings.ToUpper(ps.aggFunc) + "(" + strings.ToUpper(ps.aggCol) + ")"
-					out = replaceIgnoreCase(out, patt, ps.outName)
+	// ORDER BY handling: keys compare according to the column type or the
+	// kind of value an expression produces
+	var orderKeys []sortKey
+	if orderIdx != -1 {
+		endOrder := clauseEnd(tokens, orderIdx, limitIdx, offsetIdx)
+		resolve := func(text string, ordinal int) (sortKey, error) {
+			var ps *projSpec
+			if ordinal != 0 {
+				if ordinal < 1 || ordinal > len(projSpecs) {
+					return sortKey{}, fmt.Errorf("ORDER BY position %d is not in select list", ordinal)
 				}
-				return out
-			}
-			rewritten := rewrite(rawHaving)
-			he, herr := expr.ParseExpression(rewritten)
-			if herr != nil {
-				return "", fmt.Errorf("invalid HAVING expression: %w", herr)
-			}
-			// validate referenced columns exist in aggregated rows (use first agg row as sample)
-			sampleRow := storage.Row{}
-			if len(aggRows) > 0 {
-				sampleRow = aggRows[0]
+				ps = &projSpecs[ordinal-1]
 			}
-			for _, c := range expr.CollectColumns(he) {
-				if _, ok := sampleRow[c]; !ok {
-					return "", fmt.Errorf("HAVING references unknown aggregate/column '%s'", c)
+			for i := range projSpecs {
+				if ps == nil && (strings.EqualFold(projSpecs[i].outName, text) || strings.EqualFold(projSpecs[i].raw, text)) {
+					ps = &projSpecs[i]
 				}
 			}
-			// filter
-			filteredAgg := make([]storage.Row, 0, len(aggRows))
-			for _, ar := range aggRows {
-				ok, err := he.Eval(ar)
-				if err != nil {
-					return "", fmt.Errorf("error evaluating HAVING: %w", err)
+			if ps != nil {
+				k := sortKey{kind: colKind(ps.col)}
+				switch {
+				case grouping || ps.isAgg:
+					k.col = ps.outName
+				case ps.expr != nil:
+					k.expr = ps.expr
+				default:
+					k.col = ps.col
 				}
-				if ok {
-					filteredAgg = append(filteredAgg, ar)
+				if ps.expr != nil {
+					k.kind = expr.InferKind(ps.expr, colKind)
 				}
+				return k, nil
 			}
-			aggRows = filteredAgg
-		}
-
-		// ORDER on aggregated rows
-		if orderCol != "" {
-			sort.Slice(aggRows, func(i, j int) bool {
-				si := fmt.Sprintf("%v", aggRows[i][orderCol])
-				sj := fmt.Sprintf("%v", aggRows[j][orderCol])
-				fi, erri := strconv.ParseFloat(si, 64)
-				fj, errj := strconv.ParseFloat(sj, 64)
-				if erri == nil && errj == nil {
-					if orderAsc {
-						return fi < fj
-					}
-					return fi > fj
-				}
-				if orderAsc {
-					return strings.Compare(si, sj) < 0
-				}
-				return strings.Compare(si, sj) > 0
-			})
-		}
-
-		// LIMIT/OFFSET on aggregated rows
-		start := 0
-		if offsetIdx != -1 && offsetIdx+1 < len(tokens) {
-			if v, err := strconv.Atoi(tokens[offsetIdx+1]); err == nil {
-				start = v
+			oe, err := expr.ParseValue(text)
+			if err != nil {
+				return sortKey{}, fmt.Errorf("invalid ORDER BY expression: %w", err)
 			}
-		}
-		end := len(aggRows)
-		if limitIdx != -1 && limitIdx+1 < len(tokens) {
-			if v, err := strconv.Atoi(tokens[limitIdx+1]); err == nil {
-				end = start + v
-				if end > len(aggRows) {
-					end = len(aggRows)
+			if !grouping {
+				if len(expr.CollectAggregates(oe)) > 0 {
+					return sortKey{}, fmt.Errorf("aggregate functions in ORDER BY require GROUP BY")
 				}
-			}
-		}
-		if start < 0 {
-			start = 0
-		}
-		if start > len(aggRows) {
-			start = len(aggRows)
-		}
-		aggRows = aggRows[start:end]
-
-		// build output header based on projection specs
-		headerCols := []string{}
-		for _, ps := range projSpecs {
-			headerCols = append(headerCols, ps.outName)
-		}
-		sb := &strings.Builder{}
-		for _, c := range headerCols {
-			sb.WriteString(fmt.Sprintf("%-20s", c))
-		}
-		sb.WriteString("\n")
-		sb.WriteString(strings.Repeat("-", 20*len(headerCols)))
-		sb.WriteString("\n")
-		for _, r := range aggRows {
-			for _, ps := range projSpecs {
-				if v, ok := r[ps.outName]; ok {
-					sb.WriteString(fmt.Sprintf("%-20v", v))
-				} else if ps.isAgg {
-					sb.WriteString(fmt.Sprintf("%-20v", "NULL"))
-				} else {
-					// non-agg columns in grouping must be groupCol only
-					if ps.col == groupCol {
-						if v, ok := r[groupCol]; ok {
-							sb.WriteString(fmt.Sprintf("%-20v", v))
-						} else {
-							sb.WriteString(fmt.Sprintf("%-20s", "NULL"))
-						}
-					} else {
-						if !strings.EqualFold(ps.col, groupCol) {
-							return "", fmt.Errorf("cannot select non-aggregated column '%s' without grouping", ps.col)
-						}
-						// else allowed (case-insensitive match)
+				for _, c := range expr.CollectColumns(oe) {
+					if _, ok := getColumnDefinition(table.Columns, c); !ok {
+						return sortKey{}, fmt.Errorf("ORDER BY references unknown column '%s'", c)
 					}
 				}
 			}
-			sb.WriteString("\n")
+			if err := bindExpr(db, oe); err != nil {
+				return sortKey{}, err
+			}
+			k := sortKey{kind: expr.InferKind(oe, colKind)}
+			if col, isCol := expr.ColumnName(oe); isCol {
+				k.col = col
+			} else {
+				k.expr = oe
+			}
+			return k, nil
+		}
+		keys, err := parseOrderBy(tokens[orderIdx+2:endOrder], resolve)
+		if err != nil {
+			return nil, err
 		}
-		return sb.String(), nil
+		orderKeys = keys
 	}
 
-	// ORDER for normal rows
-	if orderCol != "" && len(rows) > 1 {
-		sort.Slice(rows, func(i, j int) bool {
-			si := fmt.Sprintf("%v", rows[i][orderCol])
-			sj := fmt.Sprintf("%v", rows[j][orderCol])
-			fi, erri := strconv.ParseFloat(si, 64)
-			fj, errj := strconv.ParseFloat(sj, 64)
-			if erri == nil && errj == nil {
-				if orderAsc {
-					return fi < fj
-				}
-				return fi > fj
-			}
-			if orderAsc {
-				return strings.Compare(si, sj) < 0
-			}
-			return strings.Compare(si, sj) > 0
-		})
+	offset, limit, err := parseLimit(tokens, limitIdx, offsetIdx)
+	if err != nil {
+		return nil, err
 	}
 
-	// DISTINCT dedupe
-	if distinct {
-		seen := map[string]struct{}{}
-		uniq := make([]storage.Row, 0, len(rows))
-		for _, r := range rows {
-			parts := make([]string, 0, len(projSpecs))
-			for _, ps := range projSpecs {
-				var val interface{}
-				if ps.isAgg {
-					if v, ok := r[ps.outName]; ok {
-						val = v
-					}
-				} else {
-					if v, ok := r[ps.col]; ok {
-						val = v
-					}
-				}
-				if val != nil {
-					parts = append(parts, fmt.Sprintf("%v", val))
-				} else {
-					parts = append(parts, "NULL")
-				}
-			}
-			key := strings.Join(parts, "|")
-			if _, ok := seen[key]; !ok {
-				seen[key] = struct{}{}
-				uniq = append(uniq, r)
-			}
+	var agg *aggregation
+	if grouping {
+		if agg, err = newAggregation(gb, projSpecs, projItem, havingExpr, orderKeys); err != nil {
+			return nil, err
 		}
-		rows = uniq
 	}
 
-	// projection output (non-aggregated rows)
 	headerCols := []string{}
 	for _, ps := range projSpecs {
 		headerCols = append(headerCols, ps.outName)
 	}
-	sb := &strings.Builder{}
-	for _, c := range headerCols {
-		sb.WriteString(fmt.Sprintf("%-20s", c))
-	}
-	sb.WriteString("\n")
-	sb.WriteString(strings.Repeat("-", 20*len(headerCols)))
-	sb.WriteString("\n")
-	for _, r := range rows {
-		for _, ps := range projSpecs {
-			if ps.isAgg {
-				if v, ok := r[ps.outName]; ok {
-					sb.WriteString(fmt.Sprintf("%-20v", v))
-				} else {
-					sb.WriteString(fmt.Sprintf("%-20s", "NULL"))
-				}
-			} else {
-				if v, ok := r[ps.col]; ok {
-					sb.WriteString(fmt.Sprintf("%-20v", v))
-				} else {
-					sb.WriteString(fmt.Sprintf("%-20s", "NULL"))
-				}
+	return &selectQuery{
+		from:      from,
+		distinct:  distinct,
+		projs:     projSpecs,
+		where:     whereExpr,
+		grouping:  grouping,
+		agg:       agg,
+		having:    havingExpr,
+		orderKeys: orderKeys,
+		offset:    offset,
+		limit:     limit,
+		header:    headerCols,
+	}, nil
+}
+
+// newAggregation collects the aggregate and GROUPING calls of a grouped
+// query's projections, HAVING and ORDER BY and gives each a slot in the
+// group rows.
+func newAggregation(gb *groupBy, projSpecs []projSpec, projItem []int, havingExpr expr.Expr, orderKeys []sortKey) (*aggregation, error) {
+	agg := &aggregation{gb: gb, projs: projSpecs, projItem: projItem}
+	exprs := []interface{}{havingExpr}
+	for _, k := range orderKeys {
+		exprs = append(exprs, k.expr)
+	}
+	for _, ps := range projSpecs {
+		exprs = append(exprs, ps.expr)
+	}
+	for _, e := range exprs {
+		agg.calls = append(agg.calls, expr.CollectAggregates(e)...)
+		agg.groupingCalls = append(agg.groupingCalls, expr.CollectGroupingCalls(e)...)
+	}
+	for i, c := range agg.calls {
+		c.Slot = fmt.Sprintf("__agg%d", i)
+	}
+	// GROUPING(...) arguments must name group items
+	agg.groupingArgs = make([][]int, len(agg.groupingCalls))
+	for i, gc := range agg.groupingCalls {
+		gc.Slot = fmt.Sprintf("__grouping%d", i)
+		for j, a := range gc.Args {
+			col, _ := expr.ColumnName(a)
+			idx := gb.itemFor(col, gc.ArgTexts[j])
+			if idx == -1 {
+				return nil, fmt.Errorf("argument '%s' of GROUPING must be a GROUP BY expression", gc.ArgTexts[j])
 			}
+			agg.groupingArgs[i] = append(agg.groupingArgs[i], idx)
 		}
-		sb.WriteString("\n")
 	}
-	return sb.String(), nil
+	return agg, nil
 }
 
-// helper: parse numeric-like interface to float64
-func toFloat(v interface{}) (float64, bool) {
-	switch t := v.(type) {
-	case float64:
-		return t, true
-	case float32:
-		return float64(t), true
-	case int:
-		return float64(t), true
-	case int64:
-		return float64(t), true
-	case string:
-		if f, err := strconv.ParseFloat(t, 64); err == nil {
-			return f, true
+// parseLimit returns the row counts of the LIMIT and OFFSET clauses, at
+// limitIdx and offsetIdx when present. limit is -1 without a LIMIT.
+func parseLimit(tokens []string, limitIdx, offsetIdx int) (offset, limit int, err error) {
+	count := func(idx int, clause string) (int, error) {
+		if idx+1 >= len(tokens) {
+			return 0, fmt.Errorf("missing row count after %s", clause)
+		}
+		n, err := strconv.Atoi(tokens[idx+1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s '%s': expected a non-negative integer", clause, tokens[idx+1])
 		}
+		return n, nil
 	}
-	return 0, false
+	limit = -1
+	if limitIdx != -1 {
+		if limit, err = count(limitIdx, "LIMIT"); err != nil {
+			return 0, 0, err
+		}
+	}
+	if offsetIdx != -1 {
+		if offset, err = count(offsetIdx, "OFFSET"); err != nil {
+			return 0, 0, err
+		}
+	}
+	return offset, limit, nil
 }
 
-// replaceIgnoreCase replaces old (case-insensitive) occurrences in s with repl
-func replaceIgnoreCase(s, old, repl string) string {
-	if old == "" {
-		return s
-	}
-	lower := strings.ToLower(s)
-	target := strings.ToLower(old)
-	var sb strings.Builder
-	i := 0
-	for {
-		idx := strings.Index(lower[i:], target)
-		if idx == -1 {
-			sb.WriteString(s[i:])
-			break
+// clauseEnd returns the index where the clause starting at start ends: the
+// first later clause keyword position, or the end of the token stream.
+func clauseEnd(tokens []string, start int, others ...int) int {
+	end := len(tokens)
+	for _, v := range others {
+		if v > start && v < end {
+			end = v
 		}
-		sb.WriteString(s[i : i+idx])
-		sb.WriteString(repl)
-		i += idx + len(target)
 	}
-	return sb.String()
+	return end
+}
+
+// splitTopLevel splits a token list on commas that are not nested inside
+// parentheses or the brackets of an ARRAY[...] constructor.
+func splitTopLevel(tokens []string) [][]string {
+	out := [][]string{}
+	cur := []string{}
+	depth := 0
+	for _, t := range tokens {
+		depth += bracketDepth(t)
+		switch t {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				out = append(out, cur)
+				cur = []string{}
+				continue
+			}
+		}
+		cur = append(cur, t)
+	}
+	return append(out, cur)
+}
+
+// bracketDepth returns how many more [ than ] a token holds outside its
+// quoted strings. The command tokenizer keeps ARRAY[1 or 2] in one token,
+// but splits ARRAY['x', 'y'] into ARRAY['x' , 'y'], so a token may both
+// start with a quoted string and close a bracket after it.
+func bracketDepth(t string) int {
+	depth, quoted := 0, false
+	for _, r := range t {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case quoted:
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+// joinExprTokens joins tokens back into expression text, dropping the spaces
+// the tokenizer introduced around parentheses so COUNT ( * ) reads COUNT(*).
+func joinExprTokens(tokens []string) string {
+	s := strings.Join(tokens, " ")
+	s = strings.ReplaceAll(s, " (", "(")
+	s = strings.ReplaceAll(s, "( ", "(")
+	s = strings.ReplaceAll(s, " )", ")")
+	s = strings.ReplaceAll(s, " ,", ",")
+	return strings.TrimSpace(s)
 }
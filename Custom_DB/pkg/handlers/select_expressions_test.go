@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func newOrdersDB(t *testing.T) *schema.Database {
+	t.Helper()
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	tbl := schema.Table{Name: "orders", Columns: []schema.Column{
+		{Name: "id", Type: schema.Integer},
+		{Name: "item", Type: schema.Text},
+		{Name: "price", Type: schema.Decimal},
+		{Name: "qty", Type: schema.Integer},
+	}}
+	if err := db.AddTable(tbl); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	tf, err := storage.NewTableFile(db.GetDBPath(), "orders")
+	if err != nil {
+		t.Fatalf("new table file: %v", err)
+	}
+	for _, r := range []storage.Row{
+		{"id": 1, "item": "pen", "price": 1.5, "qty": 4},
+		{"id": 2, "item": "book", "price": 12, "qty": 1},
+		{"id": 3, "item": "pen", "price": 1.5, "qty": 10},
+	} {
+		if err := tf.AppendRow(r); err != nil {
+			t.Fatalf("append row: %v", err)
+		}
+	}
+	return db
+}
+
+func runSelect(t *testing.T, db *schema.Database, sql string) string {
+	t.Helper()
+	cmd, err := parser.Parse(sql)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	out, err := HandleSelect(cmd, db)
+	if err != nil {
+		t.Fatalf("HandleSelect(%q) failed: %v", sql, err)
+	}
+	return out
+}
+
+func TestHandleSelect_ArithmeticProjection(t *testing.T) {
+	db := newOrdersDB(t)
+	out := runSelect(t, db, "SELECT item, price * qty AS total FROM orders ORDER BY total DESC;")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if !strings.Contains(lines[0], "total") {
+		t.Fatalf("expected alias 'total' in header, got: %s", lines[0])
+	}
+	if len(lines) != 5 || !strings.Contains(lines[2], "15") || !strings.Contains(lines[4], "6") {
+		t.Fatalf("expected totals 15, 12, 6 in descending order, got:\n%s", out)
+	}
+}
+
+func TestHandleSelect_CaseAndFunctions(t *testing.T) {
+	db := newOrdersDB(t)
+	out := runSelect(t, db, "SELECT id, CASE WHEN qty > 3 THEN 'bulk' ELSE 'single' END AS kind, COALESCE(NULL, item) || '!' AS label FROM orders WHERE CAST(price AS INT) = 2;")
+	if !strings.Contains(out, "bulk") || !strings.Contains(out, "pen!") || strings.Contains(out, "book") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestHandleSelect_GroupByExpression(t *testing.T) {
+	db := newOrdersDB(t)
+	out := runSelect(t, db, "SELECT qty > 3 AS big, SUM(price * qty) AS revenue FROM orders GROUP BY big ORDER BY revenue;")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected two groups, got:\n%s", out)
+	}
+	if !strings.HasPrefix(lines[2], "false") || !strings.Contains(lines[2], "12") || !strings.Contains(lines[3], "21") {
+		t.Fatalf("unexpected grouped output:\n%s", out)
+	}
+}
+
+func TestHandleSelect_UnknownColumnInExpression(t *testing.T) {
+	db := newOrdersDB(t)
+	cmd, err := parser.Parse("SELECT price * missing FROM orders;")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if _, err := HandleSelect(cmd, db); err == nil {
+		t.Fatalf("expected error for expression referencing unknown column")
+	}
+}
+
+func TestHandleSelect_ArgumentKinds(t *testing.T) {
+	db := newOrdersDB(t)
+	cases := []struct {
+		sql    string
+		handle func(parser.Command, *schema.Database) (string, error)
+		want   string
+	}{
+		{"SELECT UPPER(qty) FROM orders;", HandleSelect, "UPPER: argument 1 (string) must be TEXT, got INTEGER"},
+		{"SELECT ROUND(item, 0) AS r FROM orders;", HandleSelect, "ROUND: argument 1 (value) must be NUMERIC, got TEXT"},
+		{"SELECT id FROM orders WHERE ABS(item) > 1;", HandleSelect, "ABS: argument 1 (value) must be NUMERIC, got TEXT"},
+		{"SELECT MEDIAN(item) FROM orders;", HandleSelect, "MEDIAN: argument 1 (value) must be NUMERIC, got TEXT"},
+		{"UPDATE orders SET item = UPPER(price) WHERE id = 1;", HandleUpdate, "UPPER: argument 1 (string) must be TEXT, got NUMERIC"},
+		{"DELETE FROM orders WHERE LOWER(qty) = 'x';", HandleDelete, "LOWER: argument 1 (string) must be TEXT, got INTEGER"},
+	}
+	for _, c := range cases {
+		cmd, err := parser.Parse(c.sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if _, err := c.handle(cmd, db); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: expected error containing %q, got %v", c.sql, c.want, err)
+		}
+	}
+	if out := runSelect(t, db, "SELECT COUNT(*) FROM orders;"); dataLines(out)[0] != "3" {
+		t.Errorf("a rejected statement changed rows: %q", dataLines(out))
+	}
+}
+
+func TestHandleSelect_ScalarFunctions(t *testing.T) {
+	db := newOrdersDB(t)
+	out := runSelect(t, db, "SELECT UPPER(item) AS name, EXTRACT(YEAR FROM '2024-02-03') AS y FROM orders WHERE LENGTH(item) = 4;")
+	if !strings.Contains(out, "BOOK") || !strings.Contains(out, "2024") || strings.Contains(out, "PEN") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestHandleSelect_AggregateExpressionsAndHaving(t *testing.T) {
+	db := newOrdersDB(t)
+	out := runSelect(t, db, "SELECT item, SUM(price * qty) / COUNT(*) AS avg_total FROM orders GROUP BY item HAVING COUNT(*) > 1;")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], "pen") || !strings.Contains(lines[2], "10.5") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestHandleSelect_UserAggregate(t *testing.T) {
+	err := expr.RegisterAggregate(&expr.AggregateFunction{
+		Name:    "max_len",
+		Params:  []expr.Param{{Name: "value", Kind: expr.KindText}},
+		Returns: expr.KindInteger,
+		New:     func() expr.Accumulator { return &maxLenAcc{} },
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	t.Cleanup(func() { expr.UnregisterFunction("max_len") })
+
+	db := newOrdersDB(t)
+	out := runSelect(t, db, "SELECT max_len(item) AS longest FROM orders;")
+	if !strings.Contains(out, "longest") || !strings.Contains(out, "4") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	fns, err := HandleShowFunctions()
+	if err != nil || !strings.Contains(fns, "MAX_LEN") || !strings.Contains(fns, "UPPER") {
+		t.Fatalf("SHOW FUNCTIONS missing entries:\n%s", fns)
+	}
+}
+
+type maxLenAcc struct{ n int }
+
+func (m *maxLenAcc) Step(args []interface{}) error {
+	if l := len(args[0].(string)); l > m.n {
+		m.n = l
+	}
+	return nil
+}
+
+func (m *maxLenAcc) Result() (interface{}, error) { return m.n, nil }
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func newSalesDB(t *testing.T) *schema.Database {
+	t.Helper()
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	tbl := schema.Table{Name: "sales", Columns: []schema.Column{
+		{Name: "region", Type: schema.Text},
+		{Name: "year", Type: schema.Integer},
+		{Name: "amount", Type: schema.Decimal},
+	}}
+	if err := db.AddTable(tbl); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	tf, err := storage.NewTableFile(db.GetDBPath(), "sales")
+	if err != nil {
+		t.Fatalf("new table file: %v", err)
+	}
+	for _, r := range []storage.Row{
+		{"region": "east", "year": 2023, "amount": 10},
+		{"region": "east", "year": 2024, "amount": 20},
+		{"region": "west", "year": 2023, "amount": 5},
+		{"region": "east", "year": 2024, "amount": 1},
+		{"region": "1", "year": 2024, "amount": 2},
+	} {
+		if err := tf.AppendRow(r); err != nil {
+			t.Fatalf("append row: %v", err)
+		}
+	}
+	return db
+}
+
+// dataLines returns the result rows without the header and separator lines,
+// with runs of padding collapsed to single spaces.
+func dataLines(out string) []string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")[2:]
+	for i, l := range lines {
+		lines[i] = strings.Join(strings.Fields(l), " ")
+	}
+	return lines
+}
+
+func TestHandleSelect_GroupByMultipleColumns(t *testing.T) {
+	db := newSalesDB(t)
+	out := runSelect(t, db, "SELECT region, year, SUM(amount) AS total FROM sales GROUP BY region, year ORDER BY total;")
+	got := dataLines(out)
+	want := []string{"1 2024 2", "west 2023 5", "east 2023 10", "east 2024 21"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestHandleSelect_GroupByExpressionList(t *testing.T) {
+	db := newSalesDB(t)
+	out := runSelect(t, db, "SELECT UPPER(region) AS r, year > 2023 AS recent, COUNT(*) FROM sales GROUP BY UPPER(region), year > 2023 ORDER BY count DESC;")
+	got := dataLines(out)
+	if len(got) != 4 || got[0] != "EAST true 2" {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestHandleSelect_Rollup(t *testing.T) {
+	db := newSalesDB(t)
+	out := runSelect(t, db, "SELECT region, year, SUM(amount) AS total, GROUPING(region, year) AS g FROM sales WHERE region <> '1' GROUP BY ROLLUP(region, year);")
+	got := strings.Join(dataLines(out), "\n")
+	want := strings.Join([]string{
+		"east 2023 10 0", "east 2024 21 0", "west 2023 5 0",
+		"east NULL 31 1", "west NULL 5 1",
+		"NULL NULL 36 3",
+	}, "\n")
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHandleSelect_CubeAndGroupingSets(t *testing.T) {
+	db := newSalesDB(t)
+	out := runSelect(t, db, "SELECT region, year, COUNT(*) FROM sales WHERE region <> '1' GROUP BY CUBE(region, year);")
+	if n := len(dataLines(out)); n != 3+2+2+1 {
+		t.Fatalf("expected 8 CUBE rows, got %d:\n%s", n, out)
+	}
+	out = runSelect(t, db, "SELECT region, year, COUNT(*) FROM sales GROUP BY GROUPING SETS ((region), (year), ()) HAVING GROUPING(region) = 1;")
+	got := strings.Join(dataLines(out), "\n")
+	want := strings.Join([]string{"NULL 2023 2", "NULL 2024 3", "NULL NULL 5"}, "\n")
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHandleSelect_GroupingErrors(t *testing.T) {
+	db := newSalesDB(t)
+	for _, sql := range []string{
+		"SELECT region, amount, COUNT(*) FROM sales GROUP BY region;",
+		"SELECT region, GROUPING(amount) FROM sales GROUP BY region;",
+		"SELECT region FROM sales GROUP BY ROLLUP region;",
+		"SELECT region FROM sales GROUP BY nothing;",
+	} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if _, err := HandleSelect(cmd, db); err == nil {
+			t.Fatalf("expected error for %q", sql)
+		}
+	}
+}
+
+func TestHandleSelect_StatisticalAggregates(t *testing.T) {
+	db := newSalesDB(t)
+	out := runSelect(t, db, "SELECT region, COUNT(DISTINCT year) AS years, STRING_AGG(CAST(amount AS TEXT), '+' ORDER BY amount) AS parts, "+
+		"PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY amount) AS mid, COUNT(*) FILTER (WHERE year = 2024) AS recent "+
+		"FROM sales GROUP BY region ORDER BY region;")
+	got := strings.Join(dataLines(out), "\n")
+	want := strings.Join([]string{"1 1 2 2 1", "east 2 1+10+20 10 2", "west 1 5 5 0"}, "\n")
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	out = runSelect(t, db, "SELECT MIN(region), MAX(region), STDDEV_POP(year) FROM sales;")
+	if got := dataLines(out); len(got) != 1 || !strings.HasPrefix(got[0], "1 west 0.4") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	out = runSelect(t, db, "SELECT STRING_AGG(region, ', ' ORDER BY region) FROM sales;")
+	if header := strings.SplitN(out, "\n", 2)[0]; strings.TrimSpace(header) != "string_agg_region" {
+		t.Fatalf("expected header string_agg_region, got %q", header)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// newNumbersDB creates a table of n rows numbered from 1 whose code is the
+// number as text, except for the rows from bad on, whose code is not a
+// number.
+func newNumbersDB(t *testing.T, n, bad int) *schema.Database {
+	t.Helper()
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	cols, _ := schema.ParseColumnDefs("n INT, code TEXT")
+	if err := db.AddTable(schema.Table{Name: "numbers", Columns: cols}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	tf, _ := storage.NewTableFile(db.GetDBPath(), "numbers")
+	for i := 1; i <= n; i++ {
+		code := fmt.Sprint(i)
+		if i >= bad {
+			code = "x"
+		}
+		if err := tf.AppendRow(storage.Row{"n": i, "code": code}); err != nil {
+			t.Fatalf("append row: %v", err)
+		}
+	}
+	return db
+}
+
+func TestHandleSelect_LimitOffset(t *testing.T) {
+	db := newNumbersDB(t, 20, 100)
+	cases := map[string]string{
+		"SELECT n FROM numbers LIMIT 3;":                                                   "1,2,3",
+		"SELECT n FROM numbers LIMIT 2 OFFSET 5;":                                          "6,7",
+		"SELECT n FROM numbers OFFSET 18;":                                                 "19,20",
+		"SELECT n FROM numbers WHERE n % 2 = 0 ORDER BY n DESC LIMIT 2;":                   "20,18",
+		"SELECT n FROM numbers LIMIT 0;":                                                   "",
+		"SELECT DISTINCT n % 3 AS r FROM numbers ORDER BY r LIMIT 2;":                      "0,1",
+		"SELECT n % 4 AS r, COUNT(*) FROM numbers GROUP BY r ORDER BY r LIMIT 1 OFFSET 1;": "1 5",
+	}
+	for sql, want := range cases {
+		if got := strings.Join(dataLines(runSelect(t, db, sql)), ","); got != want {
+			t.Fatalf("%s: got %q, want %q", sql, got, want)
+		}
+	}
+	for _, sql := range []string{
+		"SELECT n FROM numbers LIMIT ten;",
+		"SELECT n FROM numbers LIMIT -1;",
+		"SELECT n FROM numbers LIMIT;",
+	} {
+		cmd, _ := parser.Parse(sql)
+		if _, err := HandleSelect(cmd, db); err == nil {
+			t.Fatalf("expected an error for %q", sql)
+		}
+	}
+}
+
+func TestHandleSelect_LimitStopsReading(t *testing.T) {
+	// rows from the 10th on fail the WHERE cast, so the query only succeeds
+	// when the scan stops at the limit
+	db := newNumbersDB(t, 50, 10)
+	out := runSelect(t, db, "SELECT n FROM numbers WHERE CAST(code AS INT) % 2 = 1 LIMIT 3;")
+	if got := strings.Join(dataLines(out), ","); got != "1,3,5" {
+		t.Fatalf("got %q", got)
+	}
+	cmd, _ := parser.Parse("SELECT n FROM numbers WHERE CAST(code AS INT) % 2 = 1 ORDER BY n LIMIT 3;")
+	if _, err := HandleSelect(cmd, db); err == nil {
+		t.Fatalf("a sorted query reads every row and should reach the bad ones")
+	}
+}
+
+func TestStreamSelect(t *testing.T) {
+	db := newNumbersDB(t, streamFitRows+5, 1<<30)
+	sql := "SELECT n, code FROM numbers;"
+	cmd, _ := parser.Parse(sql)
+	sb := &strings.Builder{}
+	if err := StreamSelect(cmd, db, sb); err != nil {
+		t.Fatalf("StreamSelect: %v", err)
+	}
+	if sb.String() != runSelect(t, db, sql) {
+		t.Fatalf("streamed output should match HandleSelect when values fit")
+	}
+
+	// widths are fitted to the first rows; a wider later value stays apart
+	sb.Reset()
+	rw := newResultWriter(sb, []string{"a", "b"}, 1)
+	rw.write([]interface{}{"short", 1})
+	rw.write([]interface{}{strings.Repeat("w", 25), 2})
+	rw.close()
+	lines := strings.Split(sb.String(), "\n")
+	if len(lines[2]) != 40 || !strings.HasPrefix(lines[3], strings.Repeat("w", 25)+" 2") {
+		t.Fatalf("unexpected streamed layout:\n%s", sb.String())
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+func newPeopleDB(t *testing.T) *schema.Database {
+	t.Helper()
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	tbl := schema.Table{Name: "people", Columns: []schema.Column{
+		{Name: "name", Type: schema.Text},
+		{Name: "age", Type: schema.Integer},
+		{Name: "code", Type: schema.Text},
+	}}
+	if err := db.AddTable(tbl); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	tf, err := storage.NewTableFile(db.GetDBPath(), "people")
+	if err != nil {
+		t.Fatalf("new table file: %v", err)
+	}
+	for _, r := range []storage.Row{
+		{"name": "bob", "age": 30, "code": "10"},
+		{"name": "Alice", "age": 9, "code": "9"},
+		{"name": "carol", "age": 30, "code": "100"},
+		{"name": "Bob", "age": 41, "code": "2"},
+	} {
+		if err := tf.AppendRow(r); err != nil {
+			t.Fatalf("append row: %v", err)
+		}
+	}
+	return db
+}
+
+func orderedNames(t *testing.T, db *schema.Database, sql string) string {
+	t.Helper()
+	names := []string{}
+	for _, l := range dataLines(runSelect(t, db, sql)) {
+		names = append(names, strings.Fields(l)[0])
+	}
+	return strings.Join(names, " ")
+}
+
+func TestHandleSelect_OrderByMultipleKeys(t *testing.T) {
+	db := newPeopleDB(t)
+	cases := []struct{ sql, want string }{
+		{"SELECT name, age FROM people ORDER BY age DESC, name;", "Bob bob carol Alice"},
+		{"SELECT name, age FROM people ORDER BY 2, 1 DESC;", "Alice carol bob Bob"},
+		{"SELECT name FROM people ORDER BY name;", "Alice Bob bob carol"},
+		{"SELECT name FROM people ORDER BY name COLLATE NOCASE DESC;", "carol bob Bob Alice"},
+		{"SELECT name FROM people ORDER BY code;", "bob carol Bob Alice"},
+		{"SELECT name FROM people ORDER BY CAST(code AS INT);", "Bob Alice bob carol"},
+		{"SELECT name FROM people ORDER BY age % 10, LENGTH(name) DESC;", "carol bob Bob Alice"},
+	}
+	for _, c := range cases {
+		if got := orderedNames(t, db, c.sql); got != c.want {
+			t.Fatalf("%s\ngot:  %s\nwant: %s", c.sql, got, c.want)
+		}
+	}
+}
+
+func TestHandleSelect_OrderByNulls(t *testing.T) {
+	db := newPeopleDB(t)
+	cases := []struct{ sql, want string }{
+		{"SELECT NULLIF(age, 30) AS a, name FROM people ORDER BY a, name;", "9 41 NULL NULL"},
+		{"SELECT NULLIF(age, 30) AS a, name FROM people ORDER BY a DESC;", "NULL NULL 41 9"},
+		{"SELECT NULLIF(age, 30) AS a, name FROM people ORDER BY a NULLS FIRST;", "NULL NULL 9 41"},
+		{"SELECT NULLIF(age, 30) AS a, name FROM people ORDER BY a DESC NULLS LAST;", "41 9 NULL NULL"},
+	}
+	for _, c := range cases {
+		if got := orderedNames(t, db, c.sql); got != c.want {
+			t.Fatalf("%s\ngot:  %s\nwant: %s", c.sql, got, c.want)
+		}
+	}
+}
+
+func TestHandleSelect_OrderByErrors(t *testing.T) {
+	db := newPeopleDB(t)
+	for _, sql := range []string{
+		"SELECT name FROM people ORDER BY 3;",
+		"SELECT name FROM people ORDER BY missing;",
+		"SELECT name FROM people ORDER BY name COLLATE klingon;",
+	} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if _, err := HandleSelect(cmd, db); err == nil {
+			t.Fatalf("expected error for %q", sql)
+		}
+	}
+}
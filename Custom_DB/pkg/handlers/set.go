@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+)
+
+// HandleSet processes a SET command. SET TIME ZONE zone and SET TIMEZONE
+// [TO | =] zone choose the session time zone TIMESTAMPTZ values are shown in
+// and zone-less timestamps are read in; zone is LOCAL, UTC, an IANA name or
+// an offset such as '+05:30'. SET WORK_MEM [TO | =] size sets the memory
+// a query's sorts and aggregations may hold before they spill to disk, as
+// in '16MB'; a bare number is in kilobytes. SET PARALLEL_WORKERS [TO | =] n
+// sets how many workers a large table is scanned with; 1 turns parallel
+// scans off. Each is set for the session of db only.
+func HandleSet(cmd parser.Command, db *schema.Database) (string, error) {
+	tokens := cmd.Tokens[1:]
+	switch {
+	case len(tokens) >= 1 && strings.EqualFold(tokens[0], "WORK_MEM"):
+		return setWorkMem(tokens[1:], db)
+	case len(tokens) >= 1 && strings.EqualFold(tokens[0], "PARALLEL_WORKERS"):
+		return setParallelWorkers(tokens[1:], db)
+	case len(tokens) >= 2 && strings.EqualFold(tokens[0], "TIME") && strings.EqualFold(tokens[1], "ZONE"):
+		tokens = tokens[2:]
+	case len(tokens) >= 1 && strings.EqualFold(tokens[0], "TIMEZONE"):
+		tokens = tokens[1:]
+	default:
+		return "", fmt.Errorf("invalid SET syntax. Example: SET TIME ZONE 'Europe/Berlin';")
+	}
+	if len(tokens) > 0 && (strings.EqualFold(tokens[0], "TO") || tokens[0] == "=") {
+		tokens = tokens[1:]
+	}
+	if len(tokens) != 1 {
+		return "", fmt.Errorf("invalid SET syntax. Example: SET TIME ZONE 'Europe/Berlin';")
+	}
+	if err := expr.SetSessionTimeZone(db, tokens[0]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("✅ Time zone set to %s", expr.SessionTimeZone(db)), nil
+}
+
+// minWorkMem is the least WORK_MEM accepted.
+const minWorkMem = 64 << 10
+
+func setWorkMem(tokens []string, db *schema.Database) (string, error) {
+	if len(tokens) > 0 && (strings.EqualFold(tokens[0], "TO") || tokens[0] == "=") {
+		tokens = tokens[1:]
+	}
+	if len(tokens) != 1 {
+		return "", fmt.Errorf("invalid SET syntax. Example: SET WORK_MEM '16MB';")
+	}
+	n, err := parseMemSize(tokens[0])
+	if err != nil {
+		return "", err
+	}
+	if n < minWorkMem {
+		return "", fmt.Errorf("WORK_MEM must be at least %s", formatMemSize(minWorkMem))
+	}
+	db.SetSetting("work_mem", strconv.FormatInt(n, 10))
+	return fmt.Sprintf("✅ Work memory set to %s", formatMemSize(n)), nil
+}
+
+func setParallelWorkers(tokens []string, db *schema.Database) (string, error) {
+	if len(tokens) > 0 && (strings.EqualFold(tokens[0], "TO") || tokens[0] == "=") {
+		tokens = tokens[1:]
+	}
+	if len(tokens) != 1 {
+		return "", fmt.Errorf("invalid SET syntax. Example: SET PARALLEL_WORKERS 4;")
+	}
+	n, err := strconv.Atoi(strings.Trim(tokens[0], "'\""))
+	if err != nil || n < 1 || n > maxParallelWorkers {
+		return "", fmt.Errorf("PARALLEL_WORKERS must be a number from 1 to %d", maxParallelWorkers)
+	}
+	db.SetSetting("parallel_workers", strconv.Itoa(n))
+	return fmt.Sprintf("✅ Parallel workers set to %d", n), nil
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// defaultWorkMem is the memory budget of a query until SET WORK_MEM
+// changes it.
+const defaultWorkMem = 64 << 20
+
+// workMem is the memory budget per query, in bytes, of a session that has
+// not SET WORK_MEM: what its sorts and aggregations together may hold in
+// memory before they spill rows to temporary files.
+var workMem int64 = defaultWorkMem
+
+// sessionWorkMem returns the memory budget per query of the session of db.
+func sessionWorkMem(db *schema.Database) int64 {
+	if v, ok := db.Setting("work_mem"); ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return workMem
+}
+
+// memBudget tracks the memory the operators of one query hold rows in.
+// An operator that grows past the budget writes rows to temporary files in
+// dir and releases the memory they held. The rows parallel workers hand to
+// a Gather are charged too, from the workers' goroutines. A nil budget is
+// unlimited.
+type memBudget struct {
+	limit int64
+	used  int64
+	dir   string
+}
+
+// newMemBudget returns a budget of limit bytes for a query on the database
+// at dbPath, which spills to its tmp directory.
+func newMemBudget(dbPath string, limit int64) *memBudget {
+	dir := os.TempDir()
+	if dbPath != "" {
+		dir = filepath.Join(dbPath, "tmp")
+	}
+	return &memBudget{limit: limit, dir: dir}
+}
+
+// grow records n more bytes held and reports whether the query is still
+// within its budget.
+func (m *memBudget) grow(n int64) bool {
+	if m == nil {
+		return true
+	}
+	return atomic.AddInt64(&m.used, n) <= m.limit
+}
+
+// within reports whether the query is within its budget.
+func (m *memBudget) within() bool {
+	return m == nil || atomic.LoadInt64(&m.used) <= m.limit
+}
+
+// release records n bytes no longer held.
+func (m *memBudget) release(n int64) {
+	if m != nil {
+		atomic.AddInt64(&m.used, -n)
+	}
+}
+
+// rowBytes estimates the memory a row holds.
+func rowBytes(r storage.Row) int64 {
+	n := 48
+	for k, v := range r {
+		n += 16 + len(k) + expr.ValueSize(v)
+	}
+	return int64(n)
+}
+
+// parseMemSize parses a memory size such as 64MB, 512kB or 1GB. A bare
+// number is in kilobytes.
+func parseMemSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.Trim(s, "'\""))
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) })
+	if i == -1 {
+		i = len(s)
+	}
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size '%s'", s)
+	}
+	units := map[string]int64{"": 1 << 10, "B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30}
+	unit, ok := units[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid memory size '%s': use a unit of B, kB, MB or GB", s)
+	}
+	return n * unit, nil
+}
+
+// formatMemSize renders a memory size in the largest unit that divides it.
+func formatMemSize(n int64) string {
+	for _, u := range []struct {
+		name string
+		size int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"kB", 1 << 10}} {
+		if n >= u.size && n%u.size == 0 {
+			return fmt.Sprintf("%d%s", n/u.size, u.name)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// spiller is an operator that may spill rows to disk; spilled describes
+// what it wrote, for EXPLAIN ANALYZE, or is empty.
+type spiller interface {
+	spilled() string
+}
+
+// spillFile is a temporary file of rows an operator could not keep in
+// memory. Each row is written with a tag, a number the operator gives it
+// meaning. Values keep their types (see expr.MarshalValue).
+type spillFile struct {
+	f     *os.File
+	w     *bufio.Writer
+	rows  int
+	bytes int64
+}
+
+// spillRecord is the form of a row in a spill file.
+type spillRecord struct {
+	Tag int                        `json:"tag"`
+	Row map[string]json.RawMessage `json:"row"`
+}
+
+func newSpillFile(m *memBudget) (*spillFile, error) {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory %s: %w", m.dir, err)
+	}
+	f, err := os.CreateTemp(m.dir, "spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	return &spillFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// write appends a row.
+func (s *spillFile) write(r storage.Row, tag int) error {
+	rec := spillRecord{Tag: tag, Row: make(map[string]json.RawMessage, len(r))}
+	for k, v := range r {
+		data, err := expr.MarshalValue(v)
+		if err != nil {
+			return err
+		}
+		rec.Row[k] = data
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	s.rows++
+	s.bytes += int64(len(line)) + 1
+	return nil
+}
+
+// reader returns an operator reading back the rows written, in order.
+func (s *spillFile) reader() (*spillReader, error) {
+	if err := s.w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write spill file: %w", err)
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &spillReader{r: bufio.NewReader(s.f)}, nil
+}
+
+// Close removes the file.
+func (s *spillFile) Close() error {
+	s.f.Close()
+	return os.Remove(s.f.Name())
+}
+
+// spillReader yields the rows of a spill file.
+type spillReader struct {
+	r   *bufio.Reader
+	tag int // the tag of the row last read
+}
+
+func (s *spillReader) Next() (storage.Row, error) {
+	line, err := s.r.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return nil, nil
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	var rec spillRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return nil, fmt.Errorf("corrupt spill file: %w", err)
+	}
+	r := make(storage.Row, len(rec.Row))
+	for k, data := range rec.Row {
+		v, err := expr.UnmarshalValue(data)
+		if err != nil {
+			return nil, err
+		}
+		r[k] = v
+	}
+	s.tag = rec.Tag
+	return r, nil
+}
+
+func (s *spillReader) Close() error { return nil }
+
+// mergeIter merges inputs each ordered by keys into one ordered stream,
+// the k-way merge of an external sort. Rows that tie come from the input
+// listed first, so merging consecutive runs of a stable sort is stable.
+type mergeIter struct {
+	inputs []rowIter
+	keys   []sortKey
+	heads  mergeHeap
+	primed bool
+}
+
+// mergeHead is the next row of one input of a merge.
+type mergeHead struct {
+	row   storage.Row
+	vals  []interface{}
+	input int
+}
+
+type mergeHeap struct {
+	items []mergeHead
+	keys  []sortKey
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	for k, key := range h.keys {
+		if c := compareKey(a.vals[k], b.vals[k], key); c != 0 {
+			return c < 0
+		}
+	}
+	return a.input < b.input
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(mergeHead)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// pull reads the next row of input i onto the heap.
+func (m *mergeIter) pull(i int) error {
+	r, err := m.inputs[i].Next()
+	if r == nil || err != nil {
+		return err
+	}
+	vals := make([]interface{}, len(m.keys))
+	for k := range m.keys {
+		if vals[k], err = m.keys[k].value(r); err != nil {
+			return fmt.Errorf("error evaluating ORDER BY: %w", err)
+		}
+	}
+	heap.Push(&m.heads, mergeHead{row: r, vals: vals, input: i})
+	return nil
+}
+
+func (m *mergeIter) Next() (storage.Row, error) {
+	if !m.primed {
+		m.primed = true
+		m.heads.keys = m.keys
+		for i := range m.inputs {
+			if err := m.pull(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if m.heads.Len() == 0 {
+		return nil, nil
+	}
+	head := heap.Pop(&m.heads).(mergeHead)
+	if err := m.pull(head.input); err != nil {
+		return nil, err
+	}
+	return head.row, nil
+}
+
+func (m *mergeIter) Close() error {
+	var first error
+	for _, in := range m.inputs {
+		if err := in.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
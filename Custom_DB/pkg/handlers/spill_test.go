@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// newBigOrdersDB returns a database whose orders table is too large for a
+// small WORK_MEM.
+func newBigOrdersDB(t *testing.T) *schema.Database {
+	t.Helper()
+	db := newOrdersDB(t)
+	tf, err := storage.NewTableFile(db.GetDBPath(), "orders")
+	if err != nil {
+		t.Fatalf("new table file: %v", err)
+	}
+	for i := 4; i <= 3000; i++ {
+		r := storage.Row{"id": i, "item": fmt.Sprintf("item-%03d", i%400), "price": float64(i%7) + 0.5, "qty": i % 13}
+		if i%97 == 0 {
+			r["item"] = nil
+		}
+		if err := tf.AppendRow(r); err != nil {
+			t.Fatalf("append row: %v", err)
+		}
+	}
+	return db
+}
+
+func setTestWorkMem(t *testing.T, n int64) {
+	t.Helper()
+	old := workMem
+	workMem = n
+	t.Cleanup(func() { workMem = old })
+}
+
+func TestSpill_SameResults(t *testing.T) {
+	db := newBigOrdersDB(t)
+	queries := []string{
+		"SELECT id, item, qty FROM orders ORDER BY item DESC, qty, id;",
+		"SELECT id, item FROM orders ORDER BY qty, price DESC LIMIT 20 OFFSET 5;",
+		"SELECT item, COUNT(*), SUM(qty), MIN(price) FROM orders GROUP BY item ORDER BY item;",
+		"SELECT item, qty, COUNT(*) FROM orders GROUP BY ROLLUP (item, qty) ORDER BY item, qty;",
+		"SELECT qty, STRING_AGG(item, ',' ORDER BY id) FROM orders GROUP BY qty HAVING COUNT(*) > 200 ORDER BY qty;",
+	}
+	want := map[string]string{}
+	for _, q := range queries {
+		want[q] = runHandler(t, q, HandleSelect, db)
+	}
+	setTestWorkMem(t, 64<<10)
+	for _, q := range queries {
+		if got := runHandler(t, q, HandleSelect, db); got != want[q] {
+			t.Errorf("%s: spilled result differs\ngot:\n%.500s\nwant:\n%.500s", q, got, want[q])
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(db.GetDBPath(), "tmp"))
+	if len(entries) != 0 {
+		t.Errorf("spill files left behind: %d", len(entries))
+	}
+}
+
+func TestSpill_ExplainAnalyze(t *testing.T) {
+	db := newBigOrdersDB(t)
+	setTestWorkMem(t, 64<<10)
+	out := runHandler(t, "EXPLAIN ANALYZE SELECT id FROM orders ORDER BY item, id;", HandleExplain, db)
+	if !strings.Contains(out, "Sort by item, id (rows=3000 time=") || !strings.Contains(out, "[external merge of ") {
+		t.Errorf("expected the sort to spill:\n%s", out)
+	}
+	out = runHandler(t, "EXPLAIN ANALYZE SELECT id, COUNT(*) FROM orders GROUP BY id;", HandleExplain, db)
+	if !strings.Contains(out, "(rows=3000 time=") || !strings.Contains(out, " rows spilled to partitions, ") {
+		t.Errorf("expected the aggregation to spill:\n%s", out)
+	}
+	// every group comes out once, whichever partition it was spilled to
+	out = runHandler(t, "SELECT id, COUNT(*) FROM orders GROUP BY id;", HandleSelect, db)
+	if n := strings.Count(out, "\n"); n != 3000+2 {
+		t.Errorf("expected 3000 groups, got %d", n-2)
+	}
+}
+
+func TestHandleSet_WorkMem(t *testing.T) {
+	db := newOrdersDB(t)
+	cases := []struct{ sql, want string }{
+		{"SET WORK_MEM '16MB';", "✅ Work memory set to 16MB"},
+		{"SET WORK_MEM TO 4096;", "✅ Work memory set to 4MB"},
+		{"SET work_mem = '512kB'", "✅ Work memory set to 512kB"},
+	}
+	for _, c := range cases {
+		sql, want := c.sql, c.want
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q: %v", sql, err)
+		}
+		if got, err := HandleSet(cmd, db); err != nil || got != want {
+			t.Errorf("%s: got %q, %v; want %q", sql, got, err, want)
+		}
+	}
+	if sessionWorkMem(db) != 512<<10 || workMem != defaultWorkMem {
+		t.Errorf("work_mem %d, default %d", sessionWorkMem(db), workMem)
+	}
+	for _, sql := range []string{"SET WORK_MEM '1kB';", "SET WORK_MEM '12 parsecs';", "SET WORK_MEM;"} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse %q: %v", sql, err)
+		}
+		if _, err := HandleSet(cmd, db); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"fmt"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// DropTempTables drops the temporary tables of the session of db, as when
+// the session ends. Every table is dropped even if one fails; the first
+// error is returned.
+func DropTempTables(db *schema.Database) error {
+	var first error
+	for _, table := range db.RemoveTempTables() {
+		if err := dropTempTable(db, table); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// dropTempTable releases the blobs of a temporary table already removed
+// from the schema and deletes its rows and indexes.
+func dropTempTable(db *schema.Database, table schema.Table) error {
+	blobErr := DropTableBlobs(db, table)
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+	if err != nil {
+		return fmt.Errorf("error accessing temporary table '%s': %s", table.Name, err)
+	}
+	if err := tableFile.DeleteFile(); err != nil {
+		return fmt.Errorf("error deleting temporary table '%s': %s", table.Name, err)
+	}
+	if blobErr != nil {
+		return fmt.Errorf("temporary table '%s' dropped, but its blobs were not released: %w", table.Name, blobErr)
+	}
+	return nil
+}
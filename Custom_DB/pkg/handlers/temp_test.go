@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+var tempTestColumns = []schema.Column{{Name: "id", Type: schema.Integer}, {Name: "name", Type: schema.Text}}
+
+func TestMemoryDatabase(t *testing.T) {
+	db, err := schema.NewDatabase(schema.MemoryPath)
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	if !db.InMemory() {
+		t.Fatal("expected an in-memory database")
+	}
+	if err := db.AddTable(schema.Table{Name: "people", Columns: tempTestColumns}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	runHandler(t, "INSERT INTO people (id, name) VALUES (1, 'ann');", HandleInsert, db)
+	runHandler(t, "INSERT INTO people (id, name) VALUES (2, 'bob');", HandleInsert, db)
+	runHandler(t, "UPDATE people SET name = 'cy' WHERE id = 2;", HandleUpdate, db)
+	runHandler(t, "CREATE INDEX idx_name ON people (name);", HandleCreateIndex, db)
+	out := runSelect(t, db, "SELECT id FROM people WHERE name = 'cy';")
+	if got := dataLines(out); len(got) != 1 || got[0] != "2" {
+		t.Fatalf("unexpected rows: %q", got)
+	}
+
+	for _, name := range []string{"schema.json", "people.dat"} {
+		if _, err := os.Stat(filepath.Join(db.GetDBPath(), name)); !os.IsNotExist(err) {
+			t.Errorf("%s written to disk: %v", name, err)
+		}
+	}
+	other, err := schema.NewDatabase(schema.MemoryPath)
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	defer other.Close()
+	if _, ok := other.GetTable("people"); ok {
+		t.Error("in-memory databases share tables")
+	}
+
+	session := db.Session("one")
+	if err := session.AddTable(schema.Table{Name: "scratch", Columns: tempTestColumns, Temp: true}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	runHandler(t, "INSERT INTO scratch (id, name) VALUES (1, 'ann');", HandleInsert, session)
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := os.Stat(db.GetDBPath()); !os.IsNotExist(err) {
+		t.Errorf("scratch directory left behind: %v", err)
+	}
+	people, _ := db.GetTable("people")
+	scratch, _ := session.GetTable("scratch")
+	for _, table := range []schema.Table{people, scratch} {
+		tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+		if err != nil {
+			t.Fatalf("open table: %v", err)
+		}
+		if rows, err := storage.ReadAll(tableFile); err != nil || len(rows) != 0 {
+			t.Errorf("%s: rows kept in memory after close: %v, %v", table.Name, rows, err)
+		}
+		tableFile.DeleteFile()
+	}
+}
+
+func TestTempTables(t *testing.T) {
+	db := newOrdersDB(t)
+	s1, s2 := db.Session("one"), db.Session("two")
+	if err := s1.AddTable(schema.Table{Name: "scratch", Columns: tempTestColumns, Temp: true}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	runHandler(t, "INSERT INTO scratch (id, name) VALUES (7, 'pen');", HandleInsert, s1)
+	out := runSelect(t, s1, "SELECT id FROM scratch WHERE name = 'pen';")
+	if got := dataLines(out); len(got) != 1 || got[0] != "7" {
+		t.Fatalf("unexpected rows: %q", got)
+	}
+
+	// only the session that made it sees it, and it is never saved
+	if _, ok := s2.GetTable("scratch"); ok {
+		t.Error("another session sees the temporary table")
+	}
+	for _, names := range [][]string{s2.GetAllTableNames(), db.GetAllTableNames()} {
+		if len(names) != 1 || names[0] != "orders" {
+			t.Errorf("unexpected tables %q", names)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(db.GetDBPath(), "schema.json"))
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	if strings.Contains(string(data), "scratch") {
+		t.Errorf("temporary table saved:\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(db.GetDBPath(), "scratch.dat")); !os.IsNotExist(err) {
+		t.Errorf("temporary table written to disk: %v", err)
+	}
+
+	// ending the session drops it along with its rows
+	if err := DropTempTables(s2); err != nil {
+		t.Fatalf("drop temp tables: %v", err)
+	}
+	if _, ok := s1.GetTable("scratch"); !ok {
+		t.Fatal("temporary table dropped by another session")
+	}
+	if err := DropTempTables(s1); err != nil {
+		t.Fatalf("drop temp tables: %v", err)
+	}
+	if _, ok := s1.GetTable("scratch"); ok {
+		t.Fatal("temporary table outlived its session")
+	}
+	if err := s1.AddTable(schema.Table{Name: "scratch", Columns: tempTestColumns, Temp: true}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	out = runSelect(t, s1, "SELECT COUNT(*) FROM scratch;")
+	if got := dataLines(out); len(got) != 1 || got[0] != "0" {
+		t.Errorf("rows of a dropped temporary table came back: %q", got)
+	}
+}
+
+func TestTempTables_SameNameInTwoSessions(t *testing.T) {
+	db := newOrdersDB(t)
+	s1, s2 := db.Session("one"), db.Session("two")
+	for i, s := range []*schema.Database{s1, s2} {
+		if err := s.AddTable(schema.Table{Name: "scratch", Columns: tempTestColumns, Temp: true}); err != nil {
+			t.Fatalf("session %d: add table: %v", i+1, err)
+		}
+	}
+	if err := s1.AddTable(schema.Table{Name: "scratch", Columns: tempTestColumns, Temp: true}); err == nil {
+		t.Error("expected an error for a second temporary table of the same name")
+	}
+	runHandler(t, "INSERT INTO scratch (id, name) VALUES (1, 'one');", HandleInsert, s1)
+	runHandler(t, "INSERT INTO scratch (id, name) VALUES (2, 'two');", HandleInsert, s2)
+	runHandler(t, "CREATE INDEX idx_scratch ON scratch (name);", HandleCreateIndex, s1)
+	runHandler(t, "CREATE INDEX idx_scratch ON scratch (name);", HandleCreateIndex, s2)
+	for i, s := range []*schema.Database{s1, s2} {
+		out := runSelect(t, s, "SELECT id, name FROM scratch WHERE name > 'a';")
+		want := []string{"1 one", "2 two"}[i]
+		if got := dataLines(out); len(got) != 1 || got[0] != want {
+			t.Errorf("session %d: got %q, want %q", i+1, got, want)
+		}
+	}
+
+	// a temporary table hides the table of the database of the same name
+	// from its session only
+	if err := s1.AddTable(schema.Table{Name: "orders", Columns: tempTestColumns, Temp: true}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	if out := runSelect(t, s1, "SELECT COUNT(*) FROM orders;"); dataLines(out)[0] != "0" {
+		t.Errorf("temporary orders: %q", dataLines(out))
+	}
+	if out := runSelect(t, s2, "SELECT COUNT(*) FROM orders;"); dataLines(out)[0] == "0" {
+		t.Errorf("the orders of the database are hidden from another session")
+	}
+	if err := DropTempTables(s1); err != nil {
+		t.Fatalf("drop temp tables: %v", err)
+	}
+	if table, ok := s1.GetTable("orders"); !ok || table.Temp {
+		t.Errorf("the orders of the database are gone after the session: %+v", table)
+	}
+	out := runSelect(t, s2, "SELECT name FROM scratch;")
+	if got := dataLines(out); len(got) != 1 || got[0] != "two" {
+		t.Errorf("another session's temporary table lost its rows: %q", got)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/schema"
+)
+
+func newEventsDB(t *testing.T) *schema.Database {
+	t.Helper()
+	db := newTestDB(t, "events (id INT, day DATE, at TIMESTAMP, seen TIMESTAMP WITH TIME ZONE, took INTERVAL)")
+	runHandler(t, "SET TIME ZONE 'UTC';", HandleSet, db)
+	for _, r := range []string{
+		"(1, '2024-03-01', '2024-03-01T09:00:00', '2024-03-01 09:00:00+02:00', '1 hour 30 minutes')",
+		"(2, '2024/02/28', '2024-02-28 23:15', '2024-02-28T23:15:00Z', 'PT45M')",
+		"(3, '2024-03-10', '2024-03-10 00:00:00', '2024-03-10 00:00:00', '2 days')",
+		"(4, NULL, NULL, NULL, NULL)",
+	} {
+		runHandler(t, "INSERT INTO events (id, day, at, seen, took) VALUES "+r+";", HandleInsert, db)
+	}
+	return db
+}
+
+func TestTemporalColumns(t *testing.T) {
+	db := newEventsDB(t)
+
+	out := runSelect(t, db, "SELECT id, day, at, seen, took FROM events ORDER BY at NULLS LAST;")
+	want := []string{
+		"2 2024-02-28 2024-02-28 23:15:00 2024-02-28 23:15:00+00:00 00:45:00",
+		"1 2024-03-01 2024-03-01 09:00:00 2024-03-01 07:00:00+00:00 01:30:00",
+		"3 2024-03-10 2024-03-10 00:00:00 2024-03-10 00:00:00+00:00 2 days",
+		"4 NULL NULL NULL NULL",
+	}
+	if got := dataLines(out); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	out = runSelect(t, db, "SELECT id FROM events WHERE day BETWEEN '2024-02-29' AND DATE '2024-03-05' OR took > INTERVAL '1 day' ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, ",") != "1,3" {
+		t.Fatalf("BETWEEN / interval comparison: got %q", got)
+	}
+
+	out = runSelect(t, db, "SELECT id, at + took AS done, day - DATE '2024-02-01' AS since FROM events WHERE id < 3 ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, "|") != "1 2024-03-01 10:30:00 29|2 2024-02-29 00:00:00 27" {
+		t.Fatalf("date arithmetic: got %q", got)
+	}
+
+	out = runSelect(t, db, "SELECT MIN(day) AS first, MAX(took) AS longest FROM events;")
+	if got := dataLines(out); len(got) != 1 || got[0] != "2024-02-28 2 days" {
+		t.Fatalf("MIN/MAX: got %q", got)
+	}
+
+	cmd, err := parser.Parse("INSERT INTO events (id, day) VALUES (5, '2024-02-30');")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if _, err := HandleInsert(cmd, db); err == nil {
+		t.Fatalf("expected an error inserting an invalid date")
+	}
+}
+
+func TestSetTimeZone(t *testing.T) {
+	db := newEventsDB(t)
+	cmd, err := parser.Parse("SET TIME ZONE 'Asia/Tokyo';")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	out, err := HandleSet(cmd, db)
+	if err != nil || !strings.Contains(out, "Asia/Tokyo") {
+		t.Fatalf("SET TIME ZONE: %q, %v", out, err)
+	}
+	out = runSelect(t, db, "SELECT seen FROM events WHERE id = 1;")
+	if got := dataLines(out); len(got) != 1 || got[0] != "2024-03-01 16:00:00+09:00" {
+		t.Fatalf("TIMESTAMPTZ in Asia/Tokyo: got %q", got)
+	}
+
+	// the zone is the session's own: another session reads and writes in
+	// its zone, and the first keeps Asia/Tokyo
+	other := db.Session("other")
+	runHandler(t, "SET TIME ZONE '-05:00';", HandleSet, other)
+	runHandler(t, "INSERT INTO events (id, seen) VALUES (5, '2024-03-01 02:00:00');", HandleInsert, other)
+	out = runSelect(t, other, "SELECT seen FROM events WHERE id IN (1, 5) ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, "|") != "2024-03-01 02:00:00-05:00|2024-03-01 02:00:00-05:00" {
+		t.Fatalf("TIMESTAMPTZ in -05:00: got %q", got)
+	}
+	out = runSelect(t, db, "SELECT seen FROM events WHERE seen = TIMESTAMPTZ '2024-03-01 16:00:00' ORDER BY id;")
+	if got := dataLines(out); strings.Join(got, "|") != "2024-03-01 16:00:00+09:00|2024-03-01 16:00:00+09:00" {
+		t.Fatalf("TIMESTAMPTZ literal in Asia/Tokyo: got %q", got)
+	}
+
+	for _, sql := range []string{"SET TIMEZONE TO 'Nowhere/Land';", "SET search_path = public;"} {
+		cmd, err := parser.Parse(sql)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if _, err := HandleSet(cmd, db); err == nil {
+			t.Fatalf("expected error for %q", sql)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/schema"
+)
+
+// newTestDB creates a database with the table ddl declares, as in
+// "trips (id INT, tags ARRAY<TEXT>) WITH (format='columnar')", and inserts
+// rows, each the value list of one INSERT such as "(1, '[]')".
+func newTestDB(t *testing.T, ddl string, rows ...string) *schema.Database {
+	t.Helper()
+	stmt, format, err := schema.ParseTableOptions(ddl)
+	if err != nil {
+		t.Fatalf("parse table options: %v", err)
+	}
+	name, defs, ok := strings.Cut(stmt, "(")
+	if !ok || !strings.HasSuffix(defs, ")") {
+		t.Fatalf("invalid table definition: %s", ddl)
+	}
+	name = strings.TrimSpace(name)
+	cols, err := schema.ParseColumnDefs(strings.TrimSuffix(defs, ")"))
+	if err != nil {
+		t.Fatalf("parse column defs: %v", err)
+	}
+	db, err := schema.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("new db: %v", err)
+	}
+	if err := db.AddTable(schema.Table{Name: name, Columns: cols, Format: format}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.Name
+	}
+	insert := "INSERT INTO " + name + " (" + strings.Join(names, ", ") + ") VALUES "
+	for _, r := range rows {
+		runHandler(t, insert+r+";", HandleInsert, db)
+	}
+	return db
+}
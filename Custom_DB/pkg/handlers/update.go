@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 
+	"Custom_DB/pkg/expr"
 	"Custom_DB/pkg/parser"
 	"Custom_DB/pkg/schema"
 	"Custom_DB/pkg/storage"
@@ -12,119 +13,165 @@ import (
 
 // HandleUpdate processes an UPDATE command
 func HandleUpdate(cmd parser.Command, db *schema.Database) (string, error) {
+	return HandleUpdateWithImages(cmd, db, "")
+}
+
+// HandleUpdateWithImages processes an UPDATE command, reading the files that
+// text assigned to IMAGE columns names from imageDir, as INSERT does.
+func HandleUpdateWithImages(cmd parser.Command, db *schema.Database, imageDir string) (string, error) {
 	tokens := cmd.Tokens
 	if len(tokens) < 4 {
 		return "", fmt.Errorf("invalid UPDATE syntax. Example: UPDATE table_name SET column = 'value' WHERE condition;")
 	}
 
-	// Parse: UPDATE table_name SET column = 'value' WHERE condition
+	// Parse: UPDATE table_name SET column = expression WHERE condition
 	tableName := tokens[1] // tokens[0] is "UPDATE", tokens[1] is table name
 	table, exists := db.GetTable(tableName)
 	if !exists {
 		return "", fmt.Errorf("table '%s' does not exist", tableName)
 	}
 
-	// Reconstruct full command for easier parsing
-	fullCommand := strings.Join(tokens, " ")
-
 	// Find SET and WHERE clauses
-	setIndex := strings.Index(strings.ToUpper(fullCommand), " SET ")
-	whereIndex := strings.Index(strings.ToUpper(fullCommand), " WHERE ")
+	setIdx := parser.IndexOfTopLevelKeyword(cmd, "SET")
+	whereIdx := parser.IndexOfTopLevelKeyword(cmd, "WHERE")
 
-	if setIndex == -1 {
+	if setIdx == -1 {
 		return "", fmt.Errorf("missing SET clause in UPDATE statement")
 	}
+	endSet := clauseEnd(tokens, setIdx, whereIdx)
 
-	var setPart, wherePart string
-	if whereIndex != -1 {
-		setPart = strings.TrimSpace(fullCommand[setIndex+5 : whereIndex])
-		wherePart = strings.TrimSpace(fullCommand[whereIndex+7:])
-	} else {
-		setPart = strings.TrimSpace(fullCommand[setIndex+5:])
+	// Parse SET clause: column = expression [, column = expression ...]
+	type assignment struct {
+		column schema.Column
+		value  expr.ValueExpr
 	}
+	var assignments []assignment
+	for _, item := range splitTopLevel(tokens[setIdx+1 : endSet]) {
+		setPart := joinExprTokens(item)
+		eq := strings.Index(setPart, "=")
+		if eq == -1 {
+			return "", fmt.Errorf("invalid SET clause syntax. Example: SET column = 'value'")
+		}
+		updateColumn := strings.TrimSpace(setPart[:eq])
 
-	// Parse SET clause: column = 'value'
-	if !strings.Contains(setPart, "=") {
-		return "", fmt.Errorf("invalid SET clause syntax. Example: SET column = 'value'")
-	}
+		// Validate column exists
+		column, found := getColumnDefinition(table.Columns, updateColumn)
+		if !found {
+			return "", fmt.Errorf("column '%s' does not exist in table '%s'", updateColumn, tableName)
+		}
 
-	setParts := strings.Split(setPart, "=")
-	if len(setParts) != 2 {
-		return "", fmt.Errorf("invalid SET clause syntax")
+		value, err := expr.ParseValue(setPart[eq+1:])
+		if err != nil {
+			return "", fmt.Errorf("invalid SET expression for column '%s': %w", updateColumn, err)
+		}
+		for _, c := range expr.CollectColumns(value) {
+			if _, ok := getColumnDefinition(table.Columns, c); !ok {
+				return "", fmt.Errorf("SET references unknown column '%s'", c)
+			}
+		}
+		if err := expr.CheckArgKinds(value, columnKinds(table.Columns)); err != nil {
+			return "", err
+		}
+		if err := bindExpr(db, value); err != nil {
+			return "", err
+		}
+		assignments = append(assignments, assignment{column: column, value: value})
+	}
+	if len(assignments) == 0 {
+		return "", fmt.Errorf("invalid SET clause syntax. Example: SET column = 'value'")
 	}
 
-	updateColumn := strings.TrimSpace(setParts[0])
-	updateValue := strings.TrimSpace(setParts[1])
-	updateValue = strings.Trim(updateValue, "'\"") // Remove quotes
-
-	// Validate column exists
-	columnExists := false
-	for _, col := range table.Columns {
-		if strings.EqualFold(col.Name, updateColumn) {
-			columnExists = true
-			break
+	var whereExpr expr.Expr
+	if whereIdx != -1 {
+		e, err := expr.ParseExpression(joinExprTokens(tokens[whereIdx+1:]))
+		if err != nil {
+			return "", fmt.Errorf("invalid WHERE expression: %w", err)
 		}
-	}
-	if !columnExists {
-		return "", fmt.Errorf("column '%s' does not exist in table '%s'", updateColumn, tableName)
+		for _, c := range expr.CollectColumns(e) {
+			if _, ok := getColumnDefinition(table.Columns, c); !ok {
+				return "", fmt.Errorf("WHERE references unknown column '%s'", c)
+			}
+		}
+		if err := expr.CheckArgKinds(e, columnKinds(table.Columns)); err != nil {
+			return "", err
+		}
+		if err := bindExpr(db, e); err != nil {
+			return "", err
+		}
+		whereExpr = e
 	}
 
 	// Load table data
-	tableFile, err := storage.NewTableFile(db.GetDBPath(), tableName)
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
 	if err != nil {
 		return "", fmt.Errorf("error accessing table file: %s", err)
 	}
 
-	rows, err := tableFile.ReadAllRows()
-	if err != nil {
-		return "", fmt.Errorf("error reading table data: %s", err)
-	}
-
-	updatedCount := 0
-
-	// Update matching rows
-	for i, row := range rows {
-		shouldUpdate := true
+	// blob references taken for new values are dropped again unless the
+	// update is saved; those of the values replaced are dropped once it is
+	var added, replaced []storage.BlobRef
+	saved := false
+	defer func() {
+		if !saved {
+			releaseBlobs(db, added)
+		}
+	}()
 
+	// Update matching rows, saving the table if any changed
+	loc := expr.SessionTimeZone(db)
+	updatedCount, err := tableFile.Update(func(line int, row storage.Row) (bool, error) {
+		decodeRows([]storage.Row{row}, table.Columns, loc)
 		// Apply WHERE clause if present
-		if wherePart != "" {
-			shouldUpdate = evaluateWhereClause(wherePart, row, table.Columns)
+		if whereExpr != nil {
+			ok, err := whereExpr.Eval(row)
+			if err != nil {
+				return false, fmt.Errorf("error evaluating WHERE: %w", err)
+			}
+			if !ok {
+				return false, nil
+			}
 		}
 
-		if shouldUpdate {
-			rows[i][updateColumn] = updateValue
-			updatedCount++
+		// every SET expression sees the row as it was before the update
+		newValues := make([]interface{}, len(assignments))
+		for j, a := range assignments {
+			v, err := a.value.EvalValue(row)
+			if err != nil {
+				return false, fmt.Errorf("error evaluating SET for column '%s': %w", a.column.Name, err)
+			}
+			var cv interface{}
+			if a.column.Type.IsBlob() {
+				cv, err = acquireBlob(db, v, a.column.Type, imageDir)
+				if ref, ok := cv.(storage.BlobRef); ok {
+					added = append(added, ref)
+				}
+			} else {
+				cv, err = expr.CastValueIn(v, a.column.Type, loc)
+			}
+			if err != nil {
+				return false, fmt.Errorf("error converting value for column '%s': %w", a.column.Name, err)
+			}
+			newValues[j] = cv
 		}
+		for j, a := range assignments {
+			if ref, ok := row[a.column.Name].(storage.BlobRef); ok {
+				replaced = append(replaced, ref)
+			}
+			row[a.column.Name] = newValues[j]
+		}
+		return true, nil
+	})
+	if err != nil {
+		return "", err
 	}
-
-	// Rewrite the entire file with updated data
-	if err := tableFile.RewriteFile(rows); err != nil {
-		return "", fmt.Errorf("error saving updated data: %s", err)
+	saved = true
+	if err := releaseBlobs(db, replaced); err != nil {
+		return "", fmt.Errorf("error releasing replaced blobs: %s", err)
 	}
 
 	return fmt.Sprintf("✅ %d row(s) updated in table '%s'", updatedCount, tableName), nil
 }
 
-// Simple WHERE clause evaluation
-func evaluateWhereClause(whereClause string, row storage.Row, columns []schema.Column) bool {
-	// Simple pattern: column = 'value'
-	if strings.Contains(whereClause, "=") {
-		parts := strings.Split(whereClause, "=")
-		if len(parts) == 2 {
-			col := strings.TrimSpace(parts[0])
-			val := strings.TrimSpace(parts[1])
-			val = strings.Trim(val, "'\"") // Remove quotes
-
-			// Check if row has this column and value matches
-			if rowVal, exists := row[col]; exists {
-				rowValStr := fmt.Sprintf("%v", rowVal)
-				return strings.EqualFold(rowValStr, val)
-			}
-		}
-	}
-	return false
-}
-
 // Helper function to coerce value to correct type (avoiding duplicate with insert.go)
 func coerceUpdateValue(valStr string, targetType schema.DataType) (interface{}, error) {
 	trimmedVal := strings.TrimSpace(valStr)
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"Custom_DB/pkg/parser"
+	"Custom_DB/pkg/storage"
+)
+
+func TestHandleUpdate_SetExpression(t *testing.T) {
+	db := newOrdersDB(t)
+	cmd, err := parser.Parse("UPDATE orders SET qty = qty * 2, item = UPPER_ITEM WHERE id = 1;")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if _, err := HandleUpdate(cmd, db); err == nil {
+		t.Fatalf("expected error for SET referencing unknown column")
+	}
+
+	cmd, err = parser.Parse("UPDATE orders SET qty = qty * 2, item = item || '-x' WHERE price < 2;")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	out, err := HandleUpdate(cmd, db)
+	if err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	if !strings.Contains(out, "2 row(s)") {
+		t.Fatalf("expected 2 rows updated, got: %s", out)
+	}
+
+	tf, err := storage.NewTableFile(db.GetDBPath(), "orders")
+	if err != nil {
+		t.Fatalf("new table file: %v", err)
+	}
+	rows, err := tf.ReadAllRows()
+	if err != nil {
+		t.Fatalf("read rows: %v", err)
+	}
+	for _, r := range rows {
+		if r["id"].(int64) == 3 && (r["qty"].(int64) != 20 || r["item"] != "pen-x") {
+			t.Fatalf("row 3 not updated as expected: %v", r)
+		}
+		if r["id"].(int64) == 2 && r["qty"].(int64) != 1 {
+			t.Fatalf("row 2 should be untouched: %v", r)
+		}
+	}
+}
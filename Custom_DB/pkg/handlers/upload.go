@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"fmt"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/imaging"
+	"Custom_DB/pkg/schema"
+	"Custom_DB/pkg/storage"
+)
+
+// The functions here serve BLOB and IMAGE values to the web server, which
+// addresses a row by its rowid: the row's line number in the table's data
+// file, counting from 0, as indexes address rows. A rowid holds until a
+// DELETE rewrites the file without the rows before it.
+
+// BlobUpload is a file uploaded as the value of a BLOB or IMAGE column.
+// Images are indexed under Filename without its extension.
+type BlobUpload struct {
+	Filename string
+	Data     []byte
+}
+
+// uploadContent checks an upload against the column it is stored in: IMAGE
+// columns only take images.
+func uploadContent(column schema.Column, up BlobUpload) (blobContent, error) {
+	if column.Type == schema.Image {
+		return imageContent(up.Data, up.Filename)
+	}
+	return blobContent{data: up.Data}, nil
+}
+
+// blobColumn returns the BLOB or IMAGE column of table named colName.
+func blobColumn(table schema.Table, colName string) (schema.Column, error) {
+	column, found := getColumnDefinition(table.Columns, colName)
+	if !found {
+		return schema.Column{}, fmt.Errorf("column '%s' does not exist in table '%s'", colName, table.Name)
+	}
+	if !column.Type.IsBlob() {
+		return schema.Column{}, fmt.Errorf("column '%s' is %s, not BLOB or IMAGE", colName, column.Type)
+	}
+	return column, nil
+}
+
+// RowBlob returns the blob reference held by the BLOB or IMAGE column colName
+// of the row of tableName with the given rowid.
+func RowBlob(db *schema.Database, tableName string, rowid int, colName string) (storage.BlobRef, error) {
+	table, exists := db.GetTable(tableName)
+	if !exists {
+		return storage.BlobRef{}, fmt.Errorf("table '%s' does not exist", tableName)
+	}
+	column, err := blobColumn(table, colName)
+	if err != nil {
+		return storage.BlobRef{}, err
+	}
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+	if err != nil {
+		return storage.BlobRef{}, fmt.Errorf("error accessing table file: %s", err)
+	}
+	rows, err := tableFile.ReadRowsAt([]int{rowid})
+	if err != nil {
+		return storage.BlobRef{}, fmt.Errorf("error reading table data: %s", err)
+	}
+	if len(rows) == 0 {
+		return storage.BlobRef{}, fmt.Errorf("table '%s' has no row %d", tableName, rowid)
+	}
+	decodeRows(rows, table.Columns, expr.SessionTimeZone(db))
+	switch v := rows[0][column.Name].(type) {
+	case storage.BlobRef:
+		return v, nil
+	case nil:
+		return storage.BlobRef{}, fmt.Errorf("column '%s' of row %d is NULL", column.Name, rowid)
+	case string:
+		return storage.BlobRef{}, fmt.Errorf("column '%s' of row %d holds the image path '%s'; run MIGRATE IMAGES to store it", column.Name, rowid, v)
+	default:
+		return storage.BlobRef{}, fmt.Errorf("column '%s' of row %d holds '%v', not a blob", column.Name, rowid, v)
+	}
+}
+
+// ImageThumbnail returns a thumbnail of the image ref whose longer side is
+// size pixels; see imaging.Thumbnail. Thumbnails are rendered on first use
+// and cached in the blob store.
+func ImageThumbnail(db *schema.Database, ref storage.BlobRef, size int) ([]byte, error) {
+	store, err := storage.NewBlobStore(db.GetDBPath())
+	if err != nil {
+		return nil, err
+	}
+	if data, ok, err := store.ReadThumbnail(ref, size); ok || err != nil {
+		return data, err
+	}
+	data, err := store.Read(ref)
+	if err != nil {
+		return nil, err
+	}
+	thumb, err := imaging.Thumbnail(data, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.WriteThumbnail(ref, size, thumb); err != nil {
+		return nil, err
+	}
+	return thumb, nil
+}
+
+// InsertUploadedRow inserts a row into tableName and returns its rowid, the
+// line the insert put it at, which names it until rows are deleted. The
+// BLOB and IMAGE values of the row are uploaded files, keyed by column; the
+// other values are given as text and converted to their column's type as
+// CAST converts them.
+func InsertUploadedRow(db *schema.Database, tableName string, values map[string]string, files map[string]BlobUpload) (int, error) {
+	table, exists := db.GetTable(tableName)
+	if !exists {
+		return 0, fmt.Errorf("table '%s' does not exist", tableName)
+	}
+	row := storage.Row{}
+	loc := expr.SessionTimeZone(db)
+	for colName, text := range values {
+		column, found := getColumnDefinition(table.Columns, colName)
+		if !found {
+			return 0, fmt.Errorf("unknown column '%s' in table '%s'", colName, tableName)
+		}
+		if column.Type.IsBlob() {
+			return 0, fmt.Errorf("column '%s' is %s and takes an uploaded file", colName, column.Type)
+		}
+		v, err := expr.CastValueIn(text, column.Type, loc)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing value for column '%s': %s", colName, err)
+		}
+		row[column.Name] = v
+	}
+	for colName, up := range files {
+		column, err := blobColumn(table, colName)
+		if err != nil {
+			return 0, err
+		}
+		content, err := uploadContent(column, up)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing value for column '%s': %s", colName, err)
+		}
+		row[column.Name] = content
+	}
+
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+	if err != nil {
+		return 0, fmt.Errorf("error opening table file: %s", err)
+	}
+	blobs, err := putBlobs(db, row)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store blob: %s", err)
+	}
+	rowid, err := tableFile.Insert(row)
+	if err != nil {
+		releaseBlobs(db, blobs)
+		return 0, fmt.Errorf("failed to insert row: %s", err)
+	}
+	return rowid, nil
+}
+
+// UpdateRowBlob replaces the value of the BLOB or IMAGE column colName of the
+// row of tableName with the given rowid by an uploaded file.
+func UpdateRowBlob(db *schema.Database, tableName string, rowid int, colName string, up BlobUpload) error {
+	table, exists := db.GetTable(tableName)
+	if !exists {
+		return fmt.Errorf("table '%s' does not exist", tableName)
+	}
+	column, err := blobColumn(table, colName)
+	if err != nil {
+		return err
+	}
+	content, err := uploadContent(column, up)
+	if err != nil {
+		return err
+	}
+
+	tableFile, err := storage.OpenTable(db.GetDBPath(), table)
+	if err != nil {
+		return fmt.Errorf("error accessing table file: %s", err)
+	}
+	store, err := storage.NewBlobStore(db.GetDBPath())
+	if err != nil {
+		return err
+	}
+	ref, err := storeBlob(store, content)
+	if err != nil {
+		return fmt.Errorf("failed to store blob: %s", err)
+	}
+	var old storage.BlobRef
+	replaced := false
+	loc := expr.SessionTimeZone(db)
+	n, err := tableFile.Update(func(line int, row storage.Row) (bool, error) {
+		decodeRows([]storage.Row{row}, table.Columns, loc)
+		if line != rowid {
+			return false, nil
+		}
+		old, replaced = row[column.Name].(storage.BlobRef)
+		row[column.Name] = ref
+		return true, nil
+	})
+	if err != nil || n == 0 {
+		store.Release(ref)
+		if err != nil {
+			return fmt.Errorf("error saving updated data: %s", err)
+		}
+		return fmt.Errorf("table '%s' has no row %d", tableName, rowid)
+	}
+	if replaced {
+		if err := store.Release(old); err != nil {
+			return fmt.Errorf("error releasing replaced blob: %s", err)
+		}
+	}
+	return nil
+}
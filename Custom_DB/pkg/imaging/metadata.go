@@ -0,0 +1,390 @@
+// Package imaging reads what the database records about stored images: their
+// format, dimensions and EXIF fields. It only parses file headers, so it
+// handles formats the standard library cannot decode, such as TIFF, BMP and
+// WebP.
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"strings"
+)
+
+// Metadata describes an image.
+type Metadata struct {
+	Format string // png, jpeg, gif, bmp, tiff or webp
+	Width  int
+	Height int
+	// EXIF holds the EXIF fields of the image by tag name, such as Make,
+	// DateTimeOriginal or GPSLatitude, as strings, int64, float64 or slices
+	// of them. Dates read as YYYY-MM-DD HH:MM:SS and GPS coordinates as
+	// signed decimal degrees.
+	EXIF map[string]interface{}
+}
+
+// ReadMetadata returns the metadata of an encoded image.
+func ReadMetadata(data []byte) (Metadata, error) {
+	var m Metadata
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		m, err = tiffMetadata(data)
+	case bytes.HasPrefix(data, []byte("BM")):
+		m, err = bmpMetadata(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		m, err = webpMetadata(data)
+	default:
+		cfg, format, derr := image.DecodeConfig(bytes.NewReader(data))
+		if derr != nil {
+			return Metadata{}, fmt.Errorf("unrecognized image: %w", derr)
+		}
+		m = Metadata{Format: format, Width: cfg.Width, Height: cfg.Height}
+		switch format {
+		case "jpeg":
+			m.EXIF = parseEXIF(jpegEXIF(data))
+		case "png":
+			m.EXIF = parseEXIF(pngEXIF(data))
+		}
+	}
+	if err != nil {
+		return Metadata{}, err
+	}
+	if m.Width <= 0 || m.Height <= 0 {
+		return Metadata{}, fmt.Errorf("invalid %s image dimensions %dx%d", m.Format, m.Width, m.Height)
+	}
+	return m, nil
+}
+
+func bmpMetadata(data []byte) (Metadata, error) {
+	if len(data) < 26 {
+		return Metadata{}, fmt.Errorf("truncated BMP header")
+	}
+	m := Metadata{Format: "bmp"}
+	if binary.LittleEndian.Uint32(data[14:18]) == 12 {
+		// an OS/2 BITMAPCOREHEADER with 16-bit dimensions
+		m.Width = int(binary.LittleEndian.Uint16(data[18:20]))
+		m.Height = int(binary.LittleEndian.Uint16(data[20:22]))
+		return m, nil
+	}
+	m.Width = int(int32(binary.LittleEndian.Uint32(data[18:22])))
+	// a negative height marks a top-down bitmap
+	h := int(int32(binary.LittleEndian.Uint32(data[22:26])))
+	if h < 0 {
+		h = -h
+	}
+	m.Height = h
+	return m, nil
+}
+
+func webpMetadata(data []byte) (Metadata, error) {
+	m := Metadata{Format: "webp"}
+	for off := 12; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		body := data[off+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+		switch id {
+		case "VP8 ":
+			// a key frame header: 3 bytes of frame tag, the start code,
+			// then 14-bit width and height
+			if len(body) >= 10 && m.Width == 0 {
+				m.Width = int(binary.LittleEndian.Uint16(body[6:8]) & 0x3fff)
+				m.Height = int(binary.LittleEndian.Uint16(body[8:10]) & 0x3fff)
+			}
+		case "VP8L":
+			if len(body) >= 5 && m.Width == 0 {
+				bits := binary.LittleEndian.Uint32(body[1:5])
+				m.Width = int(bits&0x3fff) + 1
+				m.Height = int(bits>>14&0x3fff) + 1
+			}
+		case "VP8X":
+			if len(body) >= 10 {
+				m.Width = int(uint32(body[4])|uint32(body[5])<<8|uint32(body[6])<<16) + 1
+				m.Height = int(uint32(body[7])|uint32(body[8])<<8|uint32(body[9])<<16) + 1
+			}
+		case "EXIF":
+			m.EXIF = parseEXIF(bytes.TrimPrefix(body, []byte("Exif\x00\x00")))
+		}
+		// chunks are padded to an even size
+		off += 8 + size + size&1
+	}
+	if m.Width == 0 {
+		return Metadata{}, fmt.Errorf("WebP image has no VP8 frame")
+	}
+	return m, nil
+}
+
+func tiffMetadata(data []byte) (Metadata, error) {
+	t, ok := newTIFF(data)
+	if !ok {
+		return Metadata{}, fmt.Errorf("truncated TIFF header")
+	}
+	ifd := t.ifd(t.u32(4))
+	m := Metadata{Format: "tiff", EXIF: parseEXIF(data)}
+	if e, ok := ifd[0x0100]; ok {
+		m.Width, _ = t.intValue(e)
+	}
+	if e, ok := ifd[0x0101]; ok {
+		m.Height, _ = t.intValue(e)
+	}
+	return m, nil
+}
+
+// jpegEXIF returns the TIFF structure of the EXIF APP1 segment of a JPEG
+// file, or nil.
+func jpegEXIF(data []byte) []byte {
+	for off := 2; off+4 <= len(data) && data[off] == 0xff; {
+		marker := data[off+1]
+		if marker == 0xda || marker == 0xd9 {
+			// image data starts; metadata segments come before it
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[off+2 : off+4]))
+		end := off + 2 + size
+		if size < 2 || end > len(data) {
+			break
+		}
+		if seg := data[off+4 : end]; marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:]
+		}
+		off = end
+	}
+	return nil
+}
+
+// pngEXIF returns the contents of the eXIf chunk of a PNG file, or nil.
+func pngEXIF(data []byte) []byte {
+	for off := 8; off+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[off : off+4]))
+		id := string(data[off+4 : off+8])
+		if size < 0 || off+8+size > len(data) || id == "IDAT" {
+			break
+		}
+		if id == "eXIf" {
+			return data[off+8 : off+8+size]
+		}
+		// the chunk's data is followed by a CRC
+		off += 12 + size
+	}
+	return nil
+}
+
+// exifTags name the tags of the IFD0, EXIF and GPS directories that are
+// recorded.
+var (
+	ifd0Tags = map[uint16]string{
+		0x010e: "ImageDescription", 0x010f: "Make", 0x0110: "Model", 0x0112: "Orientation",
+		0x0131: "Software", 0x0132: "DateTime", 0x013b: "Artist", 0x8298: "Copyright",
+	}
+	exifTags = map[uint16]string{
+		0x829a: "ExposureTime", 0x829d: "FNumber", 0x8827: "ISOSpeedRatings", 0x9003: "DateTimeOriginal",
+		0x9004: "DateTimeDigitized", 0x9209: "Flash", 0x920a: "FocalLength", 0xa434: "LensModel",
+	}
+	gpsTags = map[uint16]string{
+		0x01: "GPSLatitudeRef", 0x02: "GPSLatitude", 0x03: "GPSLongitudeRef", 0x04: "GPSLongitude",
+		0x05: "GPSAltitudeRef", 0x06: "GPSAltitude", 0x1d: "GPSDateStamp",
+	}
+)
+
+// parseEXIF reads the EXIF fields of a TIFF structure. Malformed data gives
+// the fields read up to the fault, and no data gives nil.
+func parseEXIF(data []byte) map[string]interface{} {
+	t, ok := newTIFF(data)
+	if !ok {
+		return nil
+	}
+	fields := map[string]interface{}{}
+	ifd0 := t.ifd(t.u32(4))
+	t.collect(ifd0, ifd0Tags, fields)
+	if e, ok := ifd0[0x8769]; ok {
+		if off, ok := t.intValue(e); ok {
+			t.collect(t.ifd(uint32(off)), exifTags, fields)
+		}
+	}
+	if e, ok := ifd0[0x8825]; ok {
+		if off, ok := t.intValue(e); ok {
+			t.collect(t.ifd(uint32(off)), gpsTags, fields)
+		}
+	}
+	// fold the GPS references into the sign of the coordinates
+	for _, c := range []struct{ name, ref, negative string }{
+		{"GPSLatitude", "GPSLatitudeRef", "S"},
+		{"GPSLongitude", "GPSLongitudeRef", "W"},
+	} {
+		if dms, ok := fields[c.name].([]interface{}); ok && len(dms) == 3 {
+			deg := 0.0
+			for i, part := range dms {
+				f, _ := part.(float64)
+				deg += f / math.Pow(60, float64(i))
+			}
+			if fields[c.ref] == c.negative {
+				deg = -deg
+			}
+			fields[c.name] = deg
+		}
+		delete(fields, c.ref)
+	}
+	if alt, ok := fields["GPSAltitude"].(float64); ok && fields["GPSAltitudeRef"] == int64(1) {
+		fields["GPSAltitude"] = -alt
+	}
+	delete(fields, "GPSAltitudeRef")
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// tiff reads the directories of a TIFF structure, the layout of both TIFF
+// files and EXIF blocks.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is one entry of an image file directory.
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // the entry's value bytes, nil when out of bounds
+}
+
+func newTIFF(data []byte) (*tiff, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, false
+	}
+	if t.order.Uint16(data[2:4]) != 42 {
+		return nil, false
+	}
+	return t, true
+}
+
+func (t *tiff) u32(off int) uint32 { return t.order.Uint32(t.data[off : off+4]) }
+
+// typeSizes are the sizes of the TIFF field types, by type number.
+var typeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// ifd reads the directory at offset off.
+func (t *tiff) ifd(off uint32) map[uint16]ifdEntry {
+	entries := map[uint16]ifdEntry{}
+	if uint64(off)+2 > uint64(len(t.data)) {
+		return entries
+	}
+	n := int(t.order.Uint16(t.data[off : off+2]))
+	for i := 0; i < n; i++ {
+		p := int(off) + 2 + 12*i
+		if p+12 > len(t.data) {
+			break
+		}
+		e := ifdEntry{typ: t.order.Uint16(t.data[p+2 : p+4]), count: t.order.Uint32(t.data[p+4 : p+8])}
+		size, known := typeSizes[e.typ]
+		total := uint64(size) * uint64(e.count)
+		switch {
+		case !known:
+		case total <= 4:
+			e.value = t.data[p+8 : p+8+int(total)]
+		default:
+			start := uint64(t.order.Uint32(t.data[p+8 : p+12]))
+			if start+total <= uint64(len(t.data)) {
+				e.value = t.data[start : start+total]
+			}
+		}
+		entries[t.order.Uint16(t.data[p:p+2])] = e
+	}
+	return entries
+}
+
+// intValue returns the value of a single SHORT or LONG entry.
+func (t *tiff) intValue(e ifdEntry) (int, bool) {
+	v, ok := t.value(e).(int64)
+	return int(v), ok
+}
+
+// value converts an entry's value: ASCII to a string, integers to int64 and
+// rationals to float64, as a slice when the entry has several.
+func (t *tiff) value(e ifdEntry) interface{} {
+	if e.value == nil {
+		return nil
+	}
+	if e.typ == 2 {
+		return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+	}
+	var vals []interface{}
+	for i := uint32(0); i < e.count; i++ {
+		var v interface{}
+		switch e.typ {
+		case 1, 7:
+			v = int64(e.value[i])
+		case 6:
+			v = int64(int8(e.value[i]))
+		case 3:
+			v = int64(t.order.Uint16(e.value[2*i:]))
+		case 8:
+			v = int64(int16(t.order.Uint16(e.value[2*i:])))
+		case 4:
+			v = int64(t.order.Uint32(e.value[4*i:]))
+		case 9:
+			v = int64(int32(t.order.Uint32(e.value[4*i:])))
+		case 5, 10:
+			num, den := t.order.Uint32(e.value[8*i:]), t.order.Uint32(e.value[8*i+4:])
+			if den == 0 {
+				return nil
+			}
+			if e.typ == 5 {
+				v = float64(num) / float64(den)
+			} else {
+				v = float64(int32(num)) / float64(int32(den))
+			}
+		default:
+			return nil
+		}
+		vals = append(vals, v)
+	}
+	if len(vals) == 1 {
+		return vals[0]
+	}
+	return vals
+}
+
+// collect adds the entries of ifd named by names to fields.
+func (t *tiff) collect(ifd map[uint16]ifdEntry, names map[uint16]string, fields map[string]interface{}) {
+	for tag, name := range names {
+		e, ok := ifd[tag]
+		if !ok {
+			continue
+		}
+		v := t.value(e)
+		if s, ok := v.(string); ok && strings.Contains(name, "Date") {
+			v = exifDate(s)
+		}
+		if v != nil && v != "" {
+			fields[name] = v
+		}
+	}
+}
+
+// exifDate rewrites an EXIF date such as 2023:06:01 12:34:56 as
+// 2023-06-01 12:34:56, the form TIMESTAMP values are written in.
+func exifDate(s string) string {
+	if len(s) >= 10 && s[4] == ':' && s[7] == ':' {
+		return s[:4] + "-" + s[5:7] + "-" + s[8:]
+	}
+	return s
+}
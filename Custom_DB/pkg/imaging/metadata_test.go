@@ -0,0 +1,207 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+)
+
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+	sub      int // index of the directory a pointer entry points to
+}
+
+func ascii(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), data: append([]byte(s), 0)}
+}
+
+func rationals(tag uint16, vals ...uint32) tiffEntry {
+	e := tiffEntry{tag: tag, typ: 5, count: uint32(len(vals) / 2)}
+	for _, v := range vals {
+		e.data = binary.LittleEndian.AppendUint32(e.data, v)
+	}
+	return e
+}
+
+// buildTIFF lays out a little-endian TIFF structure whose first directory
+// is IFD0; entries with sub set point to the directory of that index.
+func buildTIFF(ifds ...[]tiffEntry) []byte {
+	le := binary.LittleEndian
+	offsets := make([]int, len(ifds))
+	end := 8
+	for i, ifd := range ifds {
+		offsets[i] = end
+		end += 2 + 12*len(ifd) + 4
+	}
+	out := append([]byte("II*\x00"), le.AppendUint32(nil, 8)...)
+	var extra []byte
+	for _, ifd := range ifds {
+		out = le.AppendUint16(out, uint16(len(ifd)))
+		for _, e := range ifd {
+			out = le.AppendUint16(out, e.tag)
+			out = le.AppendUint16(out, e.typ)
+			out = le.AppendUint32(out, e.count)
+			val := e.data
+			if e.sub > 0 {
+				val = le.AppendUint32(nil, uint32(offsets[e.sub]))
+			}
+			if len(val) <= 4 {
+				out = append(out, val...)
+				out = append(out, make([]byte, 4-len(val))...)
+			} else {
+				out = le.AppendUint32(out, uint32(end+len(extra)))
+				extra = append(extra, val...)
+			}
+		}
+		out = le.AppendUint32(out, 0)
+	}
+	return append(out, extra...)
+}
+
+// cameraEXIF is the EXIF block of a photo taken in Sydney.
+func cameraEXIF() []byte {
+	return buildTIFF(
+		[]tiffEntry{ascii(0x010f, "Canon"), ascii(0x0110, "EOS R5"), {tag: 0x8769, typ: 4, count: 1, sub: 1}, {tag: 0x8825, typ: 4, count: 1, sub: 2}},
+		[]tiffEntry{ascii(0x9003, "2023:06:01 12:34:56"), rationals(0x829d, 28, 10), {tag: 0x8827, typ: 3, count: 1, data: []byte{200, 0}}},
+		[]tiffEntry{ascii(0x01, "S"), rationals(0x02, 33, 1, 51, 1, 36, 1), ascii(0x03, "E"), rationals(0x04, 151, 1, 12, 1, 0, 1)},
+	)
+}
+
+func encode(t *testing.T, img image.Image, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadMetadata(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 7, 5))
+	img.Set(1, 1, color.White)
+	exif := cameraEXIF()
+
+	jpg := encode(t, img, "jpeg")
+	app1 := append([]byte{0xff, 0xe1, 0, 0}, "Exif\x00\x00"...)
+	binary.BigEndian.PutUint16(app1[2:], uint16(2+6+len(exif)))
+	jpgWithEXIF := append(append(append([]byte{}, jpg[:2]...), append(app1, exif...)...), jpg[2:]...)
+
+	pngData := encode(t, img, "png")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(exif)))
+	chunk = append(chunk, "eXIf"...)
+	chunk = append(chunk, exif...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	pngWithEXIF := append(append(append([]byte{}, pngData[:33]...), chunk...), pngData[33:]...)
+
+	bmp := make([]byte, 54)
+	copy(bmp, "BM")
+	binary.LittleEndian.PutUint32(bmp[14:], 40)
+	binary.LittleEndian.PutUint32(bmp[18:], 7)
+	binary.LittleEndian.PutUint32(bmp[22:], uint32(0xffffffff-5+1)) // -5: top-down
+
+	webp := func(chunks ...[]byte) []byte {
+		body := []byte("WEBP")
+		for _, c := range chunks {
+			body = append(body, c...)
+		}
+		return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+	}
+	riffChunk := func(id string, data []byte) []byte {
+		c := binary.LittleEndian.AppendUint32([]byte(id), uint32(len(data)))
+		c = append(c, data...)
+		if len(data)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	lossless := riffChunk("VP8L", binary.LittleEndian.AppendUint32([]byte{0x2f}, 6|4<<14))
+	extended := riffChunk("VP8X", []byte{0x08, 0, 0, 0, 99, 0, 0, 49, 0, 0})
+
+	tiffFile := buildTIFF([]tiffEntry{
+		{tag: 0x0100, typ: 3, count: 1, data: []byte{0x80, 0x02}},
+		{tag: 0x0101, typ: 4, count: 1, data: []byte{0xe0, 0x01, 0, 0}},
+		ascii(0x010f, "Nikon"),
+	})
+
+	cases := []struct {
+		name          string
+		data          []byte
+		format        string
+		width, height int
+		exif          bool
+	}{
+		{"png", pngData, "png", 7, 5, false},
+		{"png with eXIf", pngWithEXIF, "png", 7, 5, true},
+		{"jpeg", jpg, "jpeg", 7, 5, false},
+		{"jpeg with EXIF", jpgWithEXIF, "jpeg", 7, 5, true},
+		{"gif", encode(t, img, "gif"), "gif", 7, 5, false},
+		{"bmp", bmp, "bmp", 7, 5, false},
+		{"webp lossless", webp(lossless), "webp", 7, 5, false},
+		{"webp extended", webp(extended, riffChunk("EXIF", exif), lossless), "webp", 100, 50, true},
+		{"tiff", tiffFile, "tiff", 640, 480, false},
+	}
+	for _, c := range cases {
+		m, err := ReadMetadata(c.data)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if m.Format != c.format || m.Width != c.width || m.Height != c.height {
+			t.Fatalf("%s: got %s %dx%d, want %s %dx%d", c.name, m.Format, m.Width, m.Height, c.format, c.width, c.height)
+		}
+		if !c.exif {
+			continue
+		}
+		want := map[string]interface{}{"Make": "Canon", "Model": "EOS R5", "DateTimeOriginal": "2023-06-01 12:34:56", "ISOSpeedRatings": int64(200)}
+		for k, v := range want {
+			if m.EXIF[k] != v {
+				t.Fatalf("%s: EXIF %s = %#v, want %#v", c.name, k, m.EXIF[k], v)
+			}
+		}
+		for k, v := range map[string]float64{"FNumber": 2.8, "GPSLatitude": -33.86, "GPSLongitude": 151.2} {
+			if f, _ := m.EXIF[k].(float64); math.Abs(f-v) > 1e-9 {
+				t.Fatalf("%s: EXIF %s = %#v, want %v", c.name, k, m.EXIF[k], v)
+			}
+		}
+		if _, ok := m.EXIF["GPSLatitudeRef"]; ok {
+			t.Fatalf("%s: GPS references should be folded into the coordinates", c.name)
+		}
+	}
+	if tiffMeta, _ := ReadMetadata(tiffFile); tiffMeta.EXIF["Make"] != "Nikon" {
+		t.Fatalf("TIFF IFD0 fields should be read as EXIF: %v", tiffMeta.EXIF)
+	}
+
+	for name, data := range map[string][]byte{
+		"text":          []byte("not an image"),
+		"truncated png": pngData[:20],
+		"webp no frame": webp(riffChunk("EXIF", exif)),
+		"truncated bmp": bmp[:20],
+	} {
+		if _, err := ReadMetadata(data); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+	// malformed EXIF is skipped rather than failing the image
+	bad := append(append([]byte{}, jpg[:2]...), 0xff, 0xe1, 0, 12)
+	bad = append(append(bad, "Exif\x00\x00II*\x00"...), jpg[2:]...)
+	if m, err := ReadMetadata(bad); err != nil || m.EXIF != nil {
+		t.Fatalf("malformed EXIF: %v, %v", m.EXIF, err)
+	}
+}
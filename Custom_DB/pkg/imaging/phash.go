@@ -0,0 +1,89 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// hashWidth and hashHeight are the size of the grid an image is reduced to
+// for its difference hash: each row of 9 cells gives 8 comparisons.
+const (
+	hashWidth  = 9
+	hashHeight = 8
+)
+
+// DHash returns the 64-bit difference hash of an image: the image is
+// reduced to a 9x8 grid of average brightness, and each bit records whether
+// a cell is brighter than its right neighbour. Images that look alike, such
+// as the same photo resized or recompressed, have hashes a few bits apart.
+func DHash(img image.Image) uint64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var sum [hashHeight][hashWidth]float64
+	var n [hashHeight][hashWidth]int
+	for y := 0; y < h; y++ {
+		cy := y * hashHeight / h
+		for x := 0; x < w; x++ {
+			cx := x * hashWidth / w
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			sum[cy][cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			n[cy][cx]++
+		}
+	}
+	// images narrower than the grid leave cells empty; they take the
+	// brightness of the cell to their left
+	var gray [hashHeight][hashWidth]float64
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth; x++ {
+			switch {
+			case n[y][x] > 0:
+				gray[y][x] = sum[y][x] / float64(n[y][x])
+			case x > 0:
+				gray[y][x] = gray[y][x-1]
+			case y > 0:
+				gray[y][x] = gray[y-1][x]
+			}
+		}
+	}
+	var hash uint64
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HashImage decodes an encoded image and returns its difference hash. Only
+// the formats the standard library decodes, PNG, JPEG and GIF, are hashed.
+func HashImage(data []byte) (uint64, error) {
+	img, _, err := decode(data)
+	if err != nil {
+		return 0, fmt.Errorf("cannot hash image: %w", err)
+	}
+	return DHash(img), nil
+}
+
+// Distance returns the number of bits in which two hashes differ.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash writes a hash as 16 hex digits.
+func FormatHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// ParseHash reads a hash written by FormatHash.
+func ParseHash(s string) (uint64, bool) {
+	if len(s) != 16 {
+		return 0, false
+	}
+	h, err := strconv.ParseUint(s, 16, 64)
+	return h, err == nil
+}
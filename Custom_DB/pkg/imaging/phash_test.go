@@ -0,0 +1,56 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// scene draws a w x h image of soft diagonal bands with a dark block in it;
+// variant 1 is its mirror image.
+func scene(w, h, variant int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx := x
+			if variant == 1 {
+				sx = w - 1 - x
+			}
+			v := uint8((sx*255/w + y*128/h) % 256)
+			if sx > w/2 && y < h/2 {
+				v /= 4
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	base := DHash(scene(640, 480, 0))
+	if d := Distance(base, DHash(scene(160, 120, 0))); d > 4 {
+		t.Fatalf("a resized copy should hash within 4 bits, got %d", d)
+	}
+	if d := Distance(base, DHash(scene(640, 480, 1))); d < 10 {
+		t.Fatalf("a different image should hash at least 10 bits apart, got %d", d)
+	}
+	// images smaller than the hash grid still hash
+	DHash(scene(3, 2, 0))
+
+	h, ok := ParseHash(FormatHash(base))
+	if !ok || h != base {
+		t.Fatalf("ParseHash(FormatHash(%x)) = %x, %v", base, h, ok)
+	}
+	for _, bad := range []string{"", "abc", "zzzzzzzzzzzzzzzz", "0123456789abcdef0"} {
+		if _, ok := ParseHash(bad); ok {
+			t.Fatalf("ParseHash(%q) should fail", bad)
+		}
+	}
+	if _, err := HashImage([]byte("not an image")); err == nil {
+		t.Fatalf("expected an error hashing text")
+	}
+	if _, err := HashImage(bombPNG(t, 100000, 100000)); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("expected a size error hashing a decompression bomb, got %v", err)
+	}
+}
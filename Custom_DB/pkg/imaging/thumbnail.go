@@ -0,0 +1,93 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+// MaxThumbnailSize bounds the size thumbnails are rendered at.
+const MaxThumbnailSize = 2048
+
+// MaxImagePixels bounds the pixels of the images decoded, as a few bytes of
+// a compressed image can claim dimensions that take gigabytes to decode.
+const MaxImagePixels = 50_000_000
+
+// decode decodes an encoded image once its header shows it has no more
+// than MaxImagePixels pixels.
+func decode(data []byte) (image.Image, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > MaxImagePixels {
+		return nil, "", fmt.Errorf("image of %dx%d pixels is larger than %d pixels", cfg.Width, cfg.Height, MaxImagePixels)
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// Thumbnail decodes an encoded image and returns it scaled down so that its
+// longer side is size pixels, keeping its aspect ratio. Images already that
+// small keep their dimensions. JPEG images are encoded as JPEG and the other
+// formats as PNG, which keeps their transparency. Only the formats the
+// standard library decodes, PNG, JPEG and GIF, are rendered.
+func Thumbnail(data []byte, size int) ([]byte, error) {
+	if size <= 0 || size > MaxThumbnailSize {
+		return nil, fmt.Errorf("thumbnail size must be between 1 and %d, got %d", MaxThumbnailSize, size)
+	}
+	img, format, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot render thumbnail: %w", err)
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	thumb := resize(img, w, h)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resize scales img to w x h pixels. Each pixel of the result is the average
+// of the pixels of img it covers, which keeps fine detail from aliasing when
+// an image is shrunk a lot.
+func resize(img image.Image, w, h int) *image.NRGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			// the sums are of alpha-premultiplied colors
+			c := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)}
+			out.Set(x, y, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// bombPNG returns a PNG whose header claims w x h pixels, though it holds
+// the data of one.
+func bombPNG(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	data := buf.Bytes()
+	// the IHDR chunk follows the 8-byte signature: length, type, then the
+	// width and height, and its CRC after 13 bytes of data
+	binary.BigEndian.PutUint32(data[16:], w)
+	binary.BigEndian.PutUint32(data[20:], h)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestThumbnail_TooLarge(t *testing.T) {
+	_, err := Thumbnail(bombPNG(t, 100000, 100000), 64)
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("expected a size error, got %v", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	cases := []struct {
+		name          string
+		data          []byte
+		size          int
+		format        string
+		width, height int
+	}{
+		{"landscape jpeg", encode(t, scene(640, 480, 0), "jpeg"), 128, "jpeg", 128, 96},
+		{"portrait png", encode(t, scene(300, 900, 0), "png"), 90, "png", 30, 90},
+		{"gif as png", encode(t, scene(40, 20, 0), "gif"), 10, "png", 10, 5},
+		{"small image kept", encode(t, scene(20, 10, 0), "png"), 64, "png", 20, 10},
+		{"thin image", encode(t, scene(1000, 2, 0), "png"), 100, "png", 100, 1},
+	}
+	for _, c := range cases {
+		thumb, err := Thumbnail(c.data, c.size)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(thumb))
+		if err != nil {
+			t.Fatalf("%s: decode thumbnail: %v", c.name, err)
+		}
+		if format != c.format || cfg.Width != c.width || cfg.Height != c.height {
+			t.Fatalf("%s: got %s %dx%d, want %s %dx%d", c.name, format, cfg.Width, cfg.Height, c.format, c.width, c.height)
+		}
+	}
+
+	// a 2x2 checkerboard shrunk to one pixel averages to mid gray
+	board := image.NewGray(image.Rect(0, 0, 2, 2))
+	board.SetGray(0, 0, color.Gray{Y: 255})
+	board.SetGray(1, 1, color.Gray{Y: 255})
+	thumb, err := Thumbnail(encode(t, board, "png"), 1)
+	if err != nil {
+		t.Fatalf("checkerboard: %v", err)
+	}
+	img, _, _ := image.Decode(bytes.NewReader(thumb))
+	if r, _, _, _ := img.At(0, 0).RGBA(); r>>8 < 126 || r>>8 > 129 {
+		t.Fatalf("checkerboard should average to gray, got %d", r>>8)
+	}
+
+	for _, size := range []int{0, -1, MaxThumbnailSize + 1} {
+		if _, err := Thumbnail(encode(t, board, "png"), size); err == nil {
+			t.Fatalf("size %d: expected an error", size)
+		}
+	}
+	if _, err := Thumbnail([]byte("not an image"), 32); err == nil {
+		t.Fatalf("expected an error rendering text")
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"os/exec"
 
 	"strings"
+	"time"
 
+	"Custom_DB/pkg/expr"
 	"Custom_DB/pkg/schema"
 	"Custom_DB/pkg/storage"
 )
@@ -51,10 +53,13 @@ func ImportCSV(path string, db *schema.Database, tableName string) error {
 		}
 	}
 
-	tf, err := storage.NewTableFile(db.GetDBPath(), tableName)
+	table, _ := db.GetTable(tableName)
+	tf, err := storage.OpenTable(db.GetDBPath(), table)
 	if err != nil {
 		return fmt.Errorf("failed to open table file: %w", err)
 	}
+	types := columnTypes(db, tableName)
+	loc := expr.SessionTimeZone(db)
 
 	for {
 		rec, err := r.Read()
@@ -74,10 +79,10 @@ func ImportCSV(path string, db *schema.Database, tableName string) error {
 				break
 			}
 			col := strings.TrimSpace(header[i])
-			row[col] = strings.TrimSpace(cell)
+			row[col] = typedCellValue(cell, types[col], loc)
 		}
 
-		if err := tf.AppendRow(row); err != nil {
+		if _, err := tf.Insert(row); err != nil {
 			return fmt.Errorf("failed to append row: %w", err)
 		}
 	}
@@ -85,8 +90,96 @@ func ImportCSV(path string, db *schema.Database, tableName string) error {
 	return nil
 }
 
+// cellValue converts one CSV cell to a row value. Empty cells are NULL; the
+// Parquet converters write null values as empty cells too.
+func cellValue(cell string) interface{} {
+	v := strings.TrimSpace(cell)
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// typedCellValue converts a cell for a column of type t, storing numbers
+// and DATE, TIMESTAMP, TIMESTAMPTZ and INTERVAL values in canonical form
+// whatever format the file used. JSON, ARRAY and STRUCT cells are parsed as
+// JSON documents. Other cells are kept as text. Times without a zone of
+// their own are read in loc.
+func typedCellValue(cell string, t schema.DataType, loc *time.Location) interface{} {
+	v := cellValue(cell)
+	if s, ok := v.(string); ok {
+		switch t.Base() {
+		case schema.JSON, schema.Array, schema.Struct:
+			if j, err := expr.CastValueIn(s, t, loc); err == nil {
+				return j
+			}
+		}
+	}
+	return expr.DecodeValue(v, t, loc)
+}
+
+// columnTypes maps the columns of an existing table to their types.
+func columnTypes(db *schema.Database, tableName string) map[string]schema.DataType {
+	types := map[string]schema.DataType{}
+	if table, ok := db.GetTable(tableName); ok {
+		for _, c := range table.Columns {
+			types[c.Name] = c.Type
+		}
+	}
+	return types
+}
+
+// parquetTableColumns builds the columns of a table created from a Parquet
+// file: temporal, numeric and nested (ARRAY, STRUCT and JSON) columns get
+// their mapped type and the rest are TEXT.
+func parquetTableColumns(header []string, pq map[string]parquetColumn) []schema.Column {
+	cols := make([]schema.Column, 0, len(header))
+	for _, h := range header {
+		name := strings.TrimSpace(h)
+		if name == "" {
+			continue
+		}
+		t := schema.Text
+		if c, ok := pq[name]; ok {
+			t = c.Type
+		}
+		cols = append(cols, schema.Column{Name: name, Type: t})
+	}
+	return cols
+}
+
+// createParquetTable creates the destination table of a Parquet import from
+// the header of the converted CSV file, unless it already exists.
+func createParquetTable(csvPath string, db *schema.Database, tableName string, pq map[string]parquetColumn) error {
+	if _, ok := db.GetTable(tableName); ok {
+		return nil
+	}
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return fmt.Errorf("failed to open converted CSV '%s': %w", csvPath, err)
+	}
+	defer f.Close()
+	r := csv.NewReader(bufio.NewReader(f))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	if err := db.AddTable(schema.Table{Name: tableName, Columns: parquetTableColumns(header, pq)}); err != nil {
+		return fmt.Errorf("failed to create table '%s': %w", tableName, err)
+	}
+	return nil
+}
+
 // ImportParquet is a stub; real parquet support requires a dependency.
 func ImportParquet(path string, db *schema.Database, tableName string) error {
+	// column types come from the file's own schema; without one every
+	// column is imported as TEXT
+	pq, err := readParquetColumns(path)
+	if err != nil {
+		pq = map[string]parquetColumn{}
+	}
+
 	// Try to convert parquet to CSV using local CLI tools (parquet-tools or parquet2csv)
 	cmds := [][]string{
 		{"parquet-tools", "csv", path},
@@ -110,10 +203,25 @@ func ImportParquet(path string, db *schema.Database, tableName string) error {
 
 	if execErr != nil || len(out) == 0 {
 		// Try python fallback (pandas + pyarrow)
-		pyScript := `import sys
+		// nested struct, list and map cells are written as JSON text
+		pyScript := `import sys, json
+import numpy as np
 import pandas as pd
 fn = sys.argv[1]
 df = pd.read_parquet(fn)
+def plain(v):
+    if isinstance(v, np.ndarray):
+        return v.tolist()
+    if isinstance(v, np.generic):
+        return v.item()
+    return str(v)
+def cell(v):
+    if isinstance(v, (dict, list, tuple, np.ndarray)):
+        return json.dumps(v, default=plain)
+    return v
+for c in df.columns:
+    if df[c].dtype == object:
+        df[c] = df[c].map(cell)
 print(df.to_csv(index=False))
 `
 		// try local virtualenv python first (./.venv or ./venv), then system python3
@@ -142,6 +250,9 @@ print(df.to_csv(index=False))
 			if err := tmpf.Close(); err != nil {
 				return fmt.Errorf("failed to close temp csv file: %w", err)
 			}
+			if err := createParquetTable(tmpf.Name(), db, tableName, pq); err != nil {
+				return err
+			}
 			return ImportCSV(tmpf.Name(), db, tableName)
 		}
 		return fmt.Errorf("parquet import requires an external converter (e.g. 'parquet-tools' or 'parquet2csv') or python with pandas installed; error: %v", execErr)
@@ -157,26 +268,19 @@ print(df.to_csv(index=False))
 	}
 
 	// Create table if missing
-	_, ok := db.GetTable(tableName)
-	if !ok {
-		cols := make([]schema.Column, 0, len(header))
-		for _, h := range header {
-			name := strings.TrimSpace(h)
-			if name == "" {
-				continue
-			}
-			cols = append(cols, schema.Column{Name: name, Type: schema.Text})
-		}
-		newTable := schema.Table{Name: tableName, Columns: cols}
-		if err := db.AddTable(newTable); err != nil {
+	if _, ok := db.GetTable(tableName); !ok {
+		if err := db.AddTable(schema.Table{Name: tableName, Columns: parquetTableColumns(header, pq)}); err != nil {
 			return fmt.Errorf("failed to create table '%s': %w", tableName, err)
 		}
 	}
 
-	tf, err := storage.NewTableFile(db.GetDBPath(), tableName)
+	table, _ := db.GetTable(tableName)
+	tf, err := storage.OpenTable(db.GetDBPath(), table)
 	if err != nil {
 		return fmt.Errorf("failed to open table file: %w", err)
 	}
+	types := columnTypes(db, tableName)
+	loc := expr.SessionTimeZone(db)
 
 	for {
 		rec, err := r.Read()
@@ -195,9 +299,13 @@ print(df.to_csv(index=False))
 				break
 			}
 			col := strings.TrimSpace(header[i])
-			row[col] = strings.TrimSpace(cell)
+			if c, ok := pq[col]; ok && c.Type != schema.Text && c.Type == types[col] {
+				row[col] = parquetCellValue(cell, c, loc)
+			} else {
+				row[col] = typedCellValue(cell, types[col], loc)
+			}
 		}
-		if err := tf.AppendRow(row); err != nil {
+		if _, err := tf.Insert(row); err != nil {
 			return fmt.Errorf("failed to append row: %w", err)
 		}
 	}
@@ -0,0 +1,585 @@
+package importer
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/schema"
+)
+
+// The external converters used by ImportParquet only produce CSV, which
+// loses column types, so the file's schema is read separately from the
+// Parquet footer: a Thrift compact-protocol FileMetaData struct followed by
+// its length and the "PAR1" magic.
+
+// Parquet physical types and converted types used for the type mapping.
+const (
+	parquetInt32  = 1
+	parquetInt64  = 2
+	parquetInt96  = 3
+	parquetFloat  = 4
+	parquetDouble = 5
+
+	convertedMap             = 1
+	convertedMapKeyValue     = 2
+	convertedList            = 3
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+	convertedUint8           = 11
+	convertedUint16          = 12
+	convertedUint32          = 13
+	convertedUint64          = 14
+	convertedInt8            = 15
+	convertedInt16           = 16
+
+	repetitionRepeated = 2
+
+	// LogicalType union members
+	logicalMap       = 2
+	logicalList      = 3
+	logicalDate      = 6
+	logicalTimestamp = 8
+)
+
+// timeUnit is the resolution of an integer-encoded Parquet timestamp.
+type timeUnit int
+
+const (
+	unitNone timeUnit = iota
+	unitMillis
+	unitMicros
+	unitNanos
+	unitDays // DATE: days since the Unix epoch
+	unitInt96
+)
+
+// parquetColumn is the import mapping of one Parquet leaf column.
+type parquetColumn struct {
+	Type schema.DataType
+	Unit timeUnit
+}
+
+// readParquetColumns reads the schema of a Parquet file and maps temporal
+// columns to DATE, TIMESTAMP or TIMESTAMPTZ: DATE logical types, INT96
+// timestamps, TIMESTAMP_MILLIS/MICROS converted types and TIMESTAMP logical
+// types, which are TIMESTAMPTZ when adjusted to UTC. Integer columns map to
+// SMALLINT, INT or BIGINT by width, FLOAT and DOUBLE to REAL and DOUBLE, and
+// DECIMAL columns to NUMERIC(p,s). Nested columns map to ARRAY and STRUCT
+// types as described by parquetType; maps, and nested columns whose field
+// names cannot be written in a STRUCT type, map to JSON. Other columns map
+// to TEXT.
+func readParquetColumns(path string) (map[string]parquetColumn, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open parquet file '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() < 12 {
+		return nil, fmt.Errorf("'%s' is too small to be a parquet file", path)
+	}
+	tail := make([]byte, 8)
+	if _, err := f.ReadAt(tail, st.Size()-8); err != nil {
+		return nil, fmt.Errorf("failed to read parquet footer: %w", err)
+	}
+	if string(tail[4:]) != "PAR1" {
+		return nil, fmt.Errorf("'%s' is not a parquet file", path)
+	}
+	n := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if n <= 0 || n > st.Size()-12 {
+		return nil, fmt.Errorf("invalid parquet footer length %d", n)
+	}
+	meta := make([]byte, n)
+	if _, err := f.ReadAt(meta, st.Size()-8-n); err != nil {
+		return nil, fmt.Errorf("failed to read parquet footer: %w", err)
+	}
+	elems, err := decodeParquetSchema(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode parquet schema: %w", err)
+	}
+
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("parquet schema is empty")
+	}
+	// the elements are the schema tree in depth-first order: the root, then
+	// each top-level field followed by its descendants
+	cols := map[string]parquetColumn{}
+	i := 1
+	for field := int32(0); field < elems[0].numChildren && i < len(elems); field++ {
+		e := elems[i]
+		if e.numChildren > 0 || e.repetition == repetitionRepeated {
+			t, _ := parquetType(elems, i)
+			if !schema.ValidateColumnType(string(t)) {
+				t = schema.JSON
+			}
+			cols[e.name] = parquetColumn{Type: t}
+		} else {
+			cols[e.name] = e.mapping()
+		}
+		i = skipSubtree(elems, i)
+	}
+	return cols, nil
+}
+
+// parquetType maps the schema subtree rooted at elems[i] and returns the
+// index of the element after it. A LIST group maps to ARRAY<T>, a MAP group
+// to JSON, any other group to a STRUCT of its fields and a leaf to its
+// scalar type. A repeated field is an array of what it maps to, the way
+// the repetition levels of such a field hold a list per record.
+func parquetType(elems []schemaElement, i int) (schema.DataType, int) {
+	e := elems[i]
+	next := skipSubtree(elems, i)
+	var t schema.DataType
+	switch {
+	case e.numChildren == 0:
+		t = e.mapping().Type
+	case e.converted == convertedMap || e.converted == convertedMapKeyValue || e.hasLogical && e.logical == logicalMap:
+		return schema.JSON, next
+	case e.converted == convertedList || e.hasLogical && e.logical == logicalList:
+		t = schema.ArrayType(listElementType(elems, i))
+	default:
+		t = structType(elems, i)
+	}
+	if e.repetition == repetitionRepeated {
+		t = schema.ArrayType(t)
+	}
+	return t, next
+}
+
+// structType maps the fields of the group elems[i] to a STRUCT type.
+func structType(elems []schemaElement, i int) schema.DataType {
+	fields := []schema.Column{}
+	j := i + 1
+	for c := int32(0); c < elems[i].numChildren && j < len(elems); c++ {
+		name := elems[j].name
+		var t schema.DataType
+		t, j = parquetType(elems, j)
+		fields = append(fields, schema.Column{Name: name, Type: t})
+	}
+	return schema.StructType(fields)
+}
+
+// listElementType returns the element type of the LIST group elems[i].
+// The standard layout wraps the element in a repeated group holding just
+// that field; in the legacy layouts the repeated field, or a repeated group
+// named array or <list>_tuple, is itself the element.
+func listElementType(elems []schemaElement, i int) schema.DataType {
+	j := i + 1
+	if elems[i].numChildren != 1 || j >= len(elems) {
+		return schema.JSON
+	}
+	rep := elems[j]
+	switch {
+	case rep.numChildren == 0:
+		return rep.mapping().Type
+	case rep.numChildren == 1 && rep.name != "array" && rep.name != elems[i].name+"_tuple":
+		t, _ := parquetType(elems, j+1)
+		return t
+	}
+	return structType(elems, j)
+}
+
+// skipSubtree returns the index of the element after the subtree rooted at
+// elems[i].
+func skipSubtree(elems []schemaElement, i int) int {
+	children := elems[i].numChildren
+	i++
+	for c := int32(0); c < children && i < len(elems); c++ {
+		i = skipSubtree(elems, i)
+	}
+	return i
+}
+
+// schemaElement holds the SchemaElement fields used for the type mapping.
+type schemaElement struct {
+	name        string
+	physical    int32
+	repetition  int32 // FieldRepetitionType
+	converted   int32
+	numChildren int32
+	scale       int32 // of a DECIMAL column
+	precision   int32
+	hasLogical  bool
+	logical     int16 // set field of the LogicalType union
+	utc         bool  // TimestampType.isAdjustedToUTC
+	unit        timeUnit
+}
+
+func (e schemaElement) mapping() parquetColumn {
+	switch {
+	case e.hasLogical && e.logical == logicalDate, e.converted == convertedDate:
+		return parquetColumn{Type: schema.Date, Unit: unitDays}
+	case e.hasLogical && e.logical == logicalTimestamp:
+		t := schema.Timestamp
+		if e.utc {
+			t = schema.TimestampTZ
+		}
+		return parquetColumn{Type: t, Unit: e.unit}
+	case e.converted == convertedTimestampMillis:
+		return parquetColumn{Type: schema.Timestamp, Unit: unitMillis}
+	case e.converted == convertedTimestampMicros:
+		return parquetColumn{Type: schema.Timestamp, Unit: unitMicros}
+	case e.physical == parquetInt96:
+		return parquetColumn{Type: schema.Timestamp, Unit: unitInt96}
+	case e.converted == convertedDecimal:
+		if t, ok := schema.ResolveTypeName(fmt.Sprintf("NUMERIC(%d,%d)", e.precision, e.scale)); ok {
+			return parquetColumn{Type: t}
+		}
+		return parquetColumn{Type: schema.Numeric}
+	case e.converted == convertedInt8, e.converted == convertedInt16, e.converted == convertedUint8:
+		return parquetColumn{Type: schema.SmallInt}
+	case e.converted == convertedUint16:
+		return parquetColumn{Type: schema.Integer}
+	case e.converted == convertedUint32:
+		return parquetColumn{Type: schema.BigInt}
+	case e.converted == convertedUint64:
+		return parquetColumn{Type: schema.NumericType(20, 0)}
+	case e.physical == parquetInt32:
+		return parquetColumn{Type: schema.Integer}
+	case e.physical == parquetInt64:
+		return parquetColumn{Type: schema.BigInt}
+	case e.physical == parquetFloat:
+		return parquetColumn{Type: schema.Real}
+	case e.physical == parquetDouble:
+		return parquetColumn{Type: schema.Double}
+	}
+	return parquetColumn{Type: schema.Text}
+}
+
+// decodeParquetSchema reads field 2 (schema) of a FileMetaData struct.
+func decodeParquetSchema(meta []byte) ([]schemaElement, error) {
+	r := &compactReader{buf: meta}
+	var elems []schemaElement
+	err := r.readStruct(func(id int16, typ byte) error {
+		if id != 2 || typ != ctList {
+			return r.skip(typ)
+		}
+		size, elemType, err := r.readListHeader()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if elemType != ctStruct {
+				return fmt.Errorf("schema list holds type %d, want struct", elemType)
+			}
+			e, err := r.readSchemaElement()
+			if err != nil {
+				return err
+			}
+			elems = append(elems, e)
+		}
+		return nil
+	})
+	return elems, err
+}
+
+func (r *compactReader) readSchemaElement() (schemaElement, error) {
+	e := schemaElement{physical: -1, converted: -1}
+	err := r.readStruct(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == ctI32:
+			e.physical, err = r.readI32()
+		case id == 3 && typ == ctI32:
+			e.repetition, err = r.readI32()
+		case id == 4 && typ == ctBinary:
+			var b []byte
+			b, err = r.readBinary()
+			e.name = string(b)
+		case id == 5 && typ == ctI32:
+			e.numChildren, err = r.readI32()
+		case id == 6 && typ == ctI32:
+			e.converted, err = r.readI32()
+		case id == 7 && typ == ctI32:
+			e.scale, err = r.readI32()
+		case id == 8 && typ == ctI32:
+			e.precision, err = r.readI32()
+		case id == 10 && typ == ctStruct:
+			e.hasLogical = true
+			err = r.readStruct(func(lid int16, ltyp byte) error {
+				e.logical = lid
+				if lid == logicalTimestamp && ltyp == ctStruct {
+					return r.readTimestampType(&e)
+				}
+				return r.skip(ltyp)
+			})
+		default:
+			err = r.skip(typ)
+		}
+		return err
+	})
+	return e, err
+}
+
+// readTimestampType reads a TimestampType: isAdjustedToUTC and a TimeUnit
+// union of MILLIS, MICROS or NANOS.
+func (r *compactReader) readTimestampType(e *schemaElement) error {
+	return r.readStruct(func(id int16, typ byte) error {
+		switch {
+		case id == 1 && (typ == ctTrue || typ == ctFalse):
+			e.utc = typ == ctTrue
+			return nil
+		case id == 2 && typ == ctStruct:
+			return r.readStruct(func(uid int16, utyp byte) error {
+				e.unit = map[int16]timeUnit{1: unitMillis, 2: unitMicros, 3: unitNanos}[uid]
+				return r.skip(utyp)
+			})
+		}
+		return r.skip(typ)
+	})
+}
+
+// Thrift compact protocol field types.
+const (
+	ctStop   = 0
+	ctTrue   = 1
+	ctFalse  = 2
+	ctByte   = 3
+	ctI16    = 4
+	ctI32    = 5
+	ctI64    = 6
+	ctDouble = 7
+	ctBinary = 8
+	ctList   = 9
+	ctSet    = 10
+	ctMap    = 11
+	ctStruct = 12
+)
+
+// compactReader decodes the subset of the Thrift compact protocol needed to
+// walk a Parquet footer.
+type compactReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *compactReader) readByte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *compactReader) readVarint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("varint too long")
+}
+
+func (r *compactReader) readZigZag() (int64, error) {
+	v, err := r.readVarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *compactReader) readI32() (int32, error) {
+	v, err := r.readZigZag()
+	return int32(v), err
+}
+
+func (r *compactReader) readBinary() ([]byte, error) {
+	n, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)-r.pos) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+func (r *compactReader) readListHeader() (int, byte, error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := int(b >> 4)
+	if size == 15 {
+		n, err := r.readVarint()
+		if err != nil {
+			return 0, 0, err
+		}
+		if n > uint64(len(r.buf)) {
+			return 0, 0, fmt.Errorf("list size %d exceeds footer", n)
+		}
+		size = int(n)
+	}
+	return size, b & 0x0f, nil
+}
+
+// readStruct calls field for each field header until the stop field. field
+// must consume the field's value.
+func (r *compactReader) readStruct(field func(id int16, typ byte) error) error {
+	var last int16
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		typ := b & 0x0f
+		if typ == ctStop {
+			return nil
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			v, err := r.readZigZag()
+			if err != nil {
+				return err
+			}
+			id = int16(v)
+		}
+		last = id
+		if err := field(id, typ); err != nil {
+			return err
+		}
+	}
+}
+
+// skip consumes a value of type typ.
+func (r *compactReader) skip(typ byte) error {
+	switch typ {
+	case ctTrue, ctFalse:
+		return nil
+	case ctByte:
+		_, err := r.readByte()
+		return err
+	case ctI16, ctI32, ctI64:
+		_, err := r.readVarint()
+		return err
+	case ctDouble:
+		if len(r.buf)-r.pos < 8 {
+			return io.ErrUnexpectedEOF
+		}
+		r.pos += 8
+		return nil
+	case ctBinary:
+		_, err := r.readBinary()
+		return err
+	case ctList, ctSet:
+		size, elemType, err := r.readListHeader()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			// booleans inside containers take a byte each
+			if elemType == ctTrue || elemType == ctFalse {
+				elemType = ctByte
+			}
+			if err := r.skip(elemType); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ctMap:
+		size, err := r.readVarint()
+		if err != nil || size == 0 {
+			return err
+		}
+		kv, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < size; i++ {
+			if err := r.skip(kv >> 4); err != nil {
+				return err
+			}
+			if err := r.skip(kv & 0x0f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ctStruct:
+		return r.readStruct(func(_ int16, t byte) error { return r.skip(t) })
+	}
+	return fmt.Errorf("unknown compact type %d", typ)
+}
+
+// julianUnixEpoch is the Julian day number of 1970-01-01.
+const julianUnixEpoch = 2440588
+
+// decodeInt96 converts a 12-byte INT96 timestamp: nanoseconds within the
+// day, little-endian, followed by the Julian day.
+func decodeInt96(b []byte) time.Time {
+	nanos := int64(binary.LittleEndian.Uint64(b[:8]))
+	day := int64(binary.LittleEndian.Uint32(b[8:12]))
+	return time.Unix((day-julianUnixEpoch)*86400, nanos).UTC()
+}
+
+// parquetCellValue converts one converter output cell of a typed Parquet
+// column. Converters print timestamps either as text or as the raw stored
+// integer, and INT96 values sometimes as hex or base64 bytes. Cells that
+// cannot be read are kept as text. TIMESTAMPTZ values are given in loc.
+func parquetCellValue(cell string, col parquetColumn, loc *time.Location) interface{} {
+	v := cellValue(cell)
+	s, ok := v.(string)
+	if !ok || col.Type == schema.Text {
+		return v
+	}
+	if cv, err := expr.CastValueIn(s, col.Type, loc); err == nil {
+		return cv
+	}
+	var t time.Time
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		switch col.Unit {
+		case unitMillis:
+			t = time.UnixMilli(n).UTC()
+		case unitMicros:
+			t = time.UnixMicro(n).UTC()
+		case unitNanos:
+			t = time.Unix(0, n).UTC()
+		case unitDays:
+			if n > math.MaxInt32 || n < math.MinInt32 {
+				return v
+			}
+			t = time.Unix(n*86400, 0).UTC()
+		default:
+			return v
+		}
+	} else if col.Unit == unitInt96 {
+		b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+		if err != nil || len(b) != 12 {
+			b, err = base64.StdEncoding.DecodeString(s)
+		}
+		if err != nil || len(b) != 12 {
+			return v
+		}
+		t = decodeInt96(b)
+	} else {
+		return v
+	}
+	if col.Type == schema.TimestampTZ {
+		return expr.TimestampTZ{Time: t.In(loc)}
+	}
+	cv, err := expr.CastValueIn(t, col.Type, loc)
+	if err != nil {
+		return v
+	}
+	return cv
+}
@@ -0,0 +1,243 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Custom_DB/pkg/expr"
+	"Custom_DB/pkg/schema"
+)
+
+// compactWriter encodes just enough of the Thrift compact protocol to build
+// a Parquet footer for the tests.
+type compactWriter struct {
+	buf  bytes.Buffer
+	last []int16
+}
+
+func (w *compactWriter) varint(v uint64) {
+	w.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *compactWriter) field(id int16, typ byte) {
+	prev := w.last[len(w.last)-1]
+	w.buf.WriteByte(byte(id-prev)<<4 | typ)
+	w.last[len(w.last)-1] = id
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.field(id, ctI32)
+	w.varint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (w *compactWriter) str(id int16, s string) {
+	w.field(id, ctBinary)
+	w.varint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+// begin opens a struct; id 0 starts a top-level struct or list element.
+func (w *compactWriter) begin(id int16) {
+	if id != 0 {
+		w.field(id, ctStruct)
+	}
+	w.last = append(w.last, 0)
+}
+
+func (w *compactWriter) end() {
+	w.buf.WriteByte(ctStop)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func writeParquetFooter(t *testing.T) string {
+	t.Helper()
+	w := &compactWriter{}
+	w.begin(0)
+	w.i32(1, 1)
+	w.field(2, ctList)
+	// more than 14 elements need the long list header
+	w.buf.WriteByte(0xf0 | ctStruct)
+	w.varint(17)
+
+	w.begin(0)
+	w.str(4, "schema")
+	w.i32(5, 9)
+	w.end()
+
+	w.begin(0)
+	w.i32(1, parquetInt96)
+	w.str(4, "loaded_at")
+	w.end()
+
+	w.begin(0)
+	w.i32(1, parquetInt64)
+	w.str(4, "created_ms")
+	w.i32(6, convertedTimestampMillis)
+	w.end()
+
+	w.begin(0)
+	w.i32(1, parquetInt32)
+	w.str(4, "birthday")
+	w.i32(6, convertedDate)
+	w.begin(10)
+	w.begin(6)
+	w.end()
+	w.end()
+	w.end()
+
+	w.begin(0)
+	w.i32(1, parquetInt64)
+	w.str(4, "seen_at")
+	w.begin(10)
+	w.begin(8)
+	w.field(1, ctTrue)
+	w.begin(2)
+	w.begin(2)
+	w.end()
+	w.end()
+	w.end()
+	w.end()
+	w.end()
+
+	w.begin(0)
+	w.i32(1, 6)
+	w.str(4, "name")
+	w.end()
+
+	// a struct group with two leaves, and a repeated leaf
+	w.begin(0)
+	w.str(4, "address")
+	w.i32(5, 2)
+	w.end()
+	w.begin(0)
+	w.i32(1, 6)
+	w.str(4, "city")
+	w.end()
+	w.begin(0)
+	w.i32(1, parquetInt32)
+	w.str(4, "zip")
+	w.end()
+
+	w.begin(0)
+	w.i32(1, parquetInt32)
+	w.i32(3, repetitionRepeated)
+	w.str(4, "scores")
+	w.end()
+
+	// a three-level LIST of dates, and a MAP
+	w.begin(0)
+	w.str(4, "visits")
+	w.i32(5, 1)
+	w.i32(6, convertedList)
+	w.end()
+	w.begin(0)
+	w.i32(3, repetitionRepeated)
+	w.str(4, "list")
+	w.i32(5, 1)
+	w.end()
+	w.begin(0)
+	w.i32(1, parquetInt32)
+	w.str(4, "element")
+	w.i32(6, convertedDate)
+	w.end()
+
+	w.begin(0)
+	w.str(4, "attrs")
+	w.i32(5, 1)
+	w.i32(6, convertedMap)
+	w.end()
+	w.begin(0)
+	w.i32(3, repetitionRepeated)
+	w.str(4, "key_value")
+	w.i32(5, 2)
+	w.end()
+	w.begin(0)
+	w.i32(1, 6)
+	w.str(4, "key")
+	w.end()
+	w.begin(0)
+	w.i32(1, 6)
+	w.str(4, "value")
+	w.end()
+
+	w.i32(3, 0) // num_rows, skipped by the reader
+	w.end()
+
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+	file.Write(w.buf.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(w.buf.Len()))
+	file.WriteString("PAR1")
+
+	path := filepath.Join(t.TempDir(), "t.parquet")
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadParquetColumns(t *testing.T) {
+	cols, err := readParquetColumns(writeParquetFooter(t))
+	if err != nil {
+		t.Fatalf("readParquetColumns: %v", err)
+	}
+	want := map[string]parquetColumn{
+		"loaded_at":  {Type: schema.Timestamp, Unit: unitInt96},
+		"created_ms": {Type: schema.Timestamp, Unit: unitMillis},
+		"birthday":   {Type: schema.Date, Unit: unitDays},
+		"seen_at":    {Type: schema.TimestampTZ, Unit: unitMicros},
+		"name":       {Type: schema.Text},
+		"address":    {Type: "STRUCT<city TEXT, zip INT>"},
+		"scores":     {Type: "ARRAY<INT>"},
+		"visits":     {Type: "ARRAY<DATE>"},
+		"attrs":      {Type: schema.JSON},
+	}
+	if len(cols) != len(want) {
+		t.Fatalf("got columns %v, want %v", cols, want)
+	}
+	for name, c := range want {
+		if cols[name] != c {
+			t.Fatalf("column %s = %+v, want %+v", name, cols[name], c)
+		}
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.parquet")
+	os.WriteFile(bad, []byte("not a parquet file at all"), 0644)
+	if _, err := readParquetColumns(bad); err == nil {
+		t.Fatalf("expected error for a file without the PAR1 magic")
+	}
+}
+
+func TestParquetCellValue(t *testing.T) {
+	instant := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
+	int96 := make([]byte, 12)
+	binary.LittleEndian.PutUint64(int96, uint64((7*3600+8*60+9)*time.Second))
+	binary.LittleEndian.PutUint32(int96[8:], uint32(julianUnixEpoch+instant.Unix()/86400))
+
+	cases := []struct {
+		cell string
+		col  parquetColumn
+		want string
+	}{
+		{"1683356889000", parquetColumn{Type: schema.Timestamp, Unit: unitMillis}, "2023-05-06 07:08:09"},
+		{"1683356889000000", parquetColumn{Type: schema.TimestampTZ, Unit: unitMicros}, "2023-05-06 07:08:09+00:00"},
+		{"19483", parquetColumn{Type: schema.Date, Unit: unitDays}, "2023-05-06"},
+		{"2023-05-06 07:08:09", parquetColumn{Type: schema.Timestamp, Unit: unitInt96}, "2023-05-06 07:08:09"},
+		{hex.EncodeToString(int96), parquetColumn{Type: schema.Timestamp, Unit: unitInt96}, "2023-05-06 07:08:09"},
+		{"soon", parquetColumn{Type: schema.Date, Unit: unitDays}, "soon"},
+		{"", parquetColumn{Type: schema.Date, Unit: unitDays}, "NULL"},
+		{`{"city": "Oslo", "zip": 150}`, parquetColumn{Type: schema.JSON}, `{"city":"Oslo","zip":150}`},
+		{`{"zip": "0150", "city": "Oslo"}`, parquetColumn{Type: "STRUCT<city TEXT, zip INT>"}, `{"city":"Oslo","zip":150}`},
+		{`[3, null, 5.0]`, parquetColumn{Type: "ARRAY<INT>"}, `[3,null,5]`},
+	}
+	for _, c := range cases {
+		if got := expr.FormatValue(parquetCellValue(c.cell, c.col, time.UTC)); got != c.want {
+			t.Fatalf("parquetCellValue(%q, %+v) = %q, want %q", c.cell, c.col, got, c.want)
+		}
+	}
+}
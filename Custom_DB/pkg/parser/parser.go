@@ -82,3 +82,43 @@ func IndexOfKeyword(cmd Command, keyword string) int {
 	}
 	return -1
 }
+
+// IndexOfTopLevelKeyword is like IndexOfKeyword but ignores tokens nested inside
+// parentheses, so a FROM inside EXTRACT(YEAR FROM ts) is not mistaken for a clause.
+func IndexOfTopLevelKeyword(cmd Command, keyword string) int {
+	ku := strings.ToUpper(keyword)
+	depth := 0
+	for i, t := range cmd.Tokens {
+		switch t {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		default:
+			if depth == 0 && strings.ToUpper(t) == ku {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// IndexOfTopLevelClause returns the index of the first top-level keyword that
+// is immediately followed by next, e.g. GROUP BY, skipping other uses of the
+// keyword such as WITHIN GROUP (...).
+func IndexOfTopLevelClause(cmd Command, keyword, next string) int {
+	depth := 0
+	for i, t := range cmd.Tokens {
+		switch t {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		default:
+			if depth == 0 && strings.EqualFold(t, keyword) && i+1 < len(cmd.Tokens) && strings.EqualFold(cmd.Tokens[i+1], next) {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -23,3 +23,29 @@ func TestParseVerb(t *testing.T) {
 		t.Fatalf("Unexpected verb: %s", cmd.Type)
 	}
 }
+
+func TestIndexOfTopLevelKeyword(t *testing.T) {
+	cmd, err := Parse("SELECT EXTRACT(YEAR FROM ts) FROM events")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if got := IndexOfKeyword(cmd, "FROM"); got != 4 {
+		t.Fatalf("IndexOfKeyword: got %d, want 4", got)
+	}
+	if got := IndexOfTopLevelKeyword(cmd, "FROM"); got != 7 {
+		t.Fatalf("IndexOfTopLevelKeyword: got %d, want 7", got)
+	}
+}
+
+func TestIndexOfTopLevelClause(t *testing.T) {
+	cmd, err := Parse("SELECT PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY x) FROM t GROUP BY g")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if got := IndexOfTopLevelClause(cmd, "GROUP", "BY"); got != 14 {
+		t.Fatalf("GROUP BY: got %d, want 14", got)
+	}
+	if got := IndexOfTopLevelClause(cmd, "ORDER", "BY"); got != -1 {
+		t.Fatalf("ORDER BY: got %d, want -1", got)
+	}
+}
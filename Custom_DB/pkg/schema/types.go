@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type DataType string
@@ -16,8 +19,111 @@ const (
 	Decimal DataType = "DECIMAL"
 	Boolean DataType = "BOOL"
 	Image   DataType = "IMAGE"
+
+	Date        DataType = "DATE"
+	Timestamp   DataType = "TIMESTAMP"
+	TimestampTZ DataType = "TIMESTAMPTZ" // TIMESTAMP WITH TIME ZONE
+	Interval    DataType = "INTERVAL"
+
+	SmallInt DataType = "SMALLINT" // 16-bit integer
+	BigInt   DataType = "BIGINT"   // 64-bit integer
+	Real     DataType = "REAL"     // single-precision float
+	Double   DataType = "DOUBLE"   // double-precision float
+	// Numeric is an exact decimal. NUMERIC(p,s) columns store the precision
+	// and scale in the type name, as returned by NumericType.
+	Numeric DataType = "NUMERIC"
+
+	// JSON holds a JSON document. JSONB is accepted as a synonym.
+	JSON DataType = "JSON"
+
+	// Blob holds binary content. BLOB and IMAGE values are kept in the
+	// database's blob store; rows hold a reference to the content.
+	Blob DataType = "BLOB"
+
+	// Array and Struct are the bases of the nested types ARRAY<T> and
+	// STRUCT<name T, ...>, whose element and field types are part of the
+	// type name, as returned by ArrayType and StructType.
+	Array  DataType = "ARRAY"
+	Struct DataType = "STRUCT"
 )
 
+// NumericType returns the type of a NUMERIC(precision, scale) column.
+func NumericType(precision, scale int) DataType {
+	return DataType(fmt.Sprintf("NUMERIC(%d,%d)", precision, scale))
+}
+
+// ArrayType returns the type of an array of elem values.
+func ArrayType(elem DataType) DataType {
+	return DataType("ARRAY<" + string(elem) + ">")
+}
+
+// StructType returns the type of a struct with the given fields, in order.
+func StructType(fields []Column) DataType {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f.Name + " " + string(f.Type)
+	}
+	return DataType("STRUCT<" + strings.Join(parts, ", ") + ">")
+}
+
+// Base returns t without its type modifiers, such as NUMERIC for
+// NUMERIC(10,2) or ARRAY for ARRAY<INT>.
+func (t DataType) Base() DataType {
+	if i := strings.IndexAny(string(t), "(<"); i != -1 {
+		return t[:i]
+	}
+	return t
+}
+
+// IsBlob reports whether values of type t are kept in the blob store.
+func (t DataType) IsBlob() bool {
+	return t == Blob || t == Image
+}
+
+// ElementType returns the element type of an ARRAY<T> type.
+func (t DataType) ElementType() (DataType, bool) {
+	inner, ok := t.nestedInner(Array)
+	if !ok {
+		return "", false
+	}
+	return DataType(inner), true
+}
+
+// StructFields returns the fields of a STRUCT<...> type.
+func (t DataType) StructFields() ([]Column, bool) {
+	inner, ok := t.nestedInner(Struct)
+	if !ok {
+		return nil, false
+	}
+	fields, err := ParseColumnDefs(inner)
+	if err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// nestedInner returns the text between the angle brackets of a nested type
+// with the given base.
+func (t DataType) nestedInner(base DataType) (string, bool) {
+	s := string(t)
+	if t.Base() != base || !strings.HasPrefix(s, string(base)+"<") || !strings.HasSuffix(s, ">") {
+		return "", false
+	}
+	return s[len(base)+1 : len(s)-1], true
+}
+
+// NumericModifiers returns the precision and scale of a NUMERIC(p,s) type.
+// ok is false for an unconstrained NUMERIC and for every other type.
+func (t DataType) NumericModifiers() (precision, scale int, ok bool) {
+	if t.Base() != Numeric || t == Numeric {
+		return 0, 0, false
+	}
+	if _, err := fmt.Sscanf(string(t), "NUMERIC(%d,%d)", &precision, &scale); err != nil {
+		return 0, 0, false
+	}
+	return precision, scale, true
+}
+
 type Column struct {
 	Name string   `json:"name"`
 	Type DataType `json:"type"`
@@ -26,21 +132,102 @@ type Column struct {
 type Table struct {
 	Name    string   `json:"name"`
 	Columns []Column `json:"columns"`
+	Indexes []Index  `json:"indexes,omitempty"`
+	Format  string   `json:"format,omitempty"` // how the rows are stored; empty for JSON lines
+	// Temp marks a table made by CREATE TEMP TABLE. It is seen only by the
+	// Session that made it, is never saved, and keeps its rows in memory.
+	Temp    bool     `json:"-"`
+	Storage string   `json:"-"` // the name its rows are stored under, if not Name
+}
+
+// StorageName is the name the rows and indexes of the table are stored
+// under. A temporary table has one of its own, so the temporary tables of
+// the same name in two sessions, or one that hides a table of the
+// database, never share rows.
+func (t Table) StorageName() string {
+	if t.Storage != "" {
+		return t.Storage
+	}
+	return t.Name
+}
+
+// lastTemp numbers the temporary tables made by the process.
+var lastTemp int64
+
+// Table formats other than the default. A table created WITH
+// (format='columnar') stores its rows in segments of columns; one created
+// WITH (format='memory') keeps them in memory only, so they are lost when
+// the process exits.
+const (
+	FormatColumnar = "columnar"
+	FormatMemory   = "memory"
+)
+
+// Index is an expression index on a table, such as one on doc->>'email'.
+// Expr is the indexed expression's source text.
+type Index struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
 }
 
+// MemoryPath is the path NewDatabase opens a new in-memory database at.
+const MemoryPath = ":memory:"
+
+// A Database is the tables of a database as seen by one session. The
+// sessions made by Session share the tables with the database they were
+// made from, but each has temporary tables of its own, which hide the
+// shared tables of the same name.
 type Database struct {
 	Tables         map[string]Table `json:"tables"`
-	mu             sync.RWMutex     `json:"-"`
+	mu             *sync.RWMutex    `json:"-"`
 	dbPath         string           `json:"-"`
-	schemaFilePath string           `json:"-"`
+	schemaFilePath string           `json:"-"` // empty for an in-memory database
+	session        string           `json:"-"`
+	temp           map[string]Table `json:"-"` // guarded by mu like Tables
+	settings       *settings        `json:"-"`
 }
 
+// settings are the values a session has SET, by name.
+type settings struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// Setting returns the value the session of db has SET name to, if any.
+func (db *Database) Setting(name string) (string, bool) {
+	db.settings.mu.Lock()
+	defer db.settings.mu.Unlock()
+	v, ok := db.settings.values[name]
+	return v, ok
+}
+
+// SetSetting sets name to value for the session of db only.
+func (db *Database) SetSetting(name, value string) {
+	db.settings.mu.Lock()
+	defer db.settings.mu.Unlock()
+	db.settings.values[name] = value
+}
+
+// NewDatabase opens the database at dbPath, creating it if need be. The
+// path MemoryPath opens a new in-memory database instead: its schema is
+// never saved and its tables keep their rows in memory, while its indexes,
+// blobs and spill files go to a scratch directory that Close removes.
 func NewDatabase(dbPath string) (*Database, error) {
+	if dbPath == MemoryPath {
+		dir, err := os.MkdirTemp("", "customdb-memory-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create in-memory database directory: %w", err)
+		}
+		return &Database{Tables: make(map[string]Table), mu: &sync.RWMutex{}, dbPath: dir, temp: make(map[string]Table), settings: newSettings()}, nil
+	}
 	fullPath := filepath.Join(dbPath, "schema.json")
 	db := &Database{
 		Tables:         make(map[string]Table),
+		mu:             &sync.RWMutex{},
 		dbPath:         dbPath,
 		schemaFilePath: fullPath,
+		temp:           make(map[string]Table),
+		settings:       newSettings(),
 	}
 
 	
@@ -64,7 +251,98 @@ func NewDatabase(dbPath string) (*Database, error) {
 	return db, nil
 }
 
+func newSettings() *settings {
+	return &settings{values: map[string]string{}}
+}
+
+// InMemory reports whether db was opened at MemoryPath.
+func (db *Database) InMemory() bool {
+	return db.schemaFilePath == ""
+}
+
+// closeHooks are called by Close with the path of the database it closes;
+// see OnClose.
+var closeHooks []func(dbPath string)
+
+// OnClose adds fn to the functions called with the path of every database
+// closed, so a package can free what it keeps in memory for the database.
+// It is meant to be called from init functions.
+func OnClose(fn func(dbPath string)) {
+	closeHooks = append(closeHooks, fn)
+}
+
+// Close frees the rows db's tables keep in memory, those of the temporary
+// tables of every session of db included, and removes the scratch directory
+// of an in-memory database. No session of db may be used after it.
+func (db *Database) Close() error {
+	for _, fn := range closeHooks {
+		fn(db.dbPath)
+	}
+	if !db.InMemory() {
+		return nil
+	}
+	if err := os.RemoveAll(db.dbPath); err != nil {
+		return fmt.Errorf("failed to remove in-memory database directory %s: %w", db.dbPath, err)
+	}
+	return nil
+}
+
+// Session returns a new session of db named id, which shares every table of
+// db but the temporary ones, and starts with no temporary tables and no
+// settings. Each call makes a session of its own, even for the same id.
+func (db *Database) Session(id string) *Database {
+	return &Database{
+		Tables:         db.Tables,
+		mu:             db.mu,
+		dbPath:         db.dbPath,
+		schemaFilePath: db.schemaFilePath,
+		session:        id,
+		temp:           make(map[string]Table),
+		settings:       newSettings(),
+	}
+}
+
+// lookup returns the table name refers to in the session of db: its
+// temporary table of that name if it has one, else the shared one. It is
+// called with mu held.
+func (db *Database) lookup(name string) (Table, bool) {
+	if table, ok := db.temp[name]; ok {
+		return table, true
+	}
+	table, ok := db.Tables[name]
+	return table, ok
+}
+
+// store records table in the map it belongs to, the session's for a
+// temporary table. It is called with mu held.
+func (db *Database) store(table Table) {
+	if table.Temp {
+		db.temp[table.Name] = table
+	} else {
+		db.Tables[table.Name] = table
+	}
+}
+
+// RemoveTempTables forgets the temporary tables of the session of db, as
+// when it ends, and returns them so their rows can be dropped.
+func (db *Database) RemoveTempTables() []Table {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var removed []Table
+	for name, table := range db.temp {
+		removed = append(removed, table)
+		delete(db.temp, name)
+	}
+	return removed
+}
+
+// Save writes the schema of every table but the temporary ones; it does
+// nothing for an in-memory database.
 func (db *Database) Save() error {
+	if db.InMemory() {
+		return nil
+	}
 	data, err := json.MarshalIndent(db.Tables, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal schema to JSON: %w", err)
@@ -76,14 +354,30 @@ func (db *Database) Save() error {
 	return nil
 }
 
+// AddTable adds a table to the database, or a temporary one to the session
+// of db only. A temporary table may hide a table of the database, but not
+// another temporary table of the session.
 func (db *Database) AddTable(table Table) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if _, exists := db.Tables[table.Name]; exists {
+	existing := db.Tables
+	if table.Temp {
+		existing = db.temp
+	}
+	if _, exists := existing[table.Name]; exists {
 		return fmt.Errorf("table '%s' already exists", table.Name)
 	}
-	db.Tables[table.Name] = table
+	if table.Temp {
+		table.Storage = fmt.Sprintf("temp%d.%s", atomic.AddInt64(&lastTemp, 1), table.Name)
+	}
+	if table.Temp || db.InMemory() {
+		table.Format = FormatMemory
+	}
+	db.store(table)
+	if table.Temp {
+		return nil
+	}
 	return db.Save()
 }
 
@@ -91,14 +385,17 @@ func (db *Database) GetTable(name string) (Table, bool) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	table, exists := db.Tables[name]
-	return table, exists
+	return db.lookup(name)
 }
 
 func (db *Database) RemoveTable(name string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if _, exists := db.temp[name]; exists {
+		delete(db.temp, name)
+		return nil
+	}
 	if _, exists := db.Tables[name]; !exists {
 		return fmt.Errorf("table '%s' does not exist", name)
 	}
@@ -110,22 +407,300 @@ func (db *Database) GetAllTableNames() []string {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	names := make([]string, 0, len(db.Tables))
-	for name := range db.Tables {
+	names := make([]string, 0, len(db.Tables)+len(db.temp))
+	for name := range db.temp {
 		names = append(names, name)
 	}
+	for name := range db.Tables {
+		if _, hidden := db.temp[name]; !hidden {
+			names = append(names, name)
+		}
+	}
 	return names
 }
 
+// AddIndex records an index on the named table. Index names are unique
+// across the tables the session of db sees.
+func (db *Database) AddIndex(tableName string, idx Index) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	table, exists := db.lookup(tableName)
+	if !exists {
+		return fmt.Errorf("table '%s' does not exist", tableName)
+	}
+	for _, tables := range []map[string]Table{db.temp, db.Tables} {
+		for _, t := range tables {
+			for _, existing := range t.Indexes {
+				if strings.EqualFold(existing.Name, idx.Name) {
+					return fmt.Errorf("index '%s' already exists", idx.Name)
+				}
+			}
+		}
+	}
+	table.Indexes = append(table.Indexes, idx)
+	db.store(table)
+	if table.Temp {
+		return nil
+	}
+	return db.Save()
+}
+
+// RemoveIndex drops the named index and returns the table it was on.
+func (db *Database) RemoveIndex(name string) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, tables := range []map[string]Table{db.temp, db.Tables} {
+		for tableName, table := range tables {
+			if _, hidden := db.temp[tableName]; hidden && !table.Temp {
+				continue
+			}
+			for i, idx := range table.Indexes {
+				if strings.EqualFold(idx.Name, name) {
+					table.Indexes = append(table.Indexes[:i:i], table.Indexes[i+1:]...)
+					db.store(table)
+					if table.Temp {
+						return tableName, nil
+					}
+					return tableName, db.Save()
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("index '%s' does not exist", name)
+}
+
 func (db *Database) GetDBPath() string {
 	return db.dbPath
 }
 
 func ValidateColumnType(typeStr string) bool {
-	switch DataType(typeStr) {
-	case Integer, Text, Decimal, Boolean, Image:
+	t := DataType(typeStr)
+	if _, _, ok := t.NumericModifiers(); ok {
+		return true
+	}
+	if elem, ok := t.ElementType(); ok {
+		return !elem.IsBlob() && ValidateColumnType(string(elem))
+	}
+	if fields, ok := t.StructFields(); ok {
+		for _, f := range fields {
+			if f.Type.IsBlob() || !ValidateColumnType(string(f.Type)) {
+				return false
+			}
+		}
+		return len(fields) > 0
+	}
+	switch t {
+	case Integer, Text, Decimal, Boolean, Image, Date, Timestamp, TimestampTZ, Interval,
+		SmallInt, BigInt, Real, Double, Numeric, JSON, Blob:
 		return true
 	default:
 		return false
 	}
 }
+
+// ResolveTypeName maps a SQL type name, including common synonyms such as
+// INTEGER, VARCHAR or BOOLEAN, to the DataType used for storage. Multi-word
+// names such as TIMESTAMP WITH TIME ZONE may be given as one space-separated
+// string. NUMERIC(p,s) and DECIMAL(p,s) keep their precision and scale; any
+// other length suffix like VARCHAR(20) is ignored. A bare DECIMAL is the
+// floating-point DECIMAL type older schemas use. ARRAY<T>, also written T[],
+// and STRUCT<name T, ...> resolve their element and field types in turn.
+func ResolveTypeName(name string) (DataType, bool) {
+	if t, ok, nested := resolveNestedType(strings.TrimSpace(name)); nested {
+		return t, ok
+	}
+	n := strings.ToUpper(strings.TrimSpace(name))
+	mods := ""
+	if i := strings.Index(n, "("); i != -1 {
+		rest := ""
+		if j := strings.Index(n[i:], ")"); j != -1 {
+			mods = n[i+1 : i+j]
+			rest = n[i+j+1:]
+		}
+		n = strings.TrimSpace(n[:i]) + " " + strings.TrimSpace(rest)
+	}
+	n = strings.Join(strings.Fields(n), " ")
+	switch n {
+	case "DATE":
+		return Date, true
+	case "TIMESTAMP", "DATETIME", "TIMESTAMP WITHOUT TIME ZONE":
+		return Timestamp, true
+	case "TIMESTAMPTZ", "TIMESTAMP WITH TIME ZONE":
+		return TimestampTZ, true
+	case "INTERVAL":
+		return Interval, true
+	case "INT", "INTEGER", "INT4":
+		return Integer, true
+	case "SMALLINT", "INT2":
+		return SmallInt, true
+	case "BIGINT", "INT8":
+		return BigInt, true
+	case "TEXT", "VARCHAR", "CHAR", "STRING":
+		return Text, true
+	case "REAL", "FLOAT4":
+		return Real, true
+	case "DOUBLE", "DOUBLE PRECISION", "FLOAT", "FLOAT8":
+		return Double, true
+	case "NUMERIC", "DECIMAL":
+		if mods == "" {
+			if n == "DECIMAL" {
+				return Decimal, true
+			}
+			return Numeric, true
+		}
+		return resolveNumericModifiers(mods)
+	case "BOOL", "BOOLEAN":
+		return Boolean, true
+	case "IMAGE":
+		return Image, true
+	case "BLOB", "BYTEA", "BINARY", "VARBINARY":
+		return Blob, true
+	case "JSON", "JSONB":
+		return JSON, true
+	}
+	return "", false
+}
+
+// resolveNestedType resolves ARRAY<T>, T[] and STRUCT<...> type names.
+// nested is false for any other name. Struct field names keep their case.
+// BLOB and IMAGE may not be nested, since the blob store counts references
+// per column value.
+func resolveNestedType(name string) (t DataType, ok bool, nested bool) {
+	if strings.HasSuffix(name, "[]") {
+		elem, ok := ResolveTypeName(strings.TrimSuffix(name, "[]"))
+		if !ok || elem.IsBlob() {
+			return "", false, true
+		}
+		return ArrayType(elem), true, true
+	}
+	open := strings.Index(name, "<")
+	if open == -1 || !strings.HasSuffix(name, ">") {
+		return "", false, false
+	}
+	inner := name[open+1 : len(name)-1]
+	switch strings.ToUpper(strings.TrimSpace(name[:open])) {
+	case "ARRAY", "LIST":
+		elem, ok := ResolveTypeName(inner)
+		if !ok || elem.IsBlob() {
+			return "", false, true
+		}
+		return ArrayType(elem), true, true
+	case "STRUCT", "ROW":
+		fields, err := ParseColumnDefs(inner)
+		if err != nil || len(fields) == 0 {
+			return "", false, true
+		}
+		for i, f := range fields {
+			if f.Type.IsBlob() {
+				return "", false, true
+			}
+			for _, prev := range fields[:i] {
+				if strings.EqualFold(prev.Name, f.Name) {
+					return "", false, true
+				}
+			}
+		}
+		return StructType(fields), true, true
+	}
+	return "", false, true
+}
+
+// MaxNumericPrecision is the largest precision a NUMERIC(p,s) column accepts.
+const MaxNumericPrecision = 1000
+
+// resolveNumericModifiers parses the "p" or "p,s" modifiers of a NUMERIC or
+// DECIMAL type. The scale defaults to 0 and may not exceed the precision.
+func resolveNumericModifiers(mods string) (DataType, bool) {
+	parts := strings.Split(mods, ",")
+	if len(parts) > 2 {
+		return "", false
+	}
+	vals := []int{0, 0}
+	for i, part := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return "", false
+		}
+		vals[i] = v
+	}
+	p, s := vals[0], vals[1]
+	if p < 1 || p > MaxNumericPrecision || s < 0 || s > p {
+		return "", false
+	}
+	return NumericType(p, s), true
+}
+
+// ParseTableOptions splits the WITH (name = value, ...) clause off the end
+// of a CREATE TABLE statement and returns the statement without it and
+// the storage format it asks for. The only option is format, 'row',
+// 'columnar' or 'memory'; a statement without the clause gets the row
+// format, "".
+func ParseTableOptions(stmt string) (string, string, error) {
+	stmt = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+	i := strings.LastIndex(strings.ToUpper(stmt), "WITH")
+	if i == -1 || !strings.HasSuffix(strings.TrimSpace(stmt[:i]), ")") {
+		return stmt, "", nil
+	}
+	opts := strings.TrimSpace(stmt[i+len("WITH"):])
+	if !strings.HasPrefix(opts, "(") || !strings.HasSuffix(opts, ")") {
+		return stmt, "", nil
+	}
+	format := ""
+	for _, opt := range strings.Split(opts[1:len(opts)-1], ",") {
+		name, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return "", "", fmt.Errorf("invalid table option: %s", strings.TrimSpace(opt))
+		}
+		name = strings.TrimSpace(name)
+		value = strings.Trim(strings.TrimSpace(value), "'\"")
+		if !strings.EqualFold(name, "format") {
+			return "", "", fmt.Errorf("unknown table option: %s", name)
+		}
+		switch strings.ToLower(value) {
+		case "row":
+			format = ""
+		case FormatColumnar, FormatMemory:
+			format = strings.ToLower(value)
+		default:
+			return "", "", fmt.Errorf("unknown table format: %s (supported: row, columnar, memory)", value)
+		}
+	}
+	return strings.TrimSpace(stmt[:i]), format, nil
+}
+
+// ParseColumnDefs parses the column list of a CREATE TABLE statement, such
+// as "id INT, created TIMESTAMP WITH TIME ZONE". Each definition is a name
+// followed by a type name accepted by ResolveTypeName.
+func ParseColumnDefs(defs string) ([]Column, error) {
+	columns := []Column{}
+	depth, start := 0, 0
+	parts := []string{}
+	for i, r := range defs {
+		switch r {
+		case '(', '<':
+			depth++
+		case ')', '>':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, defs[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, defs[start:])
+	for _, def := range parts {
+		fields := strings.Fields(def)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid column definition: %s", strings.TrimSpace(def))
+		}
+		t, ok := ResolveTypeName(strings.Join(fields[1:], " "))
+		if !ok {
+			return nil, fmt.Errorf("invalid column type: %s", strings.Join(fields[1:], " "))
+		}
+		columns = append(columns, Column{Name: fields[0], Type: t})
+	}
+	return columns, nil
+}
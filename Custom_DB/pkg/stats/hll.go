@@ -0,0 +1,68 @@
+// Package stats collects the table statistics ANALYZE gathers and the
+// planner estimates row counts with: row counts and, per column, the
+// number of distinct values, the fraction of NULLs, the smallest and
+// largest values and an equi-depth histogram.
+package stats
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision is the number of hash bits that pick a HyperLogLog register;
+// 2^14 registers give a standard error of about 0.8%.
+const hllPrecision = 14
+
+// HyperLogLog estimates the number of distinct keys added to it in a fixed
+// 16 KB, however many keys there are.
+type HyperLogLog struct {
+	registers []uint8
+}
+
+// NewHyperLogLog returns an empty sketch.
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+// Add records key.
+func (h *HyperLogLog) Add(key string) {
+	f := fnv.New64a()
+	f.Write([]byte(key))
+	x := mix(f.Sum64())
+	idx := x >> (64 - hllPrecision)
+	// the rank is the position of the first 1 bit in the remaining bits
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Count returns the estimated number of distinct keys added.
+func (h *HyperLogLog) Count() int64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// few keys: count the empty registers instead
+		est = m * math.Log(m/float64(zeros))
+	}
+	return int64(est + 0.5)
+}
+
+// mix spreads the bits of an FNV hash, whose high bits vary little for
+// short keys.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,262 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"Custom_DB/pkg/schema"
+)
+
+const (
+	// SampleSize is how many values of a column a histogram is built from;
+	// larger columns are sampled.
+	SampleSize = 10000
+	// HistogramBuckets is the number of buckets of a histogram.
+	HistogramBuckets = 32
+)
+
+// TableStats are the statistics of a table.
+type TableStats struct {
+	Rows    int64                   `json:"rows"`
+	Stamp   string                  `json:"stamp"` // the data file analyzed
+	Columns map[string]*ColumnStats `json:"columns"`
+}
+
+// ColumnStats are the statistics of a column. Min, Max and the histogram
+// bounds are float64 for numbers and text for other ordered values; they
+// are unset for columns whose values have no order, such as JSON.
+type ColumnStats struct {
+	Distinct int64       `json:"distinct"`
+	NullFrac float64     `json:"null_frac"`
+	Min      interface{} `json:"min,omitempty"`
+	Max      interface{} `json:"max,omitempty"`
+	// Histogram holds the bounds of equi-depth buckets: each bucket, from
+	// one bound to the next, holds the same share of the non-NULL values.
+	Histogram []interface{} `json:"histogram,omitempty"`
+}
+
+// Path returns the file the statistics of the database at dbPath are kept
+// in, next to its schema.json.
+func Path(dbPath string) string { return filepath.Join(dbPath, "stats.json") }
+
+func init() {
+	schema.OnClose(forget)
+}
+
+// loaded holds the statistics Load read or Save wrote, by database path,
+// so the file is read once and again only after ANALYZE writes it.
+var loaded = struct {
+	sync.Mutex
+	m map[string]map[string]*TableStats
+}{m: map[string]map[string]*TableStats{}}
+
+// Load reads the statistics of the database at dbPath by table name. A
+// database never analyzed has none. The map is the caller's to change, but
+// the statistics in it are shared and must not be.
+func Load(dbPath string) (map[string]*TableStats, error) {
+	loaded.Lock()
+	defer loaded.Unlock()
+	all, ok := loaded.m[dbPath]
+	if !ok {
+		data, err := os.ReadFile(Path(dbPath))
+		switch {
+		case os.IsNotExist(err):
+			all = map[string]*TableStats{}
+		case err != nil:
+			return nil, fmt.Errorf("failed to read statistics: %w", err)
+		default:
+			all = map[string]*TableStats{}
+			if err := json.Unmarshal(data, &all); err != nil {
+				return nil, fmt.Errorf("failed to read statistics from %s: %w", Path(dbPath), err)
+			}
+		}
+		loaded.m[dbPath] = all
+	}
+	return copyStats(all), nil
+}
+
+// Save writes the statistics of the database at dbPath. They are written to
+// a new file that then replaces the old one, so a reader never sees a file
+// half written.
+func Save(dbPath string, all map[string]*TableStats) error {
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal statistics: %w", err)
+	}
+	loaded.Lock()
+	defer loaded.Unlock()
+	f, err := os.CreateTemp(dbPath, "stats-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create statistics file: %w", err)
+	}
+	err = f.Chmod(0644)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), Path(dbPath))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write statistics file %s: %w", Path(dbPath), err)
+	}
+	loaded.m[dbPath] = copyStats(all)
+	return nil
+}
+
+// forget drops the statistics of the database at dbPath from memory, as
+// when it is closed.
+func forget(dbPath string) {
+	loaded.Lock()
+	defer loaded.Unlock()
+	delete(loaded.m, dbPath)
+}
+
+func copyStats(all map[string]*TableStats) map[string]*TableStats {
+	c := make(map[string]*TableStats, len(all))
+	for name, ts := range all {
+		c[name] = ts
+	}
+	return c
+}
+
+// Collector gathers the statistics of one column from its values.
+type Collector struct {
+	rows, nulls int64
+	distinct    *HyperLogLog
+	min, max    interface{}
+	// a uniform sample of the ordered values, by reservoir sampling
+	sample  []interface{}
+	ordered int64
+	rng     *rand.Rand
+}
+
+// NewCollector returns a collector that has seen no values.
+func NewCollector() *Collector {
+	// a fixed seed makes ANALYZE of the same data give the same statistics
+	return &Collector{distinct: NewHyperLogLog(), rng: rand.New(rand.NewSource(1))}
+}
+
+// AddNull records a NULL.
+func (c *Collector) AddNull() {
+	c.rows++
+	c.nulls++
+}
+
+// Add records a value: key is the same for values that are equal, and
+// ordered is the value as a float64 or a string, or nil when values of the
+// column have no order.
+func (c *Collector) Add(key string, ordered interface{}) {
+	c.rows++
+	c.distinct.Add(key)
+	if ordered == nil {
+		return
+	}
+	if c.min == nil || Compare(ordered, c.min) < 0 {
+		c.min = ordered
+	}
+	if c.max == nil || Compare(ordered, c.max) > 0 {
+		c.max = ordered
+	}
+	c.ordered++
+	if len(c.sample) < SampleSize {
+		c.sample = append(c.sample, ordered)
+	} else if i := c.rng.Int63n(c.ordered); i < SampleSize {
+		c.sample[i] = ordered
+	}
+}
+
+// Stats returns the statistics of the values recorded.
+func (c *Collector) Stats() *ColumnStats {
+	cs := &ColumnStats{Min: c.min, Max: c.max}
+	if c.rows > 0 {
+		cs.NullFrac = float64(c.nulls) / float64(c.rows)
+		cs.Distinct = c.distinct.Count()
+		// the estimate can exceed the exact bound for small columns
+		if cs.Distinct > c.rows-c.nulls {
+			cs.Distinct = c.rows - c.nulls
+		}
+	}
+	if len(c.sample) > 1 {
+		sort.Slice(c.sample, func(i, j int) bool { return Compare(c.sample[i], c.sample[j]) < 0 })
+		buckets := HistogramBuckets
+		if len(c.sample)-1 < buckets {
+			buckets = len(c.sample) - 1
+		}
+		n := len(c.sample) - 1
+		for i := 0; i <= buckets; i++ {
+			cs.Histogram = append(cs.Histogram, c.sample[i*n/buckets])
+		}
+	}
+	return cs
+}
+
+// Compare orders two ordered values. Numbers order before text.
+func Compare(a, b interface{}) int {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		switch {
+		case !ok:
+			return -1
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		y, ok := b.(string)
+		switch {
+		case !ok:
+			return 1
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return 0
+}
+
+// FractionBelow estimates the fraction of the column's non-NULL values
+// less than v, from its histogram. ok is false without a histogram or when
+// v is not ordered like the column's values.
+func (cs *ColumnStats) FractionBelow(v interface{}) (frac float64, ok bool) {
+	h := cs.Histogram
+	if len(h) < 2 {
+		return 0, false
+	}
+	if _, num := h[0].(float64); num {
+		if _, ok := v.(float64); !ok {
+			return 0, false
+		}
+	} else if _, ok := v.(string); !ok {
+		return 0, false
+	}
+	if Compare(v, h[0]) <= 0 {
+		return 0, true
+	}
+	last := len(h) - 1
+	if Compare(v, h[last]) > 0 {
+		return 1, true
+	}
+	// the bucket v falls in
+	i := sort.Search(last, func(i int) bool { return Compare(h[i+1], v) >= 0 })
+	within := 0.5
+	if lo, ok := h[i].(float64); ok {
+		if hi := h[i+1].(float64); hi > lo {
+			within = (v.(float64) - lo) / (hi - lo)
+		}
+	}
+	return (float64(i) + within) / float64(last), true
+}
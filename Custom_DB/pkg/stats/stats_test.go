@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		h := NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			// every key twice: repeats must not count
+			h.Add(fmt.Sprint(i))
+			h.Add(fmt.Sprint(i))
+		}
+		got := float64(h.Count())
+		if math.Abs(got-float64(n)) > 0.03*float64(n)+1 {
+			t.Errorf("Count over %d keys = %v", n, got)
+		}
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	for i := 1; i <= 1000; i++ {
+		if i%10 == 0 {
+			c.AddNull()
+			continue
+		}
+		c.Add(fmt.Sprint(i%100), float64(i%100))
+	}
+	cs := c.Stats()
+	if cs.NullFrac != 0.1 {
+		t.Errorf("NullFrac = %v, want 0.1", cs.NullFrac)
+	}
+	if cs.Distinct < 88 || cs.Distinct > 92 {
+		t.Errorf("Distinct = %d, want about 90", cs.Distinct)
+	}
+	if cs.Min != 1.0 || cs.Max != 99.0 || len(cs.Histogram) != HistogramBuckets+1 {
+		t.Fatalf("Min, Max = %v, %v with %d bounds", cs.Min, cs.Max, len(cs.Histogram))
+	}
+	for v, want := range map[float64]float64{0: 0, 25: 0.25, 50: 0.5, 200: 1} {
+		got, ok := cs.FractionBelow(v)
+		if !ok || math.Abs(got-want) > 0.03 {
+			t.Errorf("FractionBelow(%v) = %v, %v; want about %v", v, got, ok, want)
+		}
+	}
+	if _, ok := cs.FractionBelow("text"); ok {
+		t.Errorf("text has no place in a numeric histogram")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	all, err := Load(dir)
+	if err != nil || len(all) != 0 {
+		t.Fatalf("Load of an unanalyzed database = %v, %v", all, err)
+	}
+	all["t"] = &TableStats{Rows: 3, Columns: map[string]*ColumnStats{"a": {Distinct: 2, Min: "x", Histogram: []interface{}{1.0, 2.0}}}}
+	if err := Save(dir, all); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cs := got["t"].Columns["a"]; got["t"].Rows != 3 || cs.Distinct != 2 || cs.Min != "x" || cs.Histogram[1] != 2.0 {
+		t.Errorf("Load = %+v", got["t"])
+	}
+	delete(got, "t")
+	if again, err := Load(dir); err != nil || again["t"] == nil {
+		t.Errorf("a change to the loaded map reached the next Load: %v, %v", again, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || entries[0].Name() != "stats.json" {
+		t.Errorf("files left by Save: %v", entries)
+	}
+
+	// the file is read again once the database is forgotten
+	if err := os.WriteFile(Path(dir), []byte(`{"u": {"rows": 5}}`), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	forget(dir)
+	if got, err := Load(dir); err != nil || got["u"] == nil || got["u"].Rows != 5 || got["t"] != nil {
+		t.Errorf("Load after forget = %v, %v", got, err)
+	}
+}
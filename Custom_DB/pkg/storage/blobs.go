@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BlobRef is the value of a BLOB or IMAGE column: a reference to content
+// kept in the database's blob store, with the content's MIME type and size.
+// Images also record their format, dimensions, EXIF fields and perceptual
+// hash. It is stored in rows as a JSON object.
+type BlobRef struct {
+	SHA256 string `json:"sha256"`
+	MIME   string `json:"mime"`
+	Size   int64  `json:"size"`
+
+	Format string `json:"format,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	EXIF   string `json:"exif,omitempty"`  // a JSON object of EXIF fields
+	PHash  string `json:"phash,omitempty"` // difference hash as 16 hex digits
+}
+
+// String names the content by its digest.
+func (b BlobRef) String() string { return "sha256:" + b.SHA256 }
+
+// BlobRefOf returns the reference held by a stored value, which comes back
+// from JSON as a map. ok is false for any other value, such as the file path
+// older versions stored in IMAGE columns.
+func BlobRefOf(v interface{}) (BlobRef, bool) {
+	switch t := v.(type) {
+	case BlobRef:
+		return t, true
+	case map[string]interface{}:
+		sum, ok := t["sha256"].(string)
+		if !ok || len(sum) != sha256.Size*2 {
+			return BlobRef{}, false
+		}
+		ref := BlobRef{SHA256: sum}
+		ref.MIME, _ = t["mime"].(string)
+		ref.Size = jsonInt(t["size"])
+		ref.Format, _ = t["format"].(string)
+		ref.Width = int(jsonInt(t["width"]))
+		ref.Height = int(jsonInt(t["height"]))
+		ref.EXIF, _ = t["exif"].(string)
+		ref.PHash, _ = t["phash"].(string)
+		return ref, true
+	}
+	return BlobRef{}, false
+}
+
+// jsonInt returns a number decoded from JSON as an int64.
+func jsonInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return i
+	}
+	return 0
+}
+
+// DetectMIME returns the MIME type of content from its first bytes.
+func DetectMIME(data []byte) string {
+	// http.DetectContentType does not sniff TIFF
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	return http.DetectContentType(data)
+}
+
+// blobEntry is what the blob store records about one stored content.
+type blobEntry struct {
+	MIME string `json:"mime"`
+	Size int64  `json:"size"`
+	Refs int    `json:"refs"`
+}
+
+// blobMu serializes changes to the reference counts of every store.
+var blobMu sync.Mutex
+
+// BlobStore keeps the contents of BLOB and IMAGE values in the blobs
+// directory of a database, one file per distinct content named by its
+// SHA-256 digest, so equal contents are stored once. refs.json counts the
+// rows using each content; a content is removed once no row uses it.
+// images.json indexes the perceptual hashes of stored images by the name of
+// the file they were read from, such as photo001 for photo001.jpg. The
+// thumbs directory caches thumbnails rendered from stored images.
+type BlobStore struct {
+	dir string
+}
+
+// NewBlobStore returns the blob store of the database at dbPath.
+func NewBlobStore(dbPath string) (*BlobStore, error) {
+	if dbPath == "" {
+		return nil, fmt.Errorf("invalid parameters: dbPath cannot be empty")
+	}
+	dir := filepath.Join(dbPath, "blobs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory %s: %w", dir, err)
+	}
+	return &BlobStore{dir: dir}, nil
+}
+
+// Path returns the file holding the content with the given digest.
+func (s *BlobStore) Path(sum string) string {
+	return filepath.Join(s.dir, sum[:2], sum)
+}
+
+// Put stores data, unless the same content is already stored, and adds a
+// reference to it.
+func (s *BlobStore) Put(data []byte) (BlobRef, error) {
+	digest := sha256.Sum256(data)
+	ref := BlobRef{SHA256: hex.EncodeToString(digest[:]), MIME: DetectMIME(data), Size: int64(len(data))}
+
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	entries, err := s.readRefs()
+	if err != nil {
+		return BlobRef{}, err
+	}
+	e, exists := entries[ref.SHA256]
+	if !exists {
+		if err := s.writeBlob(ref.SHA256, data); err != nil {
+			return BlobRef{}, err
+		}
+		e = &blobEntry{MIME: ref.MIME, Size: ref.Size}
+		entries[ref.SHA256] = e
+	}
+	e.Refs++
+	if err := s.writeRefs(entries); err != nil {
+		return BlobRef{}, err
+	}
+	return ref, nil
+}
+
+// Retain adds a reference to content that is already stored, for a value
+// copied from another row.
+func (s *BlobStore) Retain(ref BlobRef) error {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	entries, err := s.readRefs()
+	if err != nil {
+		return err
+	}
+	e, ok := entries[ref.SHA256]
+	if !ok {
+		return fmt.Errorf("blob %s is not in the blob store", ref)
+	}
+	e.Refs++
+	return s.writeRefs(entries)
+}
+
+// Release drops a reference to stored content and removes the content when
+// it was the last one.
+func (s *BlobStore) Release(ref BlobRef) error {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	entries, err := s.readRefs()
+	if err != nil {
+		return err
+	}
+	e, ok := entries[ref.SHA256]
+	if !ok {
+		return nil
+	}
+	if e.Refs--; e.Refs <= 0 {
+		delete(entries, ref.SHA256)
+		if err := os.Remove(s.Path(ref.SHA256)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove blob %s: %w", ref, err)
+		}
+		if err := s.unindexImage(ref.SHA256); err != nil {
+			return err
+		}
+		s.removeThumbnails(ref.SHA256)
+	}
+	return s.writeRefs(entries)
+}
+
+// imageEntry is what the image index records about a named image.
+type imageEntry struct {
+	SHA256 string `json:"sha256"`
+	PHash  string `json:"phash"`
+}
+
+// IndexImage records the perceptual hash of a stored image under name.
+// A later image with the same name replaces it.
+func (s *BlobStore) IndexImage(name string, ref BlobRef) error {
+	if name == "" || ref.PHash == "" {
+		return nil
+	}
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	images, err := s.readImages()
+	if err != nil {
+		return err
+	}
+	images[name] = imageEntry{SHA256: ref.SHA256, PHash: ref.PHash}
+	return s.writeImages(images)
+}
+
+// LookupImage returns the perceptual hash of the image indexed under name.
+func (s *BlobStore) LookupImage(name string) (string, bool, error) {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	images, err := s.readImages()
+	if err != nil {
+		return "", false, err
+	}
+	e, ok := images[name]
+	return e.PHash, ok, nil
+}
+
+// unindexImage drops the names of a content that is no longer stored.
+func (s *BlobStore) unindexImage(sum string) error {
+	images, err := s.readImages()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for name, e := range images {
+		if e.SHA256 == sum {
+			delete(images, name)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.writeImages(images)
+}
+
+func (s *BlobStore) imagesPath() string {
+	return filepath.Join(s.dir, "images.json")
+}
+
+func (s *BlobStore) readImages() (map[string]imageEntry, error) {
+	images := map[string]imageEntry{}
+	data, err := os.ReadFile(s.imagesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return images, nil
+		}
+		return nil, fmt.Errorf("failed to read image index: %w", err)
+	}
+	if err := json.Unmarshal(data, &images); err != nil {
+		return nil, fmt.Errorf("failed to decode image index: %w", err)
+	}
+	return images, nil
+}
+
+func (s *BlobStore) writeImages(images map[string]imageEntry) error {
+	data, err := json.Marshal(images)
+	if err != nil {
+		return fmt.Errorf("failed to encode image index: %w", err)
+	}
+	return writeFileAtomic(s.imagesPath(), data)
+}
+
+// Read returns the stored content of ref.
+func (s *BlobStore) Read(ref BlobRef) ([]byte, error) {
+	if len(ref.SHA256) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid blob reference '%s'", ref)
+	}
+	data, err := os.ReadFile(s.Path(ref.SHA256))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", ref, err)
+	}
+	return data, nil
+}
+
+// ThumbnailPath returns the file caching the thumbnail of the content with
+// the given digest rendered at size pixels.
+func (s *BlobStore) ThumbnailPath(sum string, size int) string {
+	return filepath.Join(s.dir, "thumbs", sum[:2], fmt.Sprintf("%s-%d", sum, size))
+}
+
+// ReadThumbnail returns the cached thumbnail of ref rendered at size pixels.
+// ok is false when none is cached.
+func (s *BlobStore) ReadThumbnail(ref BlobRef, size int) (data []byte, ok bool, err error) {
+	if len(ref.SHA256) != sha256.Size*2 {
+		return nil, false, fmt.Errorf("invalid blob reference '%s'", ref)
+	}
+	data, err = os.ReadFile(s.ThumbnailPath(ref.SHA256, size))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to read thumbnail of %s: %w", ref, err)
+	}
+	return data, true, nil
+}
+
+// WriteThumbnail caches the thumbnail of ref rendered at size pixels. It is
+// removed with the content.
+func (s *BlobStore) WriteThumbnail(ref BlobRef, size int, data []byte) error {
+	if len(ref.SHA256) != sha256.Size*2 {
+		return fmt.Errorf("invalid blob reference '%s'", ref)
+	}
+	path := s.ThumbnailPath(ref.SHA256, size)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// removeThumbnails removes the cached thumbnails of a content that is no
+// longer stored. Thumbnails left behind are only wasted space, so failures
+// are ignored.
+func (s *BlobStore) removeThumbnails(sum string) {
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "thumbs", sum[:2], sum+"-*"))
+	for _, p := range paths {
+		os.Remove(p)
+	}
+}
+
+// Refs returns the number of rows using the content with the given digest.
+func (s *BlobStore) Refs(sum string) (int, error) {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	entries, err := s.readRefs()
+	if err != nil {
+		return 0, err
+	}
+	if e, ok := entries[sum]; ok {
+		return e.Refs, nil
+	}
+	return 0, nil
+}
+
+func (s *BlobStore) refsPath() string {
+	return filepath.Join(s.dir, "refs.json")
+}
+
+func (s *BlobStore) readRefs() (map[string]*blobEntry, error) {
+	entries := map[string]*blobEntry{}
+	data, err := os.ReadFile(s.refsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, fmt.Errorf("failed to read blob references: %w", err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode blob references: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *BlobStore) writeRefs(entries map[string]*blobEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode blob references: %w", err)
+	}
+	return writeFileAtomic(s.refsPath(), data)
+}
+
+func (s *BlobStore) writeBlob(sum string, data []byte) error {
+	path := s.Path(sum)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces path with data through a temporary file, so a
+// reader never sees a partly written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
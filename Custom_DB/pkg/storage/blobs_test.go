@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestBlobStore_Refcount(t *testing.T) {
+	s, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	data := []byte("hello, blob")
+	ref, err := s.Put(data)
+	if err != nil || ref.Size != int64(len(data)) || ref.MIME != "text/plain; charset=utf-8" {
+		t.Fatalf("put: %+v, %v", ref, err)
+	}
+	// the same content is stored once, with a reference per row
+	if again, err := s.Put(data); err != nil || again.SHA256 != ref.SHA256 {
+		t.Fatalf("put again: %+v, %v", again, err)
+	}
+	if err := s.Retain(ref); err != nil {
+		t.Fatalf("retain: %v", err)
+	}
+	if err := s.WriteThumbnail(ref, 32, []byte("thumb")); err != nil {
+		t.Fatalf("write thumbnail: %v", err)
+	}
+
+	for want := 3; want > 0; want-- {
+		if n, err := s.Refs(ref.SHA256); err != nil || n != want {
+			t.Fatalf("refs %d, %v; want %d", n, err, want)
+		}
+		if got, err := s.Read(ref); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("read with %d refs: %q, %v", want, got, err)
+		}
+		if err := s.Release(ref); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+	}
+
+	// the last release removes the content and its thumbnails
+	if n, err := s.Refs(ref.SHA256); err != nil || n != 0 {
+		t.Fatalf("refs after the last release: %d, %v", n, err)
+	}
+	if _, err := os.Stat(s.Path(ref.SHA256)); !os.IsNotExist(err) {
+		t.Errorf("content left after the last release: %v", err)
+	}
+	if _, ok, _ := s.ReadThumbnail(ref, 32); ok {
+		t.Error("thumbnail left after the last release")
+	}
+	if err := s.Release(ref); err != nil {
+		t.Errorf("releasing content no longer stored: %v", err)
+	}
+	if err := s.Retain(ref); err == nil {
+		t.Error("expected an error retaining content no longer stored")
+	}
+}
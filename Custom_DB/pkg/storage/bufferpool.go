@@ -0,0 +1,499 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PageSize is the size of the pages the buffer pool keeps of a file.
+const PageSize = 8192
+
+// SharedBuffers is the buffer pool every table file is read and appended
+// through, shared by all sessions: 2048 pages, 16 MiB.
+var SharedBuffers = NewBufferPool(2048)
+
+// A BufferPool keeps the most used pages of data files in memory. A page is
+// pinned while it is read or written, and only unpinned pages are evicted,
+// the least recently used first by the CLOCK algorithm. A page written to
+// is dirty until it is written back to its file, which happens before an
+// append returns or when the page is evicted, whichever is first.
+//
+// The pool remembers the size and modification time each file had when
+// its pages were read, and forgets the pages when the file has changed
+// since, so files rewritten or written to outside the pool are read anew.
+type BufferPool struct {
+	mu       sync.Mutex
+	capacity int
+	frames   []*page // the clock, in the order the hand visits them
+	hand     int
+	pages    map[pageKey]*page
+	files    map[string]fileState
+
+	// writeMu orders appends with the checks of files, so a file is never
+	// taken for changed on disk while an append to it is written back
+	writeMu sync.Mutex
+
+	hits, misses, evictions, writes int64
+}
+
+type pageKey struct {
+	path string
+	page int64
+}
+
+type fileState struct {
+	size  int64
+	mod   time.Time
+	lines int // the lines of the file, or -1 until an append counts them
+}
+
+// page is a frame of the pool. key, pins, dirty, ref and dropped are
+// guarded by the pool's mu; data and err by the page's own latch, held
+// while the page is loaded from disk and while it is read or written. A
+// frame is reused for another page once it is unpinned and out of the
+// pages map, so its latch is never replaced while anyone may hold it.
+type page struct {
+	latch   sync.RWMutex
+	key     pageKey
+	data    []byte
+	err     error
+	pins    int
+	dirty   bool
+	ref     bool
+	dropped bool
+}
+
+// NewBufferPool returns a pool of the given number of pages.
+func NewBufferPool(pages int) *BufferPool {
+	if pages < 1 {
+		pages = 1
+	}
+	return &BufferPool{capacity: pages, pages: map[pageKey]*page{}, files: map[string]fileState{}}
+}
+
+// PoolStats are the counters of a buffer pool. Hits and Misses count the
+// pins that found their page in the pool and those that read it from disk;
+// Writes counts dirty pages written back.
+type PoolStats struct {
+	Capacity, Pages, Pinned, Dirty  int
+	Hits, Misses, Evictions, Writes int64
+}
+
+// HitRatio is the share of pins that were hits, or 0 before the first pin.
+func (s PoolStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Stats returns the counters of the pool.
+func (p *BufferPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := PoolStats{Capacity: p.capacity, Hits: p.hits, Misses: p.misses, Evictions: p.evictions, Writes: p.writes}
+	for _, pg := range p.frames {
+		if pg.dropped {
+			continue
+		}
+		st.Pages++
+		if pg.pins > 0 {
+			st.Pinned++
+		}
+		if pg.dirty {
+			st.Dirty++
+		}
+	}
+	return st
+}
+
+// pin returns page n of the file at path, read from disk unless the pool
+// holds it, and keeps it in the pool until it is unpinned. A page past the
+// end of the file is empty.
+func (p *BufferPool) pin(path string, n int64) (*page, error) {
+	key := pageKey{path, n}
+	p.mu.Lock()
+	if pg, ok := p.pages[key]; ok {
+		pg.pins++
+		pg.ref = true
+		p.hits++
+		p.mu.Unlock()
+		pg.latch.RLock()
+		err := pg.err
+		pg.latch.RUnlock()
+		if err != nil {
+			p.unpin(pg, false)
+			return nil, err
+		}
+		return pg, nil
+	}
+	pg, err := p.victim()
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	if _, ok := p.pages[key]; ok {
+		// the page was loaded while a victim was written back; the frame
+		// is left free
+		pg.dropped = true
+		p.mu.Unlock()
+		return p.pin(path, n)
+	}
+	pg.key, pg.pins, pg.ref, pg.dirty, pg.dropped = key, 1, true, false, false
+	p.pages[key] = pg
+	p.misses++
+	// the page is loaded without the pool's lock; pins of it meanwhile
+	// wait on the latch
+	pg.latch.Lock()
+	p.mu.Unlock()
+	pg.data, pg.err = readPage(path, n)
+	err = pg.err
+	pg.latch.Unlock()
+	if err != nil {
+		p.mu.Lock()
+		pg.dropped = true
+		if p.pages[key] == pg {
+			delete(p.pages, key)
+		}
+		p.mu.Unlock()
+		p.unpin(pg, false)
+		return nil, err
+	}
+	return pg, nil
+}
+
+// readPage reads page n of the file at path.
+func readPage(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s for reading: %w", path, err)
+	}
+	defer f.Close()
+	data := make([]byte, PageSize)
+	read, err := f.ReadAt(data, n*PageSize)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading file %s: %w", path, err)
+	}
+	return data[:read], nil
+}
+
+// victim returns a frame for a new page: a new one while the pool is not
+// full, else the first unpinned one the clock's hand finds not referenced
+// since it last passed. It is called with mu held, and returns with it held
+// and the frame out of the pages map.
+//
+// A dirty page is written back first, without mu, so other pins go on
+// meanwhile. The page stays pinned while it is written, so no other victim
+// takes it, and it is evicted afterwards only if it was not used in the
+// meantime; otherwise the hand moves on.
+func (p *BufferPool) victim() (*page, error) {
+	for {
+		if len(p.frames) < p.capacity {
+			pg := &page{}
+			p.frames = append(p.frames, pg)
+			return pg, nil
+		}
+		pg, err := p.clock()
+		if err != nil {
+			return nil, err
+		}
+		if pg.dirty {
+			pg.pins++
+			pg.dirty = false
+			p.mu.Unlock()
+			err := writePage(pg)
+			p.mu.Lock()
+			pg.pins--
+			if err != nil {
+				if !pg.dropped {
+					pg.dirty = true
+				}
+				return nil, err
+			}
+			p.writes++
+			if pg.pins > 0 || pg.dirty || pg.ref {
+				continue
+			}
+		}
+		if !pg.dropped {
+			delete(p.pages, pg.key)
+			p.evictions++
+		}
+		return pg, nil
+	}
+}
+
+// clock moves the hand to the next unpinned frame that is dropped or not
+// referenced since the hand last passed, clearing the references it
+// passes. It is called with mu held.
+func (p *BufferPool) clock() (*page, error) {
+	for i := 0; i < 2*len(p.frames); i++ {
+		pg := p.frames[p.hand]
+		p.hand = (p.hand + 1) % len(p.frames)
+		if pg.pins > 0 {
+			continue
+		}
+		if !pg.dropped && pg.ref {
+			pg.ref = false
+			continue
+		}
+		return pg, nil
+	}
+	return nil, fmt.Errorf("buffer pool full: all %d pages are pinned", len(p.frames))
+}
+
+// unpin releases a pin of pg, marking it dirty if the holder wrote to it.
+func (p *BufferPool) unpin(pg *page, dirty bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pg.pins--
+	if dirty && !pg.dropped {
+		pg.dirty = true
+	}
+}
+
+// writePage writes pg back to its file.
+func writePage(pg *page) error {
+	pg.latch.RLock()
+	defer pg.latch.RUnlock()
+	f, err := os.OpenFile(pg.key.path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s for writing: %w", pg.key.path, err)
+	}
+	if _, err := f.WriteAt(pg.data, pg.key.page*PageSize); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write page of %s: %w", pg.key.path, err)
+	}
+	return f.Close()
+}
+
+// flush writes back the dirty pages of the file at path.
+func (p *BufferPool) flush(path string) error {
+	p.mu.Lock()
+	var dirty []*page
+	for _, pg := range p.frames {
+		if pg.dirty && !pg.dropped && pg.key.path == path {
+			pg.pins++
+			dirty = append(dirty, pg)
+		}
+	}
+	p.mu.Unlock()
+
+	var first error
+	for _, pg := range dirty {
+		err := writePage(pg)
+		p.mu.Lock()
+		pg.pins--
+		if err == nil {
+			pg.dirty = false
+			p.writes++
+		}
+		p.mu.Unlock()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// drop forgets the pages of the file at path, or of every file under it if
+// it is a directory, dirty or not, as when the file is replaced or removed.
+// Pages still pinned stay with their holders until unpinned.
+func (p *BufferPool) drop(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropNoLock(path)
+}
+
+func (p *BufferPool) dropNoLock(path string) {
+	under := path + string(filepath.Separator)
+	for key, pg := range p.pages {
+		if key.path == path || strings.HasPrefix(key.path, under) {
+			pg.dropped = true
+			pg.dirty = false
+			delete(p.pages, key)
+		}
+	}
+	for file := range p.files {
+		if file == path || strings.HasPrefix(file, under) {
+			delete(p.files, file)
+		}
+	}
+}
+
+// check forgets the pages of the file at path if it changed since they
+// were read, and returns the error of os.Stat for a file that cannot be
+// read, such as one that does not exist.
+func (p *BufferPool) check(path string) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.checkNoWriteLock(path)
+}
+
+func (p *BufferPool) checkNoWriteLock(path string) error {
+	info, err := os.Stat(path)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.dropNoLock(path)
+		return err
+	}
+	if old, ok := p.files[path]; !ok || old.size != info.Size() || old.mod != info.ModTime() {
+		p.dropNoLock(path)
+		p.files[path] = fileState{size: info.Size(), mod: info.ModTime(), lines: -1}
+	}
+	return nil
+}
+
+// append writes data at the end of the file at path through the pool and
+// writes the pages it dirtied back. It returns the number of lines the file
+// held before, the line data starts. The lines are counted by the first
+// append after the file changed outside the pool, and kept up to date by
+// the appends since.
+func (p *BufferPool) append(path string, data []byte) (int, error) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if err := p.checkNoWriteLock(path); err != nil {
+		return 0, fmt.Errorf("failed to open file %s for appending: %w", path, err)
+	}
+	p.mu.Lock()
+	off, lines := p.files[path].size, p.files[path].lines
+	p.mu.Unlock()
+	if lines < 0 {
+		n, err := p.countLines(path)
+		if err != nil {
+			return 0, err
+		}
+		lines = n
+	}
+	added := bytes.Count(data, []byte{'\n'})
+
+	for len(data) > 0 {
+		pg, err := p.pin(path, off/PageSize)
+		if err != nil {
+			p.drop(path)
+			return 0, err
+		}
+		within := int(off % PageSize)
+		n := PageSize - within
+		if n > len(data) {
+			n = len(data)
+		}
+		pg.latch.Lock()
+		if len(pg.data) != within {
+			pg.latch.Unlock()
+			p.unpin(pg, false)
+			p.drop(path)
+			return 0, fmt.Errorf("failed to append to %s: page out of step with the file", path)
+		}
+		pg.data = append(pg.data, data[:n]...)
+		pg.latch.Unlock()
+		p.unpin(pg, true)
+		data, off = data[n:], off+int64(n)
+	}
+	if err := p.flush(path); err != nil {
+		p.drop(path)
+		return 0, err
+	}
+	// the file is as the pool has it, so its pages are still good
+	info, err := os.Stat(path)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.dropNoLock(path)
+		return lines, nil
+	}
+	p.files[path] = fileState{size: info.Size(), mod: info.ModTime(), lines: lines + added}
+	return lines, nil
+}
+
+// countLines counts the line ends of the file at path through the pool. It
+// is called with writeMu held, so the file cannot change meanwhile.
+func (p *BufferPool) countLines(path string) (int, error) {
+	r := &pageReader{pool: p, path: path}
+	defer r.Close()
+	n := 0
+	buf := make([]byte, PageSize)
+	for {
+		read, err := r.Read(buf)
+		n += bytes.Count(buf[:read], []byte{'\n'})
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// reader returns a reader of the file at path from offset off through the
+// pool; it keeps the page it is reading pinned until it moves past it or
+// is closed.
+func (p *BufferPool) reader(path string, off int64) (*pageReader, error) {
+	if err := p.check(path); err != nil {
+		return nil, err
+	}
+	return &pageReader{pool: p, path: path, off: off}, nil
+}
+
+type pageReader struct {
+	pool *BufferPool
+	path string
+	off  int64
+	pg   *page
+}
+
+func (r *pageReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n := r.off / PageSize
+	if r.pg != nil && r.pg.key.page != n {
+		r.pool.unpin(r.pg, false)
+		r.pg = nil
+	}
+	if r.pg == nil {
+		pg, err := r.pool.pin(r.path, n)
+		if err != nil {
+			return 0, err
+		}
+		r.pg = pg
+	}
+	r.pg.latch.RLock()
+	read := 0
+	if within := int(r.off % PageSize); within < len(r.pg.data) {
+		read = copy(b, r.pg.data[within:])
+	}
+	r.pg.latch.RUnlock()
+	if read == 0 {
+		// only the last page of a file is short
+		return 0, io.EOF
+	}
+	r.off += int64(read)
+	return read, nil
+}
+
+func (r *pageReader) Close() error {
+	if r.pg != nil {
+		r.pool.unpin(r.pg, false)
+		r.pg = nil
+	}
+	return nil
+}
+
+// readAt fills b from offset off of the file at path through the pool.
+func (p *BufferPool) readAt(path string, b []byte, off int64) error {
+	r, err := p.reader(path, off)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.ReadFull(r, b)
+	return err
+}
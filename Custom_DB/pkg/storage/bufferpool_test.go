@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// poolFile writes a file of the given number of pages, each filled with
+// the letter of its number, and returns its path.
+func poolFile(t *testing.T, pages int) (string, []byte) {
+	t.Helper()
+	var data []byte
+	for i := 0; i < pages; i++ {
+		data = append(data, bytes.Repeat([]byte{byte('a' + i%26)}, PageSize)...)
+	}
+	path := filepath.Join(t.TempDir(), "pages.dat")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	return path, data
+}
+
+func readThrough(t *testing.T, p *BufferPool, path string) []byte {
+	t.Helper()
+	r, err := p.reader(path, 0)
+	if err != nil {
+		t.Fatalf("reader: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return got
+}
+
+func TestBufferPool_Eviction(t *testing.T) {
+	path, data := poolFile(t, 10)
+	p := NewBufferPool(4)
+
+	for i := 0; i < 2; i++ {
+		if got := readThrough(t, p, path); !bytes.Equal(got, data) {
+			t.Fatalf("pass %d: read %d bytes, want %d", i, len(got), len(data))
+		}
+	}
+	// each pass reads the 10 pages and the empty one past the end
+	st := p.Stats()
+	if st.Pages != 4 || st.Pinned != 0 || st.Misses != 22 || st.Evictions != 18 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+
+	// every pinned frame leaves a victim out until none is left
+	var pinned []*page
+	for n := int64(0); n < 4; n++ {
+		pg, err := p.pin(path, n)
+		if err != nil {
+			t.Fatalf("pin %d: %v", n, err)
+		}
+		pinned = append(pinned, pg)
+	}
+	if _, err := p.pin(path, 4); err == nil {
+		t.Fatal("expected an error pinning a page of a full pool")
+	}
+	for _, pg := range pinned {
+		p.unpin(pg, false)
+	}
+	if _, err := p.pin(path, 4); err != nil {
+		t.Fatalf("pin after unpinning: %v", err)
+	}
+}
+
+// TestBufferPool_DirtyWriteBack appends more than the pool holds, so the
+// pages an append dirties are evicted and written back before it flushes.
+func TestBufferPool_DirtyWriteBack(t *testing.T) {
+	path, data := poolFile(t, 1)
+	p := NewBufferPool(2)
+	long := append(bytes.Repeat([]byte("x"), 5*PageSize-1), '\n')
+	if line, err := p.append(path, long); err != nil || line != 0 {
+		t.Fatalf("append: line %d, %v", line, err)
+	}
+	// pages 1 to 5 were dirtied: the first three were written back as
+	// they were evicted, the last two by the flush
+	if st := p.Stats(); st.Writes != 5 || st.Evictions != 4 || st.Dirty != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	data = append(data, long...)
+	if onDisk, err := os.ReadFile(path); err != nil || !bytes.Equal(onDisk, data) {
+		t.Fatalf("file of %d bytes after the append: %v", len(onDisk), err)
+	}
+
+	// appends and reads side by side, in a pool that holds the pages they
+	// pin but little more
+	p = NewBufferPool(8)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var lines []int
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				row := append(bytes.Repeat([]byte{byte('0' + w)}, 3*PageSize), '\n')
+				line, err := p.append(path, row)
+				if err != nil {
+					t.Errorf("append: %v", err)
+					return
+				}
+				mu.Lock()
+				lines = append(lines, line)
+				mu.Unlock()
+				// reads while others append keep the pool under pressure
+				readThrough(t, p, path)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	st := p.Stats()
+	if st.Writes == 0 || st.Evictions == 0 || st.Dirty != 0 || st.Pinned != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	onDisk, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if got := readThrough(t, p, path); !bytes.Equal(got, onDisk) {
+		t.Fatal("the pool and the file disagree")
+	}
+	if !bytes.HasPrefix(onDisk, data) || len(onDisk) != len(data)+20*(3*PageSize+1) {
+		t.Fatalf("file of %d bytes", len(onDisk))
+	}
+	// every row is whole, and its line is the one append returned
+	seen := map[int]bool{}
+	for _, line := range lines {
+		seen[line] = true
+	}
+	for i, row := range bytes.Split(bytes.TrimSuffix(onDisk[len(data):], []byte{'\n'}), []byte{'\n'}) {
+		if len(row) != 3*PageSize || bytes.Count(row, row[:1]) != len(row) {
+			t.Fatalf("row %d is torn", i)
+		}
+		if !seen[i+1] {
+			t.Errorf("no append returned line %d", i+1)
+		}
+	}
+	if len(seen) != 20 {
+		t.Errorf("appends returned lines %v", fmt.Sprint(lines))
+	}
+}
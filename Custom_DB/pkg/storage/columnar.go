@@ -0,0 +1,873 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A columnar table keeps its rows in a directory of immutable segments.
+// A segment holds up to SegmentRows rows, one chunk per column, so a scan
+// reads only the columns it uses. Each chunk is stored plainly, as a
+// dictionary of its distinct values and a code per row, or as runs of
+// equal values, whichever suits it, and records the least and greatest of
+// its values, its zone map, so a scan can skip the segments a condition
+// rules out. Inserted rows go to a delta file of JSON lines first, and
+// become a segment once there are SegmentRows of them.
+//
+// The manifest lists the segments and names the delta file. Segments and
+// delta files are never written in place: a change writes new ones and
+// then replaces the manifest, so the table always reads as either before
+// or after it.
+
+// SegmentRows is the number of rows in a full segment of a columnar table.
+var SegmentRows = 8192
+
+// Chunk encodings.
+const (
+	encodingPlain = "plain"
+	encodingDict  = "dict"
+	encodingRLE   = "rle"
+)
+
+// ColumnarTable is a table stored in segments of columns.
+type ColumnarTable struct {
+	dir      string
+	indexDir string
+	mu       sync.RWMutex
+}
+
+// manifest lists the segments of a columnar table in row order and names
+// its delta file. Next numbers the files a change writes.
+type manifest struct {
+	Segments []*segmentMeta `json:"segments"`
+	Delta    string         `json:"delta"`
+	Next     int            `json:"next"`
+}
+
+// segmentMeta is where a segment's chunks are in its file, how they are
+// encoded and the zone map of each.
+type segmentMeta struct {
+	File    string                 `json:"file"`
+	Rows    int                    `json:"rows"`
+	Columns map[string]*columnMeta `json:"columns"`
+}
+
+type columnMeta struct {
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	Encoding string `json:"encoding"`
+	Zone
+}
+
+// Zone is the zone map of a column in a segment. Min and Max are the
+// least and greatest of its values when they are all numbers or all
+// strings, and nil otherwise; Nulls counts its NULLs and Rows its values.
+type Zone struct {
+	Min   interface{} `json:"min,omitempty"`
+	Max   interface{} `json:"max,omitempty"`
+	Nulls int         `json:"nulls"`
+	Rows  int         `json:"-"`
+}
+
+// columnChunk is a column's values in a segment. Plain chunks list every
+// value; dictionary chunks list the distinct values once, and the code of
+// each row's value in Codes; run-length chunks list a value per run, and
+// the length of each run in Runs.
+type columnChunk struct {
+	Values []json.RawMessage `json:"values"`
+	Codes  []int             `json:"codes,omitempty"`
+	Runs   []int             `json:"runs,omitempty"`
+}
+
+// NewColumnarTable opens the columnar table tableName of the database at
+// dbPath, creating it empty if it does not exist.
+func NewColumnarTable(dbPath, tableName string) (*ColumnarTable, error) {
+	if dbPath == "" || tableName == "" {
+		return nil, fmt.Errorf("invalid parameters: dbPath and tableName cannot be empty")
+	}
+	ct := &ColumnarTable{
+		dir:      filepath.Join(dbPath, tableName+".col"),
+		indexDir: filepath.Join(dbPath, "indexes", tableName),
+	}
+	if err := os.MkdirAll(ct.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for columnar table %s: %w", ct.dir, err)
+	}
+	if _, err := os.Stat(ct.manifestPath()); os.IsNotExist(err) {
+		if err := ct.writeManifest(&manifest{Segments: []*segmentMeta{}, Delta: "delta-0.dat", Next: 1}); err != nil {
+			return nil, err
+		}
+	}
+	return ct, nil
+}
+
+func (ct *ColumnarTable) manifestPath() string { return filepath.Join(ct.dir, "manifest.json") }
+
+func (ct *ColumnarTable) readManifest() (*manifest, error) {
+	data, err := os.ReadFile(ct.manifestPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of %s: %w", ct.dir, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	m := &manifest{}
+	if err := dec.Decode(m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest of %s: %w", ct.dir, err)
+	}
+	for _, s := range m.Segments {
+		for _, c := range s.Columns {
+			c.Min, c.Max = fromJSONNumber(c.Min), fromJSONNumber(c.Max)
+			c.Rows = s.Rows
+		}
+	}
+	return m, nil
+}
+
+// writeManifest replaces the manifest with m. It is written to a file of
+// its own first, so tables opened at once, which may each write the first
+// manifest, do not write over each other's.
+func (ct *ColumnarTable) writeManifest(m *manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	f, err := os.CreateTemp(ct.dir, "manifest-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write manifest of %s: %w", ct.dir, err)
+	}
+	err = f.Chmod(0644)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write manifest of %s: %w", ct.dir, err)
+	}
+	if err := os.Rename(f.Name(), ct.manifestPath()); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to replace manifest of %s: %w", ct.dir, err)
+	}
+	return nil
+}
+
+// delta returns the delta file of m. It shares the table's index
+// directory, so appending to it invalidates the indexes.
+func (ct *ColumnarTable) delta(m *manifest) *TableFile {
+	return &TableFile{path: filepath.Join(ct.dir, m.Delta), indexDir: ct.indexDir}
+}
+
+// segmentRows returns the rows in the segments of m.
+func segmentRows(m *manifest) int {
+	n := 0
+	for _, s := range m.Segments {
+		n += s.Rows
+	}
+	return n
+}
+
+// Insert adds row to the delta file, and moves the delta's rows to a new
+// segment once it has SegmentRows of them.
+func (ct *ColumnarTable) Insert(row Row) (int, error) {
+	if row == nil {
+		return 0, fmt.Errorf("cannot append nil row")
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	m, err := ct.readManifest()
+	if err != nil {
+		return 0, err
+	}
+	delta := ct.delta(m)
+	// a new delta file is created by its first row
+	f, err := os.OpenFile(delta.path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create delta file %s: %w", delta.path, err)
+	}
+	f.Close()
+	line, err := delta.appendRow(row)
+	if err != nil {
+		return 0, err
+	}
+	at := segmentRows(m) + line
+	if line+1 < SegmentRows {
+		return at, nil
+	}
+	rows, err := delta.readAllRowsNoLock()
+	if err != nil {
+		return 0, err
+	}
+	seg, err := ct.writeSegment(m, rows)
+	if err != nil {
+		return 0, err
+	}
+	m.Segments = append(m.Segments, seg)
+	m.Delta = fmt.Sprintf("delta-%d.dat", m.Next)
+	m.Next++
+	if err := ct.writeManifest(m); err != nil {
+		os.Remove(filepath.Join(ct.dir, seg.File))
+		return 0, err
+	}
+	SharedBuffers.drop(delta.path)
+	os.Remove(delta.path)
+	return at, nil
+}
+
+// writeSegment writes rows to a new segment file and returns its entry
+// for the manifest.
+func (ct *ColumnarTable) writeSegment(m *manifest, rows []Row) (*segmentMeta, error) {
+	seg := &segmentMeta{File: fmt.Sprintf("seg-%d.dat", m.Next), Rows: len(rows), Columns: map[string]*columnMeta{}}
+	m.Next++
+
+	names := map[string]bool{}
+	for _, r := range rows {
+		for k := range r {
+			names[k] = true
+		}
+	}
+	columns := make([]string, 0, len(names))
+	for k := range names {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+
+	var buf bytes.Buffer
+	for _, col := range columns {
+		raws := make([]json.RawMessage, len(rows))
+		for i, r := range rows {
+			data, err := json.Marshal(r[col])
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal value of column %s: %w", col, err)
+			}
+			raws[i] = data
+		}
+		chunk, encoding := encodeChunk(raws)
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal column %s: %w", col, err)
+		}
+		zone := zoneOf(chunk.Values)
+		zone.Nulls, zone.Rows = countNulls(raws), len(rows)
+		seg.Columns[col] = &columnMeta{Offset: int64(buf.Len()), Length: int64(len(data)), Encoding: encoding, Zone: zone}
+		buf.Write(data)
+	}
+
+	path := filepath.Join(ct.dir, seg.File)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment %s: %w", path, err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write segment %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to sync segment %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to close segment %s: %w", path, err)
+	}
+	return seg, nil
+}
+
+// encodeChunk picks the encoding of a column's values: runs when the
+// values repeat in long runs, a dictionary when there are few distinct
+// values, and the values as they are otherwise.
+func encodeChunk(raws []json.RawMessage) (*columnChunk, string) {
+	runs := &columnChunk{}
+	for i, r := range raws {
+		if i > 0 && bytes.Equal(r, raws[i-1]) {
+			runs.Runs[len(runs.Runs)-1]++
+			continue
+		}
+		runs.Values = append(runs.Values, r)
+		runs.Runs = append(runs.Runs, 1)
+	}
+	if len(runs.Runs)*4 <= len(raws) {
+		return runs, encodingRLE
+	}
+
+	dict := &columnChunk{Codes: make([]int, len(raws))}
+	codes := map[string]int{}
+	for i, r := range raws {
+		code, ok := codes[string(r)]
+		if !ok {
+			code = len(dict.Values)
+			codes[string(r)] = code
+			dict.Values = append(dict.Values, r)
+		}
+		dict.Codes[i] = code
+	}
+	if len(dict.Values)*2 <= len(raws) {
+		return dict, encodingDict
+	}
+	return &columnChunk{Values: raws}, encodingPlain
+}
+
+// zoneOf returns the least and greatest of values, the distinct values of
+// a chunk.
+func zoneOf(values []json.RawMessage) Zone {
+	z := Zone{}
+	numbers, strs := 0, 0
+	for _, raw := range values {
+		v, err := decodeValue(raw)
+		if err != nil || v == nil {
+			continue
+		}
+		switch v.(type) {
+		case int64, float64:
+			numbers++
+		case string:
+			strs++
+		default:
+			return Zone{}
+		}
+		if numbers > 0 && strs > 0 {
+			return Zone{}
+		}
+		if z.Min == nil || zoneLess(v, z.Min) {
+			z.Min = v
+		}
+		if z.Max == nil || zoneLess(z.Max, v) {
+			z.Max = v
+		}
+	}
+	return z
+}
+
+// zoneLess orders two numbers, exactly when both are integers, or two
+// strings.
+func zoneLess(a, b interface{}) bool {
+	if as, ok := a.(string); ok {
+		return as < b.(string)
+	}
+	ai, aInt := a.(int64)
+	bi, bInt := b.(int64)
+	if aInt && bInt {
+		return ai < bi
+	}
+	return zoneFloat(a) < zoneFloat(b)
+}
+
+func zoneFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+func countNulls(raws []json.RawMessage) int {
+	n := 0
+	for _, r := range raws {
+		if string(r) == "null" {
+			n++
+		}
+	}
+	return n
+}
+
+// decodeValue decodes one stored value the way decodeRow does.
+func decodeValue(raw json.RawMessage) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return fromJSONNumber(v), nil
+}
+
+// readColumns reads the chunks of the given columns of seg, or of all its
+// columns when columns is nil, and returns each column's values in row
+// order.
+func (ct *ColumnarTable) readColumns(seg *segmentMeta, columns []string) (map[string][]interface{}, error) {
+	if columns == nil {
+		for col := range seg.Columns {
+			columns = append(columns, col)
+		}
+	}
+	path := filepath.Join(ct.dir, seg.File)
+	values := map[string][]interface{}{}
+	for _, col := range columns {
+		meta, ok := seg.Columns[col]
+		if !ok {
+			continue
+		}
+		data := make([]byte, meta.Length)
+		if err := SharedBuffers.readAt(path, data, meta.Offset); err != nil {
+			return nil, fmt.Errorf("failed to read column %s of segment %s: %w", col, path, err)
+		}
+		var chunk columnChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode column %s of segment %s: %w", col, path, err)
+		}
+		vals, err := decodeChunk(&chunk, meta.Encoding, seg.Rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode column %s of segment %s: %w", col, path, err)
+		}
+		values[col] = vals
+	}
+	return values, nil
+}
+
+// decodeChunk returns the values of the rows of a chunk. Values that
+// decode to maps or slices are decoded once per row rather than shared, as
+// rows may be changed in place.
+func decodeChunk(chunk *columnChunk, encoding string, rows int) ([]interface{}, error) {
+	decoded := make([]interface{}, len(chunk.Values))
+	shared := make([]bool, len(chunk.Values))
+	for i, raw := range chunk.Values {
+		v, err := decodeValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		decoded[i] = v
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			shared[i] = true
+		}
+	}
+	value := func(i int) interface{} {
+		if shared[i] {
+			return decoded[i]
+		}
+		v, _ := decodeValue(chunk.Values[i])
+		return v
+	}
+
+	vals := make([]interface{}, 0, rows)
+	switch encoding {
+	case encodingPlain:
+		for i := range chunk.Values {
+			vals = append(vals, value(i))
+		}
+	case encodingDict:
+		for _, code := range chunk.Codes {
+			if code < 0 || code >= len(decoded) {
+				return nil, fmt.Errorf("dictionary code %d out of range", code)
+			}
+			vals = append(vals, value(code))
+		}
+	case encodingRLE:
+		if len(chunk.Runs) != len(chunk.Values) {
+			return nil, fmt.Errorf("%d runs for %d values", len(chunk.Runs), len(chunk.Values))
+		}
+		for i, n := range chunk.Runs {
+			for j := 0; j < n; j++ {
+				vals = append(vals, value(i))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+	if len(vals) != rows {
+		return nil, fmt.Errorf("%d values for %d rows", len(vals), rows)
+	}
+	return vals, nil
+}
+
+// Scan returns a *ColumnReader that reads only the columns opts lists and
+// skips the segments it rules out. The rows of the delta file are always
+// read.
+func (ct *ColumnarTable) Scan(m *Morsel, opts ScanOptions) (RowReader, error) {
+	ct.mu.RLock()
+	man, err := ct.readManifest()
+	if err != nil {
+		ct.mu.RUnlock()
+		return nil, err
+	}
+	r := &ColumnReader{ct: ct, opts: opts, segments: man.Segments, delta: ct.delta(man), end: len(man.Segments) + 1}
+	if opts.Columns != nil {
+		r.keep = map[string]bool{}
+		for _, c := range opts.Columns {
+			r.keep[c] = true
+		}
+	}
+	if m != nil {
+		r.next, r.end = int(m.Start), int(m.End)
+	}
+	return r, nil
+}
+
+// ColumnReader reads the rows of a columnar table segment by segment,
+// then those of its delta file.
+type ColumnReader struct {
+	ct       *ColumnarTable
+	opts     ScanOptions
+	keep     map[string]bool
+	segments []*segmentMeta
+	delta    *TableFile
+	next     int // the segment read next; len(segments) is the delta file
+	end      int
+	line     int
+	values   map[string][]interface{}
+	pos, n   int
+	rows     RowReader // of the delta file
+	closed   bool
+
+	read, skipped int
+}
+
+func (r *ColumnReader) Next() (Row, int, error) {
+	for {
+		if r.closed {
+			return nil, 0, nil
+		}
+		if r.pos < r.n {
+			row := Row{}
+			for col, vals := range r.values {
+				row[col] = vals[r.pos]
+			}
+			r.pos++
+			line := r.line
+			r.line++
+			return row, line, nil
+		}
+		if r.rows != nil {
+			row, line, err := r.rows.Next()
+			if row == nil || err != nil {
+				return nil, 0, err
+			}
+			if r.keep != nil {
+				for k := range row {
+					if !r.keep[k] {
+						delete(row, k)
+					}
+				}
+			}
+			return row, r.line + line, nil
+		}
+		if r.next >= r.end {
+			return nil, 0, nil
+		}
+		i := r.next
+		r.next++
+		if i == len(r.segments) {
+			rows, err := r.delta.Rows()
+			if err != nil {
+				return nil, 0, err
+			}
+			r.rows = rows
+			continue
+		}
+		seg := r.segments[i]
+		if r.opts.Skip != nil && r.skip(seg) {
+			r.skipped++
+			r.line += seg.Rows
+			continue
+		}
+		values, err := r.ct.readColumns(seg, r.opts.Columns)
+		if err != nil {
+			return nil, 0, err
+		}
+		r.read++
+		r.values, r.pos, r.n = values, 0, seg.Rows
+	}
+}
+
+func (r *ColumnReader) skip(seg *segmentMeta) bool {
+	zones := make(map[string]Zone, len(seg.Columns))
+	for col, c := range seg.Columns {
+		zones[col] = c.Zone
+	}
+	return r.opts.Skip(zones)
+}
+
+// Segments returns the number of segments the reader has read and the
+// number it skipped by their zone maps.
+func (r *ColumnReader) Segments() (read, skipped int) { return r.read, r.skipped }
+
+// Close releases the read lock.
+func (r *ColumnReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	defer r.ct.mu.RUnlock()
+	r.values = nil
+	if r.rows != nil {
+		return r.rows.Close()
+	}
+	return nil
+}
+
+// Morsels returns a morsel per segment, and one for the delta file. The
+// size of a morsel is that of a segment, whatever size asks for.
+func (ct *ColumnarTable) Morsels(size int64) ([]Morsel, error) {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+	m, err := ct.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	morsels := make([]Morsel, 0, len(m.Segments)+1)
+	for i := range m.Segments {
+		morsels = append(morsels, Morsel{Start: int64(i), End: int64(i + 1)})
+	}
+	if info, err := os.Stat(ct.delta(m).path); err == nil && info.Size() > 0 {
+		morsels = append(morsels, Morsel{Start: int64(len(m.Segments)), End: int64(len(m.Segments) + 1)})
+	}
+	return morsels, nil
+}
+
+// readLinesNoLock returns every row of the table and its line.
+func (ct *ColumnarTable) readLinesNoLock() ([]Row, []int, error) {
+	rows, lines := []Row{}, []int{}
+	err := ct.scanNoLock(func(line int, row Row) error {
+		rows, lines = append(rows, row), append(lines, line)
+		return nil
+	})
+	return rows, lines, err
+}
+
+func (ct *ColumnarTable) scanNoLock(fn func(line int, row Row) error) error {
+	m, err := ct.readManifest()
+	if err != nil {
+		return err
+	}
+	line := 0
+	for _, seg := range m.Segments {
+		values, err := ct.readColumns(seg, nil)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < seg.Rows; i++ {
+			row := Row{}
+			for col, vals := range values {
+				row[col] = vals[i]
+			}
+			if err := fn(line, row); err != nil {
+				return err
+			}
+			line++
+		}
+	}
+	return ct.delta(m).scanLines(func(l int, data []byte) (bool, error) {
+		var row Row
+		if err := decodeRow(data, &row); err != nil {
+			return true, nil
+		}
+		return true, fn(line+l, row)
+	})
+}
+
+// ReadRowsAt returns the rows with the given lines, in line order. Only
+// the segments holding them are read.
+func (ct *ColumnarTable) ReadRowsAt(lines []int) ([]Row, error) {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+	rows := []Row{}
+	if len(lines) == 0 {
+		return rows, nil
+	}
+	m, err := ct.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	want := append([]int(nil), lines...)
+	sort.Ints(want)
+	start := 0
+	for _, seg := range m.Segments {
+		end := start + seg.Rows
+		var values map[string][]interface{}
+		for len(want) > 0 && want[0] < end {
+			if want[0] >= start {
+				if values == nil {
+					if values, err = ct.readColumns(seg, nil); err != nil {
+						return nil, err
+					}
+				}
+				row := Row{}
+				for col, vals := range values {
+					row[col] = vals[want[0]-start]
+				}
+				rows = append(rows, row)
+			}
+			want = want[1:]
+		}
+		start = end
+	}
+	if len(want) == 0 {
+		return rows, nil
+	}
+	delta := map[int]bool{}
+	for _, l := range want {
+		delta[l-start] = true
+	}
+	err = ct.delta(m).scanLines(func(line int, data []byte) (bool, error) {
+		if !delta[line] {
+			return true, nil
+		}
+		delete(delta, line)
+		var row Row
+		if err := decodeRow(data, &row); err == nil {
+			rows = append(rows, row)
+		}
+		return len(delta) > 0, nil
+	})
+	return rows, err
+}
+
+// Update writes every row to new segments when fn changes one.
+func (ct *ColumnarTable) Update(fn func(line int, row Row) (bool, error)) (int, error) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	rows, lines, err := ct.readLinesNoLock()
+	if err != nil {
+		return 0, err
+	}
+	changed, err := updateRows(rows, lines, fn)
+	if err != nil || changed == 0 {
+		return 0, err
+	}
+	if err := ct.rewrite(rows); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// Delete writes the rows fn keeps to new segments.
+func (ct *ColumnarTable) Delete(fn func(row Row) (bool, error)) ([]Row, error) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	rows, _, err := ct.readLinesNoLock()
+	if err != nil {
+		return nil, err
+	}
+	kept, deleted, err := deleteRows(rows, fn)
+	if err != nil {
+		return nil, err
+	}
+	if err := ct.rewrite(kept); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// Truncate replaces the segments and delta file by none.
+func (ct *ColumnarTable) Truncate() error {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.rewrite([]Row{})
+}
+
+// Stats counts the rows of the segments and the lines of the delta file.
+func (ct *ColumnarTable) Stats() (Stats, error) {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+	m, err := ct.readManifest()
+	if err != nil {
+		return Stats{}, err
+	}
+	st := Stats{Rows: segmentRows(m)}
+	entries, err := os.ReadDir(ct.dir)
+	if err != nil {
+		return st, fmt.Errorf("failed to read %s: %w", ct.dir, err)
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			st.Bytes += info.Size()
+		}
+	}
+	err = ct.delta(m).scanLines(func(line int, data []byte) (bool, error) {
+		st.Rows++
+		return true, nil
+	})
+	return st, err
+}
+
+// rewrite replaces the rows of the table with rows, in new segments.
+func (ct *ColumnarTable) rewrite(rows []Row) error {
+	if rows == nil {
+		return fmt.Errorf("rows slice cannot be nil")
+	}
+	old, err := ct.readManifest()
+	if err != nil {
+		return err
+	}
+	m := &manifest{Segments: []*segmentMeta{}, Next: old.Next}
+	for start := 0; start < len(rows); start += SegmentRows {
+		end := start + SegmentRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		seg, err := ct.writeSegment(m, rows[start:end])
+		if err != nil {
+			removeSegments(ct.dir, m.Segments)
+			return err
+		}
+		m.Segments = append(m.Segments, seg)
+	}
+	m.Delta = fmt.Sprintf("delta-%d.dat", m.Next)
+	m.Next++
+	if err := ct.writeManifest(m); err != nil {
+		removeSegments(ct.dir, m.Segments)
+		return err
+	}
+	removeSegments(ct.dir, old.Segments)
+	SharedBuffers.drop(ct.delta(old).path)
+	os.Remove(ct.delta(old).path)
+	ct.delta(m).invalidateIndexes()
+	return nil
+}
+
+func removeSegments(dir string, segments []*segmentMeta) {
+	for _, s := range segments {
+		SharedBuffers.drop(filepath.Join(dir, s.File))
+		os.Remove(filepath.Join(dir, s.File))
+	}
+}
+
+// Stamp identifies the current contents of the table by its manifest and
+// the size of its delta file.
+func (ct *ColumnarTable) Stamp() (string, error) {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+	m, err := ct.readManifest()
+	if err != nil {
+		return "", err
+	}
+	stamp := fmt.Sprintf("%s:%d", m.Delta, m.Next)
+	info, err := os.Stat(ct.delta(m).path)
+	if err == nil {
+		stamp += fmt.Sprintf(":%d:%d", info.Size(), info.ModTime().UnixNano())
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to stat delta of %s: %w", ct.dir, err)
+	}
+	return stamp, nil
+}
+
+// DeleteFile removes the table's directory and its indexes.
+func (ct *ColumnarTable) DeleteFile() error {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	(&TableFile{indexDir: ct.indexDir}).invalidateIndexes()
+	SharedBuffers.drop(ct.dir)
+	if err := os.RemoveAll(ct.dir); err != nil {
+		return fmt.Errorf("failed to delete columnar table %s: %w", ct.dir, err)
+	}
+	return nil
+}
+
+func (ct *ColumnarTable) IndexPath(index string) string {
+	return filepath.Join(ct.indexDir, index+".idx")
+}
+
+func (ct *ColumnarTable) RemoveIndex(index string) error {
+	if err := os.Remove(ct.IndexPath(index)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove index file: %w", err)
+	}
+	return nil
+}
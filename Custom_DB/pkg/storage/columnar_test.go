@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// setTestSegmentRows makes the segments of columnar tables n rows long for
+// the test.
+func setTestSegmentRows(t *testing.T, n int) {
+	t.Helper()
+	old := SegmentRows
+	SegmentRows = n
+	t.Cleanup(func() { SegmentRows = old })
+}
+
+// columnarRows are rows of every kind of value a column holds, with NULLs,
+// a column whose type changes from row to row and one some rows lack.
+func columnarRows() []Row {
+	scores := []interface{}{int64(3), 2.5, "high", nil, true, int64(-7), 1e300, "", int64(0), 0.25}
+	rows := make([]Row, 0, len(scores))
+	for i, score := range scores {
+		row := Row{"id": int64(i), "score": score, "name": nil, "flag": i%3 == 0}
+		if i%2 == 0 {
+			row["name"] = string(rune('a' + i))
+		}
+		if i%4 == 1 {
+			row["doc"] = map[string]interface{}{"n": int64(i), "tags": []interface{}{"x", int64(i), nil}}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestColumnarTable_RoundTrip(t *testing.T) {
+	setTestSegmentRows(t, 4)
+	ct, err := NewColumnarTable(t.TempDir(), "mixed")
+	if err != nil {
+		t.Fatalf("new table: %v", err)
+	}
+	want := columnarRows()
+	for i, row := range want {
+		line, err := ct.Insert(row)
+		if err != nil || line != i {
+			t.Fatalf("insert %d: line %d, %v", i, line, err)
+		}
+	}
+
+	// two full segments and a delta file of two rows
+	if morsels, err := ct.Morsels(1); err != nil || len(morsels) != 3 {
+		t.Fatalf("morsels %v, %v", morsels, err)
+	}
+	if st, err := ct.Stats(); err != nil || st.Rows != len(want) {
+		t.Fatalf("stats %+v, %v", st, err)
+	}
+	got, err := ReadAll(ct)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("read %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		// a column a row lacks reads as NULL from a segment
+		if _, ok := got[i]["doc"]; ok && want[i]["doc"] == nil {
+			want[i]["doc"] = nil
+		}
+		if !reflect.DeepEqual(got[i], want[i]) {
+			g, _ := json.Marshal(got[i])
+			w, _ := json.Marshal(want[i])
+			t.Errorf("row %d: got %s (%#v), want %s", i, g, got[i]["score"], w)
+		}
+	}
+
+	at, err := ct.ReadRowsAt([]int{1, 6, 9})
+	if err != nil || len(at) != 3 || at[0]["id"] != int64(1) || at[1]["id"] != int64(6) || at[2]["id"] != int64(9) {
+		t.Fatalf("ReadRowsAt: %v, %v", at, err)
+	}
+
+	// a scan of some columns skips the segments whose zones rule them out
+	r, err := ct.Scan(nil, ScanOptions{Columns: []string{"id", "name"}, Skip: func(zones map[string]Zone) bool {
+		max, ok := zones["id"].Max.(int64)
+		return ok && max < 4
+	}})
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	defer r.Close()
+	var ids []interface{}
+	for {
+		row, line, err := r.Next()
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		if row == nil {
+			break
+		}
+		if len(row) != 2 || row["id"] != int64(line) {
+			t.Errorf("line %d: got %v", line, row)
+		}
+		ids = append(ids, row["id"])
+	}
+	if read, skipped := r.(*ColumnReader).Segments(); read != 1 || skipped != 1 || len(ids) != 6 {
+		t.Errorf("read %d segments and skipped %d for ids %v", read, skipped, ids)
+	}
+}
@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"Custom_DB/pkg/schema"
+)
+
+// An in-memory table keeps its rows in the memory of the process, each
+// encoded as the line a data file would hold, so its values read back as
+// they would from disk. The rows are found by the database's path and the
+// table's name, so every open of the table sees them until it is dropped,
+// its database is closed or the process exits. Its indexes are still kept
+// in files.
+
+// memoryTables holds the rows of every in-memory table, by the path
+// NewMemoryTable was given and the table's name.
+var memoryTables = struct {
+	sync.Mutex
+	m map[string]*memoryRows
+}{m: map[string]*memoryRows{}}
+
+func init() {
+	schema.OnClose(dropMemoryTables)
+}
+
+// dropMemoryTables forgets the rows of every in-memory table of the
+// database at dbPath, as when it is closed.
+func dropMemoryTables(dbPath string) {
+	prefix := filepath.Clean(dbPath) + string(filepath.Separator)
+	memoryTables.Lock()
+	defer memoryTables.Unlock()
+	for key := range memoryTables.m {
+		if strings.HasPrefix(key, prefix) {
+			delete(memoryTables.m, key)
+		}
+	}
+}
+
+// memoryRows is the rows of an in-memory table. The lines slice is only
+// ever appended to or replaced, so a reader may keep reading the lines it
+// was given without the lock. version counts the changes.
+type memoryRows struct {
+	mu      sync.RWMutex
+	lines   [][]byte
+	version int
+}
+
+// MemoryTable is a table whose rows are kept in memory.
+type MemoryTable struct {
+	key      string
+	rows     *memoryRows
+	indexDir string
+}
+
+// NewMemoryTable opens the in-memory table tableName of the database at
+// dbPath, creating it empty if it does not exist.
+func NewMemoryTable(dbPath, tableName string) (*MemoryTable, error) {
+	if dbPath == "" || tableName == "" {
+		return nil, fmt.Errorf("invalid parameters: dbPath and tableName cannot be empty")
+	}
+	key := filepath.Join(dbPath, tableName)
+	memoryTables.Lock()
+	defer memoryTables.Unlock()
+	rows, ok := memoryTables.m[key]
+	if !ok {
+		rows = &memoryRows{}
+		memoryTables.m[key] = rows
+	}
+	return &MemoryTable{key: key, rows: rows, indexDir: filepath.Join(dbPath, "indexes", tableName)}, nil
+}
+
+func (mt *MemoryTable) invalidateIndexes() {
+	(&TableFile{indexDir: mt.indexDir}).invalidateIndexes()
+}
+
+func (mt *MemoryTable) Insert(row Row) (int, error) {
+	if row == nil {
+		return 0, fmt.Errorf("cannot append nil row")
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal row to JSON: %w", err)
+	}
+	mt.rows.mu.Lock()
+	defer mt.rows.mu.Unlock()
+	mt.invalidateIndexes()
+	mt.rows.lines = append(mt.rows.lines, data)
+	mt.rows.version++
+	return len(mt.rows.lines) - 1, nil
+}
+
+// snapshot returns the current lines.
+func (mt *MemoryTable) snapshot() [][]byte {
+	mt.rows.mu.RLock()
+	defer mt.rows.mu.RUnlock()
+	return mt.rows.lines
+}
+
+// Scan reads every column of every row whatever opts hint.
+func (mt *MemoryTable) Scan(m *Morsel, opts ScanOptions) (RowReader, error) {
+	lines := mt.snapshot()
+	r := &memoryReader{lines: lines, end: len(lines)}
+	if m != nil {
+		r.start, r.pos, r.end = int(m.Start), int(m.Start), int(m.End)
+		if r.end > len(lines) {
+			r.end = len(lines)
+		}
+	}
+	return r, nil
+}
+
+// memoryReader reads the lines of an in-memory table from start to end;
+// line numbers count from start.
+type memoryReader struct {
+	lines           [][]byte
+	start, pos, end int
+}
+
+func (r *memoryReader) Next() (Row, int, error) {
+	for r.pos < r.end {
+		line := r.pos - r.start
+		data := r.lines[r.pos]
+		r.pos++
+		var row Row
+		if err := decodeRow(data, &row); err != nil {
+			continue
+		}
+		return row, line, nil
+	}
+	return nil, 0, nil
+}
+
+func (r *memoryReader) Close() error {
+	r.pos = r.end
+	return nil
+}
+
+// Morsels splits the rows into runs of about size bytes of lines.
+func (mt *MemoryTable) Morsels(size int64) ([]Morsel, error) {
+	lines := mt.snapshot()
+	morsels := []Morsel{}
+	start, bytes := 0, int64(0)
+	for i, l := range lines {
+		bytes += int64(len(l)) + 1
+		if bytes >= size || i == len(lines)-1 {
+			morsels = append(morsels, Morsel{Start: int64(start), End: int64(i + 1)})
+			start, bytes = i+1, 0
+		}
+	}
+	return morsels, nil
+}
+
+// decodeAllNoLock decodes every line, with its line number.
+func (mt *MemoryTable) decodeAllNoLock() ([]Row, []int) {
+	rows, lines := []Row{}, []int{}
+	for i, data := range mt.rows.lines {
+		var row Row
+		if err := decodeRow(data, &row); err != nil {
+			continue
+		}
+		rows, lines = append(rows, row), append(lines, i)
+	}
+	return rows, lines
+}
+
+// replaceNoLock makes rows the rows of the table.
+func (mt *MemoryTable) replaceNoLock(rows []Row) error {
+	lines := make([][]byte, len(rows))
+	for i, row := range rows {
+		data, err := json.Marshal(row)
+		if err != nil {
+			return fmt.Errorf("failed to marshal row to JSON: %w", err)
+		}
+		lines[i] = data
+	}
+	mt.rows.lines = lines
+	mt.rows.version++
+	mt.invalidateIndexes()
+	return nil
+}
+
+func (mt *MemoryTable) Update(fn func(line int, row Row) (bool, error)) (int, error) {
+	mt.rows.mu.Lock()
+	defer mt.rows.mu.Unlock()
+	rows, lines := mt.decodeAllNoLock()
+	changed, err := updateRows(rows, lines, fn)
+	if err != nil || changed == 0 {
+		return 0, err
+	}
+	if err := mt.replaceNoLock(rows); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+func (mt *MemoryTable) Delete(fn func(row Row) (bool, error)) ([]Row, error) {
+	mt.rows.mu.Lock()
+	defer mt.rows.mu.Unlock()
+	rows, _ := mt.decodeAllNoLock()
+	kept, deleted, err := deleteRows(rows, fn)
+	if err != nil {
+		return nil, err
+	}
+	if err := mt.replaceNoLock(kept); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (mt *MemoryTable) Truncate() error {
+	mt.rows.mu.Lock()
+	defer mt.rows.mu.Unlock()
+	return mt.replaceNoLock([]Row{})
+}
+
+func (mt *MemoryTable) Stats() (Stats, error) {
+	lines := mt.snapshot()
+	st := Stats{Rows: len(lines)}
+	for _, l := range lines {
+		st.Bytes += int64(len(l)) + 1
+	}
+	return st, nil
+}
+
+func (mt *MemoryTable) ReadRowsAt(lines []int) ([]Row, error) {
+	all := mt.snapshot()
+	want := append([]int(nil), lines...)
+	sort.Ints(want)
+	rows := []Row{}
+	for i, l := range want {
+		if l < 0 || l >= len(all) || (i > 0 && l == want[i-1]) {
+			continue
+		}
+		var row Row
+		if err := decodeRow(all[l], &row); err == nil {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// Stamp identifies the rows by the number of changes made to them.
+func (mt *MemoryTable) Stamp() (string, error) {
+	mt.rows.mu.RLock()
+	defer mt.rows.mu.RUnlock()
+	return fmt.Sprintf("memory:%p:%d", mt.rows, mt.rows.version), nil
+}
+
+// DeleteFile forgets the rows of the table and removes its indexes.
+func (mt *MemoryTable) DeleteFile() error {
+	memoryTables.Lock()
+	defer memoryTables.Unlock()
+	if memoryTables.m[mt.key] == mt.rows {
+		delete(memoryTables.m, mt.key)
+	}
+	mt.invalidateIndexes()
+	return nil
+}
+
+func (mt *MemoryTable) IndexPath(index string) string {
+	return filepath.Join(mt.indexDir, index+".idx")
+}
+
+func (mt *MemoryTable) RemoveIndex(index string) error {
+	if err := os.Remove(mt.IndexPath(index)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove index file: %w", err)
+	}
+	return nil
+}
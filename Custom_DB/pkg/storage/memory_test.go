@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMemoryTable_MorselLines checks that the lines a morsel's rows are read
+// with count from the start of the morsel, as for a data file, so a morsel's
+// start plus a line is the line a full scan gives the row.
+func TestMemoryTable_MorselLines(t *testing.T) {
+	dir := t.TempDir()
+	mt, err := NewMemoryTable(dir, "runs")
+	if err != nil {
+		t.Fatalf("new table: %v", err)
+	}
+	defer mt.DeleteFile()
+	for i := 0; i < 25; i++ {
+		line, err := mt.Insert(Row{"id": int64(i), "pad": strings.Repeat("x", i*7)})
+		if err != nil || line != i {
+			t.Fatalf("insert %d: line %d, %v", i, line, err)
+		}
+	}
+
+	morsels, err := mt.Morsels(200)
+	if err != nil || len(morsels) < 3 {
+		t.Fatalf("morsels %v, %v", morsels, err)
+	}
+	next := int64(0)
+	for _, m := range morsels {
+		if m.Start != next || m.End <= m.Start {
+			t.Fatalf("morsels leave a gap or overlap: %v", morsels)
+		}
+		next = m.End
+		r, err := mt.Scan(&m, ScanOptions{})
+		if err != nil {
+			t.Fatalf("scan %v: %v", m, err)
+		}
+		want := 0
+		for {
+			row, line, err := r.Next()
+			if err != nil {
+				t.Fatalf("next: %v", err)
+			}
+			if row == nil {
+				break
+			}
+			if line != want || row["id"] != m.Start+int64(line) {
+				t.Errorf("morsel %v: row %v at line %d, want line %d", m, row["id"], line, want)
+			}
+			want++
+		}
+		r.Close()
+		if want != int(m.End-m.Start) {
+			t.Errorf("morsel %v: read %d rows", m, want)
+		}
+	}
+	if next != 25 {
+		t.Errorf("morsels end at %d of 25 rows", next)
+	}
+
+	// the rows are kept by the table's path, so opening it again sees them,
+	// and deleting a row moves the lines of the rows after it up
+	again, err := NewMemoryTable(dir, "runs")
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := again.Delete(func(row Row) (bool, error) { return row["id"] == int64(3), nil }); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	rows, err := mt.ReadRowsAt([]int{3, 23})
+	if err != nil || len(rows) != 2 || rows[0]["id"] != int64(4) || rows[1]["id"] != int64(24) {
+		t.Fatalf("rows at 3 and 23 after the delete: %v, %v", rows, err)
+	}
+	other, err := NewMemoryTable(filepath.Join(dir, "other"), "runs")
+	if err != nil {
+		t.Fatalf("new table: %v", err)
+	}
+	defer other.DeleteFile()
+	if rows, err := ReadAll(other); err != nil || len(rows) != 0 {
+		t.Errorf("a table of another database has rows %v, %v", rows, err)
+	}
+}
@@ -2,8 +2,10 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -16,8 +18,9 @@ type RowID int
 type Row map[string]interface{}
 
 type TableFile struct {
-	path string
-	mu   sync.RWMutex
+	path     string
+	indexDir string
+	mu       sync.RWMutex
 }
 
 func NewTableFile(dbPath, tableName string) (*TableFile, error) {
@@ -40,48 +43,40 @@ func NewTableFile(dbPath, tableName string) (*TableFile, error) {
 	defer file.Close()
 
 	return &TableFile{
-		path: path,
-		mu:   sync.RWMutex{},
+		path:     path,
+		indexDir: filepath.Join(dbPath, "indexes", tableName),
+		mu:       sync.RWMutex{},
 	}, nil
 }
 
 func (tf *TableFile) AppendRow(row Row) error {
+	_, err := tf.appendRow(row)
+	return err
+}
+
+// appendRow appends row and returns its line.
+func (tf *TableFile) appendRow(row Row) (int, error) {
 	if row == nil {
-		return fmt.Errorf("cannot append nil row")
+		return 0, fmt.Errorf("cannot append nil row")
 	}
 
 	tf.mu.Lock()
 	defer tf.mu.Unlock()
 
-	// Validate row data
-	for col, val := range row {
-		if val == nil {
-			row[col] = "NULL" // Convert nil to "NULL" string
-		}
-	}
-
-	file, err := os.OpenFile(tf.path, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open file %s for appending: %w", tf.path, err)
-	}
-	defer file.Close()
-
+	// nil values are stored as JSON null
 	data, err := json.Marshal(row)
 	if err != nil {
-		return fmt.Errorf("failed to marshal row to JSON: %w", err)
+		return 0, fmt.Errorf("failed to marshal row to JSON: %w", err)
 	}
+	tf.invalidateIndexes()
 
-	// Use buffered writer for better performance
-	writer := bufio.NewWriter(file)
-	if _, err := writer.WriteString(string(data) + "\n"); err != nil {
-		return fmt.Errorf("failed to write row to buffer for file %s: %w", tf.path, err)
-	}
-
-	if err := writer.Flush(); err != nil {
-		return fmt.Errorf("failed to flush row to file %s: %w", tf.path, err)
+	// the row is written to the last pages of the file in the buffer pool,
+	// which are written back before the append returns
+	line, err := SharedBuffers.append(tf.path, append(data, '\n'))
+	if err != nil {
+		return 0, fmt.Errorf("failed to write row to file %s: %w", tf.path, err)
 	}
-
-	return nil
+	return line, nil
 }
 
 func (tf *TableFile) ReadAllRows() ([]Row, error) {
@@ -91,7 +86,7 @@ func (tf *TableFile) ReadAllRows() ([]Row, error) {
 }
 
 func (tf *TableFile) readAllRowsNoLock() ([]Row, error) {
-	file, err := os.OpenFile(tf.path, os.O_RDONLY, 0644)
+	file, err := SharedBuffers.reader(tf.path, 0)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return []Row{}, nil
@@ -104,7 +99,7 @@ func (tf *TableFile) readAllRowsNoLock() ([]Row, error) {
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		var row Row
-		if err := json.Unmarshal(scanner.Bytes(), &row); err == nil {
+		if err := decodeRow(scanner.Bytes(), &row); err == nil {
 			rows = append(rows, row)
 		} else {
 			fmt.Fprintf(os.Stderr, "Warning: Failed to decode JSON row from %s: %s\n", tf.path, err)
@@ -116,6 +111,54 @@ func (tf *TableFile) readAllRowsNoLock() ([]Row, error) {
 	return rows, nil
 }
 
+// decodeRow decodes one stored row. Whole JSON numbers that fit in 64 bits
+// are read as int64 rather than float64, so large BIGINT values keep every
+// digit; other numbers of a column are float64 as usual, while those nested
+// in arrays and objects stay json.Number, so a JSON document keeps numbers
+// no float64 holds exactly.
+func decodeRow(data []byte, row *Row) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(row); err != nil {
+		return err
+	}
+	for k, v := range *row {
+		(*row)[k] = fromJSONNumber(v)
+	}
+	return nil
+}
+
+// fromJSONNumber replaces the json.Number value v by int64 or float64, and
+// the json.Number values inside arrays and objects that fit int64 by int64.
+func fromJSONNumber(v interface{}) interface{} {
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	}
+	return fromNestedJSONNumber(v)
+}
+
+func fromNestedJSONNumber(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = fromNestedJSONNumber(t[i])
+		}
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = fromNestedJSONNumber(t[k])
+		}
+	}
+	return v
+}
+
 // --- Helper: normalize values for comparison ---
 
 // Enhanced normalization: returns value, and also string representation for fallback comparison
@@ -244,6 +287,8 @@ func (tf *TableFile) DeleteRows(whereCol string, whereVal interface{}) (int, err
 	if err := os.Rename(tmpPath, tf.path); err != nil {
 		return 0, fmt.Errorf("failed to replace original file with new data: %w", err)
 	}
+	SharedBuffers.drop(tf.path)
+	tf.invalidateIndexes()
 
 	return deletedCount, nil
 }
@@ -270,13 +315,6 @@ func (tf *TableFile) rewriteFile(rows []Row) error {
 
 	// Write all rows to the temporary file
 	for _, row := range rows {
-		// Validate row data
-		for col, val := range row {
-			if val == nil {
-				row[col] = "NULL"
-			}
-		}
-
 		data, err := json.Marshal(row)
 		if err != nil {
 			return fmt.Errorf("failed to marshal row to JSON during rewrite: %w", err)
@@ -304,6 +342,8 @@ func (tf *TableFile) rewriteFile(rows []Row) error {
 	if err := os.Rename(tmpPath, tf.path); err != nil {
 		return fmt.Errorf("failed to replace original file with temporary file: %w", err)
 	}
+	SharedBuffers.drop(tf.path)
+	tf.invalidateIndexes()
 
 	return nil
 }
@@ -315,10 +355,21 @@ func (tf *TableFile) RewriteFile(rows []Row) error {
 	return tf.rewriteFile(rows)
 }
 
+// LegacyNull is the string older versions stored in place of NULL.
+const LegacyNull = "NULL"
+
+// MigrateLegacyNulls replaces the LegacyNull strings in the file by NULL
+// and returns how many it replaced; see MigrateLegacyNulls.
+func (tf *TableFile) MigrateLegacyNulls() (int, error) {
+	return MigrateLegacyNulls(tf)
+}
+
 func (tf *TableFile) DeleteFile() error {
 	tf.mu.Lock()
 	defer tf.mu.Unlock()
 
+	tf.invalidateIndexes()
+	SharedBuffers.drop(tf.path)
 	if err := os.Remove(tf.path); err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -327,3 +378,351 @@ func (tf *TableFile) DeleteFile() error {
 	}
 	return nil
 }
+
+// IndexPath returns the file the named expression index of the table is
+// kept in.
+func (tf *TableFile) IndexPath(index string) string {
+	return filepath.Join(tf.indexDir, index+".idx")
+}
+
+// RemoveIndex deletes the file of the named index, if there is one.
+func (tf *TableFile) RemoveIndex(index string) error {
+	if err := os.Remove(tf.IndexPath(index)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove index file: %w", err)
+	}
+	return nil
+}
+
+func (tf *TableFile) invalidateIndexes() {
+	if err := os.RemoveAll(tf.indexDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to remove stale indexes in %s: %s\n", tf.indexDir, err)
+	}
+}
+
+// Stamp identifies the current contents of the data file by its size and
+// modification time, so an index can tell whether it was built from them.
+func (tf *TableFile) Stamp() (string, error) {
+	info, err := os.Stat(tf.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", tf.path, err)
+	}
+	return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// ScanRows calls fn for each row with its line number in the data file.
+// Lines that do not decode are skipped but still counted, so the numbers
+// match ReadRowsAt.
+func (tf *TableFile) ScanRows(fn func(line int, row Row) error) error {
+	tf.mu.RLock()
+	defer tf.mu.RUnlock()
+	return tf.scanLines(func(line int, data []byte) (bool, error) {
+		var row Row
+		if err := decodeRow(data, &row); err != nil {
+			return true, nil
+		}
+		return true, fn(line, row)
+	})
+}
+
+// ReadRowsAt returns the rows on the given line numbers, in file order.
+func (tf *TableFile) ReadRowsAt(lines []int) ([]Row, error) {
+	tf.mu.RLock()
+	defer tf.mu.RUnlock()
+	want := make(map[int]bool, len(lines))
+	for _, l := range lines {
+		want[l] = true
+	}
+	rows := []Row{}
+	if len(want) == 0 {
+		return rows, nil
+	}
+	err := tf.scanLines(func(line int, data []byte) (bool, error) {
+		if !want[line] {
+			return true, nil
+		}
+		delete(want, line)
+		var row Row
+		if err := decodeRow(data, &row); err == nil {
+			rows = append(rows, row)
+		}
+		return len(want) > 0, nil
+	})
+	return rows, err
+}
+
+// RowIterator reads the rows of a data file one at a time; see Rows.
+type RowIterator struct {
+	tf      *TableFile
+	file    *pageReader
+	scanner *bufio.Scanner
+	line    int
+	// set when reading a morsel: the reader, its offset in the file and
+	// the end of the morsel
+	reader   *bufio.Reader
+	pos, end int64
+}
+
+// Rows returns an iterator over the rows of the data file in file order, so
+// a table can be read without holding all of it in memory. Lines that do not
+// decode are skipped. The iterator holds the file's read lock until it is
+// closed.
+func (tf *TableFile) Rows() (RowReader, error) {
+	tf.mu.RLock()
+	it := &RowIterator{tf: tf}
+	file, err := SharedBuffers.reader(tf.path, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return it, nil
+		}
+		tf.mu.RUnlock()
+		return nil, fmt.Errorf("failed to open file %s for reading: %w", tf.path, err)
+	}
+	it.file = file
+	it.scanner = bufio.NewScanner(file)
+	return it, nil
+}
+
+// Next returns the next row and its line number in the data file, or a nil
+// row after the last one.
+func (it *RowIterator) Next() (Row, int, error) {
+	if it.reader != nil {
+		return it.nextInMorsel()
+	}
+	if it.scanner == nil {
+		return nil, 0, nil
+	}
+	for it.scanner.Scan() {
+		line := it.line
+		it.line++
+		var row Row
+		if err := decodeRow(it.scanner.Bytes(), &row); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to decode JSON row from %s: %s\n", it.tf.path, err)
+			continue
+		}
+		return row, line, nil
+	}
+	if err := it.scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading file %s: %w", it.tf.path, err)
+	}
+	return nil, 0, nil
+}
+
+// Morsel is a part of a table, the unit of work of a parallel scan: a byte
+// range of a data file, a run of the segments of a columnar table or of
+// the rows of an in-memory one; see Table.Morsels.
+type Morsel struct {
+	Start, End int64
+}
+
+// Morsels splits the data file into byte ranges of size bytes, the last
+// one shorter. RowsIn reads the rows whose lines start in a range, so each
+// row is in exactly one of them.
+func (tf *TableFile) Morsels(size int64) ([]Morsel, error) {
+	tf.mu.RLock()
+	defer tf.mu.RUnlock()
+	info, err := os.Stat(tf.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to stat %s: %w", tf.path, err)
+	}
+	morsels := []Morsel{}
+	for start := int64(0); start < info.Size(); start += size {
+		end := start + size
+		if end > info.Size() {
+			end = info.Size()
+		}
+		morsels = append(morsels, Morsel{Start: start, End: end})
+	}
+	return morsels, nil
+}
+
+// RowsIn returns an iterator over the rows whose lines start in morsel m,
+// like Rows, except that line numbers count from the start of the morsel.
+func (tf *TableFile) RowsIn(m Morsel) (RowReader, error) {
+	tf.mu.RLock()
+	it := &RowIterator{tf: tf}
+	// a line starts at m.Start only if the byte before it ends a line, so
+	// reading from there skips the line in progress, if any
+	it.pos, it.end = m.Start, m.End
+	if m.Start > 0 {
+		it.pos--
+	}
+	file, err := SharedBuffers.reader(tf.path, it.pos)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return it, nil
+		}
+		tf.mu.RUnlock()
+		return nil, fmt.Errorf("failed to open file %s for reading: %w", tf.path, err)
+	}
+	it.file = file
+	it.reader = bufio.NewReader(file)
+	if m.Start > 0 {
+		skipped, err := it.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			it.Close()
+			return nil, fmt.Errorf("error reading file %s: %w", tf.path, err)
+		}
+		it.pos += int64(len(skipped))
+	}
+	return it, nil
+}
+
+// nextInMorsel is Next for an iterator made by RowsIn.
+func (it *RowIterator) nextInMorsel() (Row, int, error) {
+	for it.pos < it.end {
+		data, err := it.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, 0, fmt.Errorf("error reading file %s: %w", it.tf.path, err)
+		}
+		if len(data) == 0 {
+			break
+		}
+		it.pos += int64(len(data))
+		line := it.line
+		it.line++
+		var row Row
+		if err := decodeRow(bytes.TrimRight(data, "\r\n"), &row); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to decode JSON row from %s: %s\n", it.tf.path, err)
+			continue
+		}
+		return row, line, nil
+	}
+	return nil, 0, nil
+}
+
+// Close closes the file and releases the read lock.
+func (it *RowIterator) Close() error {
+	if it.tf == nil {
+		return nil
+	}
+	defer it.tf.mu.RUnlock()
+	it.tf = nil
+	it.scanner = nil
+	it.reader = nil
+	if it.file != nil {
+		return it.file.Close()
+	}
+	return nil
+}
+
+// scanLines calls fn with each line of the data file and its number until
+// fn returns false.
+func (tf *TableFile) scanLines(fn func(line int, data []byte) (bool, error)) error {
+	file, err := SharedBuffers.reader(tf.path, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open file %s for reading: %w", tf.path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 0; scanner.Scan(); line++ {
+		more, err := fn(line, scanner.Bytes())
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading file %s: %w", tf.path, err)
+	}
+	return nil
+}
+
+// Insert is AppendRow, for Table, returning the line of the row.
+func (tf *TableFile) Insert(row Row) (int, error) { return tf.appendRow(row) }
+
+// Scan returns Rows, or RowsIn when m is not nil. Every column of every
+// row is read whatever opts hint.
+func (tf *TableFile) Scan(m *Morsel, opts ScanOptions) (RowReader, error) {
+	if m != nil {
+		return tf.RowsIn(*m)
+	}
+	return tf.Rows()
+}
+
+// readLinesNoLock returns the rows that decode and their line numbers.
+func (tf *TableFile) readLinesNoLock() ([]Row, []int, error) {
+	rows, lines := []Row{}, []int{}
+	err := tf.scanLines(func(line int, data []byte) (bool, error) {
+		var row Row
+		if err := decodeRow(data, &row); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to decode JSON row from %s: %s\n", tf.path, err)
+			return true, nil
+		}
+		rows, lines = append(rows, row), append(lines, line)
+		return true, nil
+	})
+	return rows, lines, err
+}
+
+// Update rewrites the data file when fn changes a row. Lines that do not
+// decode are dropped then.
+func (tf *TableFile) Update(fn func(line int, row Row) (bool, error)) (int, error) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	rows, lines, err := tf.readLinesNoLock()
+	if err != nil {
+		return 0, err
+	}
+	changed, err := updateRows(rows, lines, fn)
+	if err != nil || changed == 0 {
+		return 0, err
+	}
+	if err := tf.rewriteFile(rows); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// Delete rewrites the data file without the rows fn reports true for.
+func (tf *TableFile) Delete(fn func(row Row) (bool, error)) ([]Row, error) {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	rows, err := tf.readAllRowsNoLock()
+	if err != nil {
+		return nil, err
+	}
+	kept, deleted, err := deleteRows(rows, fn)
+	if err != nil {
+		return nil, err
+	}
+	if err := tf.rewriteFile(kept); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// Truncate empties the data file.
+func (tf *TableFile) Truncate() error {
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	return tf.rewriteFile([]Row{})
+}
+
+// Stats counts the lines of the data file.
+func (tf *TableFile) Stats() (Stats, error) {
+	tf.mu.RLock()
+	defer tf.mu.RUnlock()
+	st := Stats{}
+	info, err := os.Stat(tf.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return st, fmt.Errorf("failed to stat %s: %w", tf.path, err)
+	}
+	st.Bytes = info.Size()
+	err = tf.scanLines(func(line int, data []byte) (bool, error) {
+		st.Rows = line + 1
+		return true, nil
+	})
+	return st, err
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTableFile_NestedNumbersRoundTrip(t *testing.T) {
+	tf, err := NewTableFile(t.TempDir(), "docs")
+	if err != nil {
+		t.Fatalf("new table file: %v", err)
+	}
+	doc := `{"big":12345678901234567890,"exact":0.1000000000000000055511151231257827,"list":[18446744073709551615,7,1.5]}`
+	if _, err := tf.Insert(Row{"id": int64(9007199254740993), "doc": json.RawMessage(doc)}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	check := func(when string) {
+		t.Helper()
+		rows, err := tf.ReadAllRows()
+		if err != nil || len(rows) != 1 {
+			t.Fatalf("%s: read %d rows: %v", when, len(rows), err)
+		}
+		if id := rows[0]["id"]; id != int64(9007199254740993) {
+			t.Errorf("%s: id %v (%T)", when, id, id)
+		}
+		got, err := json.Marshal(rows[0]["doc"])
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", when, err)
+		}
+		if string(got) != doc {
+			t.Errorf("%s: got %s, want %s", when, got, doc)
+		}
+		list := rows[0]["doc"].(map[string]interface{})["list"].([]interface{})
+		if list[1] != int64(7) {
+			t.Errorf("%s: whole number read as %T", when, list[1])
+		}
+	}
+	check("after insert")
+
+	// rewriting the file writes back what was read
+	if _, err := tf.Update(func(line int, row Row) (bool, error) { row["id"] = int64(9007199254740993); return true, nil }); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	check("after update")
+}
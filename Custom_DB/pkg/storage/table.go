@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"fmt"
+
+	"Custom_DB/pkg/schema"
+)
+
+// Table is the stored rows of a table, whatever engine keeps them. Every
+// row has a line, a number that orders it among the stored rows. Lines
+// stay put until rows are updated or deleted, so indexes can point at rows
+// by them.
+type Table interface {
+	// Scan returns a reader of the rows of the table, or of morsel m when
+	// it is not nil. The options are hints: an engine may leave out other
+	// columns and the rows of the parts they rule out, but need not.
+	Scan(m *Morsel, opts ScanOptions) (RowReader, error)
+	// Insert adds row after the last row and returns its line.
+	Insert(row Row) (int, error)
+	// Update calls fn with every row and its line. fn may change the row
+	// in place and reports whether it did; when any row changed, every row
+	// is written back. Update returns the number of rows changed, and
+	// leaves the table as it was when fn fails.
+	Update(fn func(line int, row Row) (bool, error)) (int, error)
+	// Delete removes the rows fn reports true for and returns them. The
+	// rows kept are written back as fn left them.
+	Delete(fn func(row Row) (bool, error)) ([]Row, error)
+	// Truncate removes every row.
+	Truncate() error
+	// Stats returns the size of the table.
+	Stats() (Stats, error)
+	// ReadRowsAt returns the rows with the given lines, in line order.
+	ReadRowsAt(lines []int) ([]Row, error)
+	// Stamp identifies the current contents of the table, so an index can
+	// tell whether it was built from them.
+	Stamp() (string, error)
+	// Morsels splits the table into parts for a parallel scan, of about
+	// size bytes where the engine can choose.
+	Morsels(size int64) ([]Morsel, error)
+	// DeleteFile removes the rows and indexes of the table from storage.
+	DeleteFile() error
+	// IndexPath returns the file the named expression index of the table
+	// is kept in. Index files are derived data: every write to the table
+	// removes them, and they are rebuilt from the rows on next use.
+	IndexPath(index string) string
+	// RemoveIndex deletes the file of the named index, if there is one.
+	RemoveIndex(index string) error
+}
+
+// ScanOptions are the hints of a scan. Columns lists the columns the scan
+// needs, or is nil for all of them. Skip reports whether a part of the
+// table, given the zone maps of its columns, holds no row the scan needs.
+type ScanOptions struct {
+	Columns []string
+	Skip    func(zones map[string]Zone) bool
+}
+
+// Stats is the size of a table: its rows, including any that no longer
+// decode, and the bytes it takes in storage.
+type Stats struct {
+	Rows  int
+	Bytes int64
+}
+
+// RowReader reads the rows of a table one at a time; see Table.Scan.
+type RowReader interface {
+	// Next returns the next row and its line, or a nil row after the last
+	// one.
+	Next() (Row, int, error)
+	Close() error
+}
+
+// Engine opens tables kept in one format.
+type Engine interface {
+	Open(dbPath string, table schema.Table) (Table, error)
+}
+
+// engines are the engines of the formats a table's schema can name.
+var engines = map[string]Engine{
+	"":                    rowEngine{},
+	schema.FormatColumnar: columnarEngine{},
+	schema.FormatMemory:   memoryEngine{},
+}
+
+type rowEngine struct{}
+
+func (rowEngine) Open(dbPath string, table schema.Table) (Table, error) {
+	return NewTableFile(dbPath, table.StorageName())
+}
+
+type columnarEngine struct{}
+
+func (columnarEngine) Open(dbPath string, table schema.Table) (Table, error) {
+	return NewColumnarTable(dbPath, table.StorageName())
+}
+
+type memoryEngine struct{}
+
+func (memoryEngine) Open(dbPath string, table schema.Table) (Table, error) {
+	return NewMemoryTable(dbPath, table.StorageName())
+}
+
+// OpenTable opens the stored rows of table with the engine of the format
+// its schema gives.
+func OpenTable(dbPath string, table schema.Table) (Table, error) {
+	e, ok := engines[table.Format]
+	if !ok {
+		return nil, fmt.Errorf("table '%s' has unknown storage format '%s'", table.Name, table.Format)
+	}
+	return e.Open(dbPath, table)
+}
+
+// ReadAll returns every row of t.
+func ReadAll(t Table) ([]Row, error) {
+	rows := []Row{}
+	err := ScanAll(t, func(line int, row Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+// ScanAll calls fn for each row of t with its line.
+func ScanAll(t Table, fn func(line int, row Row) error) error {
+	r, err := t.Scan(nil, ScanOptions{})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		row, line, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			return nil
+		}
+		if err := fn(line, row); err != nil {
+			return err
+		}
+	}
+}
+
+// MigrateLegacyNulls replaces the LegacyNull strings stored in t by NULL and
+// returns how many it replaced.
+func MigrateLegacyNulls(t Table) (int, error) {
+	converted := 0
+	_, err := t.Update(func(line int, row Row) (bool, error) {
+		changed := false
+		for col, val := range row {
+			if s, ok := val.(string); ok && s == LegacyNull {
+				row[col] = nil
+				converted++
+				changed = true
+			}
+		}
+		return changed, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to migrate NULL values: %w", err)
+	}
+	return converted, nil
+}
+
+// updateRows calls fn with each row and its line and returns how many
+// rows it changed.
+func updateRows(rows []Row, lines []int, fn func(line int, row Row) (bool, error)) (int, error) {
+	changed := 0
+	for i, row := range rows {
+		ok, err := fn(lines[i], row)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// deleteRows splits rows into those fn keeps and those it deletes.
+func deleteRows(rows []Row, fn func(row Row) (bool, error)) (kept, deleted []Row, err error) {
+	kept = []Row{}
+	for _, row := range rows {
+		del, err := fn(row)
+		if err != nil {
+			return nil, nil, err
+		}
+		if del {
+			deleted = append(deleted, row)
+		} else {
+			kept = append(kept, row)
+		}
+	}
+	return kept, deleted, nil
+}